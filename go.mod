@@ -4,6 +4,7 @@ go 1.15
 
 require (
 	github.com/alpacahq/alpaca-trade-api-go v1.7.0
+	github.com/gorilla/websocket v1.4.0
 	github.com/onsi/ginkgo v1.14.2
 	github.com/onsi/gomega v1.10.4
 	github.com/shopspring/decimal v1.2.0
@@ -0,0 +1,326 @@
+package algorithm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/shopspring/decimal"
+)
+
+// New returns the algorithm with the given name, configured from
+// a map of parameters. Parameters that are not set keep their defaults.
+func New(name string, params map[string]string) (api.AlpacaAlgorithm, error) {
+	p := &parameters{values: params}
+
+	switch name {
+	case "martingale":
+		return NewMartingale()
+	case "crossover":
+		config := DefaultCrossoverConfig()
+		config.FastPeriod = p.int("fast", config.FastPeriod)
+		config.SlowPeriod = p.int("slow", config.SlowPeriod)
+		config.Exponential = p.bool("exponential", config.Exponential)
+		config.BarInterval = p.duration("interval", config.BarInterval)
+		config.TrendPeriod = p.int("trend", config.TrendPeriod)
+		config.AllowShort = p.bool("short", config.AllowShort)
+		config.ATRPeriod = p.int("atr", config.ATRPeriod)
+		if p.err != nil {
+			return nil, p.err
+		}
+		return NewCrossover(config)
+	case "mean_reversion":
+		config := DefaultMeanReversionConfig()
+		config.Lookback = p.int("lookback", config.Lookback)
+		config.BarInterval = p.duration("interval", config.BarInterval)
+		config.EntryZ = p.float("entry_z", config.EntryZ)
+		config.ExitZ = p.float("exit_z", config.ExitZ)
+		config.ScaleInStep = p.float("scale_step", config.ScaleInStep)
+		config.MaxScaleIns = p.int("max_scale_ins", config.MaxScaleIns)
+		config.MaxHoldingPeriod = p.duration("max_holding", config.MaxHoldingPeriod)
+		config.AllowShort = p.bool("short", config.AllowShort)
+		if p.err != nil {
+			return nil, p.err
+		}
+		return NewMeanReversion(config)
+	case "rsi_momentum":
+		config := DefaultRSIMomentumConfig()
+		config.RSIPeriod = p.int("period", config.RSIPeriod)
+		config.BarInterval = p.duration("interval", config.BarInterval)
+		config.Oversold = p.float("oversold", config.Oversold)
+		config.Overbought = p.float("overbought", config.Overbought)
+		config.DivergenceExit = p.bool("divergence_exit", config.DivergenceExit)
+		config.VolumePeriod = p.int("volume_period", config.VolumePeriod)
+		config.VolumeMultiple = p.float("volume_multiple", config.VolumeMultiple)
+		if p.err != nil {
+			return nil, p.err
+		}
+		return NewRSIMomentum(config)
+	case "execution":
+		config := DefaultExecutionConfig()
+		config.Symbol = p.string("symbol", config.Symbol)
+		config.Side = alpaca.Side(p.string("side", string(config.Side)))
+		config.Quantity = p.decimal("quantity", config.Quantity)
+		config.Start = p.time("start", config.Start)
+		config.End = p.time("end", config.End)
+		config.Schedule = Schedule(p.string("schedule", string(config.Schedule)))
+		config.SliceInterval = p.duration("slice", config.SliceInterval)
+		config.MaxParticipation = p.float("participation", config.MaxParticipation)
+		config.LimitOffset = p.float("limit_offset", config.LimitOffset)
+		if p.err != nil {
+			return nil, p.err
+		}
+		return NewExecution(config)
+	case "grid":
+		config := DefaultGridConfig()
+		config.Levels = p.int("levels", config.Levels)
+		config.Spacing = p.float("spacing", config.Spacing)
+		config.Quantity = p.decimal("quantity", config.Quantity)
+		config.MaxInventory = p.decimal("max_inventory", config.MaxInventory)
+		config.AllowShort = p.bool("short", config.AllowShort)
+		if p.err != nil {
+			return nil, p.err
+		}
+		return NewGrid(config)
+	case "pairs":
+		config := DefaultPairsConfig()
+		config.SymbolA = p.string("a", config.SymbolA)
+		config.SymbolB = p.string("b", config.SymbolB)
+		config.Lookback = p.int("lookback", config.Lookback)
+		config.BarInterval = p.duration("interval", config.BarInterval)
+		config.EntryZ = p.float("entry_z", config.EntryZ)
+		config.ExitZ = p.float("exit_z", config.ExitZ)
+		config.Notional = p.float("notional", config.Notional)
+		config.LegTimeout = p.int("leg_timeout", config.LegTimeout)
+		if p.err != nil {
+			return nil, p.err
+		}
+		return NewPairs(config)
+	case "rebalance":
+		config := DefaultRebalanceConfig()
+		config.Weights = p.namedFloats("weights", config.Weights)
+		config.CheckInterval = p.duration("check", config.CheckInterval)
+		config.Interval = p.duration("interval", config.Interval)
+		config.DriftThreshold = p.float("drift", config.DriftThreshold)
+		config.CashBuffer = p.float("cash_buffer", config.CashBuffer)
+		config.MinOrderValue = p.float("min_order", config.MinOrderValue)
+		if p.err != nil {
+			return nil, p.err
+		}
+		return NewRebalance(config)
+	case "dca":
+		config := DefaultDCAConfig()
+		config.Amounts = p.namedFloats("amounts", config.Amounts)
+		config.Frequency = Frequency(p.string("frequency", string(config.Frequency)))
+		config.Dips = p.dips("dips", config.Dips)
+		config.CheckInterval = p.duration("check", config.CheckInterval)
+		if p.err != nil {
+			return nil, p.err
+		}
+		return NewDCA(config)
+	case "ensemble":
+		config := EnsembleConfig{Combine: Combine(p.string("combine", string(Weighted)))}
+		weights := p.namedFloats("weights", map[string]float64{})
+		children := p.list("children", []string{})
+		if p.err != nil {
+			return nil, p.err
+		}
+		for _, child := range children {
+			// Each child is configured by the parameters prefixed with its name
+			childAlgorithm, err := New(child, p.prefixed(child+"."))
+			if err != nil {
+				return nil, fmt.Errorf("ensemble child %s: %v", child, err)
+			}
+			weight, exists := weights[child]
+			if !exists {
+				weight = 1
+			}
+			config.Children = append(config.Children, EnsembleChild{
+				Name:      child,
+				Algorithm: childAlgorithm,
+				Weight:    weight,
+			})
+		}
+		return NewEnsemble(config)
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q", name)
+	}
+}
+
+// parameters parses algorithm parameters, remembering the first error
+type parameters struct {
+	values map[string]string
+	err    error
+}
+
+func (p *parameters) string(key string, defaultValue string) string {
+	if value, exists := p.values[key]; exists {
+		return value
+	}
+	return defaultValue
+}
+
+func (p *parameters) int(key string, defaultValue int) int {
+	rawValue, exists := p.values[key]
+	if !exists {
+		return defaultValue
+	}
+	value, err := strconv.Atoi(rawValue)
+	if err != nil {
+		p.fail(key, err)
+		return defaultValue
+	}
+	return value
+}
+
+func (p *parameters) float(key string, defaultValue float64) float64 {
+	rawValue, exists := p.values[key]
+	if !exists {
+		return defaultValue
+	}
+	value, err := strconv.ParseFloat(rawValue, 64)
+	if err != nil {
+		p.fail(key, err)
+		return defaultValue
+	}
+	return value
+}
+
+func (p *parameters) bool(key string, defaultValue bool) bool {
+	rawValue, exists := p.values[key]
+	if !exists {
+		return defaultValue
+	}
+	value, err := strconv.ParseBool(rawValue)
+	if err != nil {
+		p.fail(key, err)
+		return defaultValue
+	}
+	return value
+}
+
+func (p *parameters) duration(key string, defaultValue time.Duration) time.Duration {
+	rawValue, exists := p.values[key]
+	if !exists {
+		return defaultValue
+	}
+	value, err := time.ParseDuration(rawValue)
+	if err != nil {
+		p.fail(key, err)
+		return defaultValue
+	}
+	return value
+}
+
+func (p *parameters) decimal(key string, defaultValue decimal.Decimal) decimal.Decimal {
+	rawValue, exists := p.values[key]
+	if !exists {
+		return defaultValue
+	}
+	value, err := decimal.NewFromString(rawValue)
+	if err != nil {
+		p.fail(key, err)
+		return defaultValue
+	}
+	return value
+}
+
+// time parses an RFC 3339 timestamp
+func (p *parameters) time(key string, defaultValue time.Time) time.Time {
+	rawValue, exists := p.values[key]
+	if !exists {
+		return defaultValue
+	}
+	value, err := time.Parse(time.RFC3339, rawValue)
+	if err != nil {
+		p.fail(key, err)
+		return defaultValue
+	}
+	return value.UTC()
+}
+
+// namedFloats parses a number for each name, like "VTI:0.6|BND:0.4"
+func (p *parameters) namedFloats(key string, defaultValue map[string]float64) map[string]float64 {
+	rawValue, exists := p.values[key]
+	if !exists {
+		return defaultValue
+	}
+	value := map[string]float64{}
+	for _, entry := range strings.Split(rawValue, "|") {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			p.fail(key, fmt.Errorf("expected SYMBOL:WEIGHT, got %q", entry))
+			return defaultValue
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			p.fail(key, err)
+			return defaultValue
+		}
+		value[strings.TrimSpace(parts[0])] = weight
+	}
+	return value
+}
+
+// list parses names separated by "|"
+func (p *parameters) list(key string, defaultValue []string) []string {
+	rawValue, exists := p.values[key]
+	if !exists {
+		return defaultValue
+	}
+	value := []string{}
+	for _, item := range strings.Split(rawValue, "|") {
+		if item = strings.TrimSpace(item); item != "" {
+			value = append(value, item)
+		}
+	}
+	return value
+}
+
+// prefixed returns the parameters starting with a prefix, with it removed
+func (p *parameters) prefixed(prefix string) map[string]string {
+	values := map[string]string{}
+	for key, value := range p.values {
+		if strings.HasPrefix(key, prefix) {
+			values[strings.TrimPrefix(key, prefix)] = value
+		}
+	}
+	return values
+}
+
+// dips parses drawdowns and multipliers, like "0.1:1.5|0.2:2"
+func (p *parameters) dips(key string, defaultValue []Dip) []Dip {
+	rawValue, exists := p.values[key]
+	if !exists {
+		return defaultValue
+	}
+	value := []Dip{}
+	for _, entry := range strings.Split(rawValue, "|") {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			p.fail(key, fmt.Errorf("expected DRAWDOWN:MULTIPLIER, got %q", entry))
+			return defaultValue
+		}
+		drawdown, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		if err != nil {
+			p.fail(key, err)
+			return defaultValue
+		}
+		multiplier, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			p.fail(key, err)
+			return defaultValue
+		}
+		value = append(value, Dip{Drawdown: drawdown, Multiplier: multiplier})
+	}
+	return value
+}
+
+func (p *parameters) fail(key string, err error) {
+	if p.err == nil {
+		p.err = fmt.Errorf("invalid algorithm parameter %s: %v", key, err)
+	}
+}
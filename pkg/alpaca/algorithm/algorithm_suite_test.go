@@ -0,0 +1,13 @@
+package algorithm_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestAlgorithm(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Alpaca Algorithm Suite")
+}
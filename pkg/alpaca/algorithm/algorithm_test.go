@@ -0,0 +1,84 @@
+package algorithm_test
+
+import (
+	"time"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/algorithm"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("New", func() {
+	It("should configure a crossover from parameters", func() {
+		result, err := algorithm.New("crossover", map[string]string{
+			"fast":     "5",
+			"slow":     "20",
+			"interval": "5m",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(BeAssignableToTypeOf(&algorithm.Crossover{}))
+		Expect(result.(api.BarHandler).BarSpec().Interval).To(Equal(5 * time.Minute))
+	})
+
+	It("should configure a rebalance from weights", func() {
+		result, err := algorithm.New("rebalance", map[string]string{
+			"weights": "VTI:0.5|BND:0.3|VXUS:0.2",
+			"check":   "5m",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(BeAssignableToTypeOf(&algorithm.Rebalance{}))
+		Expect(result.(api.TimerHandler).TimerInterval()).To(Equal(5 * time.Minute))
+	})
+
+	It("should reject malformed weights", func() {
+		_, err := algorithm.New("rebalance", map[string]string{"weights": "VTI=0.5"})
+		Expect(err).To(MatchError(`invalid algorithm parameter weights: expected SYMBOL:WEIGHT, got "VTI=0.5"`))
+	})
+
+	It("should configure dollar-cost averaging", func() {
+		result, err := algorithm.New("dca", map[string]string{
+			"amounts":   "VTI:100|BND:50",
+			"frequency": "monthly",
+			"dips":      "0.1:1.5|0.2:2",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(BeAssignableToTypeOf(&algorithm.DCA{}))
+	})
+
+	It("should configure an ensemble and its children", func() {
+		result, err := algorithm.New("ensemble", map[string]string{
+			"children":        "crossover|rsi_momentum",
+			"combine":         "unanimous",
+			"weights":         "crossover:2",
+			"crossover.fast":  "5",
+			"crossover.slow":  "20",
+			"crossover.short": "true",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(BeAssignableToTypeOf(&algorithm.Ensemble{}))
+		Expect(result.(*algorithm.Ensemble).Votes("VTI")).To(Equal(map[string]float64{
+			"crossover":    0,
+			"rsi_momentum": 0,
+		}))
+	})
+
+	It("should report which ensemble child is misconfigured", func() {
+		_, err := algorithm.New("ensemble", map[string]string{
+			"children":       "crossover",
+			"crossover.fast": "five",
+		})
+		Expect(err).To(MatchError("ensemble child crossover: invalid algorithm parameter fast: strconv.Atoi: parsing \"five\": invalid syntax"))
+	})
+
+	It("should reject an invalid parameter", func() {
+		_, err := algorithm.New("crossover", map[string]string{"fast": "five"})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("invalid algorithm parameter fast"))
+	})
+
+	It("should reject an unknown algorithm", func() {
+		_, err := algorithm.New("foo", map[string]string{})
+		Expect(err).To(MatchError(`unknown algorithm "foo"`))
+	})
+})
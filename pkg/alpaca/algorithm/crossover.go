@@ -0,0 +1,243 @@
+package algorithm
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/bars"
+	"github.com/markliederbach/stonks/pkg/alpaca/indicators"
+	"github.com/sirupsen/logrus"
+)
+
+var _ api.AlpacaAlgorithm = &Crossover{}
+var _ api.BarHandler = &Crossover{}
+var _ api.StartHandler = &Crossover{}
+var _ api.IntentTrader = &Crossover{}
+
+// CrossoverConfig configures the moving average crossover strategy
+type CrossoverConfig struct {
+	FastPeriod int
+	SlowPeriod int
+	// Exponential uses EMAs rather than SMAs
+	Exponential bool
+	BarInterval time.Duration
+	// TrendPeriod, when set, only takes positions in the direction
+	// of a moving average over this many bars
+	TrendPeriod int
+	// AllowShort goes short on a bearish crossover, rather than flat
+	AllowShort bool
+	// ATRPeriod is the number of bars in the volatility estimate
+	// passed along to the sizer
+	ATRPeriod int
+}
+
+// DefaultCrossoverConfig returns a long-only 10/30 bar SMA crossover on one minute bars
+func DefaultCrossoverConfig() CrossoverConfig {
+	return CrossoverConfig{
+		FastPeriod:  10,
+		SlowPeriod:  30,
+		BarInterval: time.Minute,
+		ATRPeriod:   14,
+	}
+}
+
+// Crossover goes long when a fast moving average crosses above a slow
+// one, and flat (or short) when it crosses back below. Each stock has
+// its own averages.
+type Crossover struct {
+	config CrossoverConfig
+	stocks map[string]*crossoverState
+}
+
+// crossoverState is the averages and signal for one stock
+type crossoverState struct {
+	fast  indicators.SeriesIndicator
+	slow  indicators.SeriesIndicator
+	trend indicators.SeriesIndicator
+	atr   *indicators.ATR
+
+	// lastSpread is the fast average less the slow, as of the previous bar
+	lastSpread *float64
+	// signal is the last signal the controller accepted
+	signal *float64
+}
+
+// NewCrossover returns a new Crossover algorithm
+func NewCrossover(config CrossoverConfig) (*Crossover, error) {
+	if config.FastPeriod <= 0 || config.SlowPeriod <= config.FastPeriod {
+		return nil, errors.New("crossover requires a positive fast period shorter than the slow period")
+	}
+	if config.BarInterval <= 0 {
+		return nil, errors.New("crossover requires a positive bar interval")
+	}
+
+	return &Crossover{
+		config: config,
+		stocks: map[string]*crossoverState{},
+	}, nil
+}
+
+// stock returns the state for a stock, starting it if it is new
+func (c *Crossover) stock(symbol string) *crossoverState {
+	state, ok := c.stocks[symbol]
+	if ok {
+		return state
+	}
+
+	state = &crossoverState{
+		fast: newMovingAverage(c.config.FastPeriod, c.config.Exponential),
+		slow: newMovingAverage(c.config.SlowPeriod, c.config.Exponential),
+		atr:  indicators.NewATR(c.config.ATRPeriod),
+	}
+	if c.config.TrendPeriod > 0 {
+		state.trend = newMovingAverage(c.config.TrendPeriod, c.config.Exponential)
+	}
+	c.stocks[symbol] = state
+	return state
+}
+
+// TradesWithIntents implements the function on the IntentTrader interface
+func (c *Crossover) TradesWithIntents() {}
+
+// HandleStreamTrade implements the function on the AlpacaAlgorithm interface.
+// Crossover reacts to bars rather than individual trades.
+func (c *Crossover) HandleStreamTrade(context api.StreamTradeContext) {}
+
+// BarSpec implements the function on the BarHandler interface
+func (c *Crossover) BarSpec() api.BarSpec {
+	return api.BarSpec{
+		Type:     api.TimeBars,
+		Interval: c.config.BarInterval,
+	}
+}
+
+// History implements the function on the StartHandler interface, asking
+// for enough bars to have the averages ready to cross on the first live bar.
+// No history is asked for when Alpaca does not keep bars of our interval.
+func (c *Crossover) History() api.HistorySpec {
+	spec := api.HistorySpec{
+		Interval: c.config.BarInterval,
+		Bars:     c.config.SlowPeriod + 1,
+	}
+	if c.config.TrendPeriod >= spec.Bars {
+		spec.Bars = c.config.TrendPeriod + 1
+	}
+	if c.config.ATRPeriod >= spec.Bars {
+		spec.Bars = c.config.ATRPeriod + 1
+	}
+
+	if bars.CheckHistory(spec) != nil {
+		return api.HistorySpec{}
+	}
+	return spec
+}
+
+// OnStart implements the function on the StartHandler interface,
+// warming up each stock's averages from its own history without trading
+func (c *Crossover) OnStart(context api.StartContext) error {
+	for symbol, symbolBars := range context.History {
+		history := append([]api.Bar{}, symbolBars...)
+		sort.SliceStable(history, func(i, j int) bool {
+			return history[i].End.Before(history[j].End)
+		})
+
+		state := c.stock(symbol)
+		for _, bar := range history {
+			state.update(bar)
+		}
+
+		context.ContextLog.WithFields(logrus.Fields{
+			"logger": "algorithm_crossover",
+			"symbol": symbol,
+			"bars":   len(history),
+			"ready":  state.lastSpread != nil,
+		}).Info("Warmed up from history")
+	}
+	return nil
+}
+
+// OnBar implements the function on the BarHandler interface
+func (c *Crossover) OnBar(context api.BarContext) {
+	bar := context.Bar
+	state := c.stock(bar.Symbol)
+
+	lastSpread, spread, ok := state.update(bar)
+	if !ok || context.Backfill {
+		// Backfilled bars only catch the averages up
+		return
+	}
+
+	var signal float64
+	switch {
+	case lastSpread <= 0 && spread > 0:
+		if state.trend == nil || bar.Close > state.trend.Value() {
+			signal = 1
+		}
+	case lastSpread >= 0 && spread < 0:
+		if c.config.AllowShort && (state.trend == nil || bar.Close < state.trend.Value()) {
+			signal = -1
+		}
+	default:
+		// No crossover on this bar
+		return
+	}
+
+	contextLog := context.ContextLog.WithFields(logrus.Fields{
+		"logger": "algorithm_crossover",
+		"fast":   state.fast.Value(),
+		"slow":   state.slow.Value(),
+		"signal": signal,
+	})
+
+	if state.signal != nil && *state.signal == signal {
+		contextLog.Debug("Crossover does not change our signal")
+		return
+	}
+
+	intent := api.SignalIntent(bar.Symbol, signal, bar.Close)
+	if state.atr.Ready() {
+		intent.Volatility = state.atr.Value()
+	}
+
+	contextLog.Info("Moving averages crossed")
+	if _, err := context.Router.SubmitIntent(intent); err != nil {
+		// Keep the signal we hold, so the next crossover tries again
+		contextLog.Errorf("Failed to submit intent: %v", err)
+		return
+	}
+	state.signal = &signal
+}
+
+// update adds a bar to the averages, returning the spread between them
+// before and after the bar once there is a spread for both
+func (s *crossoverState) update(bar api.Bar) (float64, float64, bool) {
+	s.fast.UpdateBar(bar)
+	s.slow.UpdateBar(bar)
+	s.atr.UpdateBar(bar)
+	if s.trend != nil {
+		s.trend.UpdateBar(bar)
+	}
+
+	if !s.slow.Ready() || (s.trend != nil && !s.trend.Ready()) {
+		return 0, 0, false
+	}
+
+	spread := s.fast.Value() - s.slow.Value()
+	lastSpread := s.lastSpread
+	s.lastSpread = &spread
+
+	if lastSpread == nil {
+		return 0, spread, false
+	}
+	return *lastSpread, spread, true
+}
+
+// newMovingAverage returns an EMA or SMA over the given period
+func newMovingAverage(period int, exponential bool) indicators.SeriesIndicator {
+	if exponential {
+		return indicators.NewEMA(period)
+	}
+	return indicators.NewSMA(period)
+}
@@ -0,0 +1,213 @@
+package algorithm_test
+
+import (
+	"errors"
+	"time"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/algorithm"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/internal"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Crossover", func() {
+	var (
+		crossover *algorithm.Crossover
+		config    algorithm.CrossoverConfig
+		router    *internal.MockOrderRouter
+		stock     string = "MKL"
+		err       error
+	)
+
+	feed := func(closes ...float64) {
+		for _, price := range closes {
+			crossover.OnBar(api.BarContext{
+				Router:     router,
+				Bar:        api.Bar{Symbol: stock, Open: price, High: price, Low: price, Close: price},
+				ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+			})
+		}
+	}
+
+	signals := func() []float64 {
+		result := []float64{}
+		for _, intent := range router.Intents {
+			result = append(result, *intent.Signal)
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		router = internal.NewMockOrderRouter()
+		config = algorithm.CrossoverConfig{
+			FastPeriod:  2,
+			SlowPeriod:  3,
+			BarInterval: time.Minute,
+			ATRPeriod:   2,
+		}
+	})
+
+	JustBeforeEach(func() {
+		crossover, err = algorithm.NewCrossover(config)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should request time bars at the configured interval", func() {
+		Expect(crossover.BarSpec()).To(Equal(api.BarSpec{Type: api.TimeBars, Interval: time.Minute}))
+	})
+
+	Context("when the fast average crosses above the slow average", func() {
+		JustBeforeEach(func() {
+			feed(10, 9, 8, 7, 9, 11)
+		})
+		It("should signal a long position", func() {
+			Expect(signals()).To(Equal([]float64{1}))
+			Expect(router.Intents[0].LimitPrice).To(Equal(float64(11)))
+			Expect(router.Intents[0].Volatility).To(BeNumerically(">", 0))
+		})
+
+		Context("when the fast average crosses back below", func() {
+			JustBeforeEach(func() {
+				feed(7, 5)
+			})
+			It("should signal a flat position", func() {
+				Expect(signals()).To(Equal([]float64{1, 0}))
+			})
+		})
+
+		Context("when shorting is allowed", func() {
+			BeforeEach(func() {
+				config.AllowShort = true
+			})
+			JustBeforeEach(func() {
+				feed(7, 5)
+			})
+			It("should signal a short position", func() {
+				Expect(signals()).To(Equal([]float64{1, -1}))
+			})
+		})
+	})
+
+	Context("when the controller refuses an exit", func() {
+		BeforeEach(func() {
+			config.TrendPeriod = 6
+		})
+		JustBeforeEach(func() {
+			feed(10, 9, 8, 7, 6, 5, 8)
+			router.Err = errors.New("boom")
+			feed(11, 7, 5)
+			router.Err = nil
+		})
+		It("should try again on the next crossover", func() {
+			Expect(signals()).To(Equal([]float64{1}))
+			// Crosses up, but below the trend
+			feed(4, 3, 2, 2.2, 2.4)
+			Expect(signals()).To(Equal([]float64{1, 0}))
+		})
+	})
+
+	Context("when the averages cross on backfilled bars", func() {
+		JustBeforeEach(func() {
+			for _, price := range []float64{10, 9, 8, 7, 9, 11} {
+				crossover.OnBar(api.BarContext{
+					Router:     router,
+					Bar:        api.Bar{Symbol: stock, Open: price, High: price, Low: price, Close: price},
+					Backfill:   true,
+					ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+				})
+			}
+		})
+		It("should only catch the averages up", func() {
+			Expect(router.Intents).To(BeEmpty())
+			feed(7, 5)
+			Expect(signals()).To(Equal([]float64{0}))
+		})
+	})
+
+	Context("when trading several stocks", func() {
+		JustBeforeEach(func() {
+			for _, price := range []float64{10, 9, 8, 7, 9, 11} {
+				feed(price)
+				crossover.OnBar(api.BarContext{
+					Router:     router,
+					Bar:        api.Bar{Symbol: "VTI", Open: 500, High: 500, Low: 500, Close: 500},
+					ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+				})
+			}
+		})
+		It("should keep each stock's averages apart", func() {
+			Expect(signals()).To(Equal([]float64{1}))
+			Expect(router.Intents[0].Symbol).To(Equal(stock))
+		})
+	})
+
+	Context("when started with history", func() {
+		warm := func(closes ...float64) {
+			history := []api.Bar{}
+			for i, price := range closes {
+				end := time.Date(2020, 11, 2, 14, 31+i, 0, 0, time.UTC)
+				history = append(history, api.Bar{Symbol: stock, End: end, Open: price, High: price, Low: price, Close: price})
+			}
+			Expect(crossover.OnStart(api.StartContext{
+				Router:     router,
+				History:    map[string][]api.Bar{stock: history},
+				ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+			})).To(Succeed())
+		}
+
+		It("should ask for enough bars to cross on the first live bar", func() {
+			Expect(crossover.History()).To(Equal(api.HistorySpec{Interval: time.Minute, Bars: 4}))
+		})
+
+		It("should warm up without trading", func() {
+			warm(10, 9, 8, 7)
+			Expect(router.Intents).To(BeEmpty())
+			feed(9, 11)
+			Expect(signals()).To(Equal([]float64{1}))
+		})
+
+		It("should warm up each stock from its own history", func() {
+			Expect(crossover.OnStart(api.StartContext{
+				Router: router,
+				History: map[string][]api.Bar{
+					stock: {{Symbol: stock, Close: 10}, {Symbol: stock, Close: 9}, {Symbol: stock, Close: 8}, {Symbol: stock, Close: 7}},
+					"VTI": {{Symbol: "VTI", Close: 500}, {Symbol: "VTI", Close: 500}, {Symbol: "VTI", Close: 500}, {Symbol: "VTI", Close: 500}},
+				},
+				ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+			})).To(Succeed())
+			feed(9, 11)
+			Expect(signals()).To(Equal([]float64{1}))
+		})
+
+		Context("when Alpaca keeps no bars at the interval", func() {
+			BeforeEach(func() {
+				config.BarInterval = 2 * time.Minute
+			})
+			It("should not ask for history", func() {
+				Expect(crossover.History()).To(Equal(api.HistorySpec{}))
+			})
+		})
+	})
+
+	Context("when a trend filter is set", func() {
+		BeforeEach(func() {
+			config.TrendPeriod = 8
+		})
+		JustBeforeEach(func() {
+			// Crosses up while price is still below the long-term average
+			feed(20, 18, 16, 14, 12, 10, 9, 8, 9, 11)
+		})
+		It("should not go long against the trend", func() {
+			Expect(signals()).To(Equal([]float64{0}))
+		})
+	})
+
+	Context("when the periods are invalid", func() {
+		It("should fail to create the algorithm", func() {
+			_, err := algorithm.NewCrossover(algorithm.CrossoverConfig{FastPeriod: 5, SlowPeriod: 5, BarInterval: time.Minute})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
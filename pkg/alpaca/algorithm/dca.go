@@ -0,0 +1,326 @@
+package algorithm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+var _ api.AlpacaAlgorithm = &DCA{}
+var _ api.TimerHandler = &DCA{}
+var _ api.OrderUpdateHandler = &DCA{}
+
+const (
+	// calendarDate is the layout of dates in the Alpaca calendar
+	calendarDate string = "2006-01-02"
+)
+
+// Frequency is how often dollar-cost averaging buys
+type Frequency string
+
+const (
+	// Daily buys on every trading day
+	Daily Frequency = "daily"
+	// Weekly buys on the first trading day of each week
+	Weekly Frequency = "weekly"
+	// Monthly buys on the first trading day of each month
+	Monthly Frequency = "monthly"
+)
+
+// Dip buys more when a stock has fallen from its high
+type Dip struct {
+	// Drawdown is how far the price must be below the highest
+	// price seen, as a fraction of that high
+	Drawdown float64
+	// Multiplier scales the amount bought
+	Multiplier float64
+}
+
+// DCAConfig configures the dollar-cost averaging strategy
+type DCAConfig struct {
+	// Amounts is the dollar amount to buy of each stock
+	Amounts   map[string]float64
+	Frequency Frequency
+	// Dips buy more on a drawdown. The largest multiplier whose
+	// drawdown has been reached applies.
+	Dips []Dip
+	// CheckInterval is how often the schedule is checked
+	CheckInterval time.Duration
+}
+
+// DefaultDCAConfig returns a weekly $100 purchase of VTI
+func DefaultDCAConfig() DCAConfig {
+	return DCAConfig{
+		Amounts:       map[string]float64{"VTI": 100},
+		Frequency:     Weekly,
+		CheckInterval: time.Minute,
+	}
+}
+
+// DCA buys a fixed dollar amount of each stock on a schedule of trading
+// days, optionally buying more after a dip. Each day is a UTC date, which
+// is the same as the exchange's date for the whole of the trading session.
+// A purchase waits for the first trade in the stock that day, so orders
+// are priced from the current session.
+type DCA struct {
+	config DCAConfig
+
+	// prices and priceDates hold the last trade price of each
+	// stock, and the date it traded
+	prices     map[string]float64
+	priceDates map[string]string
+	// peaks holds the highest price seen in each stock
+	peaks map[string]float64
+
+	// tradingDays caches the calendar between calendarFrom and calendarTo
+	tradingDays  map[string]bool
+	calendarFrom string
+	calendarTo   string
+
+	// bought holds the date of the last accepted purchase of each stock
+	bought map[string]string
+	// orders are the purchases still working, by order ID
+	orders map[string]bool
+}
+
+// NewDCA returns a new DCA algorithm
+func NewDCA(config DCAConfig) (*DCA, error) {
+	if len(config.Amounts) == 0 {
+		return nil, errors.New("dca requires at least one stock to buy")
+	}
+	for symbol, amount := range config.Amounts {
+		if amount <= 0 {
+			return nil, fmt.Errorf("dca amount for %s must be positive", symbol)
+		}
+	}
+	switch config.Frequency {
+	case Daily, Weekly, Monthly:
+	default:
+		return nil, fmt.Errorf("unknown dca frequency %q", config.Frequency)
+	}
+	for _, dip := range config.Dips {
+		if dip.Drawdown <= 0 || dip.Drawdown >= 1 || dip.Multiplier <= 0 {
+			return nil, errors.New("dca dips require a drawdown between 0 and 1 and a positive multiplier")
+		}
+	}
+	if config.CheckInterval <= 0 {
+		return nil, errors.New("dca requires a positive check interval")
+	}
+
+	return &DCA{
+		config:      config,
+		prices:      map[string]float64{},
+		priceDates:  map[string]string{},
+		peaks:       map[string]float64{},
+		tradingDays: map[string]bool{},
+		bought:      map[string]string{},
+		orders:      map[string]bool{},
+	}, nil
+}
+
+// HandleStreamTrade implements the function on the AlpacaAlgorithm interface
+func (c *DCA) HandleStreamTrade(context api.StreamTradeContext) {
+	trade := context.Trade
+	price := float64(trade.Price)
+
+	c.prices[trade.Symbol] = price
+	c.priceDates[trade.Symbol] = trade.Time().UTC().Format(calendarDate)
+	if price > c.peaks[trade.Symbol] {
+		c.peaks[trade.Symbol] = price
+	}
+}
+
+// TimerInterval implements the function on the TimerHandler interface
+func (c *DCA) TimerInterval() time.Duration {
+	return c.config.CheckInterval
+}
+
+// OnTimer implements the function on the TimerHandler interface
+func (c *DCA) OnTimer(context api.TimerContext) {
+	contextLog := context.ContextLog.WithFields(logrus.Fields{
+		"logger":    "algorithm_dca",
+		"frequency": c.config.Frequency,
+	})
+
+	today := context.Now.UTC().Format(calendarDate)
+	scheduled, err := c.scheduled(context.Client, context.Now.UTC())
+	if err != nil {
+		contextLog.Errorf("Failed to load trading calendar: %v", err)
+		return
+	}
+	if !scheduled {
+		return
+	}
+
+	symbols := make([]string, 0, len(c.config.Amounts))
+	for symbol := range c.config.Amounts {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	cash := context.Account.Cash
+	for _, symbol := range symbols {
+		if c.bought[symbol] == today || c.priceDates[symbol] != today {
+			// Already bought, or waiting for the stock to trade today
+			continue
+		}
+
+		price := c.prices[symbol]
+		amount := c.config.Amounts[symbol] * c.multiplier(symbol, price)
+		symbolLog := contextLog.WithFields(logrus.Fields{
+			"symbol": symbol,
+			"amount": amount,
+			"price":  price,
+		})
+
+		if amount > cash {
+			symbolLog.WithFields(logrus.Fields{"cash": cash}).Warn("Not enough cash, skipping purchase")
+			continue
+		}
+
+		precision := int32(0)
+		if context.Stocks[symbol].Asset.Fractionable {
+			precision = api.FractionalPrecision
+		}
+		quantity := decimal.NewFromFloat(amount / price).Truncate(precision)
+		if !quantity.IsPositive() {
+			symbolLog.Warn("Amount buys less than one share, skipping purchase")
+			continue
+		}
+
+		order, err := context.Router.PlaceLimitOrder(symbol, alpaca.Buy, quantity, price)
+		if err != nil {
+			symbolLog.Errorf("Failed to place purchase: %v", err)
+			continue
+		}
+
+		symbolLog.WithFields(logrus.Fields{"quantity": quantity}).Info("Placed scheduled purchase")
+		c.bought[symbol] = today
+		c.orders[order.ID] = true
+		cash -= amount
+	}
+}
+
+// OnOrderUpdate implements the function on the OrderUpdateHandler interface
+func (c *DCA) OnOrderUpdate(context api.OrderUpdateContext) {
+	update := context.Update
+	if !c.orders[update.Order.ID] {
+		return
+	}
+
+	switch update.Event {
+	case "fill", "canceled", "expired", "rejected":
+		delete(c.orders, update.Order.ID)
+	default:
+		return
+	}
+
+	if !update.Order.FilledQty.IsPositive() {
+		return
+	}
+
+	price := 0.0
+	if update.Order.FilledAvgPrice != nil {
+		price, _ = update.Order.FilledAvgPrice.Float64()
+	}
+	quantity, _ := update.Order.FilledQty.Float64()
+	note := ""
+	if update.Event != "fill" {
+		note = "partially filled before " + update.Event
+	}
+
+	err := context.Journal.Record(api.JournalEntry{
+		Time:      update.Order.UpdatedAt,
+		Algorithm: "dca",
+		Symbol:    update.Order.Symbol,
+		Side:      alpaca.Buy,
+		Quantity:  update.Order.FilledQty,
+		Price:     price,
+		Notional:  price * quantity,
+		OrderID:   update.Order.ID,
+		Note:      note,
+	})
+	if err != nil {
+		context.ContextLog.WithFields(logrus.Fields{
+			"logger":   "algorithm_dca",
+			"order_id": update.Order.ID,
+		}).Errorf("Failed to record purchase in journal: %v", err)
+	}
+}
+
+// multiplier returns how much more to buy given how far
+// the price has fallen from its high
+func (c *DCA) multiplier(symbol string, price float64) float64 {
+	multiplier := 1.0
+	peak := c.peaks[symbol]
+	if peak <= 0 {
+		return multiplier
+	}
+
+	drawdown := 1 - price/peak
+	for _, dip := range c.config.Dips {
+		if drawdown >= dip.Drawdown && dip.Multiplier > multiplier {
+			multiplier = dip.Multiplier
+		}
+	}
+	return multiplier
+}
+
+// scheduled reports whether today is a day to buy
+func (c *DCA) scheduled(client api.AlpacaClient, now time.Time) (bool, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	periodStart := today
+	switch c.config.Frequency {
+	case Weekly:
+		// Weeks start on Monday
+		periodStart = today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+	case Monthly:
+		periodStart = today.AddDate(0, 0, 1-today.Day())
+	}
+
+	if err := c.loadCalendar(client, periodStart, today); err != nil {
+		return false, err
+	}
+
+	if !c.tradingDays[today.Format(calendarDate)] {
+		return false, nil
+	}
+
+	// Only the first trading day of the period is scheduled
+	for day := periodStart; day.Before(today); day = day.AddDate(0, 0, 1) {
+		if c.tradingDays[day.Format(calendarDate)] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// loadCalendar makes sure the trading days between from and to are cached,
+// fetching the calendar up to the end of the month when they are not
+func (c *DCA) loadCalendar(client api.AlpacaClient, from, to time.Time) error {
+	fromDate, toDate := from.Format(calendarDate), to.Format(calendarDate)
+	if c.calendarFrom != "" && c.calendarFrom <= fromDate && toDate <= c.calendarTo {
+		return nil
+	}
+
+	endOfMonth := to.AddDate(0, 1, -to.Day())
+	start, end := fromDate, endOfMonth.Format(calendarDate)
+	days, err := client.GetCalendar(&start, &end)
+	if err != nil {
+		return err
+	}
+
+	c.tradingDays = map[string]bool{}
+	for _, day := range days {
+		c.tradingDays[day.Date] = true
+	}
+	c.calendarFrom, c.calendarTo = start, end
+	return nil
+}
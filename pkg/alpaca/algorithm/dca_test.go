@@ -0,0 +1,244 @@
+package algorithm_test
+
+import (
+	"errors"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/algorithm"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/internal"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("DCA", func() {
+	var (
+		dca     *algorithm.DCA
+		config  algorithm.DCAConfig
+		router  *internal.MockOrderRouter
+		journal *internal.MockJournal
+		stocks  map[string]api.StockInfo
+		cash    float64
+		err     error
+	)
+
+	// November 2020, where the 2nd is a Monday
+	day := func(date int, hour int) time.Time {
+		return time.Date(2020, 11, date, hour, 0, 0, 0, time.UTC)
+	}
+
+	calendar := func(dates ...string) {
+		days := []alpaca.CalendarDay{}
+		for _, date := range dates {
+			days = append(days, alpaca.CalendarDay{Date: date, Open: "09:30", Close: "16:00"})
+		}
+		Expect(internal.AddObjReturns("GetCalendar", days)).To(Succeed())
+	}
+
+	trade := func(symbol string, price float64, at time.Time) {
+		dca.HandleStreamTrade(api.StreamTradeContext{
+			Trade: alpaca.StreamTrade{Symbol: symbol, Price: float32(price), Timestamp: at.UnixNano()},
+		})
+	}
+
+	tick := func(at time.Time) {
+		dca.OnTimer(api.TimerContext{
+			Client:     internal.NewMockAlpacaClient(),
+			Router:     router,
+			Stocks:     stocks,
+			Account:    api.AccountInfo{Equity: 10000, Cash: cash},
+			Journal:    journal,
+			Now:        at,
+			ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+		})
+	}
+
+	BeforeEach(func() {
+		router = internal.NewMockOrderRouter()
+		journal = internal.NewMockJournal()
+		cash = 1000
+		stocks = map[string]api.StockInfo{
+			"VTI": {Symbol: "VTI", Asset: api.Asset{Fractionable: true}},
+			"BND": {Symbol: "BND", Asset: api.Asset{Fractionable: false}},
+		}
+		config = algorithm.DCAConfig{
+			Amounts:       map[string]float64{"VTI": 100},
+			Frequency:     algorithm.Weekly,
+			CheckInterval: time.Minute,
+		}
+	})
+
+	JustBeforeEach(func() {
+		dca, err = algorithm.NewDCA(config)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should buy once on the first trading day of the week", func() {
+		calendar("2020-11-02", "2020-11-03", "2020-11-04", "2020-11-05", "2020-11-06")
+
+		tick(day(2, 14))
+		Expect(router.Orders).To(BeEmpty(), "waits for a trade today")
+
+		trade("VTI", 80, day(2, 14))
+		tick(day(2, 15))
+		Expect(router.Orders).To(HaveLen(1))
+		Expect(*router.Orders[0].AssetKey).To(Equal("VTI"))
+		Expect(router.Orders[0].Side).To(Equal(alpaca.Buy))
+		Expect(router.Orders[0].Qty).To(Equal(decimal.NewFromFloat(1.25)))
+		Expect(router.Orders[0].LimitPrice.Equal(decimal.NewFromInt(80))).To(BeTrue())
+
+		tick(day(2, 16))
+		trade("VTI", 80, day(3, 14))
+		tick(day(3, 15))
+		Expect(router.Orders).To(HaveLen(1))
+	})
+
+	It("should buy on the next trading day when the week starts on a holiday", func() {
+		calendar("2020-11-03", "2020-11-04", "2020-11-05", "2020-11-06")
+
+		trade("VTI", 80, day(2, 14))
+		tick(day(2, 15))
+		Expect(router.Orders).To(BeEmpty())
+
+		trade("VTI", 80, day(3, 14))
+		tick(day(3, 15))
+		Expect(router.Orders).To(HaveLen(1))
+	})
+
+	Context("monthly", func() {
+		BeforeEach(func() {
+			config.Frequency = algorithm.Monthly
+		})
+
+		It("should only buy on the first trading day of the month", func() {
+			calendar("2020-11-02", "2020-11-03", "2020-11-09")
+
+			trade("VTI", 80, day(2, 14))
+			tick(day(2, 15))
+			trade("VTI", 80, day(9, 14))
+			tick(day(9, 15))
+			Expect(router.Orders).To(HaveLen(1))
+		})
+	})
+
+	Context("daily, across several stocks", func() {
+		BeforeEach(func() {
+			config.Frequency = algorithm.Daily
+			config.Amounts = map[string]float64{"VTI": 100, "BND": 100}
+		})
+
+		It("should only buy whole shares of stocks that are not fractionable", func() {
+			calendar("2020-11-02", "2020-11-03")
+
+			trade("VTI", 80, day(2, 14))
+			trade("BND", 30, day(2, 14))
+			tick(day(2, 15))
+			Expect(router.Orders).To(HaveLen(2))
+			Expect(*router.Orders[0].AssetKey).To(Equal("BND"))
+			Expect(router.Orders[0].Qty).To(Equal(decimal.NewFromInt(3)))
+
+			trade("VTI", 80, day(3, 14))
+			trade("BND", 30, day(3, 14))
+			tick(day(3, 15))
+			Expect(router.Orders).To(HaveLen(4))
+		})
+
+		It("should retry purchases the cash could not cover", func() {
+			cash = 150
+			calendar("2020-11-02")
+
+			trade("VTI", 80, day(2, 14))
+			trade("BND", 30, day(2, 14))
+			tick(day(2, 15))
+			Expect(router.Orders).To(HaveLen(1))
+			Expect(*router.Orders[0].AssetKey).To(Equal("BND"))
+
+			cash = 1000
+			tick(day(2, 16))
+			Expect(router.Orders).To(HaveLen(2))
+			Expect(*router.Orders[1].AssetKey).To(Equal("VTI"))
+
+			tick(day(2, 17))
+			Expect(router.Orders).To(HaveLen(2), "does not buy twice in a day")
+		})
+	})
+
+	Context("with dip multipliers", func() {
+		BeforeEach(func() {
+			config.Dips = []algorithm.Dip{
+				{Drawdown: 0.1, Multiplier: 1.5},
+				{Drawdown: 0.2, Multiplier: 2},
+			}
+		})
+
+		It("should buy more after a drawdown", func() {
+			calendar("2020-11-02")
+
+			trade("VTI", 100, day(2, 14))
+			trade("VTI", 85, day(2, 14))
+			tick(day(2, 15))
+			Expect(router.Orders).To(HaveLen(1))
+			// $150 at $85
+			Expect(router.Orders[0].Qty).To(Equal(decimal.NewFromFloat(150.0 / 85).Truncate(9)))
+		})
+	})
+
+	It("should record fills in the journal", func() {
+		calendar("2020-11-02")
+
+		trade("VTI", 80, day(2, 14))
+		tick(day(2, 15))
+		Expect(router.Orders).To(HaveLen(1))
+
+		averagePrice := decimal.NewFromFloat(79.5)
+		update := func(event string, filled float64) {
+			dca.OnOrderUpdate(api.OrderUpdateContext{
+				Router:  router,
+				Journal: journal,
+				Update: alpaca.TradeUpdate{
+					Event: event,
+					Order: alpaca.Order{
+						ID:             "order1",
+						Symbol:         "VTI",
+						Qty:            decimal.NewFromFloat(1.25),
+						FilledQty:      decimal.NewFromFloat(filled),
+						FilledAvgPrice: &averagePrice,
+						UpdatedAt:      day(2, 15),
+					},
+				},
+				ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+			})
+		}
+
+		update("partial_fill", 1)
+		Expect(journal.Entries).To(BeEmpty())
+
+		update("fill", 1.25)
+		Expect(journal.Entries).To(HaveLen(1))
+		Expect(journal.Entries[0].Symbol).To(Equal("VTI"))
+		Expect(journal.Entries[0].Algorithm).To(Equal("dca"))
+		Expect(journal.Entries[0].OrderID).To(Equal("order1"))
+		Expect(journal.Entries[0].Quantity).To(Equal(decimal.NewFromFloat(1.25)))
+		Expect(journal.Entries[0].Notional).To(BeNumerically("~", 99.375, 1e-9))
+
+		update("fill", 1.25)
+		Expect(journal.Entries).To(HaveLen(1), "ignores orders it is no longer following")
+	})
+
+	It("should not buy when the calendar cannot be loaded", func() {
+		Expect(internal.AddObjReturns("GetCalendar", errors.New("boom"))).To(Succeed())
+
+		trade("VTI", 80, day(2, 14))
+		tick(day(2, 15))
+		Expect(router.Orders).To(BeEmpty())
+	})
+
+	It("should reject an unknown frequency", func() {
+		config.Frequency = "hourly"
+		_, err := algorithm.NewDCA(config)
+		Expect(err).To(MatchError(`unknown dca frequency "hourly"`))
+	})
+})
@@ -0,0 +1,611 @@
+package algorithm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/bars"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+var _ api.AlpacaAlgorithm = &Ensemble{}
+var _ api.BarHandler = &Ensemble{}
+var _ api.TimerHandler = &Ensemble{}
+var _ api.OrderUpdateHandler = &Ensemble{}
+var _ api.StartHandler = &Ensemble{}
+var _ api.StopHandler = &Ensemble{}
+var _ api.QuoteHandler = &Ensemble{}
+var _ api.AggregateHandler = &Ensemble{}
+var _ api.MarketHandler = &Ensemble{}
+var _ api.OrderRouter = &voteRouter{}
+
+const (
+	// ensembleTimer is the ensemble's timer interval when no child
+	// has a timer of its own
+	ensembleTimer time.Duration = time.Minute
+
+	// signalPrecision rounds combined signals, so float noise
+	// does not resubmit an unchanged signal
+	signalPrecision float64 = 1e6
+)
+
+// Combine is how an ensemble combines its children's votes
+type Combine string
+
+const (
+	// Weighted takes the weighted average of every child's signal,
+	// counting children without an opinion as flat
+	Weighted Combine = "weighted"
+	// Majority follows the direction backed by more than half of the
+	// total weight, at the average signal of the children backing it
+	Majority Combine = "majority"
+	// Unanimous only takes a position when every child agrees on its
+	// direction, at their average signal
+	Unanimous Combine = "unanimous"
+)
+
+// EnsembleChild is an algorithm voting in an ensemble
+type EnsembleChild struct {
+	Name      string
+	Algorithm api.AlpacaAlgorithm
+	Weight    float64
+}
+
+// EnsembleConfig configures the ensemble algorithm
+type EnsembleConfig struct {
+	Children []EnsembleChild
+	Combine  Combine
+}
+
+// ensembleBars are the bars the ensemble asks for when no child
+// consumes bars of its own
+var ensembleBars = api.BarSpec{Type: api.TimeBars, Interval: time.Minute}
+
+// ensembleChild tracks the state the ensemble keeps for one child
+type ensembleChild struct {
+	EnsembleChild
+	router *voteRouter
+	// pnl is the profit and loss attributed to the child
+	pnl float64
+}
+
+// Ensemble runs several algorithms side by side and trades the combination
+// of their votes. Children submit intents as usual, but each intent is
+// recorded as a vote between -1 and 1 instead of being sent: signals vote
+// as they are, and targets or notionals vote their direction. The combined
+// vote for each stock is submitted as a signal intent, sized by the
+// controller. Children must be api.IntentTraders, as they cannot place
+// or cancel orders of their own, and their orders never fill.
+//
+// The controller builds bars and runs the timer for the ensemble, which
+// passes them on, so children that consume bars must share one bar spec
+// and children with timers must share one interval.
+type Ensemble struct {
+	config   EnsembleConfig
+	children []*ensembleChild
+
+	// barSpec is the bar spec shared by children that consume bars
+	barSpec api.BarSpec
+	// timerInterval is the interval shared by children with timers
+	timerInterval time.Duration
+
+	// signals are the combined signals the controller last accepted, by symbol
+	signals map[string]float64
+	// refused holds the symbols whose combined signal the controller
+	// refused, which are combined again even if no vote changes
+	refused map[string]bool
+	// prices are the last trade prices, by symbol, for attributing P&L
+	prices map[string]float64
+	// unattributed is P&L made while no child voted for a position
+	unattributed float64
+}
+
+// NewEnsemble returns a new Ensemble algorithm
+func NewEnsemble(config EnsembleConfig) (*Ensemble, error) {
+	if len(config.Children) == 0 {
+		return nil, errors.New("ensemble requires at least one child")
+	}
+	switch config.Combine {
+	case Weighted, Majority, Unanimous:
+	default:
+		return nil, fmt.Errorf("unknown ensemble combination %q", config.Combine)
+	}
+
+	ensemble := &Ensemble{
+		config:        config,
+		barSpec:       ensembleBars,
+		timerInterval: ensembleTimer,
+		signals:       map[string]float64{},
+		refused:       map[string]bool{},
+		prices:        map[string]float64{},
+	}
+
+	names := map[string]bool{}
+	barChild, timerChild := "", ""
+	for _, child := range config.Children {
+		if names[child.Name] {
+			return nil, fmt.Errorf("ensemble child %s is listed more than once", child.Name)
+		}
+		names[child.Name] = true
+
+		if _, ok := child.Algorithm.(api.IntentTrader); !ok {
+			return nil, fmt.Errorf("ensemble child %s does not trade with intents, so cannot vote", child.Name)
+		}
+		if child.Weight <= 0 {
+			return nil, fmt.Errorf("ensemble weight for %s must be positive", child.Name)
+		}
+		if handler, ok := child.Algorithm.(api.BarHandler); ok {
+			if _, err := bars.NewBuilder("", handler.BarSpec()); err != nil {
+				return nil, fmt.Errorf("ensemble child %s: %v", child.Name, err)
+			}
+			if barChild != "" && handler.BarSpec() != ensemble.barSpec {
+				return nil, fmt.Errorf("ensemble children %s and %s must share one bar spec", barChild, child.Name)
+			}
+			barChild, ensemble.barSpec = child.Name, handler.BarSpec()
+		}
+		if handler, ok := child.Algorithm.(api.StartHandler); ok {
+			if err := bars.CheckHistory(handler.History()); err != nil {
+				return nil, fmt.Errorf("ensemble child %s: %v", child.Name, err)
+			}
+		}
+		if handler, ok := child.Algorithm.(api.TimerHandler); ok {
+			if handler.TimerInterval() <= 0 {
+				return nil, fmt.Errorf("ensemble child %s: timer interval must be positive", child.Name)
+			}
+			if timerChild != "" && handler.TimerInterval() != ensemble.timerInterval {
+				return nil, fmt.Errorf("ensemble children %s and %s must share one timer interval", timerChild, child.Name)
+			}
+			timerChild, ensemble.timerInterval = child.Name, handler.TimerInterval()
+		}
+
+		ensemble.children = append(ensemble.children, &ensembleChild{
+			EnsembleChild: child,
+			router:        newVoteRouter(child.Name),
+		})
+	}
+
+	return ensemble, nil
+}
+
+// HandleStreamTrade implements the function on the AlpacaAlgorithm interface
+func (c *Ensemble) HandleStreamTrade(context api.StreamTradeContext) {
+	contextLog := context.ContextLog.WithFields(logrus.Fields{
+		"logger": "algorithm_ensemble",
+	})
+
+	symbol := context.Trade.Symbol
+	price := float64(context.Trade.Price)
+	c.attribute(symbol, context.Stock.Position, price)
+
+	for _, child := range c.children {
+		childContext := context
+		childContext.Router = child.router
+		childContext.ContextLog = contextLog.WithFields(logrus.Fields{"child": child.Name})
+		child.Algorithm.HandleStreamTrade(childContext)
+	}
+
+	if context.Backfill {
+		// Children only catch up on backfilled trades
+		return
+	}
+	c.combine(context.Router, contextLog)
+}
+
+// BarSpec implements the function on the BarHandler interface, asking
+// for the bars the children share
+func (c *Ensemble) BarSpec() api.BarSpec {
+	return c.barSpec
+}
+
+// OnBar implements the function on the BarHandler interface,
+// passing bars on to children that consume them
+func (c *Ensemble) OnBar(context api.BarContext) {
+	contextLog := context.ContextLog.WithFields(logrus.Fields{
+		"logger": "algorithm_ensemble",
+	})
+
+	for _, child := range c.children {
+		handler, ok := child.Algorithm.(api.BarHandler)
+		if !ok {
+			continue
+		}
+		childContext := context
+		childContext.Router = child.router
+		childContext.ContextLog = contextLog.WithFields(logrus.Fields{"child": child.Name})
+		handler.OnBar(childContext)
+	}
+
+	if context.Backfill {
+		// Children only catch up on backfilled bars
+		return
+	}
+	c.combine(context.Router, contextLog)
+}
+
+// TimerInterval implements the function on the TimerHandler interface,
+// returning the interval the children share
+func (c *Ensemble) TimerInterval() time.Duration {
+	return c.timerInterval
+}
+
+// OnTimer implements the function on the TimerHandler interface,
+// running the children's timers
+func (c *Ensemble) OnTimer(context api.TimerContext) {
+	contextLog := context.ContextLog.WithFields(logrus.Fields{
+		"logger": "algorithm_ensemble",
+	})
+
+	for _, child := range c.children {
+		handler, ok := child.Algorithm.(api.TimerHandler)
+		if !ok {
+			continue
+		}
+		childContext := context
+		childContext.Router = child.router
+		childContext.ContextLog = contextLog.WithFields(logrus.Fields{"child": child.Name})
+		handler.OnTimer(childContext)
+	}
+
+	c.combine(context.Router, contextLog)
+}
+
+// OnOrderUpdate implements the function on the OrderUpdateHandler
+// interface, passing updates on to children that follow them
+func (c *Ensemble) OnOrderUpdate(context api.OrderUpdateContext) {
+	for _, child := range c.children {
+		handler, ok := child.Algorithm.(api.OrderUpdateHandler)
+		if !ok {
+			continue
+		}
+		childContext := context
+		childContext.Router = child.router
+		childContext.ContextLog = context.ContextLog.WithFields(logrus.Fields{
+			"logger": "algorithm_ensemble",
+			"child":  child.Name,
+		})
+		handler.OnOrderUpdate(childContext)
+	}
+}
+
+// History implements the function on the StartHandler interface. The
+// ensemble loads each child's history itself when it starts.
+func (c *Ensemble) History() api.HistorySpec {
+	return api.HistorySpec{}
+}
+
+// OnStart implements the function on the StartHandler interface,
+// starting each child with the history it asked for
+func (c *Ensemble) OnStart(context api.StartContext) error {
+	symbols := []string{}
+	for symbol := range context.Stocks {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	for _, child := range c.children {
+		handler, ok := child.Algorithm.(api.StartHandler)
+		if !ok {
+			continue
+		}
+
+		history, err := bars.LoadHistory(context.Client, symbols, handler.History(), context.Now)
+		if err != nil {
+			return fmt.Errorf("ensemble child %s: failed to load history: %w", child.Name, err)
+		}
+
+		childContext := context
+		childContext.Router = child.router
+		childContext.History = history
+		childContext.ContextLog = context.ContextLog.WithFields(logrus.Fields{
+			"logger": "algorithm_ensemble",
+			"child":  child.Name,
+		})
+		if err := handler.OnStart(childContext); err != nil {
+			return fmt.Errorf("ensemble child %s: %w", child.Name, err)
+		}
+	}
+
+	c.combine(context.Router, context.ContextLog.WithFields(logrus.Fields{
+		"logger": "algorithm_ensemble",
+	}))
+	return nil
+}
+
+// OnQuote implements the function on the QuoteHandler interface,
+// passing quotes on to children that follow them
+func (c *Ensemble) OnQuote(context api.QuoteContext) {
+	contextLog := context.ContextLog.WithFields(logrus.Fields{
+		"logger": "algorithm_ensemble",
+	})
+
+	for _, child := range c.children {
+		handler, ok := child.Algorithm.(api.QuoteHandler)
+		if !ok {
+			continue
+		}
+		childContext := context
+		childContext.Router = child.router
+		childContext.ContextLog = contextLog.WithFields(logrus.Fields{"child": child.Name})
+		handler.OnQuote(childContext)
+	}
+
+	c.combine(context.Router, contextLog)
+}
+
+// OnAggregate implements the function on the AggregateHandler interface,
+// passing aggregates on to children that follow them
+func (c *Ensemble) OnAggregate(context api.AggregateContext) {
+	contextLog := context.ContextLog.WithFields(logrus.Fields{
+		"logger": "algorithm_ensemble",
+	})
+
+	for _, child := range c.children {
+		handler, ok := child.Algorithm.(api.AggregateHandler)
+		if !ok {
+			continue
+		}
+		childContext := context
+		childContext.Router = child.router
+		childContext.ContextLog = contextLog.WithFields(logrus.Fields{"child": child.Name})
+		handler.OnAggregate(childContext)
+	}
+
+	if context.Backfill {
+		// Children only catch up on backfilled aggregates
+		return
+	}
+	c.combine(context.Router, contextLog)
+}
+
+// OnMarketOpen implements the function on the MarketHandler interface
+func (c *Ensemble) OnMarketOpen(context api.MarketContext) {
+	c.onMarket(context, api.MarketHandler.OnMarketOpen)
+}
+
+// OnMarketClose implements the function on the MarketHandler interface
+func (c *Ensemble) OnMarketClose(context api.MarketContext) {
+	c.onMarket(context, api.MarketHandler.OnMarketClose)
+}
+
+// onMarket passes a market event on to children that follow them
+func (c *Ensemble) onMarket(context api.MarketContext, event func(api.MarketHandler, api.MarketContext)) {
+	contextLog := context.ContextLog.WithFields(logrus.Fields{
+		"logger": "algorithm_ensemble",
+	})
+
+	for _, child := range c.children {
+		handler, ok := child.Algorithm.(api.MarketHandler)
+		if !ok {
+			continue
+		}
+		childContext := context
+		childContext.Router = child.router
+		childContext.ContextLog = contextLog.WithFields(logrus.Fields{"child": child.Name})
+		event(handler, childContext)
+	}
+
+	c.combine(context.Router, contextLog)
+}
+
+// OnStop implements the function on the StopHandler interface,
+// stopping each child. Votes cast while stopping are not traded.
+func (c *Ensemble) OnStop(context api.StopContext) {
+	for _, child := range c.children {
+		handler, ok := child.Algorithm.(api.StopHandler)
+		if !ok {
+			continue
+		}
+		childContext := context
+		childContext.Router = child.router
+		childContext.ContextLog = context.ContextLog.WithFields(logrus.Fields{
+			"logger": "algorithm_ensemble",
+			"child":  child.Name,
+		})
+		handler.OnStop(childContext)
+	}
+}
+
+// Votes returns each child's current vote in a stock, by child name
+func (c *Ensemble) Votes(symbol string) map[string]float64 {
+	votes := map[string]float64{}
+	for _, child := range c.children {
+		votes[child.Name] = child.router.votes[symbol]
+	}
+	return votes
+}
+
+// PnL returns the profit and loss attributed to each child, by child
+// name. P&L made while no child voted for a position, such as while
+// closing out, is not attributed to any child and is reported under
+// an empty name.
+func (c *Ensemble) PnL() map[string]float64 {
+	pnl := map[string]float64{"": c.unattributed}
+	for _, child := range c.children {
+		pnl[child.Name] = child.pnl
+	}
+	return pnl
+}
+
+// attribute splits the P&L of our position since the last trade between
+// the children, in proportion to their weighted votes for it
+func (c *Ensemble) attribute(symbol string, position decimal.Decimal, price float64) {
+	lastPrice, ok := c.prices[symbol]
+	c.prices[symbol] = price
+	if !ok || position.IsZero() {
+		return
+	}
+
+	held, _ := position.Float64()
+	pnl := held * (price - lastPrice)
+
+	total := 0.0
+	for _, child := range c.children {
+		total += child.Weight * child.router.votes[symbol]
+	}
+	if total == 0 {
+		c.unattributed += pnl
+		return
+	}
+
+	for _, child := range c.children {
+		child.pnl += pnl * child.Weight * child.router.votes[symbol] / total
+	}
+}
+
+// combine submits the combined vote for each stock whose vote changed,
+// or whose last combined vote was refused
+func (c *Ensemble) combine(router api.OrderRouter, contextLog *logrus.Entry) {
+	symbols := c.refused
+	c.refused = map[string]bool{}
+	for _, child := range c.children {
+		for symbol := range child.router.changed {
+			symbols[symbol] = true
+		}
+		child.router.changed = map[string]bool{}
+	}
+
+	sorted := make([]string, 0, len(symbols))
+	for symbol := range symbols {
+		sorted = append(sorted, symbol)
+	}
+	sort.Strings(sorted)
+
+	for _, symbol := range sorted {
+		signal := c.vote(symbol)
+		if last, ok := c.signals[symbol]; ok && last == signal {
+			continue
+		}
+
+		intent := api.SignalIntent(symbol, signal, 0)
+		for _, child := range c.children {
+			if child.router.prices[symbol] > 0 {
+				intent.LimitPrice = child.router.prices[symbol]
+			}
+			intent.Volatility = math.Max(intent.Volatility, child.router.volatility[symbol])
+		}
+
+		symbolLog := contextLog.WithFields(logrus.Fields{
+			"symbol": symbol,
+			"signal": signal,
+			"votes":  c.Votes(symbol),
+		})
+		symbolLog.Info("Ensemble vote changed")
+		if _, err := router.SubmitIntent(intent); err != nil {
+			symbolLog.Errorf("Failed to submit intent: %v", err)
+			c.refused[symbol] = true
+			continue
+		}
+		c.signals[symbol] = signal
+	}
+}
+
+// vote combines the children's votes in a stock
+func (c *Ensemble) vote(symbol string) float64 {
+	total, long, short := 0.0, 0.0, 0.0
+	weighted, longSum, shortSum := 0.0, 0.0, 0.0
+	for _, child := range c.children {
+		vote := child.router.votes[symbol]
+		total += child.Weight
+		weighted += child.Weight * vote
+		switch {
+		case vote > 0:
+			long += child.Weight
+			longSum += child.Weight * vote
+		case vote < 0:
+			short += child.Weight
+			shortSum += child.Weight * vote
+		}
+	}
+
+	signal := 0.0
+	switch c.config.Combine {
+	case Weighted:
+		signal = weighted / total
+	case Majority:
+		if long > total/2 {
+			signal = longSum / long
+		} else if short > total/2 {
+			signal = shortSum / short
+		}
+	case Unanimous:
+		if long == total {
+			signal = longSum / long
+		} else if short == total {
+			signal = shortSum / short
+		}
+	}
+	return math.Round(signal*signalPrecision) / signalPrecision
+}
+
+// voteRouter is the order router given to an ensemble child. It records
+// the child's intents as votes rather than sending orders.
+type voteRouter struct {
+	// name is the child's name, which prefixes the IDs of its votes
+	name string
+	// count is how many votes the child has cast
+	count int
+	// votes are the child's votes between -1 and 1, by symbol
+	votes map[string]float64
+	// prices and volatility are from the child's latest intent, by symbol
+	prices     map[string]float64
+	volatility map[string]float64
+	// changed holds the symbols voted on since the ensemble last combined
+	changed map[string]bool
+}
+
+func newVoteRouter(name string) *voteRouter {
+	return &voteRouter{
+		name:       name,
+		votes:      map[string]float64{},
+		prices:     map[string]float64{},
+		volatility: map[string]float64{},
+		changed:    map[string]bool{},
+	}
+}
+
+// SubmitIntent implements the function on the OrderRouter interface. Each
+// vote is returned as an order with its own ID, though it is never sent.
+func (r *voteRouter) SubmitIntent(intent api.OrderIntent) (*alpaca.Order, error) {
+	vote := 0.0
+	switch {
+	case intent.Signal != nil:
+		vote = math.Max(-1, math.Min(1, *intent.Signal))
+	case intent.Notional != nil:
+		vote = float64(intent.Notional.Sign())
+	default:
+		vote = float64(intent.Target.Sign())
+	}
+
+	r.votes[intent.Symbol] = vote
+	r.prices[intent.Symbol] = intent.LimitPrice
+	r.volatility[intent.Symbol] = intent.Volatility
+	r.changed[intent.Symbol] = true
+	r.count++
+	return &alpaca.Order{ID: fmt.Sprintf("%s-vote%d", r.name, r.count), Symbol: intent.Symbol}, nil
+}
+
+// SubmitIntents implements the function on the OrderRouter interface
+func (r *voteRouter) SubmitIntents(intents ...api.OrderIntent) ([]*alpaca.Order, error) {
+	orders := []*alpaca.Order{}
+	for _, intent := range intents {
+		order, _ := r.SubmitIntent(intent)
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+// PlaceLimitOrder implements the function on the OrderRouter interface
+func (r *voteRouter) PlaceLimitOrder(symbol string, side alpaca.Side, quantity decimal.Decimal, limitPrice float64) (*alpaca.Order, error) {
+	return &alpaca.Order{}, errors.New("ensemble children must trade with intents")
+}
+
+// CancelOrder implements the function on the OrderRouter interface
+func (r *voteRouter) CancelOrder(orderID string) error {
+	return errors.New("ensemble children must trade with intents")
+}
@@ -0,0 +1,330 @@
+package algorithm_test
+
+import (
+	"errors"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/algorithm"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/internal"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// voter submits the same intent on every trade, and
+// tries to place an order of its own when asked to
+type voter struct {
+	intent    *api.OrderIntent
+	orderIDs  []string
+	placeErr  error
+	placeOnce bool
+}
+
+func (v *voter) HandleStreamTrade(context api.StreamTradeContext) {
+	if v.placeOnce {
+		v.placeOnce = false
+		_, v.placeErr = context.Router.PlaceLimitOrder(context.Trade.Symbol, alpaca.Buy, decimal.NewFromInt(1), 100)
+	}
+	if v.intent != nil {
+		order, _ := context.Router.SubmitIntent(*v.intent)
+		v.orderIDs = append(v.orderIDs, order.ID)
+	}
+}
+
+func (v *voter) TradesWithIntents() {}
+
+func (v *voter) vote(signal float64) {
+	intent := api.SignalIntent("VTI", signal, 100)
+	v.intent = &intent
+}
+
+var _ = Describe("Ensemble", func() {
+	var (
+		ensemble *algorithm.Ensemble
+		config   algorithm.EnsembleConfig
+		router   *internal.MockOrderRouter
+		first    *voter
+		second   *voter
+		third    *voter
+		position decimal.Decimal
+		start    time.Time
+		trades   int
+		err      error
+	)
+
+	trade := func(price float64) {
+		ensemble.HandleStreamTrade(api.StreamTradeContext{
+			Router: router,
+			Stock:  api.StockInfo{Symbol: "VTI", Position: position},
+			Trade: alpaca.StreamTrade{
+				Symbol:    "VTI",
+				Price:     float32(price),
+				Size:      100,
+				Timestamp: start.Add(time.Duration(trades) * time.Second).UnixNano(),
+			},
+			ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+		})
+		trades++
+	}
+
+	signals := func() []float64 {
+		result := []float64{}
+		for _, intent := range router.Intents {
+			Expect(intent.Signal).ToNot(BeNil())
+			result = append(result, *intent.Signal)
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		router = internal.NewMockOrderRouter()
+		first, second, third = &voter{}, &voter{}, &voter{}
+		position = decimal.Zero
+		start = time.Date(2020, 11, 2, 14, 30, 0, 0, time.UTC)
+		trades = 0
+		config = algorithm.EnsembleConfig{
+			Children: []algorithm.EnsembleChild{
+				{Name: "first", Algorithm: first, Weight: 1},
+				{Name: "second", Algorithm: second, Weight: 1},
+			},
+			Combine: algorithm.Weighted,
+		}
+	})
+
+	JustBeforeEach(func() {
+		ensemble, err = algorithm.NewEnsemble(config)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should average the weighted votes", func() {
+		first.vote(1)
+		trade(100)
+		Expect(signals()).To(Equal([]float64{0.5}))
+		Expect(router.Intents[0].Symbol).To(Equal("VTI"))
+		Expect(router.Intents[0].LimitPrice).To(Equal(100.0))
+
+		trade(100)
+		Expect(signals()).To(HaveLen(1), "does not resubmit an unchanged vote")
+
+		second.vote(-1)
+		trade(100)
+		Expect(signals()).To(Equal([]float64{0.5, 0}))
+	})
+
+	It("should submit a refused vote again", func() {
+		first.vote(1)
+		router.Err = errors.New("boom")
+		trade(100)
+		Expect(router.Intents).To(BeEmpty())
+
+		router.Err = nil
+		first.intent = nil
+		trade(100)
+		Expect(signals()).To(Equal([]float64{0.5}))
+	})
+
+	It("should give each vote its own order ID", func() {
+		first.vote(1)
+		second.vote(1)
+		trade(100)
+		trade(100)
+		Expect(first.orderIDs).To(Equal([]string{"first-vote1", "first-vote2"}))
+		Expect(second.orderIDs).To(Equal([]string{"second-vote1", "second-vote2"}))
+	})
+
+	It("should count targets and notionals by their direction", func() {
+		target := api.TargetIntent("VTI", decimal.NewFromInt(-10), 100)
+		notional := api.NotionalIntent("VTI", decimal.NewFromInt(-500), 100)
+		first.intent, second.intent = &target, &notional
+		trade(100)
+		Expect(signals()).To(Equal([]float64{-1}))
+		Expect(ensemble.Votes("VTI")).To(Equal(map[string]float64{"first": -1, "second": -1}))
+	})
+
+	It("should not let children place orders of their own", func() {
+		first.placeOnce = true
+		trade(100)
+		Expect(first.placeErr).To(MatchError("ensemble children must trade with intents"))
+		Expect(router.Orders).To(BeEmpty())
+	})
+
+	Context("by majority", func() {
+		BeforeEach(func() {
+			config.Combine = algorithm.Majority
+			config.Children = append(config.Children, algorithm.EnsembleChild{Name: "third", Algorithm: third, Weight: 1})
+		})
+
+		It("should follow the direction most of the weight backs", func() {
+			first.vote(1)
+			second.vote(0.5)
+			third.vote(-1)
+			trade(100)
+			Expect(signals()).To(Equal([]float64{0.75}))
+
+			second.vote(-1)
+			trade(100)
+			Expect(signals()).To(Equal([]float64{0.75, -1}))
+
+			second.vote(0)
+			trade(100)
+			Expect(signals()).To(Equal([]float64{0.75, -1, 0}))
+		})
+	})
+
+	Context("by unanimity", func() {
+		BeforeEach(func() {
+			config.Combine = algorithm.Unanimous
+		})
+
+		It("should only trade when every child agrees", func() {
+			first.vote(1)
+			trade(100)
+			Expect(signals()).To(Equal([]float64{0}))
+
+			second.vote(0.5)
+			trade(100)
+			Expect(signals()).To(Equal([]float64{0, 0.75}))
+		})
+	})
+
+	It("should attribute P&L to children by their weighted votes", func() {
+		config.Children[1].Weight = 3
+		ensemble, err = algorithm.NewEnsemble(config)
+		Expect(err).ToNot(HaveOccurred())
+
+		trade(100)
+		first.vote(1)
+		second.vote(1)
+		trade(100)
+
+		position = decimal.NewFromInt(10)
+		trade(101)
+		pnl := ensemble.PnL()
+		Expect(pnl["first"]).To(BeNumerically("~", 2.5, 1e-9))
+		Expect(pnl["second"]).To(BeNumerically("~", 7.5, 1e-9))
+
+		first.vote(0)
+		second.vote(0)
+		trade(101)
+		trade(99)
+		Expect(ensemble.PnL()[""]).To(BeNumerically("~", -20, 1e-9))
+	})
+
+	Context("with children that consume bars and timers", func() {
+		var (
+			barChild   *internal.MockBarAlgorithm
+			timerChild *internal.MockTimerAlgorithm
+		)
+
+		BeforeEach(func() {
+			barChild = internal.NewMockBarAlgorithm(api.BarSpec{Type: api.TimeBars, Interval: time.Minute})
+			timerChild = internal.NewMockTimerAlgorithm(time.Minute)
+			config.Children = []algorithm.EnsembleChild{
+				{Name: "bars", Algorithm: barChild, Weight: 1},
+				{Name: "timer", Algorithm: timerChild, Weight: 1},
+			}
+		})
+
+		It("should ask for the children's bars and timer", func() {
+			Expect(ensemble.BarSpec()).To(Equal(api.BarSpec{Type: api.TimeBars, Interval: time.Minute}))
+			Expect(ensemble.TimerInterval()).To(Equal(time.Minute))
+		})
+
+		It("should pass bars and timers on to the children that want them", func() {
+			contextLog := logrus.NewEntry(logrus.StandardLogger())
+			ensemble.OnBar(api.BarContext{Router: router, Bar: api.Bar{Symbol: "VTI", Close: 101}, ContextLog: contextLog})
+			ensemble.OnTimer(api.TimerContext{Router: router, Now: start, ContextLog: contextLog})
+			Expect(barChild.OnBarCalls).To(Equal([]api.Bar{{Symbol: "VTI", Close: 101}}))
+			Expect(timerChild.Ticks).To(Equal([]time.Time{start}))
+		})
+
+		It("should reject children wanting different bars", func() {
+			config.Children = append(config.Children, algorithm.EnsembleChild{
+				Name:      "ticks",
+				Algorithm: internal.NewMockBarAlgorithm(api.BarSpec{Type: api.TickBars, Threshold: 10}),
+				Weight:    1,
+			})
+			_, err := algorithm.NewEnsemble(config)
+			Expect(err).To(MatchError("ensemble children bars and ticks must share one bar spec"))
+		})
+
+		It("should reject children wanting different timers", func() {
+			config.Children = append(config.Children, algorithm.EnsembleChild{
+				Name:      "hourly",
+				Algorithm: internal.NewMockTimerAlgorithm(time.Hour),
+				Weight:    1,
+			})
+			_, err := algorithm.NewEnsemble(config)
+			Expect(err).To(MatchError("ensemble children timer and hourly must share one timer interval"))
+		})
+	})
+
+	It("should ask for minute bars and timer when no child wants them", func() {
+		Expect(ensemble.BarSpec()).To(Equal(api.BarSpec{Type: api.TimeBars, Interval: time.Minute}))
+		Expect(ensemble.TimerInterval()).To(Equal(time.Minute))
+	})
+
+	Context("with a child that follows the lifecycle", func() {
+		var lifecycle *internal.MockLifecycleAlgorithm
+
+		BeforeEach(func() {
+			lifecycle = internal.NewMockLifecycleAlgorithm(api.HistorySpec{Interval: time.Minute, Bars: 2})
+			config.Children = append(config.Children, algorithm.EnsembleChild{Name: "lifecycle", Algorithm: lifecycle, Weight: 1})
+		})
+
+		It("should start, inform and stop the child", func() {
+			contextLog := logrus.NewEntry(logrus.StandardLogger())
+			Expect(internal.AddObjReturns("ListBars", map[string][]alpaca.Bar{
+				"VTI": {{Time: start.Unix(), Close: 100}},
+			})).To(Succeed())
+
+			Expect(ensemble.OnStart(api.StartContext{
+				Client:     internal.NewMockAlpacaClient(),
+				Router:     router,
+				Stocks:     map[string]api.StockInfo{"VTI": {Symbol: "VTI"}},
+				Now:        start,
+				ContextLog: contextLog,
+			})).To(Succeed())
+			Expect(lifecycle.Bars["VTI"]).To(HaveLen(1))
+
+			ensemble.OnQuote(api.QuoteContext{Router: router, Quote: alpaca.StreamQuote{Symbol: "VTI"}, ContextLog: contextLog})
+			ensemble.OnAggregate(api.AggregateContext{Router: router, Bar: api.Bar{Symbol: "VTI"}, ContextLog: contextLog})
+			ensemble.OnMarketOpen(api.MarketContext{Router: router, ContextLog: contextLog})
+			ensemble.OnMarketClose(api.MarketContext{Router: router, ContextLog: contextLog})
+			ensemble.OnStop(api.StopContext{Router: router, ContextLog: contextLog})
+			Expect(lifecycle.Quotes).To(HaveLen(1))
+			Expect(lifecycle.Aggregates).To(HaveLen(1))
+			Expect(lifecycle.Market).To(Equal([]bool{true, false}))
+			Expect(lifecycle.Stopped).To(BeTrue())
+		})
+
+		It("should reject a child wanting history Alpaca does not keep", func() {
+			lifecycle.Spec.Interval = 2 * time.Minute
+			_, err := algorithm.NewEnsemble(config)
+			Expect(err).To(MatchError("ensemble child lifecycle: no history for 2m0s bars"))
+		})
+	})
+
+	It("should reject a child that does not trade with intents", func() {
+		martingale, err := algorithm.NewMartingale()
+		Expect(err).ToNot(HaveOccurred())
+		config.Children[1] = algorithm.EnsembleChild{Name: "martingale", Algorithm: martingale, Weight: 1}
+		_, err = algorithm.NewEnsemble(config)
+		Expect(err).To(MatchError("ensemble child martingale does not trade with intents, so cannot vote"))
+	})
+
+	It("should reject children listed twice", func() {
+		config.Children[1].Name = "first"
+		_, err := algorithm.NewEnsemble(config)
+		Expect(err).To(MatchError("ensemble child first is listed more than once"))
+	})
+
+	It("should reject an unknown combination", func() {
+		config.Combine = "plurality"
+		_, err := algorithm.NewEnsemble(config)
+		Expect(err).To(MatchError(`unknown ensemble combination "plurality"`))
+	})
+})
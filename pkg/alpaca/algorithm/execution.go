@@ -0,0 +1,477 @@
+package algorithm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+var _ api.AlpacaAlgorithm = &Execution{}
+var _ api.BarHandler = &Execution{}
+var _ api.OrderUpdateHandler = &Execution{}
+var _ api.QuoteHandler = &Execution{}
+
+// scheduleRounding is the number of decimal places a scheduled
+// quantity is rounded to
+const scheduleRounding int32 = 6
+
+// Schedule selects how a parent order is spread over time
+type Schedule string
+
+const (
+	// TWAPSchedule trades evenly through time
+	TWAPSchedule Schedule = "twap"
+	// VWAPSchedule trades in line with the expected volume profile
+	VWAPSchedule Schedule = "vwap"
+)
+
+// DefaultVolumeProfile is a typical share of a US equity session's volume
+// traded in each half hour from the open to the close
+var DefaultVolumeProfile = []float64{
+	0.12, 0.08, 0.07, 0.06, 0.06, 0.05, 0.05, 0.05, 0.06, 0.06, 0.08, 0.10, 0.16,
+}
+
+var (
+	// sessionOpen is when the regular session opens, in New York
+	sessionOpen = 9*time.Hour + 30*time.Minute
+	// sessionBucket is the time each share of DefaultVolumeProfile covers
+	sessionBucket = 30 * time.Minute
+)
+
+// ExecutionConfig describes a parent order and how to work it
+type ExecutionConfig struct {
+	// Symbol is the stock the parent order is in. Market data and
+	// updates for any other stock are ignored.
+	Symbol   string
+	Side     alpaca.Side
+	Quantity decimal.Decimal
+	Start    time.Time
+	End      time.Time
+	Schedule Schedule
+	// VolumeProfile is the share of volume expected in each of a number
+	// of equal buckets spanning Start to End, for VWAP schedules. When
+	// empty, DefaultVolumeProfile is sliced to the window's time of day,
+	// which must then fall within one regular session.
+	VolumeProfile []float64
+	// SliceInterval is how often a child order is sent
+	SliceInterval time.Duration
+	// MaxParticipation caps each child order at a fraction of the volume
+	// that traded in the last slice. Zero leaves child orders uncapped.
+	MaxParticipation float64
+	// LimitOffset prices child orders this fraction past the last
+	// price, so they can cross the spread, when there is no quote
+	// from the current slice to price them at the far side of
+	LimitOffset float64
+}
+
+// DefaultExecutionConfig returns a VWAP schedule following the default
+// volume profile over the window, sliced every minute and taking at most
+// a tenth of the market's volume. The symbol, side, quantity and time
+// window must still be set.
+func DefaultExecutionConfig() ExecutionConfig {
+	return ExecutionConfig{
+		Schedule:         VWAPSchedule,
+		SliceInterval:    time.Minute,
+		MaxParticipation: 0.1,
+		LimitOffset:      0.001,
+	}
+}
+
+// ExecutionReport summarizes how well a parent order was worked. Slippage
+// is in basis points, and positive when we paid more (or sold for less)
+// than the benchmark.
+type ExecutionReport struct {
+	Side               alpaca.Side
+	Quantity           decimal.Decimal
+	Filled             decimal.Decimal
+	ArrivalPrice       float64
+	AveragePrice       float64
+	MarketVWAP         float64
+	ArrivalSlippageBps float64
+	VWAPSlippageBps    float64
+	Done               bool
+}
+
+// childOrder tracks one slice of the parent order
+type childOrder struct {
+	quantity decimal.Decimal
+	filled   decimal.Decimal
+	price    float64
+	open     bool
+	// canceling is set once we have asked for an open order to be canceled
+	canceling bool
+}
+
+// Execution works a large parent order as a series of small child limit
+// orders, following a TWAP or VWAP schedule. Each slice re-prices any
+// unfilled child order and sends whatever the schedule is behind by.
+type Execution struct {
+	config ExecutionConfig
+
+	// quote is the latest quote, if any
+	quote *alpaca.StreamQuote
+
+	arrivalPrice   float64
+	marketNotional float64
+	marketVolume   float64
+	children       map[string]*childOrder
+	done           bool
+}
+
+// NewExecution returns a new Execution algorithm
+func NewExecution(config ExecutionConfig) (*Execution, error) {
+	if config.Symbol == "" {
+		return nil, errors.New("execution requires a symbol")
+	}
+	if config.Side != alpaca.Buy && config.Side != alpaca.Sell {
+		return nil, fmt.Errorf("execution requires a buy or sell side, got %q", config.Side)
+	}
+	if !config.Quantity.IsPositive() {
+		return nil, errors.New("execution requires a positive quantity")
+	}
+	if config.Start.IsZero() || !config.End.After(config.Start) {
+		return nil, errors.New("execution requires a start time before its end time")
+	}
+	if config.SliceInterval <= 0 {
+		return nil, errors.New("execution requires a positive slice interval")
+	}
+	if config.MaxParticipation < 0 || config.MaxParticipation > 1 {
+		return nil, errors.New("execution participation must be between 0 and 1")
+	}
+	if config.LimitOffset < 0 {
+		return nil, errors.New("execution limit offset must not be negative")
+	}
+
+	switch config.Schedule {
+	case TWAPSchedule:
+	case VWAPSchedule:
+		if len(config.VolumeProfile) == 0 {
+			profile, err := sessionProfile(config.Start, config.End)
+			if err != nil {
+				return nil, err
+			}
+			config.VolumeProfile = profile
+		}
+		total := 0.0
+		for _, share := range config.VolumeProfile {
+			if share < 0 {
+				return nil, errors.New("execution volume profile must not be negative")
+			}
+			total += share
+		}
+		if total <= 0 {
+			return nil, errors.New("vwap execution requires a volume profile")
+		}
+	default:
+		return nil, fmt.Errorf("unknown execution schedule %q", config.Schedule)
+	}
+
+	return &Execution{
+		config:   config,
+		children: map[string]*childOrder{},
+	}, nil
+}
+
+// HandleStreamTrade implements the function on the AlpacaAlgorithm interface.
+// Execution works its order on bars rather than individual trades.
+func (c *Execution) HandleStreamTrade(context api.StreamTradeContext) {}
+
+// BarSpec implements the function on the BarHandler interface
+func (c *Execution) BarSpec() api.BarSpec {
+	return api.BarSpec{
+		Type:     api.TimeBars,
+		Interval: c.config.SliceInterval,
+	}
+}
+
+// OnBar implements the function on the BarHandler interface
+func (c *Execution) OnBar(context api.BarContext) {
+	bar := context.Bar
+
+	if bar.Symbol != c.config.Symbol || c.done || !bar.End.After(c.config.Start) {
+		return
+	}
+
+	if c.arrivalPrice == 0 {
+		c.arrivalPrice = bar.Open
+	}
+	c.marketNotional += bar.VWAP * bar.Volume
+	c.marketVolume += bar.Volume
+
+	if context.Backfill {
+		// Backfilled bars only count towards the market's volume
+		return
+	}
+
+	contextLog := context.ContextLog.WithFields(logrus.Fields{
+		"logger": "algorithm_execution",
+		"symbol": c.config.Symbol,
+		"side":   c.config.Side,
+	})
+
+	if !bar.End.Before(c.config.End) || c.Filled().GreaterThanOrEqual(c.config.Quantity) {
+		c.finish(context.Router, contextLog)
+		return
+	}
+
+	// Re-price child orders from earlier slices. They still count
+	// against the schedule until the cancel is confirmed, so we
+	// never send more than the parent order.
+	c.cancelOpenChildren(context.Router, contextLog)
+
+	outstanding := c.outstanding()
+	// Round away floating point noise in the schedule before it reaches a quantity
+	scheduled := c.config.Quantity.Mul(decimal.NewFromFloat(c.scheduledFraction(bar.End))).Round(scheduleRounding)
+	quantity := decimal.Min(scheduled, c.config.Quantity).Sub(outstanding)
+
+	if c.config.MaxParticipation > 0 {
+		quantity = decimal.Min(quantity, decimal.NewFromFloat(c.config.MaxParticipation*bar.Volume))
+	}
+
+	precision := int32(0)
+	if context.Stock.Asset.Fractionable {
+		precision = api.FractionalPrecision
+	}
+	quantity = quantity.Truncate(precision)
+
+	if !quantity.IsPositive() {
+		return
+	}
+
+	price := c.limitPrice(bar)
+
+	order, err := context.Router.PlaceLimitOrder(c.config.Symbol, c.config.Side, quantity, price)
+	if err != nil {
+		contextLog.Errorf("Failed to place child order: %v", err)
+		return
+	}
+
+	c.children[order.ID] = &childOrder{
+		quantity: quantity,
+		filled:   decimal.Zero,
+		open:     true,
+	}
+
+	contextLog.WithFields(logrus.Fields{
+		"order_id":  order.ID,
+		"quantity":  quantity,
+		"price":     price,
+		"scheduled": scheduled,
+		"filled":    c.Filled(),
+	}).Info("Sent child order")
+}
+
+// OnQuote implements the function on the QuoteHandler interface
+func (c *Execution) OnQuote(context api.QuoteContext) {
+	quote := context.Quote
+	if quote.Symbol != c.config.Symbol || quote.BidPrice <= 0 || quote.AskPrice < quote.BidPrice {
+		return
+	}
+	c.quote = &quote
+}
+
+// OnOrderUpdate implements the function on the OrderUpdateHandler interface
+func (c *Execution) OnOrderUpdate(context api.OrderUpdateContext) {
+	update := context.Update
+	if update.Order.Symbol != c.config.Symbol {
+		return
+	}
+	child, ok := c.children[update.Order.ID]
+	if !ok {
+		return
+	}
+
+	child.filled = update.Order.FilledQty
+	if update.Order.FilledAvgPrice != nil {
+		child.price, _ = update.Order.FilledAvgPrice.Float64()
+	}
+
+	switch update.Event {
+	case "fill", "canceled", "rejected", "expired", "done_for_day":
+		child.open = false
+	}
+
+	if !c.done && c.Filled().GreaterThanOrEqual(c.config.Quantity) {
+		c.finish(context.Router, context.ContextLog.WithFields(logrus.Fields{
+			"logger": "algorithm_execution",
+			"symbol": c.config.Symbol,
+			"side":   c.config.Side,
+		}))
+	}
+}
+
+// Filled returns how much of the parent order has filled
+func (c *Execution) Filled() decimal.Decimal {
+	filled := decimal.Zero
+	for _, child := range c.children {
+		filled = filled.Add(child.filled)
+	}
+	return filled
+}
+
+// Done reports whether the parent order has finished working
+func (c *Execution) Done() bool {
+	return c.done
+}
+
+// Report summarizes the execution so far
+func (c *Execution) Report() ExecutionReport {
+	report := ExecutionReport{
+		Side:         c.config.Side,
+		Quantity:     c.config.Quantity,
+		Filled:       c.Filled(),
+		ArrivalPrice: c.arrivalPrice,
+		Done:         c.done,
+	}
+
+	if c.marketVolume > 0 {
+		report.MarketVWAP = c.marketNotional / c.marketVolume
+	}
+
+	notional := 0.0
+	for _, child := range c.children {
+		filled, _ := child.filled.Float64()
+		notional += filled * child.price
+	}
+	if filled, _ := report.Filled.Float64(); filled > 0 {
+		report.AveragePrice = notional / filled
+		report.ArrivalSlippageBps = c.slippageBps(report.AveragePrice, report.ArrivalPrice)
+		report.VWAPSlippageBps = c.slippageBps(report.AveragePrice, report.MarketVWAP)
+	}
+
+	return report
+}
+
+// limitPrice prices a child order to cross the spread, at the far side of
+// a quote from the bar's slice, or past the bar's close without one
+func (c *Execution) limitPrice(bar api.Bar) float64 {
+	price := bar.Close * (1 + c.config.LimitOffset)
+	if c.config.Side == alpaca.Sell {
+		price = bar.Close * (1 - c.config.LimitOffset)
+	}
+
+	if c.quote != nil && !c.quote.Time().Before(bar.Start) {
+		price = float64(c.quote.AskPrice)
+		if c.config.Side == alpaca.Sell {
+			price = float64(c.quote.BidPrice)
+		}
+	}
+
+	return math.Round(price*100) / 100
+}
+
+// slippageBps compares a fill price to a benchmark, in our disfavour
+func (c *Execution) slippageBps(price, benchmark float64) float64 {
+	if benchmark == 0 {
+		return 0
+	}
+	slippage := (price - benchmark) / benchmark * 10000
+	if c.config.Side == alpaca.Sell {
+		slippage = -slippage
+	}
+	return slippage
+}
+
+// outstanding returns the quantity filled or still working
+func (c *Execution) outstanding() decimal.Decimal {
+	outstanding := decimal.Zero
+	for _, child := range c.children {
+		if child.open {
+			outstanding = outstanding.Add(child.quantity)
+		} else {
+			outstanding = outstanding.Add(child.filled)
+		}
+	}
+	return outstanding
+}
+
+// scheduledFraction returns how much of the parent order
+// should have been sent by the given time
+func (c *Execution) scheduledFraction(now time.Time) float64 {
+	elapsed := float64(now.Sub(c.config.Start)) / float64(c.config.End.Sub(c.config.Start))
+	elapsed = math.Max(0, math.Min(elapsed, 1))
+
+	if c.config.Schedule == TWAPSchedule {
+		return elapsed
+	}
+
+	profile := c.config.VolumeProfile
+	position := elapsed * float64(len(profile))
+	total, scheduled := 0.0, 0.0
+	for bucket, share := range profile {
+		total += share
+		scheduled += share * math.Max(0, math.Min(position-float64(bucket), 1))
+	}
+	return scheduled / total
+}
+
+// sessionProfile slices DefaultVolumeProfile to the part of the regular
+// session from start to end, as a bucket a minute
+func sessionProfile(start, end time.Time) ([]float64, error) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		return nil, err
+	}
+	local := start.In(newYork)
+	open := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, newYork).Add(sessionOpen)
+	close := open.Add(time.Duration(len(DefaultVolumeProfile)) * sessionBucket)
+	if start.Before(open) || end.After(close) {
+		return nil, errors.New("vwap execution outside the regular session requires a volume profile")
+	}
+
+	// traded is the share of the session's volume expected by the given time
+	traded := func(at time.Time) float64 {
+		elapsed := float64(at.Sub(open)) / float64(sessionBucket)
+		total := 0.0
+		for bucket, share := range DefaultVolumeProfile {
+			total += share * math.Max(0, math.Min(elapsed-float64(bucket), 1))
+		}
+		return total
+	}
+
+	buckets := int(math.Ceil(float64(end.Sub(start)) / float64(time.Minute)))
+	width := end.Sub(start) / time.Duration(buckets)
+	profile := make([]float64, buckets)
+	for bucket := range profile {
+		from := start.Add(time.Duration(bucket) * width)
+		profile[bucket] = traded(from.Add(width)) - traded(from)
+	}
+	return profile, nil
+}
+
+// cancelOpenChildren asks for any working child orders to be canceled
+func (c *Execution) cancelOpenChildren(router api.OrderRouter, contextLog *logrus.Entry) {
+	for orderID, child := range c.children {
+		if !child.open || child.canceling {
+			continue
+		}
+		if err := router.CancelOrder(orderID); err != nil {
+			contextLog.WithFields(logrus.Fields{"order_id": orderID}).Errorf("Failed to cancel child order: %v", err)
+			continue
+		}
+		child.canceling = true
+	}
+}
+
+// finish stops working the parent order and reports how it went
+func (c *Execution) finish(router api.OrderRouter, contextLog *logrus.Entry) {
+	c.done = true
+	c.cancelOpenChildren(router, contextLog)
+
+	report := c.Report()
+	contextLog.WithFields(logrus.Fields{
+		"quantity":             report.Quantity,
+		"filled":               report.Filled,
+		"arrival_price":        report.ArrivalPrice,
+		"average_price":        report.AveragePrice,
+		"market_vwap":          report.MarketVWAP,
+		"arrival_slippage_bps": math.Round(report.ArrivalSlippageBps*100) / 100,
+		"vwap_slippage_bps":    math.Round(report.VWAPSlippageBps*100) / 100,
+	}).Info("Finished working parent order")
+}
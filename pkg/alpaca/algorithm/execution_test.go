@@ -0,0 +1,291 @@
+package algorithm_test
+
+import (
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/algorithm"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/internal"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Execution", func() {
+	var (
+		execution *algorithm.Execution
+		config    algorithm.ExecutionConfig
+		router    *internal.MockOrderRouter
+		stockInfo api.StockInfo
+		stock     string = "MKL"
+		start     time.Time
+		bars      int
+		volume    float64
+		err       error
+	)
+
+	feed := func(closes ...float64) {
+		for _, price := range closes {
+			barStart := start.Add(time.Duration(bars) * time.Minute)
+			bars++
+			execution.OnBar(api.BarContext{
+				Router: router,
+				Stock:  stockInfo,
+				Bar: api.Bar{
+					Symbol: stock,
+					Start:  barStart,
+					End:    barStart.Add(time.Minute),
+					Open:   price,
+					High:   price,
+					Low:    price,
+					Close:  price,
+					Volume: volume,
+					VWAP:   price,
+				},
+				ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+			})
+		}
+	}
+
+	update := func(event, orderID string, filled, price float64) {
+		filledPrice := decimal.NewFromFloat(price)
+		execution.OnOrderUpdate(api.OrderUpdateContext{
+			Router: router,
+			Stock:  stockInfo,
+			Update: alpaca.TradeUpdate{
+				Event: event,
+				Order: alpaca.Order{
+					ID:             orderID,
+					Symbol:         stock,
+					FilledQty:      decimal.NewFromFloat(filled),
+					FilledAvgPrice: &filledPrice,
+				},
+			},
+			ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+		})
+	}
+
+	quantities := func() []string {
+		result := []string{}
+		for _, order := range router.Orders {
+			result = append(result, order.Qty.String())
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		router = internal.NewMockOrderRouter()
+		stockInfo = api.StockInfo{Symbol: stock, Asset: api.Asset{Fractionable: false}}
+		start = time.Date(2020, 11, 2, 14, 30, 0, 0, time.UTC)
+		bars = 0
+		volume = 100
+		config = algorithm.ExecutionConfig{
+			Symbol:        stock,
+			Side:          alpaca.Buy,
+			Quantity:      decimal.NewFromFloat(10),
+			Start:         start,
+			End:           start.Add(10 * time.Minute),
+			Schedule:      algorithm.TWAPSchedule,
+			SliceInterval: time.Minute,
+		}
+	})
+
+	JustBeforeEach(func() {
+		execution, err = algorithm.NewExecution(config)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Context("when following a TWAP schedule", func() {
+		JustBeforeEach(func() {
+			feed(10)
+			update("fill", "order1", 1, 10)
+			feed(10)
+		})
+		It("should send an even slice each interval", func() {
+			Expect(quantities()).To(Equal([]string{"1", "1"}))
+			Expect(router.Orders[0].Side).To(Equal(alpaca.Buy))
+			Expect(router.Orders[0].LimitPrice.String()).To(Equal("10"))
+			Expect(router.Cancels).To(BeEmpty())
+		})
+
+		Context("when a child order does not fill in its slice", func() {
+			JustBeforeEach(func() {
+				feed(10.5)
+			})
+			It("should re-price it without over-sending", func() {
+				Expect(router.Cancels).To(Equal([]string{"order2"}))
+				Expect(quantities()).To(Equal([]string{"1", "1", "1"}))
+			})
+
+			Context("when the cancel is confirmed", func() {
+				JustBeforeEach(func() {
+					update("canceled", "order2", 0, 0)
+					feed(10.5)
+				})
+				It("should catch up with the schedule", func() {
+					Expect(quantities()).To(Equal([]string{"1", "1", "1", "2"}))
+				})
+			})
+		})
+
+		Context("when the window ends", func() {
+			JustBeforeEach(func() {
+				update("fill", "order2", 1, 10.1)
+				feed(10, 10, 10, 10, 10, 10, 10, 10)
+			})
+			It("should stop and report slippage", func() {
+				Expect(execution.Done()).To(BeTrue())
+				report := execution.Report()
+				Expect(report.Filled.String()).To(Equal("2"))
+				Expect(report.ArrivalPrice).To(Equal(float64(10)))
+				Expect(report.AveragePrice).To(BeNumerically("~", 10.05, 1e-9))
+				Expect(report.ArrivalSlippageBps).To(BeNumerically("~", 50, 1e-6))
+				Expect(report.MarketVWAP).To(BeNumerically("~", 10, 1e-9))
+			})
+		})
+	})
+
+	Context("when following a VWAP schedule", func() {
+		BeforeEach(func() {
+			config.Schedule = algorithm.VWAPSchedule
+			config.VolumeProfile = []float64{3, 1}
+			stockInfo.Asset.Fractionable = true
+		})
+		JustBeforeEach(func() {
+			feed(10, 10, 10, 10, 10)
+		})
+		It("should front-load the heavier volume bucket", func() {
+			// By the middle of the window, 3/4 of the order is scheduled
+			Expect(quantities()).To(Equal([]string{"1.5", "1.5", "1.5", "1.5", "1.5"}))
+		})
+	})
+
+	Context("when following the default volume profile", func() {
+		BeforeEach(func() {
+			config.Schedule = algorithm.VWAPSchedule
+			stockInfo.Asset.Fractionable = true
+			// 9:45 to 10:15 in New York, across the first two half hours
+			start = start.Add(15 * time.Minute)
+			config.Start = start
+			config.End = start.Add(30 * time.Minute)
+		})
+		JustBeforeEach(func() {
+			feed(10)
+		})
+		It("should follow the volume expected in the window's time of day", func() {
+			// The first half hour trades 0.12 of the session and the
+			// second 0.08, so each of the window's first 15 minutes
+			// carries 0.004 of the 0.1 traded in the window
+			Expect(quantities()).To(Equal([]string{"0.4"}))
+		})
+	})
+
+	Context("when the default volume profile does not cover the window", func() {
+		It("should fail to create the algorithm", func() {
+			config.Schedule = algorithm.VWAPSchedule
+			config.Start = start.Add(-time.Hour)
+			_, err := algorithm.NewExecution(config)
+			Expect(err).To(MatchError("vwap execution outside the regular session requires a volume profile"))
+		})
+	})
+
+	Context("when participation is capped", func() {
+		BeforeEach(func() {
+			config.MaxParticipation = 0.1
+			volume = 5
+		})
+		JustBeforeEach(func() {
+			feed(10, 10)
+		})
+		It("should not send child orders larger than the cap", func() {
+			Expect(quantities()).To(BeEmpty())
+		})
+	})
+
+	Context("when selling", func() {
+		BeforeEach(func() {
+			config.Side = alpaca.Sell
+			config.LimitOffset = 0.01
+		})
+		JustBeforeEach(func() {
+			feed(10)
+		})
+		It("should price child orders below the last price", func() {
+			Expect(router.Orders[0].Side).To(Equal(alpaca.Sell))
+			Expect(router.Orders[0].LimitPrice.String()).To(Equal("9.9"))
+		})
+	})
+
+	Context("when quoted", func() {
+		quote := func(bid, ask float32, at time.Time) {
+			execution.OnQuote(api.QuoteContext{
+				Router:     router,
+				Stock:      stockInfo,
+				Quote:      alpaca.StreamQuote{Symbol: stock, BidPrice: bid, AskPrice: ask, Timestamp: at.UnixNano()},
+				ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+			})
+		}
+
+		BeforeEach(func() {
+			config.LimitOffset = 0.01
+		})
+
+		It("should price child orders at the far side of the spread", func() {
+			quote(9.95, 10.05, start.Add(30*time.Second))
+			feed(10)
+			Expect(router.Orders[0].LimitPrice.String()).To(Equal("10.05"))
+		})
+
+		It("should ignore quotes from before the slice", func() {
+			quote(9.95, 10.05, start.Add(-time.Second))
+			feed(10)
+			Expect(router.Orders[0].LimitPrice.String()).To(Equal("10.1"))
+		})
+
+		It("should ignore crossed quotes", func() {
+			quote(10.05, 9.95, start.Add(30*time.Second))
+			feed(10)
+			Expect(router.Orders[0].LimitPrice.String()).To(Equal("10.1"))
+		})
+	})
+
+	Context("when other stocks trade", func() {
+		JustBeforeEach(func() {
+			execution.OnQuote(api.QuoteContext{
+				Router:     router,
+				Quote:      alpaca.StreamQuote{Symbol: "VTI", BidPrice: 199.95, AskPrice: 200.05, Timestamp: start.UnixNano()},
+				ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+			})
+			execution.OnBar(api.BarContext{
+				Router:     router,
+				Stock:      api.StockInfo{Symbol: "VTI"},
+				Bar:        api.Bar{Symbol: "VTI", Start: start, End: start.Add(time.Minute), Close: 200, Volume: 100},
+				ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+			})
+			feed(10)
+		})
+		It("should only work the parent order in its own stock", func() {
+			Expect(router.Orders).To(HaveLen(1))
+			Expect(*router.Orders[0].AssetKey).To(Equal(stock))
+			Expect(router.Orders[0].LimitPrice.String()).To(Equal("10"))
+		})
+	})
+
+	Context("when the symbol is missing", func() {
+		It("should fail to create the algorithm", func() {
+			config.Symbol = ""
+			_, err := algorithm.NewExecution(config)
+			Expect(err).To(MatchError("execution requires a symbol"))
+		})
+	})
+
+	Context("when the window is invalid", func() {
+		It("should fail to create the algorithm", func() {
+			config.End = config.Start
+			_, err := algorithm.NewExecution(config)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
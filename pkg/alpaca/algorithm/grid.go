@@ -0,0 +1,246 @@
+package algorithm
+
+import (
+	"errors"
+	"math"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+var _ api.AlpacaAlgorithm = &Grid{}
+var _ api.OrderUpdateHandler = &Grid{}
+
+// GridConfig configures the grid strategy
+type GridConfig struct {
+	// Levels is the number of resting orders either side of the reference price
+	Levels int
+	// Spacing is the distance in dollars between levels
+	Spacing float64
+	// Quantity is the number of shares at each level
+	Quantity decimal.Decimal
+	// MaxInventory caps the position the grid can build up, counting
+	// every working order as if it fills
+	MaxInventory decimal.Decimal
+	// AllowShort lets sell orders take the position below zero
+	AllowShort bool
+}
+
+// DefaultGridConfig returns a long-only grid of five one share levels
+// a dime apart, holding at most ten shares
+func DefaultGridConfig() GridConfig {
+	return GridConfig{
+		Levels:       5,
+		Spacing:      0.10,
+		Quantity:     decimal.NewFromInt(1),
+		MaxInventory: decimal.NewFromInt(10),
+	}
+}
+
+// gridOrder is a resting order placed by the grid
+type gridOrder struct {
+	side      alpaca.Side
+	price     float64
+	remaining decimal.Decimal
+	// canceling is set once the order has been asked to cancel
+	canceling bool
+}
+
+// Grid rests a ladder of buy limits below a reference price and sell
+// limits above it. Each fill is replaced by an order on the opposite side
+// one level away, capturing the spacing as price oscillates. When price
+// leaves the grid it is rebuilt around the new price. Each stock has its
+// own grid.
+type Grid struct {
+	config GridConfig
+	stocks map[string]*gridState
+}
+
+// gridState is the grid working in one stock
+type gridState struct {
+	// reference is the price the grid is centered on, or zero before the first trade
+	reference float64
+	orders    map[string]*gridOrder
+}
+
+// NewGrid returns a new Grid algorithm
+func NewGrid(config GridConfig) (*Grid, error) {
+	if config.Levels <= 0 {
+		return nil, errors.New("grid requires at least one level")
+	}
+	if config.Spacing <= 0 {
+		return nil, errors.New("grid requires a positive spacing")
+	}
+	if !config.Quantity.IsPositive() {
+		return nil, errors.New("grid requires a positive quantity per level")
+	}
+	if config.MaxInventory.LessThan(config.Quantity) {
+		return nil, errors.New("grid inventory cap must hold at least one level")
+	}
+
+	return &Grid{
+		config: config,
+		stocks: map[string]*gridState{},
+	}, nil
+}
+
+// stock returns the grid for a stock, starting it if it is new
+func (c *Grid) stock(symbol string) *gridState {
+	state, ok := c.stocks[symbol]
+	if !ok {
+		state = &gridState{orders: map[string]*gridOrder{}}
+		c.stocks[symbol] = state
+	}
+	return state
+}
+
+// HandleStreamTrade implements the function on the AlpacaAlgorithm interface
+func (c *Grid) HandleStreamTrade(context api.StreamTradeContext) {
+	if context.Backfill {
+		// The grid is only placed around live prices
+		return
+	}
+
+	price := float64(context.Trade.Price)
+	state := c.stock(context.Trade.Symbol)
+	contextLog := context.ContextLog.WithFields(logrus.Fields{
+		"logger":    "algorithm_grid",
+		"reference": state.reference,
+	})
+
+	width := float64(c.config.Levels) * c.config.Spacing
+	if state.reference != 0 && math.Abs(price-state.reference) <= width {
+		return
+	}
+
+	if state.reference != 0 {
+		contextLog.Info("Price left the grid, recentering")
+		state.cancelAll(context.Router, contextLog)
+	}
+
+	state.reference = price
+	for level := 1; level <= c.config.Levels; level++ {
+		offset := float64(level) * c.config.Spacing
+		c.place(context.Router, state, context.Stock, context.Trade.Symbol, alpaca.Buy, price-offset, contextLog)
+		c.place(context.Router, state, context.Stock, context.Trade.Symbol, alpaca.Sell, price+offset, contextLog)
+	}
+}
+
+// OnOrderUpdate implements the function on the OrderUpdateHandler interface
+func (c *Grid) OnOrderUpdate(context api.OrderUpdateContext) {
+	update := context.Update
+	state, ok := c.stocks[update.Order.Symbol]
+	if !ok {
+		return
+	}
+	order, ok := state.orders[update.Order.ID]
+	if !ok {
+		return
+	}
+
+	switch update.Event {
+	case "partial_fill":
+		order.remaining = update.Order.Qty.Sub(update.Order.FilledQty)
+	case "fill":
+		delete(state.orders, update.Order.ID)
+		if order.canceling {
+			// Filled while recentering, so there is no level to replenish
+			return
+		}
+
+		contextLog := context.ContextLog.WithFields(logrus.Fields{
+			"logger": "algorithm_grid",
+			"side":   order.side,
+			"price":  order.price,
+		})
+		contextLog.Info("Grid level filled, replenishing the opposite side")
+
+		if order.side == alpaca.Buy {
+			c.place(context.Router, state, context.Stock, update.Order.Symbol, alpaca.Sell, order.price+c.config.Spacing, contextLog)
+		} else {
+			c.place(context.Router, state, context.Stock, update.Order.Symbol, alpaca.Buy, order.price-c.config.Spacing, contextLog)
+		}
+	case "canceled", "rejected", "expired":
+		delete(state.orders, update.Order.ID)
+	}
+}
+
+// WorkingOrders returns the number of orders the grid has working
+// across every stock
+func (c *Grid) WorkingOrders() int {
+	count := 0
+	for _, state := range c.stocks {
+		count += len(state.orders)
+	}
+	return count
+}
+
+// place rests an order at a level, unless it could take
+// our inventory beyond the cap
+func (c *Grid) place(router api.OrderRouter, state *gridState, stock api.StockInfo, symbol string, side alpaca.Side, price float64, contextLog *logrus.Entry) {
+	price = math.Round(price*100) / 100
+	if price <= 0 {
+		return
+	}
+
+	floor := decimal.Zero
+	if c.config.AllowShort {
+		floor = c.config.MaxInventory.Neg()
+	}
+
+	worstCase := stock.Position.Add(state.workingQuantity(side))
+	if side == alpaca.Buy {
+		if worstCase.Add(c.config.Quantity).GreaterThan(c.config.MaxInventory) {
+			contextLog.Debug("Skipping buy level at inventory cap")
+			return
+		}
+	} else if worstCase.Sub(c.config.Quantity).LessThan(floor) {
+		contextLog.Debug("Skipping sell level at inventory floor")
+		return
+	}
+
+	order, err := router.PlaceLimitOrder(symbol, side, c.config.Quantity, price)
+	if err != nil {
+		contextLog.WithFields(logrus.Fields{"side": side, "price": price}).Errorf("Failed to place grid order: %v", err)
+		return
+	}
+
+	state.orders[order.ID] = &gridOrder{
+		side:      side,
+		price:     price,
+		remaining: c.config.Quantity,
+	}
+}
+
+// workingQuantity returns the signed quantity left to fill across our
+// working orders on one side, including any still being canceled
+func (s *gridState) workingQuantity(side alpaca.Side) decimal.Decimal {
+	quantity := decimal.Zero
+	for _, order := range s.orders {
+		if order.side != side {
+			continue
+		}
+		if side == alpaca.Sell {
+			quantity = quantity.Sub(order.remaining)
+		} else {
+			quantity = quantity.Add(order.remaining)
+		}
+	}
+	return quantity
+}
+
+// cancelAll asks for every working grid order to be canceled
+func (s *gridState) cancelAll(router api.OrderRouter, contextLog *logrus.Entry) {
+	for orderID, order := range s.orders {
+		if order.canceling {
+			continue
+		}
+		if err := router.CancelOrder(orderID); err != nil {
+			contextLog.WithFields(logrus.Fields{"order_id": orderID}).Errorf("Failed to cancel grid order: %v", err)
+			continue
+		}
+		order.canceling = true
+	}
+}
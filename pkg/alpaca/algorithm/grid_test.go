@@ -0,0 +1,160 @@
+package algorithm_test
+
+import (
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/algorithm"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/internal"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Grid", func() {
+	var (
+		grid     *algorithm.Grid
+		config   algorithm.GridConfig
+		router   *internal.MockOrderRouter
+		position float64
+		stock    string = "MKL"
+		err      error
+	)
+
+	stockInfo := func() api.StockInfo {
+		return api.StockInfo{Symbol: stock, Position: decimal.NewFromFloat(position)}
+	}
+
+	trade := func(price float32) {
+		grid.HandleStreamTrade(api.StreamTradeContext{
+			Router:     router,
+			Stock:      stockInfo(),
+			Trade:      alpaca.StreamTrade{Symbol: stock, Price: price, Size: 100},
+			ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+		})
+	}
+
+	update := func(event, orderID string) {
+		grid.OnOrderUpdate(api.OrderUpdateContext{
+			Router:     router,
+			Stock:      stockInfo(),
+			Update:     alpaca.TradeUpdate{Event: event, Order: alpaca.Order{ID: orderID, Symbol: stock}},
+			ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+		})
+	}
+
+	orders := func() []string {
+		result := []string{}
+		for _, order := range router.Orders {
+			result = append(result, string(order.Side)+" "+order.LimitPrice.String())
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		router = internal.NewMockOrderRouter()
+		position = 0
+		config = algorithm.GridConfig{
+			Levels:       2,
+			Spacing:      0.5,
+			Quantity:     decimal.NewFromInt(1),
+			MaxInventory: decimal.NewFromInt(3),
+		}
+	})
+
+	JustBeforeEach(func() {
+		grid, err = algorithm.NewGrid(config)
+		Expect(err).ToNot(HaveOccurred())
+		trade(10)
+	})
+
+	It("should ladder buys below the first price without going short", func() {
+		Expect(orders()).To(Equal([]string{"buy 9.5", "buy 9"}))
+		Expect(grid.WorkingOrders()).To(Equal(2))
+	})
+
+	Context("when shorting is allowed", func() {
+		BeforeEach(func() {
+			config.AllowShort = true
+		})
+		It("should ladder sells above the first price", func() {
+			Expect(orders()).To(Equal([]string{"buy 9.5", "sell 10.5", "buy 9", "sell 11"}))
+		})
+	})
+
+	Context("when a buy level fills", func() {
+		JustBeforeEach(func() {
+			position = 1
+			update("fill", "order1")
+		})
+		It("should replenish with a sell one level up", func() {
+			Expect(orders()).To(Equal([]string{"buy 9.5", "buy 9", "sell 10"}))
+		})
+
+		Context("when the sell fills", func() {
+			JustBeforeEach(func() {
+				position = 0
+				update("fill", "order3")
+			})
+			It("should replenish the buy", func() {
+				Expect(orders()).To(Equal([]string{"buy 9.5", "buy 9", "sell 10", "buy 9.5"}))
+				Expect(grid.WorkingOrders()).To(Equal(2))
+			})
+		})
+	})
+
+	Context("when the inventory cap is reached", func() {
+		BeforeEach(func() {
+			config.MaxInventory = decimal.NewFromInt(1)
+		})
+		It("should only rest as many buys as the cap allows", func() {
+			Expect(orders()).To(Equal([]string{"buy 9.5"}))
+		})
+	})
+
+	Context("when price leaves the grid", func() {
+		JustBeforeEach(func() {
+			trade(11.5)
+		})
+		It("should cancel the old grid and recenter within the cap", func() {
+			Expect(router.Cancels).To(ConsistOf("order1", "order2"))
+			Expect(orders()).To(Equal([]string{"buy 9.5", "buy 9", "buy 11"}))
+		})
+
+		Context("when the cancels are confirmed", func() {
+			JustBeforeEach(func() {
+				update("canceled", "order1")
+				update("canceled", "order2")
+			})
+			It("should only track the new grid", func() {
+				Expect(grid.WorkingOrders()).To(Equal(1))
+			})
+		})
+	})
+
+	Context("when another stock trades", func() {
+		JustBeforeEach(func() {
+			grid.HandleStreamTrade(api.StreamTradeContext{
+				Router:     router,
+				Stock:      api.StockInfo{Symbol: "VTI"},
+				Trade:      alpaca.StreamTrade{Symbol: "VTI", Price: 200, Size: 100},
+				ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+			})
+		})
+		It("should build it a grid of its own", func() {
+			Expect(router.Cancels).To(BeEmpty())
+			Expect(orders()).To(Equal([]string{"buy 9.5", "buy 9", "buy 199.5", "buy 199"}))
+			Expect(grid.WorkingOrders()).To(Equal(4))
+		})
+	})
+
+	Context("when price moves within the grid", func() {
+		JustBeforeEach(func() {
+			trade(10.75)
+		})
+		It("should leave the grid in place", func() {
+			Expect(router.Cancels).To(BeEmpty())
+			Expect(orders()).To(HaveLen(2))
+		})
+	})
+})
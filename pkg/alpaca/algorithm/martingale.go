@@ -11,51 +11,46 @@ import (
 )
 
 var _ api.AlpacaAlgorithm = &Martingale{}
+var _ api.BarHandler = &Martingale{}
 
 // Martingale implements the martingale system for tracking a stock
 type Martingale struct {
-	tickSize      int
-	tickIndex     int
-	lastPrice     float64
-	lastTradeTime time.Time
+	barInterval time.Duration
+	// lastPrices holds the last bar close of each stock
+	lastPrices map[string]float64
 }
 
 // NewMartingale returns a new Martingale algorithm
 func NewMartingale() (*Martingale, error) {
 	return &Martingale{
-		tickSize:      5,
-		tickIndex:     -1,
-		lastPrice:     0,
-		lastTradeTime: time.Now().UTC(),
+		barInterval: 5 * time.Second,
+		lastPrices:  map[string]float64{},
 	}, nil
 }
 
-// HandleStreamTrade implements the function on the AlpacaAlgorithm interface
-func (c *Martingale) HandleStreamTrade(context api.StreamTradeContext) {
-	now := time.Now().UTC()
-	if now.Sub(c.lastTradeTime) < time.Second {
-		// don't react every tick unless at least 1 second past
-		return
-	}
-
-	c.lastTradeTime = now
-
-	// Increment or cycle the tick index
-	c.tickIndex = (c.tickIndex + 1) % c.tickSize
-
-	// Only process every n ticks
-	if c.tickIndex == 0 {
-		// It's time to update
+// HandleStreamTrade implements the function on the AlpacaAlgorithm interface.
+// Martingale reacts to bars rather than individual trades.
+func (c *Martingale) HandleStreamTrade(context api.StreamTradeContext) {}
 
-		// Update price info
-		previousPrice := c.lastPrice
-		newPrice := float64(context.Trade.Price)
-		c.lastPrice = newPrice
-
-		context.ContextLog.WithFields(logrus.Fields{
-			"logger":         "algorithm_martingale",
-			"previous_price": math.Round(previousPrice*100) / 100,
-			"tick_price":     math.Round(newPrice*100) / 100,
-		}).Info("Handling stream trade")
+// BarSpec implements the function on the BarHandler interface
+func (c *Martingale) BarSpec() api.BarSpec {
+	return api.BarSpec{
+		Type:     api.TimeBars,
+		Interval: c.barInterval,
 	}
 }
+
+// OnBar implements the function on the BarHandler interface
+func (c *Martingale) OnBar(context api.BarContext) {
+	// Update price info
+	previousPrice := c.lastPrices[context.Bar.Symbol]
+	newPrice := context.Bar.Close
+	c.lastPrices[context.Bar.Symbol] = newPrice
+
+	context.ContextLog.WithFields(logrus.Fields{
+		"logger":         "algorithm_martingale",
+		"symbol":         context.Bar.Symbol,
+		"previous_price": math.Round(previousPrice*100) / 100,
+		"bar_price":      math.Round(newPrice*100) / 100,
+	}).Info("Handling bar")
+}
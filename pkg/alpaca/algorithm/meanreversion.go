@@ -0,0 +1,241 @@
+package algorithm
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/indicators"
+	"github.com/sirupsen/logrus"
+)
+
+var _ api.AlpacaAlgorithm = &MeanReversion{}
+var _ api.BarHandler = &MeanReversion{}
+var _ api.IntentTrader = &MeanReversion{}
+
+// MeanReversionConfig configures the mean reversion strategy
+type MeanReversionConfig struct {
+	// Lookback is the number of bars in the rolling mean and deviation
+	Lookback    int
+	BarInterval time.Duration
+	// EntryZ is how many standard deviations from the mean price
+	// must stretch before we take a position
+	EntryZ float64
+	// ExitZ is the z-score, on our side of the mean, at which we close.
+	// Zero exits at the mean itself.
+	ExitZ float64
+	// ScaleInStep is how many further standard deviations price must
+	// stretch before each additional scale-in
+	ScaleInStep float64
+	// MaxScaleIns caps the scale-ins after the initial entry. Each
+	// entry adds an equal share of the full signal.
+	MaxScaleIns int
+	// MaxHoldingPeriod closes a position that has not reverted in time.
+	// Zero holds until price reverts.
+	MaxHoldingPeriod time.Duration
+	// AllowShort fades stretches above the mean as well as below
+	AllowShort bool
+}
+
+// DefaultMeanReversionConfig returns a long-only strategy entering at two
+// standard deviations from a 20 bar mean of one minute bars
+func DefaultMeanReversionConfig() MeanReversionConfig {
+	return MeanReversionConfig{
+		Lookback:         20,
+		BarInterval:      time.Minute,
+		EntryZ:           2,
+		ScaleInStep:      0.5,
+		MaxScaleIns:      2,
+		MaxHoldingPeriod: time.Hour,
+	}
+}
+
+// MeanReversion fades moves away from a rolling mean, scaling in as price
+// stretches further and closing once it reverts or has been held too long.
+// Each stock has its own mean and position.
+type MeanReversion struct {
+	config MeanReversionConfig
+	stocks map[string]*meanReversionState
+}
+
+// meanReversionState is the rolling mean and position for one stock
+type meanReversionState struct {
+	zScore *indicators.ZScore
+
+	// direction is 1 when long, -1 when short and 0 when flat
+	direction float64
+	// entries is how many times we have entered the current position
+	entries int
+	// enteredAt is when the current position was first entered
+	enteredAt time.Time
+	// cooldown holds off new positions after a time stop, until
+	// price has come back inside the entry band
+	cooldown bool
+}
+
+// NewMeanReversion returns a new MeanReversion algorithm
+func NewMeanReversion(config MeanReversionConfig) (*MeanReversion, error) {
+	if config.Lookback < 2 {
+		return nil, errors.New("mean reversion requires a lookback of at least two bars")
+	}
+	if config.BarInterval <= 0 {
+		return nil, errors.New("mean reversion requires a positive bar interval")
+	}
+	if config.EntryZ <= 0 || config.ExitZ >= config.EntryZ {
+		return nil, errors.New("mean reversion requires a positive entry z-score above the exit z-score")
+	}
+	if config.MaxScaleIns < 0 || (config.MaxScaleIns > 0 && config.ScaleInStep <= 0) {
+		return nil, errors.New("mean reversion scale-ins require a positive step")
+	}
+
+	return &MeanReversion{
+		config: config,
+		stocks: map[string]*meanReversionState{},
+	}, nil
+}
+
+// stock returns the state for a stock, starting it if it is new
+func (c *MeanReversion) stock(symbol string) *meanReversionState {
+	state, ok := c.stocks[symbol]
+	if !ok {
+		state = &meanReversionState{zScore: indicators.NewZScore(c.config.Lookback)}
+		c.stocks[symbol] = state
+	}
+	return state
+}
+
+// TradesWithIntents implements the function on the IntentTrader interface
+func (c *MeanReversion) TradesWithIntents() {}
+
+// HandleStreamTrade implements the function on the AlpacaAlgorithm interface.
+// MeanReversion reacts to bars rather than individual trades.
+func (c *MeanReversion) HandleStreamTrade(context api.StreamTradeContext) {}
+
+// BarSpec implements the function on the BarHandler interface
+func (c *MeanReversion) BarSpec() api.BarSpec {
+	return api.BarSpec{
+		Type:     api.TimeBars,
+		Interval: c.config.BarInterval,
+	}
+}
+
+// OnBar implements the function on the BarHandler interface
+func (c *MeanReversion) OnBar(context api.BarContext) {
+	bar := context.Bar
+	state := c.stock(bar.Symbol)
+
+	state.zScore.UpdateBar(bar)
+	if !state.zScore.Ready() || context.Backfill {
+		// Backfilled bars only catch the mean up
+		return
+	}
+
+	z := state.zScore.Value()
+	contextLog := context.ContextLog.WithFields(logrus.Fields{
+		"logger": "algorithm_mean_reversion",
+		"mean":   state.zScore.Mean(),
+		"z":      z,
+	})
+
+	if state.direction == 0 {
+		c.handleFlat(context, state, contextLog, z)
+		return
+	}
+
+	// Distance from the mean on the side we are betting on
+	stretch := -state.direction * z
+
+	switch {
+	case stretch <= c.config.ExitZ:
+		contextLog.Info("Price reverted to the mean")
+		c.exit(context, state, contextLog)
+	case c.config.MaxHoldingPeriod > 0 && !bar.End.Before(state.enteredAt.Add(c.config.MaxHoldingPeriod)):
+		contextLog.Info("Position held too long without reverting")
+		if c.exit(context, state, contextLog) {
+			state.cooldown = true
+		}
+	default:
+		if entries := c.entriesFor(stretch); entries > state.entries {
+			contextLog.Info("Scaling in further from the mean")
+			if c.submit(context, state, contextLog, state.direction, entries) {
+				state.entries = entries
+			}
+		}
+	}
+}
+
+// handleFlat decides whether to open a position
+func (c *MeanReversion) handleFlat(context api.BarContext, state *meanReversionState, contextLog *logrus.Entry, z float64) {
+	if state.cooldown {
+		if math.Abs(z) >= c.config.EntryZ {
+			return
+		}
+		state.cooldown = false
+	}
+
+	var direction float64
+	switch {
+	case z <= -c.config.EntryZ:
+		direction = 1
+	case z >= c.config.EntryZ && c.config.AllowShort:
+		direction = -1
+	default:
+		return
+	}
+
+	entries := c.entriesFor(math.Abs(z))
+	contextLog.Info("Price stretched beyond the entry band")
+	if c.submit(context, state, contextLog, direction, entries) {
+		state.direction = direction
+		state.entries = entries
+		state.enteredAt = context.Bar.End
+	}
+}
+
+// entriesFor returns how many entries a stretch from the mean calls for
+func (c *MeanReversion) entriesFor(stretch float64) int {
+	if stretch < c.config.EntryZ {
+		return 0
+	}
+	entries := 1
+	if c.config.MaxScaleIns > 0 {
+		scaleIns := int(math.Floor((stretch - c.config.EntryZ) / c.config.ScaleInStep))
+		entries += minInt(scaleIns, c.config.MaxScaleIns)
+	}
+	return entries
+}
+
+// exit closes the current position, reporting whether the controller
+// accepted it. A refused exit is tried again on the next bar.
+func (c *MeanReversion) exit(context api.BarContext, state *meanReversionState, contextLog *logrus.Entry) bool {
+	if !c.submit(context, state, contextLog, 0, 0) {
+		return false
+	}
+	state.direction = 0
+	state.entries = 0
+	return true
+}
+
+// submit sends the signal for a position to the controller, reporting
+// whether it was accepted. The position is only changed by the caller
+// once it is, so a refused signal is sent again on a later bar.
+func (c *MeanReversion) submit(context api.BarContext, state *meanReversionState, contextLog *logrus.Entry, direction float64, entries int) bool {
+	signal := direction * float64(entries) / float64(c.config.MaxScaleIns+1)
+
+	intent := api.SignalIntent(context.Bar.Symbol, signal, context.Bar.Close)
+	intent.Volatility = state.zScore.StdDev()
+
+	if _, err := context.Router.SubmitIntent(intent); err != nil {
+		contextLog.Errorf("Failed to submit intent: %v", err)
+		return false
+	}
+	return true
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,163 @@
+package algorithm_test
+
+import (
+	"errors"
+	"time"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/algorithm"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/internal"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("MeanReversion", func() {
+	var (
+		meanReversion *algorithm.MeanReversion
+		config        algorithm.MeanReversionConfig
+		router        *internal.MockOrderRouter
+		stock         string = "MKL"
+		start         time.Time
+		bars          int
+		err           error
+	)
+
+	feed := func(closes ...float64) {
+		for _, price := range closes {
+			barStart := start.Add(time.Duration(bars) * time.Minute)
+			bars++
+			meanReversion.OnBar(api.BarContext{
+				Router: router,
+				Bar: api.Bar{
+					Symbol: stock,
+					Start:  barStart,
+					End:    barStart.Add(time.Minute),
+					Open:   price,
+					High:   price,
+					Low:    price,
+					Close:  price,
+				},
+				ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+			})
+		}
+	}
+
+	signals := func() []float64 {
+		result := []float64{}
+		for _, intent := range router.Intents {
+			result = append(result, *intent.Signal)
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		router = internal.NewMockOrderRouter()
+		start = time.Date(2020, 11, 2, 14, 30, 0, 0, time.UTC)
+		bars = 0
+		config = algorithm.MeanReversionConfig{
+			Lookback:    5,
+			BarInterval: time.Minute,
+			EntryZ:      1.5,
+			ScaleInStep: 0.3,
+			MaxScaleIns: 1,
+		}
+	})
+
+	JustBeforeEach(func() {
+		meanReversion, err = algorithm.NewMeanReversion(config)
+		Expect(err).ToNot(HaveOccurred())
+		feed(10, 10.1, 9.9, 10, 10.05)
+	})
+
+	It("should not trade while price stays near the mean", func() {
+		Expect(router.Intents).To(BeEmpty())
+	})
+
+	Context("when price falls beyond the entry band", func() {
+		JustBeforeEach(func() {
+			// z-scores of -1.77 then -1.90
+			feed(9.7, 9.0)
+		})
+		It("should enter and then scale in", func() {
+			Expect(signals()).To(Equal([]float64{0.5, 1}))
+			Expect(router.Intents[0].Volatility).To(BeNumerically(">", 0))
+		})
+
+		Context("when price reverts above the mean", func() {
+			JustBeforeEach(func() {
+				// z-scores of -0.19 then 1.17
+				feed(9.6, 10.2)
+			})
+			It("should only exit once the mean is reached", func() {
+				Expect(signals()).To(Equal([]float64{0.5, 1, 0}))
+			})
+		})
+
+		Context("when the controller refuses the exit", func() {
+			JustBeforeEach(func() {
+				router.Err = errors.New("boom")
+				feed(9.6, 10.2)
+				router.Err = nil
+			})
+			It("should exit on the next bar", func() {
+				Expect(signals()).To(Equal([]float64{0.5, 1}))
+				feed(10.3)
+				Expect(signals()).To(Equal([]float64{0.5, 1, 0}))
+			})
+		})
+	})
+
+	Context("when another stock trades far from the mean", func() {
+		JustBeforeEach(func() {
+			meanReversion.OnBar(api.BarContext{
+				Router:     router,
+				Bar:        api.Bar{Symbol: "VTI", Open: 200, High: 200, Low: 200, Close: 200},
+				ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+			})
+			feed(10)
+		})
+		It("should keep each stock's mean apart", func() {
+			Expect(router.Intents).To(BeEmpty())
+		})
+	})
+
+	Context("when price does not revert within the holding period", func() {
+		BeforeEach(func() {
+			config.MaxHoldingPeriod = 3 * time.Minute
+		})
+		JustBeforeEach(func() {
+			feed(9.7, 9.6, 9.5, 9.4, 9.3, 9.2)
+		})
+		It("should exit on the time stop and not re-enter inside the band", func() {
+			Expect(signals()).To(Equal([]float64{0.5, 0}))
+		})
+	})
+
+	Context("when price rises beyond the entry band", func() {
+		JustBeforeEach(func() {
+			// z-score of 1.97
+			feed(11)
+		})
+		It("should stay flat when shorting is not allowed", func() {
+			Expect(router.Intents).To(BeEmpty())
+		})
+
+		Context("when shorting is allowed", func() {
+			BeforeEach(func() {
+				config.AllowShort = true
+			})
+			It("should go short", func() {
+				Expect(signals()).To(Equal([]float64{-1}))
+			})
+		})
+	})
+
+	Context("when the exit band is beyond the entry band", func() {
+		It("should fail to create the algorithm", func() {
+			config.ExitZ = 2
+			_, err := algorithm.NewMeanReversion(config)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
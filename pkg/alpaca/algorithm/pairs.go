@@ -0,0 +1,320 @@
+package algorithm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/indicators"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+var _ api.AlpacaAlgorithm = &Pairs{}
+var _ api.StartHandler = &Pairs{}
+var _ api.BarHandler = &Pairs{}
+var _ api.OrderUpdateHandler = &Pairs{}
+
+// PairsConfig configures the pairs trading strategy
+type PairsConfig struct {
+	// SymbolA and SymbolB are the two legs. The spread is long A and short B.
+	SymbolA     string
+	SymbolB     string
+	Lookback    int
+	BarInterval time.Duration
+	// EntryZ is how many standard deviations the spread must
+	// stretch before we trade it
+	EntryZ float64
+	// ExitZ is the z-score, on our side of the mean, at which we close
+	ExitZ float64
+	// Notional is the dollar value of the A leg. The B leg is sized
+	// by the hedge ratio.
+	Notional float64
+	// LegTimeout is the number of bars to wait for every leg to fill.
+	// After that, working legs are canceled and, once the cancels are
+	// confirmed, filled legs unwound.
+	LegTimeout int
+}
+
+// DefaultPairsConfig returns a VTI/VOO pair on one minute bars, entering
+// at two standard deviations from a 60 bar mean with $1000 a leg.
+// Both stocks must be traded by the controller.
+func DefaultPairsConfig() PairsConfig {
+	return PairsConfig{
+		SymbolA:     "VTI",
+		SymbolB:     "VOO",
+		Lookback:    60,
+		BarInterval: time.Minute,
+		EntryZ:      2,
+		ExitZ:       0,
+		Notional:    1000,
+		LegTimeout:  2,
+	}
+}
+
+// pairLeg tracks the order sent for one leg of the pair
+type pairLeg struct {
+	symbol    string
+	filled    bool
+	failed    bool
+	canceling bool
+}
+
+// Pairs trades the spread between two related stocks. A rolling regression
+// of log prices gives the hedge ratio, and the spread is entered when its
+// z-score stretches and closed once it reverts. Both legs are sent together,
+// and if one fills without the other the position is unwound.
+type Pairs struct {
+	config     PairsConfig
+	regression *indicators.Regression
+	zScore     *indicators.ZScore
+
+	// latest holds the most recent bar for each symbol, so bars
+	// for the same interval can be paired up
+	latest map[string]api.Bar
+	// lastEnd is the end of the last interval we acted on
+	lastEnd time.Time
+
+	// direction is 1 when long the spread, -1 when short and 0 when flat
+	direction float64
+	// legs are the orders sent for the last change of position, by order ID
+	legs map[string]*pairLeg
+	// legBars counts the bars since legs were sent
+	legBars int
+}
+
+// NewPairs returns a new Pairs algorithm
+func NewPairs(config PairsConfig) (*Pairs, error) {
+	if config.SymbolA == "" || config.SymbolB == "" || config.SymbolA == config.SymbolB {
+		return nil, errors.New("pairs requires two different symbols")
+	}
+	if config.Lookback < 2 {
+		return nil, errors.New("pairs requires a lookback of at least two bars")
+	}
+	if config.BarInterval <= 0 {
+		return nil, errors.New("pairs requires a positive bar interval")
+	}
+	if config.EntryZ <= 0 || config.ExitZ >= config.EntryZ {
+		return nil, errors.New("pairs requires a positive entry z-score above the exit z-score")
+	}
+	if config.Notional <= 0 {
+		return nil, errors.New("pairs requires a positive notional")
+	}
+	if config.LegTimeout <= 0 {
+		return nil, errors.New("pairs requires a positive leg timeout")
+	}
+
+	return &Pairs{
+		config:     config,
+		regression: indicators.NewRegression(config.Lookback),
+		zScore:     indicators.NewZScore(config.Lookback),
+		latest:     map[string]api.Bar{},
+		legs:       map[string]*pairLeg{},
+	}, nil
+}
+
+// History implements the function on the StartHandler interface.
+// Pairs needs no history.
+func (c *Pairs) History() api.HistorySpec {
+	return api.HistorySpec{}
+}
+
+// OnStart implements the function on the StartHandler interface, failing
+// when either leg is not a stock the controller trades, since its bars
+// would never arrive
+func (c *Pairs) OnStart(context api.StartContext) error {
+	for _, symbol := range []string{c.config.SymbolA, c.config.SymbolB} {
+		if _, ok := context.Stocks[symbol]; !ok {
+			return fmt.Errorf("pairs symbol %s is not a traded stock", symbol)
+		}
+	}
+	return nil
+}
+
+// HandleStreamTrade implements the function on the AlpacaAlgorithm interface.
+// Pairs reacts to bars rather than individual trades.
+func (c *Pairs) HandleStreamTrade(context api.StreamTradeContext) {}
+
+// BarSpec implements the function on the BarHandler interface. Gaps are
+// filled so that both legs have a bar for every interval.
+func (c *Pairs) BarSpec() api.BarSpec {
+	return api.BarSpec{
+		Type:     api.TimeBars,
+		Interval: c.config.BarInterval,
+		FillGaps: true,
+	}
+}
+
+// OnBar implements the function on the BarHandler interface
+func (c *Pairs) OnBar(context api.BarContext) {
+	bar := context.Bar
+	if bar.Symbol != c.config.SymbolA && bar.Symbol != c.config.SymbolB {
+		return
+	}
+	c.latest[bar.Symbol] = bar
+
+	barA, okA := c.latest[c.config.SymbolA]
+	barB, okB := c.latest[c.config.SymbolB]
+	if !okA || !okB || !barA.End.Equal(barB.End) || !barA.End.After(c.lastEnd) {
+		// Wait for the other leg's bar for this interval
+		return
+	}
+	c.lastEnd = barA.End
+
+	contextLog := context.ContextLog.WithFields(logrus.Fields{
+		"logger": "algorithm_pairs",
+		"pair":   c.config.SymbolA + "/" + c.config.SymbolB,
+	})
+
+	if barA.Close <= 0 || barB.Close <= 0 {
+		return
+	}
+
+	logA, logB := math.Log(barA.Close), math.Log(barB.Close)
+	c.regression.Update(logB, logA)
+	hedgeRatio := c.regression.Slope()
+	c.zScore.Update(logA - hedgeRatio*logB)
+
+	if context.Backfill {
+		// Backfilled bars only catch the spread up
+		return
+	}
+
+	if c.checkLegs(context.Router, contextLog) {
+		return
+	}
+
+	if !c.regression.Ready() || !c.zScore.Ready() {
+		return
+	}
+
+	z := c.zScore.Value()
+	contextLog = contextLog.WithFields(logrus.Fields{
+		"hedge_ratio": hedgeRatio,
+		"z":           z,
+	})
+
+	switch {
+	case c.direction == 0 && z <= -c.config.EntryZ && hedgeRatio > 0:
+		contextLog.Info("Spread is cheap, buying the spread")
+		c.enter(context.Router, contextLog, 1, hedgeRatio, barA.Close, barB.Close)
+	case c.direction == 0 && z >= c.config.EntryZ && hedgeRatio > 0:
+		contextLog.Info("Spread is rich, selling the spread")
+		c.enter(context.Router, contextLog, -1, hedgeRatio, barA.Close, barB.Close)
+	case c.direction != 0 && -c.direction*z <= c.config.ExitZ:
+		contextLog.Info("Spread reverted, closing both legs")
+		c.exit(context.Router, contextLog, barA.Close, barB.Close)
+	}
+}
+
+// OnOrderUpdate implements the function on the OrderUpdateHandler interface
+func (c *Pairs) OnOrderUpdate(context api.OrderUpdateContext) {
+	leg, ok := c.legs[context.Update.Order.ID]
+	if !ok {
+		return
+	}
+
+	switch context.Update.Event {
+	case "fill":
+		leg.filled = true
+	case "canceled", "rejected", "expired":
+		leg.failed = true
+	}
+}
+
+// checkLegs deals with legs that have not all filled, canceling the ones
+// still working and unwinding the pair once every leg has finished. It
+// reports whether the legs are still being worked.
+func (c *Pairs) checkLegs(router api.OrderRouter, contextLog *logrus.Entry) bool {
+	if len(c.legs) == 0 {
+		return false
+	}
+
+	filled, failed := 0, 0
+	for _, leg := range c.legs {
+		if leg.filled {
+			filled++
+		}
+		if leg.failed {
+			failed++
+		}
+	}
+
+	if filled == len(c.legs) {
+		c.legs = map[string]*pairLeg{}
+		return false
+	}
+
+	if filled+failed == len(c.legs) {
+		contextLog.WithFields(logrus.Fields{
+			"filled_legs": filled,
+			"failed_legs": failed,
+		}).Warn("Legs did not all fill, unwinding the pair")
+
+		latestA, latestB := c.latest[c.config.SymbolA], c.latest[c.config.SymbolB]
+		c.exit(router, contextLog, latestA.Close, latestB.Close)
+		return true
+	}
+
+	c.legBars++
+	if failed == 0 && c.legBars <= c.config.LegTimeout {
+		return true
+	}
+
+	// The unwind waits for the cancels to be confirmed, since a
+	// working leg could still fill and change what needs closing
+	for orderID, leg := range c.legs {
+		if leg.filled || leg.failed || leg.canceling {
+			continue
+		}
+		legLog := contextLog.WithFields(logrus.Fields{"order_id": orderID, "symbol": leg.symbol})
+		if err := router.CancelOrder(orderID); err != nil {
+			legLog.Errorf("Failed to cancel leg: %v", err)
+			continue
+		}
+		legLog.Warn("Leg did not fill, canceling it")
+		leg.canceling = true
+	}
+	return true
+}
+
+// enter sends both legs of a new spread position
+func (c *Pairs) enter(router api.OrderRouter, contextLog *logrus.Entry, direction, hedgeRatio, priceA, priceB float64) {
+	notionalA := decimal.NewFromFloat(direction * c.config.Notional)
+	notionalB := decimal.NewFromFloat(-direction * hedgeRatio * c.config.Notional)
+
+	if c.submit(router, contextLog,
+		api.NotionalIntent(c.config.SymbolA, notionalA, priceA),
+		api.NotionalIntent(c.config.SymbolB, notionalB, priceB),
+	) {
+		c.direction = direction
+	}
+}
+
+// exit closes both legs
+func (c *Pairs) exit(router api.OrderRouter, contextLog *logrus.Entry, priceA, priceB float64) {
+	c.direction = 0
+	c.submit(router, contextLog,
+		api.TargetIntent(c.config.SymbolA, decimal.Zero, priceA),
+		api.TargetIntent(c.config.SymbolB, decimal.Zero, priceB),
+	)
+}
+
+// submit sends the legs together and starts tracking their orders
+func (c *Pairs) submit(router api.OrderRouter, contextLog *logrus.Entry, intents ...api.OrderIntent) bool {
+	c.legs = map[string]*pairLeg{}
+	c.legBars = 0
+
+	orders, err := router.SubmitIntents(intents...)
+	if err != nil {
+		contextLog.Errorf("Failed to submit legs: %v", err)
+		return false
+	}
+
+	for _, order := range orders {
+		c.legs[order.ID] = &pairLeg{symbol: order.Symbol}
+	}
+	return true
+}
@@ -0,0 +1,203 @@
+package algorithm_test
+
+import (
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/algorithm"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/internal"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Pairs", func() {
+	var (
+		pairs  *algorithm.Pairs
+		config algorithm.PairsConfig
+		router *internal.MockOrderRouter
+		start  time.Time
+		bars   int
+		err    error
+	)
+
+	bar := func(symbol string, price float64) {
+		barStart := start.Add(time.Duration(bars) * time.Minute)
+		pairs.OnBar(api.BarContext{
+			Router: router,
+			Bar: api.Bar{
+				Symbol: symbol,
+				Start:  barStart,
+				End:    barStart.Add(time.Minute),
+				Open:   price,
+				High:   price,
+				Low:    price,
+				Close:  price,
+			},
+			ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+		})
+	}
+
+	feed := func(prices ...[2]float64) {
+		for _, pair := range prices {
+			bar("AAA", pair[0])
+			bar("BBB", pair[1])
+			bars++
+		}
+	}
+
+	update := func(event, orderID string) {
+		pairs.OnOrderUpdate(api.OrderUpdateContext{
+			Router:     router,
+			Update:     alpaca.TradeUpdate{Event: event, Order: alpaca.Order{ID: orderID}},
+			ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+		})
+	}
+
+	BeforeEach(func() {
+		router = internal.NewMockOrderRouter()
+		start = time.Date(2020, 11, 2, 14, 30, 0, 0, time.UTC)
+		bars = 0
+		config = algorithm.PairsConfig{
+			SymbolA:     "AAA",
+			SymbolB:     "BBB",
+			Lookback:    5,
+			BarInterval: time.Minute,
+			EntryZ:      1.5,
+			Notional:    1000,
+			LegTimeout:  1,
+		}
+	})
+
+	JustBeforeEach(func() {
+		pairs, err = algorithm.NewPairs(config)
+		Expect(err).ToNot(HaveOccurred())
+		feed(
+			[2]float64{50, 100},
+			[2]float64{50.5, 101},
+			[2]float64{50.25, 100.5},
+			[2]float64{50.75, 101.5},
+			[2]float64{51, 102},
+			[2]float64{50.5, 101},
+			[2]float64{51, 102},
+			// AAA jumps relative to BBB, but the hedge ratio jumps
+			// further, leaving the spread at a z-score of -2
+			[2]float64{52.5, 103},
+		)
+	})
+
+	It("should buy the spread with both legs together", func() {
+		Expect(router.Intents).To(HaveLen(2))
+		Expect(router.Intents[0].Symbol).To(Equal("AAA"))
+		Expect(router.Intents[0].Notional.String()).To(Equal("1000"))
+		Expect(router.Intents[1].Symbol).To(Equal("BBB"))
+		Expect(router.Intents[1].Notional.IsNegative()).To(BeTrue())
+	})
+
+	It("should start when both legs are traded", func() {
+		Expect(pairs.OnStart(api.StartContext{
+			Stocks:     map[string]api.StockInfo{"AAA": {}, "BBB": {}},
+			ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+		})).To(Succeed())
+	})
+
+	It("should refuse to start when a leg is not traded", func() {
+		Expect(pairs.OnStart(api.StartContext{
+			Stocks:     map[string]api.StockInfo{"AAA": {}},
+			ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+		})).To(MatchError("pairs symbol BBB is not a traded stock"))
+	})
+
+	Context("when both legs fill and the spread reverts", func() {
+		JustBeforeEach(func() {
+			update("fill", "intent1")
+			update("fill", "intent2")
+			feed([2]float64{51.3, 102.5}, [2]float64{51.8, 103.5})
+		})
+		It("should close both legs", func() {
+			Expect(router.Intents).To(HaveLen(4))
+			Expect(router.Intents[2].Symbol).To(Equal("AAA"))
+			Expect(router.Intents[2].Target.IsZero()).To(BeTrue())
+			Expect(router.Intents[3].Symbol).To(Equal("BBB"))
+			Expect(router.Intents[3].Target.IsZero()).To(BeTrue())
+			Expect(router.Cancels).To(BeEmpty())
+		})
+	})
+
+	Context("when one leg fills and the other does not", func() {
+		JustBeforeEach(func() {
+			update("fill", "intent1")
+			feed([2]float64{51.3, 102.5})
+		})
+		It("should wait for the leg timeout", func() {
+			Expect(router.Intents).To(HaveLen(2))
+		})
+
+		Context("when the timeout passes", func() {
+			JustBeforeEach(func() {
+				feed([2]float64{51.3, 102.5})
+			})
+			It("should cancel the working leg and wait for the cancel", func() {
+				Expect(router.Cancels).To(Equal([]string{"intent2"}))
+				Expect(router.Intents).To(HaveLen(2))
+				feed([2]float64{51.3, 102.5})
+				Expect(router.Cancels).To(HaveLen(1))
+				Expect(router.Intents).To(HaveLen(2))
+			})
+
+			Context("when the cancel is confirmed", func() {
+				JustBeforeEach(func() {
+					update("canceled", "intent2")
+					feed([2]float64{51.3, 102.5})
+				})
+				It("should unwind the filled leg", func() {
+					Expect(router.Intents).To(HaveLen(4))
+					Expect(router.Intents[2].Target.IsZero()).To(BeTrue())
+					Expect(router.Intents[3].Target.IsZero()).To(BeTrue())
+				})
+			})
+
+			Context("when the working leg fills before it is canceled", func() {
+				JustBeforeEach(func() {
+					update("fill", "intent2")
+					feed([2]float64{51.3, 102.5})
+				})
+				It("should keep the pair", func() {
+					Expect(router.Intents).To(HaveLen(2))
+				})
+			})
+		})
+	})
+
+	Context("when a leg is rejected while the other is working", func() {
+		JustBeforeEach(func() {
+			update("rejected", "intent1")
+			feed([2]float64{51.3, 102.5})
+		})
+		It("should cancel the working leg before unwinding", func() {
+			Expect(router.Cancels).To(Equal([]string{"intent2"}))
+			Expect(router.Intents).To(HaveLen(2))
+		})
+	})
+
+	Context("when a leg is rejected", func() {
+		JustBeforeEach(func() {
+			update("fill", "intent1")
+			update("rejected", "intent2")
+			feed([2]float64{51.3, 102.5})
+		})
+		It("should unwind straight away", func() {
+			Expect(router.Cancels).To(BeEmpty())
+			Expect(router.Intents).To(HaveLen(4))
+		})
+	})
+
+	Context("when the symbols are the same", func() {
+		It("should fail to create the algorithm", func() {
+			config.SymbolB = config.SymbolA
+			_, err := algorithm.NewPairs(config)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,295 @@
+package algorithm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+var _ api.AlpacaAlgorithm = &Rebalance{}
+var _ api.StartHandler = &Rebalance{}
+var _ api.TimerHandler = &Rebalance{}
+var _ api.OrderUpdateHandler = &Rebalance{}
+
+// RebalanceConfig configures the portfolio rebalancing strategy
+type RebalanceConfig struct {
+	// Weights is the share of the portfolio to hold in each stock
+	Weights map[string]float64
+	// CheckInterval is how often drift is checked
+	CheckInterval time.Duration
+	// Interval rebalances on a fixed schedule. Zero only
+	// rebalances on drift.
+	Interval time.Duration
+	// DriftThreshold rebalances once any stock's weight is this far
+	// from its target. Zero only rebalances on the schedule.
+	DriftThreshold float64
+	// CashBuffer is the share of equity kept out of the portfolio
+	CashBuffer float64
+	// MinOrderValue skips trades worth less than this many dollars
+	MinOrderValue float64
+}
+
+// DefaultRebalanceConfig returns a 60/40 VTI/BND portfolio, checked every
+// minute and rebalanced once a weight drifts by five percentage points.
+// Both stocks must be traded by the controller.
+func DefaultRebalanceConfig() RebalanceConfig {
+	return RebalanceConfig{
+		Weights:        map[string]float64{"VTI": 0.6, "BND": 0.4},
+		CheckInterval:  time.Minute,
+		DriftThreshold: 0.05,
+		CashBuffer:     0.02,
+		MinOrderValue:  10,
+	}
+}
+
+// Rebalance holds a portfolio at target weights, trading only the
+// difference between each holding and its target when the portfolio
+// drifts too far or the rebalancing schedule comes round. Overweight
+// stocks are sold first, and underweight stocks are bought on a later
+// check once the sales have finished, with only the cash on hand.
+type Rebalance struct {
+	config RebalanceConfig
+
+	// prices holds the last trade price of each stock, for
+	// stocks we do not yet hold
+	prices map[string]float64
+	// lastRebalance is when we last rebalanced
+	lastRebalance time.Time
+	// working holds the IDs of rebalancing orders that have not finished
+	working map[string]bool
+	// buysPending is set when sales were sent ahead of buys
+	buysPending bool
+}
+
+// NewRebalance returns a new Rebalance algorithm
+func NewRebalance(config RebalanceConfig) (*Rebalance, error) {
+	if len(config.Weights) == 0 {
+		return nil, errors.New("rebalance requires at least one target weight")
+	}
+	total := 0.0
+	for symbol, weight := range config.Weights {
+		if weight < 0 {
+			return nil, fmt.Errorf("rebalance weight for %s must not be negative", symbol)
+		}
+		total += weight
+	}
+	if total > 1+1e-9 {
+		return nil, fmt.Errorf("rebalance weights add up to %v, more than 1", total)
+	}
+	if config.CheckInterval <= 0 {
+		return nil, errors.New("rebalance requires a positive check interval")
+	}
+	if config.Interval <= 0 && config.DriftThreshold <= 0 {
+		return nil, errors.New("rebalance requires a schedule or a drift threshold")
+	}
+	if config.CashBuffer < 0 || config.CashBuffer >= 1 {
+		return nil, errors.New("rebalance cash buffer must be between 0 and 1")
+	}
+
+	return &Rebalance{
+		config:  config,
+		prices:  map[string]float64{},
+		working: map[string]bool{},
+	}, nil
+}
+
+// History implements the function on the StartHandler interface.
+// Rebalance needs no history.
+func (c *Rebalance) History() api.HistorySpec {
+	return api.HistorySpec{}
+}
+
+// OnStart implements the function on the StartHandler interface, failing
+// when a weighted stock is not one the controller trades, since it could
+// never be priced or bought
+func (c *Rebalance) OnStart(context api.StartContext) error {
+	for _, symbol := range c.symbols() {
+		if _, ok := context.Stocks[symbol]; !ok {
+			return fmt.Errorf("rebalance weight for %s is not a traded stock", symbol)
+		}
+	}
+	return nil
+}
+
+// HandleStreamTrade implements the function on the AlpacaAlgorithm interface
+func (c *Rebalance) HandleStreamTrade(context api.StreamTradeContext) {
+	c.prices[context.Trade.Symbol] = float64(context.Trade.Price)
+}
+
+// TimerInterval implements the function on the TimerHandler interface
+func (c *Rebalance) TimerInterval() time.Duration {
+	return c.config.CheckInterval
+}
+
+// OnTimer implements the function on the TimerHandler interface
+func (c *Rebalance) OnTimer(context api.TimerContext) {
+	contextLog := context.ContextLog.WithFields(logrus.Fields{
+		"logger": "algorithm_rebalance",
+	})
+
+	if len(c.working) > 0 {
+		contextLog.WithFields(logrus.Fields{"orders": len(c.working)}).Debug("Waiting for rebalancing orders to finish")
+		return
+	}
+
+	positions, err := context.Client.ListPositions()
+	if err != nil {
+		contextLog.Errorf("Failed to list positions: %v", err)
+		return
+	}
+
+	values := map[string]float64{}
+	for _, position := range positions {
+		if _, ok := c.config.Weights[position.Symbol]; !ok {
+			continue
+		}
+		values[position.Symbol], _ = position.MarketValue.Float64()
+		if price, _ := position.CurrentPrice.Float64(); price > 0 {
+			c.prices[position.Symbol] = price
+		}
+	}
+
+	investable := context.Account.Equity * (1 - c.config.CashBuffer)
+	if investable <= 0 {
+		contextLog.Warn("No equity to rebalance")
+		return
+	}
+
+	maxDrift := 0.0
+	for symbol, weight := range c.config.Weights {
+		maxDrift = math.Max(maxDrift, math.Abs(values[symbol]/investable-weight))
+	}
+
+	scheduled := c.config.Interval > 0 && !context.Now.Before(c.lastRebalance.Add(c.config.Interval))
+	drifted := c.config.DriftThreshold > 0 && maxDrift >= c.config.DriftThreshold
+	if !scheduled && !drifted && !c.buysPending {
+		return
+	}
+
+	contextLog = contextLog.WithFields(logrus.Fields{
+		"max_drift": math.Round(maxDrift*10000) / 10000,
+		"scheduled": scheduled,
+	})
+
+	sells, buys := c.trades(values, investable, contextLog)
+	if !c.buysPending {
+		c.lastRebalance = context.Now
+	}
+	c.buysPending = false
+
+	if len(sells) > 0 {
+		contextLog.Info("Selling overweight stocks before buying")
+		c.buysPending = c.submit(context.Router, sells, contextLog) && len(buys) > 0
+		return
+	}
+
+	// Only cash outside the buffer may be spent
+	cash := context.Account.Cash - context.Account.Equity*c.config.CashBuffer
+	intents := c.affordable(buys, values, cash, contextLog)
+	if len(intents) == 0 {
+		contextLog.Debug("Portfolio is within minimum order size of its targets")
+		return
+	}
+
+	contextLog.Info("Buying underweight stocks")
+	c.submit(context.Router, intents, contextLog)
+}
+
+// OnOrderUpdate implements the function on the OrderUpdateHandler interface
+func (c *Rebalance) OnOrderUpdate(context api.OrderUpdateContext) {
+	switch context.Update.Event {
+	case "fill", "canceled", "rejected", "expired":
+		delete(c.working, context.Update.Order.ID)
+	}
+}
+
+// submit sends rebalancing trades and waits on their orders, reporting
+// whether they were sent
+func (c *Rebalance) submit(router api.OrderRouter, intents []api.OrderIntent, contextLog *logrus.Entry) bool {
+	orders, err := router.SubmitIntents(intents...)
+	if err != nil {
+		contextLog.Errorf("Failed to submit rebalancing trades: %v", err)
+		return false
+	}
+
+	for _, order := range orders {
+		c.working[order.ID] = true
+	}
+	return true
+}
+
+// trades returns an intent to sell each overweight stock, and the dollar
+// amount to buy of each underweight stock, that is far enough from its
+// target to be worth trading
+func (c *Rebalance) trades(values map[string]float64, investable float64, contextLog *logrus.Entry) ([]api.OrderIntent, map[string]float64) {
+	sells, buys := []api.OrderIntent{}, map[string]float64{}
+
+	for _, symbol := range c.symbols() {
+		target := c.config.Weights[symbol] * investable
+		delta := target - values[symbol]
+		if math.Abs(delta) < c.config.MinOrderValue || delta == 0 {
+			continue
+		}
+
+		price, ok := c.prices[symbol]
+		if !ok {
+			contextLog.WithFields(logrus.Fields{"symbol": symbol}).Warn("No price yet, skipping stock")
+			continue
+		}
+
+		if delta < 0 {
+			sells = append(sells, api.NotionalIntent(symbol, decimal.NewFromFloat(target), price))
+		} else {
+			buys[symbol] = delta
+		}
+	}
+
+	return sells, buys
+}
+
+// affordable returns an intent for each buy, scaled down so that
+// together they spend no more than the given cash
+func (c *Rebalance) affordable(buys map[string]float64, values map[string]float64, cash float64, contextLog *logrus.Entry) []api.OrderIntent {
+	intents := []api.OrderIntent{}
+
+	spend := 0.0
+	for _, delta := range buys {
+		spend += delta
+	}
+
+	scale := 1.0
+	if spend > cash {
+		scale = math.Max(cash, 0) / spend
+		contextLog.WithFields(logrus.Fields{
+			"cash":  cash,
+			"spend": spend,
+		}).Warn("Not enough cash for every buy, scaling them down")
+	}
+
+	for _, symbol := range c.symbols() {
+		delta, ok := buys[symbol]
+		if !ok || delta*scale < c.config.MinOrderValue {
+			continue
+		}
+		target := values[symbol] + delta*scale
+		intents = append(intents, api.NotionalIntent(symbol, decimal.NewFromFloat(target), c.prices[symbol]))
+	}
+
+	return intents
+}
+
+// symbols returns the weighted stocks in order
+func (c *Rebalance) symbols() []string {
+	symbols := make([]string, 0, len(c.config.Weights))
+	for symbol := range c.config.Weights {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
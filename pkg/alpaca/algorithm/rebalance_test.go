@@ -0,0 +1,237 @@
+package algorithm_test
+
+import (
+	"errors"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/algorithm"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/internal"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Rebalance", func() {
+	var (
+		rebalance *algorithm.Rebalance
+		config    algorithm.RebalanceConfig
+		router    *internal.MockOrderRouter
+		now       time.Time
+		err       error
+	)
+
+	position := func(symbol string, value, price float64) alpaca.Position {
+		return alpaca.Position{
+			Symbol:       symbol,
+			MarketValue:  decimal.NewFromFloat(value),
+			CurrentPrice: decimal.NewFromFloat(price),
+		}
+	}
+
+	check := func(account api.AccountInfo) {
+		rebalance.OnTimer(api.TimerContext{
+			Client:     internal.NewMockAlpacaClient(),
+			Router:     router,
+			Account:    account,
+			Now:        now,
+			ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+		})
+		now = now.Add(time.Minute)
+	}
+
+	// tick checks the portfolio, holding whatever equity is not
+	// in the positions as cash
+	tick := func(equity float64, positions ...alpaca.Position) {
+		cash := equity
+		for _, position := range positions {
+			value, _ := position.MarketValue.Float64()
+			cash -= value
+		}
+
+		Expect(internal.AddObjReturns("ListPositions", positions)).To(Succeed())
+		check(api.AccountInfo{Equity: equity, Cash: cash})
+	}
+
+	fill := func(orderID string) {
+		rebalance.OnOrderUpdate(api.OrderUpdateContext{
+			Router:     router,
+			Update:     alpaca.TradeUpdate{Event: "fill", Order: alpaca.Order{ID: orderID}},
+			ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+		})
+	}
+
+	notional := func(intent api.OrderIntent) float64 {
+		Expect(intent.Notional).ToNot(BeNil())
+		value, _ := intent.Notional.Float64()
+		return value
+	}
+
+	BeforeEach(func() {
+		router = internal.NewMockOrderRouter()
+		now = time.Date(2020, 11, 2, 14, 30, 0, 0, time.UTC)
+		config = algorithm.RebalanceConfig{
+			Weights:        map[string]float64{"VTI": 0.6, "BND": 0.4},
+			CheckInterval:  time.Minute,
+			DriftThreshold: 0.05,
+			CashBuffer:     0,
+			MinOrderValue:  10,
+		}
+	})
+
+	JustBeforeEach(func() {
+		rebalance, err = algorithm.NewRebalance(config)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should check on its configured interval", func() {
+		Expect(rebalance.TimerInterval()).To(Equal(time.Minute))
+	})
+
+	It("should start when every weighted stock is traded", func() {
+		Expect(rebalance.OnStart(api.StartContext{
+			Stocks:     map[string]api.StockInfo{"VTI": {}, "BND": {}},
+			ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+		})).To(Succeed())
+	})
+
+	It("should refuse to start when a weighted stock is not traded", func() {
+		Expect(rebalance.OnStart(api.StartContext{
+			Stocks:     map[string]api.StockInfo{"VTI": {}},
+			ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+		})).To(MatchError("rebalance weight for BND is not a traded stock"))
+	})
+
+	It("should leave a portfolio within its drift threshold alone", func() {
+		tick(1000, position("VTI", 620, 100), position("BND", 380, 50))
+		Expect(router.Intents).To(BeEmpty())
+	})
+
+	It("should sell the overweight stock before buying the underweight one", func() {
+		tick(1000, position("VTI", 700, 100), position("BND", 300, 50))
+		Expect(router.Intents).To(HaveLen(1))
+		Expect(router.Intents[0].Symbol).To(Equal("VTI"))
+		Expect(notional(router.Intents[0])).To(BeNumerically("~", 600, 1e-9))
+		Expect(router.Intents[0].LimitPrice).To(Equal(100.0))
+
+		// Positions are not listed while the sale is working
+		check(api.AccountInfo{Equity: 1000})
+		Expect(router.Intents).To(HaveLen(1), "waits for the sale to finish")
+
+		fill("intent1")
+		tick(1000, position("VTI", 600, 100), position("BND", 300, 50))
+		Expect(router.Intents).To(HaveLen(2))
+		Expect(router.Intents[1].Symbol).To(Equal("BND"))
+		Expect(notional(router.Intents[1])).To(BeNumerically("~", 400, 1e-9))
+	})
+
+	It("should only buy with the cash on hand", func() {
+		// BND is $100 under its target, but a stock outside the
+		// portfolio leaves only $50 of cash
+		tick(1000, position("VTI", 600, 100), position("BND", 300, 50), position("SPY", 50, 500))
+		Expect(router.Intents).To(HaveLen(1))
+		Expect(router.Intents[0].Symbol).To(Equal("BND"))
+		Expect(notional(router.Intents[0])).To(BeNumerically("~", 350, 1e-9))
+	})
+
+	Context("with a large minimum order", func() {
+		BeforeEach(func() {
+			config.MinOrderValue = 150
+		})
+
+		It("should skip trades below the minimum order value", func() {
+			// VTI is 100 over target and BND 100 under, but VTI's 10
+			// point drift still triggers a rebalance
+			tick(1000, position("VTI", 700, 100), position("BND", 300, 50))
+			Expect(router.Intents).To(BeEmpty())
+		})
+	})
+
+	Context("with a cash buffer", func() {
+		BeforeEach(func() {
+			config.CashBuffer = 0.1
+		})
+
+		It("should only invest equity outside the buffer", func() {
+			rebalance.HandleStreamTrade(api.StreamTradeContext{
+				Trade: alpaca.StreamTrade{Symbol: "VTI", Price: 100},
+			})
+			rebalance.HandleStreamTrade(api.StreamTradeContext{
+				Trade: alpaca.StreamTrade{Symbol: "BND", Price: 50},
+			})
+			tick(1000)
+			Expect(router.Intents).To(HaveLen(2))
+			Expect(router.Intents[0].Symbol).To(Equal("BND"))
+			Expect(notional(router.Intents[0])).To(BeNumerically("~", 360, 1e-9))
+			Expect(router.Intents[1].Symbol).To(Equal("VTI"))
+			Expect(notional(router.Intents[1])).To(BeNumerically("~", 540, 1e-9))
+		})
+
+		It("should skip stocks it has no price for", func() {
+			tick(1000)
+			Expect(router.Intents).To(BeEmpty())
+		})
+	})
+
+	Context("on a schedule", func() {
+		BeforeEach(func() {
+			config.DriftThreshold = 0
+			config.Interval = 5 * time.Minute
+		})
+
+		It("should rebalance once per interval regardless of drift", func() {
+			tick(1000, position("VTI", 600, 100), position("BND", 380, 50))
+			Expect(router.Intents).To(HaveLen(1))
+			fill("intent1")
+
+			for i := 0; i < 4; i++ {
+				tick(1000, position("VTI", 600, 100), position("BND", 380, 50))
+			}
+			Expect(router.Intents).To(HaveLen(1))
+
+			tick(1000, position("VTI", 600, 100), position("BND", 380, 50))
+			Expect(router.Intents).To(HaveLen(2))
+		})
+
+		It("should buy between intervals once its sales finish", func() {
+			tick(1000, position("VTI", 700, 100), position("BND", 300, 50))
+			Expect(router.Intents).To(HaveLen(1))
+			Expect(router.Intents[0].Symbol).To(Equal("VTI"))
+
+			fill("intent1")
+			tick(1000, position("VTI", 600, 100), position("BND", 300, 50))
+			Expect(router.Intents).To(HaveLen(2))
+			Expect(router.Intents[1].Symbol).To(Equal("BND"))
+
+			fill("intent2")
+			tick(1000, position("VTI", 600, 100), position("BND", 400, 50))
+			Expect(router.Intents).To(HaveLen(2))
+		})
+	})
+
+	It("should not trade when positions cannot be listed", func() {
+		Expect(internal.AddObjReturns("ListPositions", errors.New("boom"))).To(Succeed())
+		check(api.AccountInfo{Equity: 1000})
+		Expect(router.Intents).To(BeEmpty())
+	})
+
+	It("should reject weights adding up to more than one", func() {
+		config.Weights = map[string]float64{"VTI": 0.7, "BND": 0.4}
+		_, err := algorithm.NewRebalance(config)
+		Expect(err).To(MatchError("rebalance weights add up to 1.1, more than 1"))
+	})
+
+	It("should reject a negative weight", func() {
+		config.Weights = map[string]float64{"VTI": -0.1}
+		_, err := algorithm.NewRebalance(config)
+		Expect(err).To(MatchError("rebalance weight for VTI must not be negative"))
+	})
+
+	It("should require a schedule or a drift threshold", func() {
+		config.DriftThreshold = 0
+		_, err := algorithm.NewRebalance(config)
+		Expect(err).To(MatchError("rebalance requires a schedule or a drift threshold"))
+	})
+})
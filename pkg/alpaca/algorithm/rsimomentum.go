@@ -0,0 +1,217 @@
+package algorithm
+
+import (
+	"errors"
+	"time"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/indicators"
+	"github.com/sirupsen/logrus"
+)
+
+var _ api.AlpacaAlgorithm = &RSIMomentum{}
+var _ api.BarHandler = &RSIMomentum{}
+var _ api.IntentTrader = &RSIMomentum{}
+
+// RSIMomentumConfig configures the RSI momentum strategy
+type RSIMomentumConfig struct {
+	RSIPeriod   int
+	BarInterval time.Duration
+	// Oversold is the RSI level price must recover back above to enter
+	Oversold float64
+	// Overbought is the RSI level at which we take profit
+	Overbought float64
+	// DivergenceExit also exits when price makes a higher high
+	// that RSI does not confirm
+	DivergenceExit bool
+	// VolumePeriod, when set, only enters on bars whose volume is at least
+	// VolumeMultiple times the average over this many bars
+	VolumePeriod   int
+	VolumeMultiple float64
+}
+
+// DefaultRSIMomentumConfig returns a 14 bar RSI strategy on one minute bars,
+// entering out of 30 and exiting at 70 or on a bearish divergence
+func DefaultRSIMomentumConfig() RSIMomentumConfig {
+	return RSIMomentumConfig{
+		RSIPeriod:      14,
+		BarInterval:    time.Minute,
+		Oversold:       30,
+		Overbought:     70,
+		DivergenceExit: true,
+		VolumeMultiple: 1,
+	}
+}
+
+// peak is a local high in price, with the RSI as of that bar
+type peak struct {
+	price float64
+	rsi   float64
+}
+
+// RSIMomentum buys when RSI recovers from oversold, and sells when it
+// becomes overbought or diverges bearishly from price. Each stock has
+// its own RSI and position.
+type RSIMomentum struct {
+	config RSIMomentumConfig
+	stocks map[string]*rsiMomentumState
+}
+
+// rsiMomentumState is the RSI, peaks and position for one stock
+type rsiMomentumState struct {
+	rsi    *indicators.RSI
+	volume *indicators.SMA
+
+	// lastRSI is the RSI as of the previous bar
+	lastRSI *float64
+	// highs holds the last two bar highs with their RSI, to find peaks
+	highs []peak
+	// lastPeak is the most recent local high
+	lastPeak *peak
+	long     bool
+}
+
+// NewRSIMomentum returns a new RSIMomentum algorithm
+func NewRSIMomentum(config RSIMomentumConfig) (*RSIMomentum, error) {
+	if config.RSIPeriod <= 0 {
+		return nil, errors.New("rsi momentum requires a positive rsi period")
+	}
+	if config.BarInterval <= 0 {
+		return nil, errors.New("rsi momentum requires a positive bar interval")
+	}
+	if config.Oversold <= 0 || config.Overbought >= 100 || config.Oversold >= config.Overbought {
+		return nil, errors.New("rsi momentum requires oversold and overbought levels with 0 < oversold < overbought < 100")
+	}
+	if config.VolumePeriod < 0 {
+		return nil, errors.New("rsi momentum requires a non-negative volume period")
+	}
+
+	return &RSIMomentum{
+		config: config,
+		stocks: map[string]*rsiMomentumState{},
+	}, nil
+}
+
+// stock returns the state for a stock, starting it if it is new
+func (c *RSIMomentum) stock(symbol string) *rsiMomentumState {
+	state, ok := c.stocks[symbol]
+	if ok {
+		return state
+	}
+
+	state = &rsiMomentumState{rsi: indicators.NewRSI(c.config.RSIPeriod)}
+	if c.config.VolumePeriod > 0 {
+		state.volume = indicators.NewSMA(c.config.VolumePeriod)
+	}
+	c.stocks[symbol] = state
+	return state
+}
+
+// TradesWithIntents implements the function on the IntentTrader interface
+func (c *RSIMomentum) TradesWithIntents() {}
+
+// HandleStreamTrade implements the function on the AlpacaAlgorithm interface.
+// RSIMomentum reacts to bars rather than individual trades.
+func (c *RSIMomentum) HandleStreamTrade(context api.StreamTradeContext) {}
+
+// BarSpec implements the function on the BarHandler interface
+func (c *RSIMomentum) BarSpec() api.BarSpec {
+	return api.BarSpec{
+		Type:     api.TimeBars,
+		Interval: c.config.BarInterval,
+	}
+}
+
+// OnBar implements the function on the BarHandler interface
+func (c *RSIMomentum) OnBar(context api.BarContext) {
+	bar := context.Bar
+	state := c.stock(bar.Symbol)
+
+	// Compare volume against the average of the bars before this one
+	volumeConfirmed := state.volumeConfirms(bar, c.config.VolumeMultiple)
+
+	state.rsi.UpdateBar(bar)
+	if !state.rsi.Ready() {
+		return
+	}
+
+	rsi := state.rsi.Value()
+	lastRSI := state.lastRSI
+	state.lastRSI = &rsi
+	diverged := state.updatePeaks(bar, rsi)
+
+	if lastRSI == nil || context.Backfill {
+		// Backfilled bars only catch the indicators up
+		return
+	}
+
+	contextLog := context.ContextLog.WithFields(logrus.Fields{
+		"logger": "algorithm_rsi_momentum",
+		"rsi":    rsi,
+	})
+
+	switch {
+	case !state.long && *lastRSI <= c.config.Oversold && rsi > c.config.Oversold:
+		if !volumeConfirmed {
+			contextLog.Debug("RSI recovered from oversold without volume confirmation")
+			return
+		}
+		contextLog.Info("RSI recovered from oversold")
+		if c.submit(context, contextLog, 1) {
+			state.long = true
+		}
+	case state.long && rsi >= c.config.Overbought:
+		contextLog.Info("RSI is overbought")
+		if c.submit(context, contextLog, 0) {
+			state.long = false
+		}
+	case state.long && diverged && c.config.DivergenceExit:
+		contextLog.Info("Price high is not confirmed by RSI")
+		if c.submit(context, contextLog, 0) {
+			state.long = false
+		}
+	}
+}
+
+// volumeConfirms reports whether the bar has enough volume to enter on,
+// and adds it to the volume average
+func (s *rsiMomentumState) volumeConfirms(bar api.Bar, multiple float64) bool {
+	if s.volume == nil {
+		return true
+	}
+	confirmed := s.volume.Ready() && bar.Volume >= multiple*s.volume.Value()
+	s.volume.Update(bar.Volume)
+	return confirmed
+}
+
+// updatePeaks records the bar, and reports whether the previous bar formed
+// a local high above the last one while RSI formed a lower one
+func (s *rsiMomentumState) updatePeaks(bar api.Bar, rsi float64) bool {
+	s.highs = append(s.highs, peak{price: bar.High, rsi: rsi})
+	if len(s.highs) > 3 {
+		s.highs = s.highs[1:]
+	}
+	if len(s.highs) < 3 {
+		return false
+	}
+
+	before, candidate, after := s.highs[0], s.highs[1], s.highs[2]
+	if candidate.price <= before.price || candidate.price < after.price {
+		return false
+	}
+
+	lastPeak := s.lastPeak
+	s.lastPeak = &candidate
+
+	return lastPeak != nil && candidate.price > lastPeak.price && candidate.rsi < lastPeak.rsi
+}
+
+// submit sends a signal to the controller, reporting whether it was accepted
+func (c *RSIMomentum) submit(context api.BarContext, contextLog *logrus.Entry, signal float64) bool {
+	intent := api.SignalIntent(context.Bar.Symbol, signal, context.Bar.Close)
+	if _, err := context.Router.SubmitIntent(intent); err != nil {
+		contextLog.Errorf("Failed to submit intent: %v", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+package algorithm_test
+
+import (
+	"errors"
+	"time"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/algorithm"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/internal"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("RSIMomentum", func() {
+	var (
+		rsiMomentum *algorithm.RSIMomentum
+		config      algorithm.RSIMomentumConfig
+		router      *internal.MockOrderRouter
+		stock       string = "MKL"
+		err         error
+	)
+
+	feedBar := func(price, volume float64) {
+		rsiMomentum.OnBar(api.BarContext{
+			Router:     router,
+			Bar:        api.Bar{Symbol: stock, Open: price, High: price, Low: price, Close: price, Volume: volume},
+			ContextLog: logrus.NewEntry(logrus.StandardLogger()),
+		})
+	}
+
+	feed := func(closes ...float64) {
+		for _, price := range closes {
+			feedBar(price, 100)
+		}
+	}
+
+	signals := func() []float64 {
+		result := []float64{}
+		for _, intent := range router.Intents {
+			result = append(result, *intent.Signal)
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		router = internal.NewMockOrderRouter()
+		config = algorithm.RSIMomentumConfig{
+			RSIPeriod:      3,
+			BarInterval:    time.Minute,
+			Oversold:       30,
+			Overbought:     80,
+			DivergenceExit: true,
+		}
+	})
+
+	JustBeforeEach(func() {
+		rsiMomentum, err = algorithm.NewRSIMomentum(config)
+		Expect(err).ToNot(HaveOccurred())
+		// RSI of 0 then 20
+		feed(10, 9, 8, 7, 7.5)
+	})
+
+	Context("when RSI recovers from oversold and becomes overbought", func() {
+		JustBeforeEach(func() {
+			// RSI of 38.46, 54.29, 67.01, 76.73 then 83.86
+			feed(8, 8.5, 9, 9.5, 10)
+		})
+		It("should enter and take profit", func() {
+			Expect(signals()).To(Equal([]float64{1, 0}))
+			Expect(router.Intents[0].LimitPrice).To(Equal(float64(8)))
+			Expect(router.Intents[1].LimitPrice).To(Equal(float64(10)))
+		})
+	})
+
+	Context("when the controller refuses the exit", func() {
+		JustBeforeEach(func() {
+			feed(8, 8.5, 9, 9.5)
+			router.Err = errors.New("boom")
+			feed(10)
+			router.Err = nil
+		})
+		It("should exit on the next overbought bar", func() {
+			Expect(signals()).To(Equal([]float64{1}))
+			feed(10.5)
+			Expect(signals()).To(Equal([]float64{1, 0}))
+		})
+	})
+
+	Context("when price makes a higher high on a lower RSI", func() {
+		JustBeforeEach(func() {
+			// Peaks of 9.3 at RSI 69.27, then 9.35 at RSI 68.87
+			feed(8, 9, 8.8, 9.3, 8.7, 8.9, 9.35, 9.2)
+		})
+		It("should exit on the divergence", func() {
+			Expect(signals()).To(Equal([]float64{1, 0}))
+			Expect(router.Intents[1].LimitPrice).To(Equal(9.2))
+		})
+
+		Context("when divergence exits are disabled", func() {
+			BeforeEach(func() {
+				config.DivergenceExit = false
+			})
+			It("should stay long", func() {
+				Expect(signals()).To(Equal([]float64{1}))
+			})
+		})
+	})
+
+	Context("when volume confirmation is required", func() {
+		BeforeEach(func() {
+			config.VolumePeriod = 2
+			config.VolumeMultiple = 1.5
+		})
+
+		It("should not enter on average volume", func() {
+			feedBar(8, 100)
+			Expect(router.Intents).To(BeEmpty())
+		})
+
+		It("should enter on heavy volume", func() {
+			feedBar(8, 200)
+			Expect(signals()).To(Equal([]float64{1}))
+		})
+	})
+
+	Context("when the levels are invalid", func() {
+		It("should fail to create the algorithm", func() {
+			config.Oversold = 80
+			_, err := algorithm.NewRSIMomentum(config)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/shopspring/decimal"
 	"github.com/sirupsen/logrus"
 )
 
@@ -11,45 +12,510 @@ import (
 type AlpacaClient interface {
 	CancelAllOrders() error
 	GetAccount() (*alpaca.Account, error)
+	GetAsset(symbol string) (*Asset, error)
 	GetPosition(string) (*alpaca.Position, error)
+	GetCalendar(start, end *string) ([]alpaca.CalendarDay, error)
+	GetClock() (*alpaca.Clock, error)
+	ListBars(symbols []string, opts alpaca.ListBarParams) (map[string][]alpaca.Bar, error)
+	ListPositions() ([]alpaca.Position, error)
 	CancelOrder(orderID string) error
 	ListOrders(status *string, until *time.Time, limit *int, nested *bool) ([]alpaca.Order, error)
 	PlaceOrder(req alpaca.PlaceOrderRequest) (*alpaca.Order, error)
 }
 
+// FailFastClient is a client that waits out rate limits and retries,
+// and can give a client that fails instead of waiting, for callers
+// holding a lock that other work is queued behind
+type FailFastClient interface {
+	AlpacaClient
+	FailFast() AlpacaClient
+}
+
 // AlpacaAlgorithm defines a contract for any implementing
 // algorithm strategy to use with our Alpaca controller.
 // The underlying assumption is that all algorithms will base
-// their actions on a set of stream trades.
+// their actions on a set of stream trades. The other handlers
+// below are optional, and the controller calls the ones an
+// algorithm implements.
 type AlpacaAlgorithm interface {
 	// Given a stream trade, perform some action based on the data.
 	HandleStreamTrade(context StreamTradeContext)
 }
 
+// StartHandler is implemented by algorithms that want to prepare
+// before trading begins, such as warming up indicators from history.
+type StartHandler interface {
+	// History describes the bars the algorithm wants at start.
+	History() HistorySpec
+	// Prepare to trade, failing the controller's start on error.
+	OnStart(context StartContext) error
+}
+
+// StopHandler is implemented by algorithms that want to clean up
+// when the controller stops.
+type StopHandler interface {
+	// Clean up before the controller stops.
+	OnStop(context StopContext)
+}
+
+// BarHandler is implemented by algorithms that want the controller
+// to aggregate stream trades into bars for them.
+type BarHandler interface {
+	// BarSpec describes the bars the algorithm wants.
+	BarSpec() BarSpec
+	// Given a completed bar, perform some action based on the data.
+	OnBar(context BarContext)
+}
+
+// OrderUpdateHandler is implemented by algorithms that want to
+// hear about updates to orders in the stock, such as fills.
+type OrderUpdateHandler interface {
+	// Given an update to an order, perform some action based on the data.
+	OnOrderUpdate(context OrderUpdateContext)
+}
+
+// QuoteHandler is implemented by algorithms that want the
+// bid and ask, such as to price limit orders off the spread.
+type QuoteHandler interface {
+	// Given a stream quote, perform some action based on the data.
+	OnQuote(context QuoteContext)
+}
+
+// AggregateHandler is implemented by algorithms that want bars
+// aggregated by the data stream, rather than built from trades.
+type AggregateHandler interface {
+	// Given a streamed aggregate, perform some action based on the data.
+	OnAggregate(context AggregateContext)
+}
+
+// MarketHandler is implemented by algorithms that want to know
+// when the market opens and closes.
+type MarketHandler interface {
+	// The market has opened, or was open when the controller started.
+	OnMarketOpen(context MarketContext)
+	// The market has closed.
+	OnMarketClose(context MarketContext)
+}
+
+// AccountHandler is implemented by algorithms that want to hear
+// about changes to the account, such as trading being blocked.
+type AccountHandler interface {
+	// Given an update to the account, perform some action based on the data.
+	OnAccountUpdate(context AccountContext)
+}
+
+// TimerHandler is implemented by algorithms that want to act on a
+// schedule, rather than only in response to market data.
+type TimerHandler interface {
+	// TimerInterval is how often the algorithm wants to be called.
+	TimerInterval() time.Duration
+	// Perform some scheduled action.
+	OnTimer(context TimerContext)
+}
+
+// IntentTrader is implemented by algorithms that only trade by
+// submitting intents, without placing, canceling or waiting on orders
+// themselves, so their intents can be combined as votes.
+type IntentTrader interface {
+	// TradesWithIntents marks the algorithm as trading only with intents.
+	TradesWithIntents()
+}
+
+// OrderRouter accepts order intents from an algorithm and turns
+// them into orders with the broker.
+type OrderRouter interface {
+	// SubmitIntent orders towards the position described by the intent
+	SubmitIntent(intent OrderIntent) (*alpaca.Order, error)
+	// SubmitIntents orders towards several positions together, such as
+	// the legs of a pair, checking every leg before sending any
+	SubmitIntents(intents ...OrderIntent) ([]*alpaca.Order, error)
+	// PlaceLimitOrder sends a single limit order for a quantity of shares,
+	// for algorithms that manage their own orders
+	PlaceLimitOrder(symbol string, side alpaca.Side, quantity decimal.Decimal, limitPrice float64) (*alpaca.Order, error)
+	// CancelOrder cancels an open order
+	CancelOrder(orderID string) error
+}
+
+// DataStream delivers market data for the stocks we trade. Handlers
+// may be called on another goroutine, one event at a time.
+type DataStream interface {
+	// SubscribeTrades calls the handler with each trade in a stock
+	SubscribeTrades(symbol string, handler func(alpaca.StreamTrade)) error
+	// SubscribeQuotes calls the handler with each quote for a stock
+	SubscribeQuotes(symbol string, handler func(alpaca.StreamQuote)) error
+	// SubscribeAggregates calls the handler with each bar the stream
+	// aggregates for a stock on one of the aggregate channels
+	SubscribeAggregates(symbol string, channel Channel, handler func(Bar)) error
+	// Unsubscribe stops all data for a stock
+	Unsubscribe(symbol string) error
+}
+
+// AccountStream delivers updates to our account. An OrderStream may
+// also implement it, for the controller to follow the account without
+// polling. Handlers may be called on another goroutine, one event at
+// a time.
+type AccountStream interface {
+	// SubscribeAccountUpdates calls the handler with each update to the account
+	SubscribeAccountUpdates(handler func(AccountUpdate)) error
+	// UnsubscribeAccountUpdates stops updates to the account
+	UnsubscribeAccountUpdates() error
+}
+
+// Channel is a kind of market data a stream delivers for a stock
+type Channel string
+
+const (
+	// TradeChannel delivers each trade
+	TradeChannel Channel = "trades"
+	// QuoteChannel delivers each change to the bid or ask
+	QuoteChannel Channel = "quotes"
+	// MinuteAggregateChannel delivers a bar for each minute
+	MinuteAggregateChannel Channel = "minute_aggregates"
+	// SecondAggregateChannel delivers a bar for each second. Only
+	// Polygon streams second aggregates.
+	SecondAggregateChannel Channel = "second_aggregates"
+)
+
+// OrderStream delivers updates to our orders. Handlers may be
+// called on another goroutine, one event at a time.
+type OrderStream interface {
+	// SubscribeTradeUpdates calls the handler with each update to an order
+	SubscribeTradeUpdates(handler func(alpaca.TradeUpdate)) error
+	// UnsubscribeTradeUpdates stops updates to orders
+	UnsubscribeTradeUpdates() error
+}
+
+// Clock tells the controller the time and runs its schedules,
+// so a session can be replayed on a clock of its own
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// Every calls the function with the time once per interval,
+	// one call at a time, until done is closed. It returns at once.
+	Every(interval time.Duration, done <-chan struct{}, fn func(time.Time))
+}
+
+// Journal records trades for later review
+type Journal interface {
+	// Record adds an entry to the journal
+	Record(entry JournalEntry) error
+}
+
+// JournalEntry is a trade recorded in the journal
+type JournalEntry struct {
+	Time      time.Time       `json:"time"`
+	Algorithm string          `json:"algorithm"`
+	Symbol    string          `json:"symbol"`
+	Side      alpaca.Side     `json:"side"`
+	Quantity  decimal.Decimal `json:"quantity"`
+	Price     float64         `json:"price"`
+	Notional  float64         `json:"notional"`
+	OrderID   string          `json:"order_id"`
+	Note      string          `json:"note,omitempty"`
+}
+
 // StreamTradeContext encapsulates context that is passed from
-// a controller to the implementing algorithm
+// a controller to the implementing algorithm. Backfill is set on
+// trades that were missed and are being caught up on, which are
+// only for rebuilding state, as orders are refused meanwhile.
 type StreamTradeContext struct {
 	Client     AlpacaClient
+	Router     OrderRouter
 	Stock      StockInfo
+	Stocks     map[string]StockInfo
 	Account    AccountInfo
+	Journal    Journal
 	Trade      alpaca.StreamTrade
+	Backfill   bool
+	ContextLog *logrus.Entry
+}
+
+// BarContext encapsulates context that is passed from
+// a controller to an algorithm when a bar completes. Backfill
+// is set on bars completed by backfilled trades.
+type BarContext struct {
+	Client     AlpacaClient
+	Router     OrderRouter
+	Stock      StockInfo
+	Stocks     map[string]StockInfo
+	Account    AccountInfo
+	Journal    Journal
+	Bar        Bar
+	Backfill   bool
+	ContextLog *logrus.Entry
+}
+
+// OrderUpdateContext encapsulates context that is passed from
+// a controller to an algorithm when one of its orders changes
+type OrderUpdateContext struct {
+	Client     AlpacaClient
+	Router     OrderRouter
+	Stock      StockInfo
+	Stocks     map[string]StockInfo
+	Account    AccountInfo
+	Journal    Journal
+	Update     alpaca.TradeUpdate
+	ContextLog *logrus.Entry
+}
+
+// TimerContext encapsulates context that is passed from
+// a controller to an algorithm on a schedule
+type TimerContext struct {
+	Client     AlpacaClient
+	Router     OrderRouter
+	Stocks     map[string]StockInfo
+	Account    AccountInfo
+	Journal    Journal
+	Now        time.Time
 	ContextLog *logrus.Entry
 }
 
+// QuoteContext encapsulates context that is passed from
+// a controller to an algorithm on each quote
+type QuoteContext struct {
+	Client     AlpacaClient
+	Router     OrderRouter
+	Stock      StockInfo
+	Stocks     map[string]StockInfo
+	Account    AccountInfo
+	Journal    Journal
+	Quote      alpaca.StreamQuote
+	ContextLog *logrus.Entry
+}
+
+// AggregateContext encapsulates context that is passed from
+// a controller to an algorithm on each streamed aggregate.
+// Backfill is set on aggregates that were missed and are
+// being caught up on, when orders are refused.
+type AggregateContext struct {
+	Client     AlpacaClient
+	Router     OrderRouter
+	Stock      StockInfo
+	Stocks     map[string]StockInfo
+	Account    AccountInfo
+	Journal    Journal
+	Channel    Channel
+	Bar        Bar
+	Backfill   bool
+	ContextLog *logrus.Entry
+}
+
+// StartContext encapsulates context that is passed from
+// a controller to an algorithm before trading begins
+type StartContext struct {
+	Client  AlpacaClient
+	Router  OrderRouter
+	Stocks  map[string]StockInfo
+	Account AccountInfo
+	Journal Journal
+	// History holds the bars asked for by the algorithm, oldest
+	// first, by symbol
+	History    map[string][]Bar
+	Now        time.Time
+	ContextLog *logrus.Entry
+}
+
+// MarketContext encapsulates context that is passed from
+// a controller to an algorithm when the market opens or closes
+type MarketContext struct {
+	Client     AlpacaClient
+	Router     OrderRouter
+	Stocks     map[string]StockInfo
+	Account    AccountInfo
+	Journal    Journal
+	Clock      alpaca.Clock
+	ContextLog *logrus.Entry
+}
+
+// AccountContext encapsulates context that is passed from
+// a controller to an algorithm when the account changes
+type AccountContext struct {
+	Client     AlpacaClient
+	Router     OrderRouter
+	Stocks     map[string]StockInfo
+	Account    AccountInfo
+	Journal    Journal
+	Update     AccountUpdate
+	ContextLog *logrus.Entry
+}
+
+// StopContext encapsulates context that is passed from
+// a controller to an algorithm when the controller stops
+type StopContext struct {
+	Client     AlpacaClient
+	Router     OrderRouter
+	Stocks     map[string]StockInfo
+	Account    AccountInfo
+	Journal    Journal
+	Now        time.Time
+	ContextLog *logrus.Entry
+}
+
+// HistorySpec describes the historical bars an algorithm wants
+type HistorySpec struct {
+	// Interval is the length of each bar. Alpaca provides one minute,
+	// five minute, fifteen minute and daily bars.
+	Interval time.Duration
+	// Bars is the number of bars, up to the most recent. Zero asks
+	// for no history.
+	Bars int
+}
+
+// BarType selects how trades are grouped into bars
+type BarType string
+
+const (
+	// TimeBars close after a fixed interval of time
+	TimeBars BarType = "time"
+	// TickBars close after a fixed number of trades
+	TickBars BarType = "tick"
+	// VolumeBars close after a fixed number of shares trade
+	VolumeBars BarType = "volume"
+	// DollarBars close after a fixed dollar value trades
+	DollarBars BarType = "dollar"
+)
+
+// BarSpec describes how to aggregate trades into bars
+type BarSpec struct {
+	Type BarType
+	// Interval is the length of each time bar
+	Interval time.Duration
+	// Threshold is the number of trades, shares or dollars in each
+	// tick, volume or dollar bar
+	Threshold float64
+	// FillGaps emits flat, zero-volume time bars for intervals
+	// without any trades
+	FillGaps bool
+}
+
+// Bar is a set of trades aggregated into a single candle
+type Bar struct {
+	Symbol     string
+	Start      time.Time
+	End        time.Time
+	Open       float64
+	High       float64
+	Low        float64
+	Close      float64
+	Volume     float64
+	VWAP       float64
+	TradeCount int
+}
+
+// OrderIntent describes the position an algorithm would like to hold.
+// The target may be expressed as a share count or as a dollar notional.
+type OrderIntent struct {
+	Symbol string
+	// Target is the desired position in shares, which may be fractional
+	// and is negative for a short position
+	Target decimal.Decimal
+	// Notional, when set, is the desired position value in dollars
+	// and takes precedence over Target
+	Notional *decimal.Decimal
+	// Signal, when set, asks the controller's sizer for the target,
+	// where 1 is a full long position, -1 a full short and 0 flat.
+	// It takes precedence over Target and Notional.
+	Signal *float64
+	// Volatility is an optional ATR estimate for volatility-based sizing
+	Volatility float64
+	// LimitPrice is the price used for the order, and to convert
+	// a notional into shares
+	LimitPrice float64
+}
+
+// TargetIntent returns an intent to hold a number of shares
+func TargetIntent(symbol string, target decimal.Decimal, limitPrice float64) OrderIntent {
+	return OrderIntent{
+		Symbol:     symbol,
+		Target:     target,
+		LimitPrice: limitPrice,
+	}
+}
+
+// NotionalIntent returns an intent to hold a dollar amount of a stock
+func NotionalIntent(symbol string, notional decimal.Decimal, limitPrice float64) OrderIntent {
+	return OrderIntent{
+		Symbol:     symbol,
+		Notional:   &notional,
+		LimitPrice: limitPrice,
+	}
+}
+
+// SignalIntent returns an intent that leaves the position size to the controller
+func SignalIntent(symbol string, signal float64, limitPrice float64) OrderIntent {
+	return OrderIntent{
+		Symbol:     symbol,
+		Signal:     &signal,
+		LimitPrice: limitPrice,
+	}
+}
+
 // AccountInfo stores latest data about our alpaca account
 type AccountInfo struct {
 	ID               string
+	Status           string
 	Equity           float64
+	Cash             float64
+	BuyingPower      float64
 	MarginMultiplier float64
+	ShortingEnabled  bool
+	ShortMarketValue float64
+	DaytradeCount    int64
+	PatternDayTrader bool
+	TradingBlocked   bool
+	AccountBlocked   bool
+}
+
+// Blocked reports whether the account may not place orders, as
+// trading is blocked or the account is not active
+func (a AccountInfo) Blocked() bool {
+	return a.TradingBlocked || a.AccountBlocked || (a.Status != "" && a.Status != "ACTIVE")
 }
 
-// StockInfo tracks our position in the stock we are watching
+// AccountUpdate is a change to our account pushed by Alpaca. Updates
+// carry only some of the account's details, and those they do not
+// carry are nil.
+type AccountUpdate struct {
+	ID               string           `json:"id"`
+	Status           string           `json:"status"`
+	UpdatedAt        time.Time        `json:"updated_at"`
+	Equity           *decimal.Decimal `json:"equity,omitempty"`
+	Cash             *decimal.Decimal `json:"cash,omitempty"`
+	BuyingPower      *decimal.Decimal `json:"buying_power,omitempty"`
+	DaytradeCount    *int64           `json:"daytrade_count,omitempty"`
+	PatternDayTrader *bool            `json:"pattern_day_trader,omitempty"`
+	TradingBlocked   *bool            `json:"trading_blocked,omitempty"`
+	AccountBlocked   *bool            `json:"account_blocked,omitempty"`
+}
+
+// FractionalPrecision is the number of decimal places Alpaca
+// supports for fractional share quantities
+const FractionalPrecision int32 = 9
+
+// Asset extends alpaca.Asset with fields the SDK does not decode
+type Asset struct {
+	alpaca.Asset
+	Fractionable bool `json:"fractionable"`
+}
+
+// StockInfo tracks our position in a stock we are watching
 type StockInfo struct {
 	Symbol   string
-	Position int64
+	Position decimal.Decimal
+	Asset    Asset
 }
 
-// OrderInfo tracks our current order status
+// OrderInfo tracks a working order
 type OrderInfo struct {
-	ID string
+	ID         string
+	Symbol     string
+	Side       alpaca.Side
+	Qty        decimal.Decimal
+	FilledQty  decimal.Decimal
+	LimitPrice float64
+}
+
+// Remaining returns the quantity of the order that has not filled
+func (o OrderInfo) Remaining() decimal.Decimal {
+	return o.Qty.Sub(o.FilledQty)
 }
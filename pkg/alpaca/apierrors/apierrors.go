@@ -0,0 +1,170 @@
+package apierrors
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+)
+
+// Kind is what went wrong in a call to the broker, so callers can
+// branch on it rather than on the wording of the error
+type Kind string
+
+const (
+	// Unknown is any error we cannot tell the kind of
+	Unknown Kind = "unknown"
+	// NotFound is asking for something that does not exist, such as
+	// the position in a stock we hold none of
+	NotFound Kind = "not_found"
+	// InsufficientBuyingPower is an order the account cannot afford
+	InsufficientBuyingPower Kind = "insufficient_buying_power"
+	// RateLimited is a call refused for going over the rate limit
+	RateLimited Kind = "rate_limited"
+	// MarketClosed is an order refused as the market is closed
+	MarketClosed Kind = "market_closed"
+	// PatternDayTrader is an order refused by the pattern day trader rule
+	PatternDayTrader Kind = "pattern_day_trader"
+	// InvalidOrder is an order the broker will never accept as sent
+	InvalidOrder Kind = "invalid_order"
+	// Unauthorized is a call our keys or account are not allowed to make
+	Unauthorized Kind = "unauthorized"
+	// Transient is the broker failing on its side, or the network failing
+	Transient Kind = "transient"
+)
+
+// Temporary reports whether errors of the kind are likely to pass on their own
+func (k Kind) Temporary() bool {
+	return k == RateLimited || k == Transient
+}
+
+// Error is an error of a known kind, for failures we raise ourselves
+type Error struct {
+	Kind    Kind
+	Message string
+}
+
+// New returns an error of the given kind
+func New(kind Kind, message string) *Error {
+	return &Error{Kind: kind, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Classify returns the kind of an error, from its kind if we raised it,
+// or from the status and message if Alpaca did. When Alpaca or a gateway
+// in front of it fails with a body that is not JSON, such as an HTML
+// error page, the SDK only returns the error decoding it, so those are
+// taken to be transient. A nil error has no kind.
+func Classify(err error) Kind {
+	if err == nil {
+		return ""
+	}
+
+	var known *Error
+	if errors.As(err, &known) {
+		return known.Kind
+	}
+
+	var apiErr *alpaca.APIError
+	if errors.As(err, &apiErr) {
+		return classifyAPIError(apiErr)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return Transient
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return Transient
+	}
+
+	return Unknown
+}
+
+// Is reports whether an error is of the given kind
+func Is(err error, kind Kind) bool {
+	return err != nil && Classify(err) == kind
+}
+
+// StatusCode returns the HTTP status of an Alpaca error code. Alpaca
+// codes are the status followed by five digits, such as 40410000.
+func StatusCode(code int) int {
+	for code >= 1000 {
+		code /= 10
+	}
+	return code
+}
+
+// classifyAPIError tells the kind of an error from Alpaca. Orders are
+// refused with the same few statuses for many reasons, so those are
+// told apart by their message.
+func classifyAPIError(err *alpaca.APIError) Kind {
+	message := strings.ToLower(err.Message)
+	switch {
+	case strings.Contains(message, "buying power"):
+		return InsufficientBuyingPower
+	case strings.Contains(message, "pattern day"):
+		return PatternDayTrader
+	case strings.Contains(message, "market is closed"), strings.Contains(message, "market hours"):
+		return MarketClosed
+	}
+
+	switch status := StatusCode(err.Code); {
+	case status == http.StatusUnauthorized, status == http.StatusForbidden:
+		return Unauthorized
+	case status == http.StatusNotFound:
+		return NotFound
+	case status == http.StatusTooManyRequests:
+		return RateLimited
+	case status >= http.StatusInternalServerError:
+		return Transient
+	case status == http.StatusBadRequest, status == http.StatusUnprocessableEntity:
+		return InvalidOrder
+	default:
+		return Unknown
+	}
+}
+
+// Counter counts errors by kind, for metrics. The zero value is ready to use.
+type Counter struct {
+	counts map[Kind]int
+	mu     sync.Mutex
+}
+
+// Record counts an error, returning its kind. Nil errors are not counted.
+func (c *Counter) Record(err error) Kind {
+	kind := Classify(err)
+	if kind == "" {
+		return kind
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.counts == nil {
+		c.counts = map[Kind]int{}
+	}
+	c.counts[kind]++
+	return kind
+}
+
+// Counts returns how many errors of each kind have been recorded
+func (c *Counter) Counts() map[Kind]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counts := make(map[Kind]int, len(c.counts))
+	for kind, count := range c.counts {
+		counts[kind] = count
+	}
+	return counts
+}
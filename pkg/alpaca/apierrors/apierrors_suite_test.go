@@ -0,0 +1,13 @@
+package apierrors_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestAPIErrors(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Alpaca API Errors Suite")
+}
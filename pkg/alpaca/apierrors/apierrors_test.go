@@ -0,0 +1,82 @@
+package apierrors_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/apierrors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("API errors", func() {
+	It("should classify errors from Alpaca", func() {
+		cases := []struct {
+			code    int
+			message string
+			kind    apierrors.Kind
+		}{
+			{40410000, "position does not exist", apierrors.NotFound},
+			{40310000, "insufficient buying power", apierrors.InsufficientBuyingPower},
+			{40310000, "trade denied due to pattern day trading protection", apierrors.PatternDayTrader},
+			{42210000, "market is closed", apierrors.MarketClosed},
+			{42210000, "qty must be > 0", apierrors.InvalidOrder},
+			{40010001, "request body format is invalid", apierrors.InvalidOrder},
+			{40110000, "request is not authorized", apierrors.Unauthorized},
+			{40310000, "forbidden", apierrors.Unauthorized},
+			{42910000, "rate limit exceeded", apierrors.RateLimited},
+			{50010000, "internal server error", apierrors.Transient},
+			{503, "status code 503", apierrors.Transient},
+			{30010000, "moved", apierrors.Unknown},
+		}
+		for _, c := range cases {
+			Expect(apierrors.Classify(&alpaca.APIError{Code: c.code, Message: c.message})).To(Equal(c.kind), c.message)
+		}
+	})
+
+	It("should classify network failures as transient", func() {
+		err := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+		Expect(apierrors.Classify(err)).To(Equal(apierrors.Transient))
+		Expect(apierrors.Classify(err).Temporary()).To(BeTrue())
+	})
+
+	It("should classify failures with bodies that are not JSON as transient", func() {
+		err := json.Unmarshal([]byte("<html>502 Bad Gateway</html>"), &alpaca.APIError{})
+		Expect(apierrors.Classify(err)).To(Equal(apierrors.Transient))
+		err = json.Unmarshal([]byte{}, &alpaca.APIError{})
+		Expect(apierrors.Classify(err)).To(Equal(apierrors.Transient))
+	})
+
+	It("should classify errors we raise by their kind, even wrapped", func() {
+		err := fmt.Errorf("failed to place order: %w", apierrors.New(apierrors.Unauthorized, "trading is blocked"))
+		Expect(apierrors.Is(err, apierrors.Unauthorized)).To(BeTrue())
+		Expect(apierrors.Classify(err).Temporary()).To(BeFalse())
+		Expect(err).To(MatchError("failed to place order: trading is blocked"))
+	})
+
+	It("should not know the kind of other errors", func() {
+		Expect(apierrors.Classify(errors.New("boom"))).To(Equal(apierrors.Unknown))
+		Expect(apierrors.Classify(nil)).To(BeEmpty())
+		Expect(apierrors.Is(nil, apierrors.NotFound)).To(BeFalse())
+	})
+
+	It("should take the status from Alpaca codes", func() {
+		Expect(apierrors.StatusCode(40410000)).To(Equal(404))
+		Expect(apierrors.StatusCode(503)).To(Equal(503))
+	})
+
+	It("should count errors by kind", func() {
+		counter := apierrors.Counter{}
+		Expect(counter.Record(&alpaca.APIError{Code: 42910000})).To(Equal(apierrors.RateLimited))
+		counter.Record(&alpaca.APIError{Code: 42910000})
+		counter.Record(errors.New("boom"))
+		counter.Record(nil)
+		Expect(counter.Counts()).To(Equal(map[apierrors.Kind]int{
+			apierrors.RateLimited: 2,
+			apierrors.Unknown:     1,
+		}))
+	})
+})
@@ -0,0 +1,260 @@
+package bars
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+)
+
+const (
+	// DefaultGracePeriod is how long a time bar stays open past its end,
+	// waiting for trades that arrive late, before a flush closes it.
+	DefaultGracePeriod time.Duration = time.Second
+
+	// thresholdEpsilon absorbs floating point error when splitting trades
+	thresholdEpsilon float64 = 1e-9
+)
+
+// Builder aggregates stream trades into bars
+type Builder interface {
+	// Add a trade, returning any bars it completed
+	Add(trade alpaca.StreamTrade) []api.Bar
+	// Flush returns any bars that have closed as of the given time
+	Flush(now time.Time) []api.Bar
+}
+
+// NewBuilder returns a builder for the given bar spec
+func NewBuilder(symbol string, spec api.BarSpec) (Builder, error) {
+	switch spec.Type {
+	case api.TimeBars:
+		if spec.Interval <= 0 {
+			return nil, fmt.Errorf("time bars require a positive interval, got %v", spec.Interval)
+		}
+		return NewTimeBuilder(symbol, spec.Interval, spec.FillGaps), nil
+	case api.TickBars, api.VolumeBars, api.DollarBars:
+		if spec.Threshold <= 0 {
+			return nil, fmt.Errorf("%s bars require a positive threshold, got %v", spec.Type, spec.Threshold)
+		}
+		return NewThresholdBuilder(symbol, spec.Type, spec.Threshold), nil
+	default:
+		return nil, fmt.Errorf("unknown bar type %q", spec.Type)
+	}
+}
+
+// candle accumulates trades into a bar
+type candle struct {
+	bar      api.Bar
+	notional float64
+}
+
+func newCandle(symbol string, start time.Time) *candle {
+	return &candle{bar: api.Bar{Symbol: symbol, Start: start}}
+}
+
+func (c *candle) add(price, size float64, timestamp time.Time) {
+	if c.bar.TradeCount == 0 {
+		c.bar.Open = price
+		c.bar.High = price
+		c.bar.Low = price
+	}
+	c.bar.High = math.Max(c.bar.High, price)
+	c.bar.Low = math.Min(c.bar.Low, price)
+	c.bar.Close = price
+	c.bar.Volume += size
+	c.bar.TradeCount++
+	c.notional += price * size
+	if c.bar.Volume > 0 {
+		c.bar.VWAP = c.notional / c.bar.Volume
+	} else {
+		c.bar.VWAP = price
+	}
+	if timestamp.After(c.bar.End) {
+		c.bar.End = timestamp
+	}
+}
+
+// TimeBuilder aggregates trades into fixed intervals of time, aligned
+// to the interval (so 1m bars start on the minute). Trades are placed
+// by their exchange timestamp, and trades that belong to a bar which
+// has already been emitted, or that start before the bar being built,
+// are dropped and counted in LateTrades.
+type TimeBuilder struct {
+	Symbol      string
+	Interval    time.Duration
+	FillGaps    bool
+	GracePeriod time.Duration
+	LateTrades  int
+
+	current *candle
+	// lastEnd is the end of the last emitted bar
+	lastEnd   time.Time
+	lastClose float64
+}
+
+// NewTimeBuilder returns a builder for time bars
+func NewTimeBuilder(symbol string, interval time.Duration, fillGaps bool) *TimeBuilder {
+	return &TimeBuilder{
+		Symbol:      symbol,
+		Interval:    interval,
+		FillGaps:    fillGaps,
+		GracePeriod: DefaultGracePeriod,
+	}
+}
+
+// Add implements the function on the Builder interface
+func (b *TimeBuilder) Add(trade alpaca.StreamTrade) []api.Bar {
+	var completed []api.Bar
+
+	timestamp := trade.Time().UTC()
+	start := timestamp.Truncate(b.Interval)
+
+	if start.Before(b.lastEnd) || (b.current != nil && start.Before(b.current.bar.Start)) {
+		// This trade belongs to a bar we have already emitted, or to
+		// one we skipped over, and would be misplaced in the current one
+		b.LateTrades++
+		return nil
+	}
+
+	if b.current != nil && start.After(b.current.bar.Start) {
+		completed = append(completed, b.close())
+	}
+
+	if b.current == nil {
+		completed = append(completed, b.gapBars(start)...)
+		b.current = newCandle(b.Symbol, start)
+	}
+
+	b.current.add(float64(trade.Price), float64(trade.Size), timestamp)
+
+	return completed
+}
+
+// Flush implements the function on the Builder interface
+func (b *TimeBuilder) Flush(now time.Time) []api.Bar {
+	var completed []api.Bar
+
+	if b.current != nil && !now.Before(b.current.bar.Start.Add(b.Interval+b.GracePeriod)) {
+		completed = append(completed, b.close())
+	}
+
+	if b.current == nil && !b.lastEnd.IsZero() {
+		// Only fill intervals whose grace period has also passed
+		completed = append(completed, b.gapBars(now.Add(-b.GracePeriod).Truncate(b.Interval))...)
+	}
+
+	return completed
+}
+
+// close completes the current bar
+func (b *TimeBuilder) close() api.Bar {
+	bar := b.current.bar
+	bar.End = bar.Start.Add(b.Interval)
+
+	b.current = nil
+	b.lastEnd = bar.End
+	b.lastClose = bar.Close
+
+	return bar
+}
+
+// gapBars returns flat bars for each empty interval between the
+// last emitted bar and the given start, if gaps are being filled.
+func (b *TimeBuilder) gapBars(until time.Time) []api.Bar {
+	var gaps []api.Bar
+
+	if !b.FillGaps || b.lastEnd.IsZero() {
+		return gaps
+	}
+
+	for start := b.lastEnd; start.Before(until); start = start.Add(b.Interval) {
+		gaps = append(gaps, api.Bar{
+			Symbol: b.Symbol,
+			Start:  start,
+			End:    start.Add(b.Interval),
+			Open:   b.lastClose,
+			High:   b.lastClose,
+			Low:    b.lastClose,
+			Close:  b.lastClose,
+			VWAP:   b.lastClose,
+		})
+		b.lastEnd = start.Add(b.Interval)
+	}
+
+	return gaps
+}
+
+// ThresholdBuilder aggregates trades into bars that close once a number
+// of trades, shares or dollars have traded. Trades are placed in the
+// order they arrive. A trade that crosses the threshold is split across
+// bars, so every volume or dollar bar holds exactly the threshold.
+type ThresholdBuilder struct {
+	Symbol    string
+	Type      api.BarType
+	Threshold float64
+
+	current *candle
+	// filled is how much of the threshold the current bar holds
+	filled float64
+}
+
+// NewThresholdBuilder returns a builder for tick, volume or dollar bars
+func NewThresholdBuilder(symbol string, barType api.BarType, threshold float64) *ThresholdBuilder {
+	return &ThresholdBuilder{
+		Symbol:    symbol,
+		Type:      barType,
+		Threshold: threshold,
+	}
+}
+
+// Add implements the function on the Builder interface
+func (b *ThresholdBuilder) Add(trade alpaca.StreamTrade) []api.Bar {
+	var completed []api.Bar
+
+	timestamp := trade.Time().UTC()
+	price := float64(trade.Price)
+	remaining := float64(trade.Size)
+
+	for {
+		if b.current == nil {
+			b.current = newCandle(b.Symbol, timestamp)
+			b.filled = 0
+		}
+
+		size := remaining
+		switch b.Type {
+		case api.TickBars:
+			b.filled++
+		case api.VolumeBars:
+			size = math.Min(remaining, b.Threshold-b.filled)
+			b.filled += size
+		case api.DollarBars:
+			if price > 0 {
+				size = math.Min(remaining, (b.Threshold-b.filled)/price)
+			}
+			b.filled += size * price
+		}
+
+		b.current.add(price, size, timestamp)
+		remaining -= size
+
+		if b.filled >= b.Threshold-thresholdEpsilon {
+			completed = append(completed, b.current.bar)
+			b.current = nil
+		}
+
+		if remaining <= thresholdEpsilon {
+			break
+		}
+	}
+
+	return completed
+}
+
+// Flush implements the function on the Builder interface. Threshold
+// bars never close on time alone.
+func (b *ThresholdBuilder) Flush(now time.Time) []api.Bar {
+	return nil
+}
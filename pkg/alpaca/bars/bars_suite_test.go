@@ -0,0 +1,13 @@
+package bars_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestBars(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Alpaca Bars Suite")
+}
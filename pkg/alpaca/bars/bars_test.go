@@ -0,0 +1,194 @@
+package bars_test
+
+import (
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/bars"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bars", func() {
+	var (
+		symbol string    = "MKL"
+		origin time.Time = time.Date(2021, 1, 4, 14, 30, 0, 0, time.UTC)
+	)
+
+	trade := func(offset time.Duration, price float32, size int32) alpaca.StreamTrade {
+		return alpaca.StreamTrade{
+			Symbol:    symbol,
+			Price:     price,
+			Size:      size,
+			Timestamp: origin.Add(offset).UnixNano(),
+		}
+	}
+
+	Context("when building time bars", func() {
+		var (
+			builder   *bars.TimeBuilder
+			completed []api.Bar
+		)
+
+		BeforeEach(func() {
+			builder = bars.NewTimeBuilder(symbol, time.Minute, false)
+			completed = nil
+			for _, t := range []alpaca.StreamTrade{
+				trade(5*time.Second, 10, 100),
+				trade(20*time.Second, 12, 100),
+				trade(40*time.Second, 9, 200),
+				trade(50*time.Second, 11, 100),
+			} {
+				completed = append(completed, builder.Add(t)...)
+			}
+		})
+
+		It("should not complete a bar before the interval ends", func() {
+			Expect(completed).To(BeEmpty())
+		})
+
+		Context("when a trade arrives in the next interval", func() {
+			BeforeEach(func() {
+				completed = builder.Add(trade(65*time.Second, 11.5, 50))
+			})
+
+			It("should complete the bar with OHLCV and VWAP", func() {
+				Expect(completed).To(Equal([]api.Bar{{
+					Symbol:     symbol,
+					Start:      origin,
+					End:        origin.Add(time.Minute),
+					Open:       10,
+					High:       12,
+					Low:        9,
+					Close:      11,
+					Volume:     500,
+					VWAP:       10.2,
+					TradeCount: 4,
+				}}))
+			})
+
+			It("should drop trades for the completed bar", func() {
+				Expect(builder.Add(trade(59*time.Second, 8, 100))).To(BeEmpty())
+				Expect(builder.LateTrades).To(Equal(1))
+				bar := builder.Add(trade(125*time.Second, 11, 10))[0]
+				Expect(bar.Low).To(Equal(11.5))
+			})
+		})
+
+		Context("when trades arrive out of order within the interval", func() {
+			BeforeEach(func() {
+				Expect(builder.Add(trade(30*time.Second, 8, 100))).To(BeEmpty())
+			})
+			It("should include them in the bar", func() {
+				bar := builder.Flush(origin.Add(time.Minute + bars.DefaultGracePeriod))[0]
+				Expect(bar.Low).To(Equal(float64(8)))
+				Expect(bar.TradeCount).To(Equal(5))
+			})
+		})
+
+		Context("when flushing", func() {
+			It("should wait for the grace period past the end of the bar", func() {
+				Expect(builder.Flush(origin.Add(time.Minute))).To(BeEmpty())
+				Expect(builder.Flush(origin.Add(time.Minute + bars.DefaultGracePeriod))).To(HaveLen(1))
+				Expect(builder.Flush(origin.Add(5 * time.Minute))).To(BeEmpty())
+			})
+		})
+
+		Context("when there is a gap in trading", func() {
+			It("should skip the empty intervals", func() {
+				completed = builder.Add(trade(3*time.Minute+time.Second, 13, 10))
+				Expect(completed).To(HaveLen(1))
+				bar := builder.Flush(origin.Add(4*time.Minute + bars.DefaultGracePeriod))[0]
+				Expect(bar.Start).To(Equal(origin.Add(3 * time.Minute)))
+			})
+
+			It("should drop trades for the intervals it skipped", func() {
+				builder.Add(trade(3*time.Minute+time.Second, 13, 10))
+				Expect(builder.Add(trade(2*time.Minute, 8, 100))).To(BeEmpty())
+				Expect(builder.LateTrades).To(Equal(1))
+				bar := builder.Flush(origin.Add(4*time.Minute + bars.DefaultGracePeriod))[0]
+				Expect(bar.Start).To(Equal(origin.Add(3 * time.Minute)))
+				Expect(bar.Low).To(Equal(float64(13)))
+			})
+
+			Context("when filling gaps", func() {
+				BeforeEach(func() {
+					builder.FillGaps = true
+				})
+
+				It("should emit flat bars for the empty intervals", func() {
+					completed = builder.Add(trade(3*time.Minute+time.Second, 13, 10))
+					Expect(completed).To(HaveLen(3))
+					Expect(completed[1]).To(Equal(api.Bar{
+						Symbol: symbol,
+						Start:  origin.Add(time.Minute),
+						End:    origin.Add(2 * time.Minute),
+						Open:   11,
+						High:   11,
+						Low:    11,
+						Close:  11,
+						VWAP:   11,
+					}))
+					Expect(completed[2].Start).To(Equal(origin.Add(2 * time.Minute)))
+				})
+
+				It("should emit flat bars on flush while no trades arrive", func() {
+					completed = builder.Flush(origin.Add(3*time.Minute + bars.DefaultGracePeriod))
+					Expect(completed).To(HaveLen(3))
+					Expect(completed[2].End).To(Equal(origin.Add(3 * time.Minute)))
+					Expect(builder.Add(trade(3*time.Minute+time.Second, 13, 10))).To(BeEmpty())
+				})
+			})
+		})
+	})
+
+	Context("when building tick bars", func() {
+		It("should complete a bar every n trades", func() {
+			builder, err := bars.NewBuilder(symbol, api.BarSpec{Type: api.TickBars, Threshold: 2})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(builder.Add(trade(0, 10, 5))).To(BeEmpty())
+			completed := builder.Add(trade(time.Second, 11, 5))
+			Expect(completed).To(HaveLen(1))
+			Expect(completed[0].Close).To(Equal(float64(11)))
+			Expect(completed[0].Volume).To(Equal(float64(10)))
+			Expect(completed[0].End).To(Equal(origin.Add(time.Second)))
+		})
+	})
+
+	Context("when building volume bars", func() {
+		It("should split large trades across bars", func() {
+			builder, err := bars.NewBuilder(symbol, api.BarSpec{Type: api.VolumeBars, Threshold: 100})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(builder.Add(trade(0, 10, 60))).To(BeEmpty())
+			completed := builder.Add(trade(time.Second, 12, 250))
+			Expect(completed).To(HaveLen(3))
+			Expect(completed[0].Volume).To(Equal(float64(100)))
+			Expect(completed[0].VWAP).To(BeNumerically("~", 10.8, 1e-9))
+			Expect(completed[1].Volume).To(Equal(float64(100)))
+			Expect(completed[1].Open).To(Equal(float64(12)))
+			Expect(completed[2].Volume).To(Equal(float64(100)))
+			Expect(builder.Flush(origin.Add(time.Hour))).To(BeEmpty())
+		})
+	})
+
+	Context("when building dollar bars", func() {
+		It("should complete a bar once the dollar threshold trades", func() {
+			builder, err := bars.NewBuilder(symbol, api.BarSpec{Type: api.DollarBars, Threshold: 1000})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(builder.Add(trade(0, 10, 50))).To(BeEmpty())
+			completed := builder.Add(trade(time.Second, 20, 50))
+			Expect(completed).To(HaveLen(1))
+			Expect(completed[0].Volume).To(Equal(float64(75)))
+		})
+	})
+
+	Context("when the spec is invalid", func() {
+		It("should fail to create a builder", func() {
+			_, err := bars.NewBuilder(symbol, api.BarSpec{Type: api.TimeBars})
+			Expect(err).To(HaveOccurred())
+			_, err = bars.NewBuilder(symbol, api.BarSpec{Type: "renko", Threshold: 1})
+			Expect(err).To(MatchError(`unknown bar type "renko"`))
+		})
+	})
+})
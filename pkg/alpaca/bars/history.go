@@ -0,0 +1,70 @@
+package bars
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+)
+
+// timeframes maps the bar intervals Alpaca keeps history
+// for to the timeframe names its bars endpoint expects
+var timeframes = map[time.Duration]string{
+	time.Minute:      "1Min",
+	5 * time.Minute:  "5Min",
+	15 * time.Minute: "15Min",
+	24 * time.Hour:   "1D",
+}
+
+// CheckHistory returns an error if Alpaca has no history for the spec
+func CheckHistory(spec api.HistorySpec) error {
+	if spec.Bars < 0 {
+		return fmt.Errorf("history requires a non-negative number of bars, got %d", spec.Bars)
+	}
+	if _, ok := timeframes[spec.Interval]; spec.Bars > 0 && !ok {
+		return fmt.Errorf("no history for %v bars", spec.Interval)
+	}
+	return nil
+}
+
+// LoadHistory fetches the most recent bars for each stock up to the
+// given time, oldest first, by symbol
+func LoadHistory(client api.AlpacaClient, symbols []string, spec api.HistorySpec, end time.Time) (map[string][]api.Bar, error) {
+	history := map[string][]api.Bar{}
+	if spec.Bars == 0 || len(symbols) == 0 {
+		return history, nil
+	}
+	if err := CheckHistory(spec); err != nil {
+		return nil, err
+	}
+
+	limit := spec.Bars
+	results, err := client.ListBars(symbols, alpaca.ListBarParams{
+		Timeframe: timeframes[spec.Interval],
+		EndDt:     &end,
+		Limit:     &limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, symbol := range symbols {
+		history[symbol] = []api.Bar{}
+		for _, result := range results[symbol] {
+			start := time.Unix(result.Time, 0).UTC()
+			history[symbol] = append(history[symbol], api.Bar{
+				Symbol: symbol,
+				Start:  start,
+				End:    start.Add(spec.Interval),
+				Open:   float64(result.Open),
+				High:   float64(result.High),
+				Low:    float64(result.Low),
+				Close:  float64(result.Close),
+				Volume: float64(result.Volume),
+			})
+		}
+	}
+
+	return history, nil
+}
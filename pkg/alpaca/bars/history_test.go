@@ -0,0 +1,32 @@
+package bars_test
+
+import (
+	"time"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/bars"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("History", func() {
+	It("should accept the intervals Alpaca keeps", func() {
+		for _, interval := range []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute, 24 * time.Hour} {
+			Expect(bars.CheckHistory(api.HistorySpec{Interval: interval, Bars: 10})).To(Succeed())
+		}
+	})
+
+	It("should not need an interval when no history is wanted", func() {
+		Expect(bars.CheckHistory(api.HistorySpec{})).To(Succeed())
+	})
+
+	It("should reject other intervals", func() {
+		Expect(bars.CheckHistory(api.HistorySpec{Interval: time.Hour, Bars: 10})).To(MatchError("no history for 1h0m0s bars"))
+	})
+
+	It("should not load anything when no history is wanted", func() {
+		history, err := bars.LoadHistory(nil, []string{"MKL"}, api.HistorySpec{}, time.Now())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(history).To(BeEmpty())
+	})
+})
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/alpacahq/alpaca-trade-api-go/common"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+)
+
+const (
+	apiVersion string = "v2"
+)
+
+var _ api.AlpacaClient = &Client{}
+
+// Client extends the Alpaca SDK client with the endpoints and fields
+// the vendored SDK does not decode yet.
+type Client struct {
+	*alpaca.Client
+
+	baseURL     string
+	credentials *common.APIKey
+	httpClient  *http.Client
+}
+
+// NewClient returns a new client for the given Alpaca base URL
+func NewClient(baseURL string, credentials *common.APIKey) *Client {
+	return &Client{
+		Client:      alpaca.NewClient(credentials),
+		baseURL:     strings.TrimSuffix(baseURL, "/"),
+		credentials: credentials,
+		httpClient:  http.DefaultClient,
+	}
+}
+
+// GetAsset returns an asset for the given symbol, including whether
+// it can be traded in fractional quantities.
+func (c *Client) GetAsset(symbol string) (*api.Asset, error) {
+	u, err := url.Parse(fmt.Sprintf("%s/%s/assets/%s", c.baseURL, apiVersion, symbol))
+	if err != nil {
+		return nil, err
+	}
+
+	asset := &api.Asset{}
+	if err := c.get(u, asset); err != nil {
+		return nil, err
+	}
+
+	return asset, nil
+}
+
+func (c *Client) get(u *url.URL, data interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	if c.credentials.OAuth != "" {
+		req.Header.Set("Authorization", "Bearer "+c.credentials.OAuth)
+	} else {
+		req.Header.Set("APCA-API-KEY-ID", c.credentials.ID)
+		req.Header.Set("APCA-API-SECRET-KEY", c.credentials.Secret)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		// Mirror the SDK, which surfaces API failures as *alpaca.APIError
+		apiErr := &alpaca.APIError{}
+		if err := json.Unmarshal(body, apiErr); err != nil {
+			return &alpaca.APIError{Code: resp.StatusCode, Message: fmt.Sprintf("status code %v", resp.StatusCode)}
+		}
+		return apiErr
+	}
+
+	return json.Unmarshal(body, data)
+}
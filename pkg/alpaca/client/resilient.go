@@ -0,0 +1,387 @@
+package client
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/apierrors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultRequestsPerMinute is Alpaca's limit on requests per API key
+	DefaultRequestsPerMinute int = 200
+
+	// DefaultRetries is how many times a failed call is retried
+	DefaultRetries int = 3
+
+	// DefaultBackoff is the longest wait before the first retry,
+	// doubled for each retry after it
+	DefaultBackoff time.Duration = 250 * time.Millisecond
+
+	// DefaultMaxBackoff caps the wait before any retry
+	DefaultMaxBackoff time.Duration = 5 * time.Second
+
+	// DefaultFailureThreshold is how many calls in a row may fail
+	// before the circuit opens
+	DefaultFailureThreshold int = 5
+
+	// DefaultCooldown is how long the circuit stays open before
+	// a call is let through to test the API
+	DefaultCooldown time.Duration = 30 * time.Second
+)
+
+var _ api.FailFastClient = &Resilient{}
+
+// ErrCircuitOpen is returned without calling Alpaca while
+// the API is unhealthy
+var ErrCircuitOpen error = apierrors.New(apierrors.Transient, "alpaca api is unhealthy, requests are paused")
+
+// ErrRateLimited is returned without calling Alpaca by a client that
+// fails fast, when a call would go over the rate limit
+var ErrRateLimited error = apierrors.New(apierrors.RateLimited, "request rate limit reached, try again shortly")
+
+// Resilient wraps a client, keeping requests under Alpaca's rate limit
+// and retrying calls that are safe to repeat when Alpaca rate limits us
+// or fails on its side. Orders are only retried when rate limited, as
+// any other failure may have placed them. Once enough calls in a row
+// have failed, the circuit opens and every call fails fast, which
+// pauses trading until a call gets through again.
+type Resilient struct {
+	client  api.AlpacaClient
+	limiter *tokenBucket
+	breaker *breaker
+	// failFast makes calls that would wait for the rate limit fail
+	// instead, and is only set on the clients FailFast returns
+	failFast bool
+
+	// Retries, Backoff and MaxBackoff control retries, which wait a
+	// random time up to the backoff, doubling for each retry
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// FailureThreshold and Cooldown control the circuit breaker
+	FailureThreshold int
+	Cooldown         time.Duration
+
+	// Errors counts the calls that failed by kind
+	Errors *apierrors.Counter
+}
+
+// breaker is the state of the circuit breaker. failures is how many
+// calls in a row have failed, openedAt when the circuit last opened,
+// or zero while it is closed, and trial whether a call is testing the
+// API while it is open.
+type breaker struct {
+	failures int
+	openedAt time.Time
+	trial    bool
+	mu       sync.Mutex
+}
+
+// NewResilient returns a client making at most the given number of
+// requests a minute through the wrapped client, or any number if it
+// is not positive
+func NewResilient(client api.AlpacaClient, requestsPerMinute int) *Resilient {
+	return &Resilient{
+		client:           client,
+		limiter:          newTokenBucket(requestsPerMinute),
+		breaker:          &breaker{},
+		Errors:           &apierrors.Counter{},
+		Retries:          DefaultRetries,
+		Backoff:          DefaultBackoff,
+		MaxBackoff:       DefaultMaxBackoff,
+		FailureThreshold: DefaultFailureThreshold,
+		Cooldown:         DefaultCooldown,
+	}
+}
+
+// FailFast returns a client sharing this one's rate limit, circuit
+// breaker and error counts that never waits: failed calls are not
+// retried, and calls over the rate limit fail with ErrRateLimited. It
+// is for callers holding a lock that other work is queued behind.
+func (r *Resilient) FailFast() api.AlpacaClient {
+	failFast := *r
+	failFast.Retries = 0
+	failFast.failFast = true
+	return &failFast
+}
+
+// temporary reports whether an error is likely to pass on its own: Alpaca
+// rate limiting us or failing on its side, or the network failing
+func temporary(err error) bool {
+	return apierrors.Classify(err).Temporary()
+}
+
+// rateLimited reports whether Alpaca refused a call for exceeding
+// its rate limit, so the call was not acted on
+func rateLimited(err error) bool {
+	return apierrors.Is(err, apierrors.RateLimited)
+}
+
+// CancelAllOrders implements the function on the api.AlpacaClient interface
+func (r *Resilient) CancelAllOrders() error {
+	return r.call("CancelAllOrders", temporary, r.client.CancelAllOrders)
+}
+
+// GetAccount implements the function on the api.AlpacaClient interface
+func (r *Resilient) GetAccount() (account *alpaca.Account, err error) {
+	err = r.call("GetAccount", temporary, func() (err error) {
+		account, err = r.client.GetAccount()
+		return err
+	})
+	return account, err
+}
+
+// GetAsset implements the function on the api.AlpacaClient interface
+func (r *Resilient) GetAsset(symbol string) (asset *api.Asset, err error) {
+	err = r.call("GetAsset", temporary, func() (err error) {
+		asset, err = r.client.GetAsset(symbol)
+		return err
+	})
+	return asset, err
+}
+
+// GetPosition implements the function on the api.AlpacaClient interface
+func (r *Resilient) GetPosition(symbol string) (position *alpaca.Position, err error) {
+	err = r.call("GetPosition", temporary, func() (err error) {
+		position, err = r.client.GetPosition(symbol)
+		return err
+	})
+	return position, err
+}
+
+// GetCalendar implements the function on the api.AlpacaClient interface
+func (r *Resilient) GetCalendar(start, end *string) (days []alpaca.CalendarDay, err error) {
+	err = r.call("GetCalendar", temporary, func() (err error) {
+		days, err = r.client.GetCalendar(start, end)
+		return err
+	})
+	return days, err
+}
+
+// GetClock implements the function on the api.AlpacaClient interface
+func (r *Resilient) GetClock() (clock *alpaca.Clock, err error) {
+	err = r.call("GetClock", temporary, func() (err error) {
+		clock, err = r.client.GetClock()
+		return err
+	})
+	return clock, err
+}
+
+// ListBars implements the function on the api.AlpacaClient interface
+func (r *Resilient) ListBars(symbols []string, opts alpaca.ListBarParams) (bars map[string][]alpaca.Bar, err error) {
+	err = r.call("ListBars", temporary, func() (err error) {
+		bars, err = r.client.ListBars(symbols, opts)
+		return err
+	})
+	return bars, err
+}
+
+// ListPositions implements the function on the api.AlpacaClient interface
+func (r *Resilient) ListPositions() (positions []alpaca.Position, err error) {
+	err = r.call("ListPositions", temporary, func() (err error) {
+		positions, err = r.client.ListPositions()
+		return err
+	})
+	return positions, err
+}
+
+// CancelOrder implements the function on the api.AlpacaClient interface
+func (r *Resilient) CancelOrder(orderID string) error {
+	return r.call("CancelOrder", temporary, func() error {
+		return r.client.CancelOrder(orderID)
+	})
+}
+
+// ListOrders implements the function on the api.AlpacaClient interface
+func (r *Resilient) ListOrders(status *string, until *time.Time, limit *int, nested *bool) (orders []alpaca.Order, err error) {
+	err = r.call("ListOrders", temporary, func() (err error) {
+		orders, err = r.client.ListOrders(status, until, limit, nested)
+		return err
+	})
+	return orders, err
+}
+
+// PlaceOrder implements the function on the api.AlpacaClient interface.
+// It is only retried when rate limited, as after any other failure the
+// order may have been placed.
+func (r *Resilient) PlaceOrder(req alpaca.PlaceOrderRequest) (order *alpaca.Order, err error) {
+	err = r.call("PlaceOrder", rateLimited, func() (err error) {
+		order, err = r.client.PlaceOrder(req)
+		return err
+	})
+	return order, err
+}
+
+// call makes a call through the circuit breaker and rate limit,
+// retrying failures the retry function allows
+func (r *Resilient) call(name string, retry func(error) bool, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		if r.failFast && !r.limiter.take() {
+			r.Errors.Record(ErrRateLimited)
+			return ErrRateLimited
+		}
+
+		trial, err := r.allow()
+		if err != nil {
+			return err
+		}
+
+		if !r.failFast {
+			r.limiter.wait()
+		}
+		err = fn()
+		r.record(err, trial)
+		kind := r.Errors.Record(err)
+
+		if err == nil || attempt >= r.Retries || !retry(err) {
+			return err
+		}
+
+		delay := r.backoff(attempt)
+		logrus.WithFields(logrus.Fields{
+			"call":       name,
+			"attempt":    attempt + 1,
+			"delay":      delay,
+			"error_kind": kind,
+		}).Warnf("Retrying failed call: %v", err)
+		time.Sleep(delay)
+	}
+}
+
+// backoff returns a random wait before a retry, up to a limit
+// that doubles with each attempt
+func (r *Resilient) backoff(attempt int) time.Duration {
+	limit := float64(r.Backoff) * math.Pow(2, float64(attempt))
+	if limit > float64(r.MaxBackoff) {
+		limit = float64(r.MaxBackoff)
+	}
+	if limit < 1 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(limit)))
+}
+
+// allow fails fast while the circuit is open. Once the cooldown has
+// passed, one call at a time is let through to test the API, which
+// it reports as a trial.
+func (r *Resilient) allow() (bool, error) {
+	b := r.breaker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return false, nil
+	}
+	if b.trial || time.Since(b.openedAt) < r.Cooldown {
+		return false, ErrCircuitOpen
+	}
+	b.trial = true
+	return true, nil
+}
+
+// record counts a call towards opening the circuit if it failed in a
+// way that suggests the API is unhealthy, and closes it on success
+func (r *Resilient) record(err error, trial bool) {
+	b := r.breaker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if trial {
+		b.trial = false
+	}
+
+	if !temporary(err) {
+		if !b.openedAt.IsZero() {
+			logrus.Info("Alpaca API recovered, resuming requests")
+		}
+		b.failures = 0
+		b.openedAt = time.Time{}
+		return
+	}
+
+	b.failures++
+	if trial || (b.openedAt.IsZero() && b.failures >= r.FailureThreshold) {
+		b.openedAt = time.Now()
+		logrus.WithFields(logrus.Fields{
+			"failures": b.failures,
+			"cooldown": r.Cooldown,
+		}).Errorf("Alpaca API is unhealthy, pausing requests: %v", err)
+	}
+}
+
+// tokenBucket spaces out requests to stay under a rate limit, letting
+// through bursts of up to a tenth of the limit at once
+type tokenBucket struct {
+	capacity float64
+	tokens   float64
+	// rate is how many tokens are added a second
+	rate float64
+	last time.Time
+	mu   sync.Mutex
+}
+
+// newTokenBucket returns a bucket for a limit of requests a minute,
+// or nil if there is no limit
+func newTokenBucket(perMinute int) *tokenBucket {
+	if perMinute <= 0 {
+		return nil
+	}
+
+	capacity := math.Max(1, float64(perMinute)/10)
+	return &tokenBucket{
+		capacity: capacity,
+		tokens:   capacity,
+		rate:     float64(perMinute) / 60,
+		last:     time.Now(),
+	}
+}
+
+// take takes a token if there is one, without waiting
+func (b *tokenBucket) take() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// wait takes a token, waiting for one to be added if there are none.
+// Tokens are reserved in turn, so waiting requests go in order.
+func (b *tokenBucket) wait() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	b.refill()
+	b.tokens--
+	delay := time.Duration(0)
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// refill adds the tokens earned since the last refill, up to capacity
+func (b *tokenBucket) refill() {
+	now := time.Now()
+	b.tokens = math.Min(b.capacity, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
@@ -0,0 +1,157 @@
+package client_test
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/apierrors"
+	"github.com/markliederbach/stonks/pkg/alpaca/client"
+	"github.com/markliederbach/stonks/pkg/alpaca/internal"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/shopspring/decimal"
+)
+
+var _ = Describe("Resilient", func() {
+	var (
+		resilient     *client.Resilient
+		unavailable   error
+		rateLimited   error
+		notFound      error
+		symbol        string = "MKL"
+		limitPrice           = decimal.NewFromFloat(100)
+		orderRequest  alpaca.PlaceOrderRequest
+		requestsLimit int
+	)
+
+	BeforeEach(func() {
+		unavailable = &alpaca.APIError{Code: 50010000, Message: "internal server error"}
+		rateLimited = &alpaca.APIError{Code: 42910000, Message: "rate limit exceeded"}
+		notFound = &alpaca.APIError{Code: 40410000, Message: "position does not exist"}
+		orderRequest = alpaca.PlaceOrderRequest{
+			AssetKey:   &symbol,
+			Qty:        decimal.NewFromInt(1),
+			Side:       alpaca.Buy,
+			Type:       alpaca.Limit,
+			LimitPrice: &limitPrice,
+		}
+		requestsLimit = 0
+	})
+
+	JustBeforeEach(func() {
+		resilient = client.NewResilient(internal.NewMockAlpacaClient(), requestsLimit)
+		resilient.Backoff = time.Millisecond
+		resilient.Cooldown = 50 * time.Millisecond
+	})
+
+	It("should retry calls that are safe to repeat", func() {
+		Expect(internal.AddObjReturns("GetAccount", unavailable, rateLimited)).To(Succeed())
+		account, err := resilient.GetAccount()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(account.ID).To(Equal("account123"))
+		Expect(resilient.Errors.Counts()).To(Equal(map[apierrors.Kind]int{
+			apierrors.Transient:   1,
+			apierrors.RateLimited: 1,
+		}))
+	})
+
+	It("should retry failures with bodies that are not JSON", func() {
+		gatewayPage := json.Unmarshal([]byte("<html>502 Bad Gateway</html>"), &alpaca.APIError{})
+		Expect(internal.AddObjReturns("GetClock", gatewayPage)).To(Succeed())
+		_, err := resilient.GetClock()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should not retry when failing fast", func() {
+		Expect(internal.AddObjReturns("GetAccount", unavailable)).To(Succeed())
+		_, err := resilient.FailFast().GetAccount()
+		Expect(err).To(Equal(unavailable))
+	})
+
+	It("should give up after its retries", func() {
+		resilient.Retries = 1
+		Expect(internal.AddObjReturns("ListOrders", unavailable, unavailable)).To(Succeed())
+		_, err := resilient.ListOrders(nil, nil, nil, nil)
+		Expect(err).To(Equal(unavailable))
+	})
+
+	It("should not retry errors that will not pass", func() {
+		Expect(internal.AddObjReturns("GetPosition", notFound)).To(Succeed())
+		_, err := resilient.GetPosition(symbol)
+		Expect(err).To(Equal(notFound))
+	})
+
+	It("should not retry orders that may have been placed", func() {
+		Expect(internal.AddObjReturns("PlaceOrder", unavailable)).To(Succeed())
+		_, err := resilient.PlaceOrder(orderRequest)
+		Expect(err).To(Equal(unavailable))
+	})
+
+	It("should retry orders that were rate limited", func() {
+		Expect(internal.AddObjReturns("PlaceOrder", rateLimited)).To(Succeed())
+		order, err := resilient.PlaceOrder(orderRequest)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(order.ID).To(Equal("order123"))
+	})
+
+	Context("when the API keeps failing", func() {
+		JustBeforeEach(func() {
+			resilient.Retries = 0
+			resilient.FailureThreshold = 2
+			Expect(internal.AddObjReturns("GetClock", unavailable, unavailable)).To(Succeed())
+			for i := 0; i < 2; i++ {
+				_, err := resilient.GetClock()
+				Expect(err).To(Equal(unavailable))
+			}
+		})
+
+		It("should pause requests", func() {
+			_, err := resilient.PlaceOrder(orderRequest)
+			Expect(err).To(Equal(client.ErrCircuitOpen))
+		})
+
+		It("should resume once a call gets through after the cooldown", func() {
+			time.Sleep(60 * time.Millisecond)
+			_, err := resilient.GetClock()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = resilient.PlaceOrder(orderRequest)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should stay paused if the call after the cooldown fails", func() {
+			time.Sleep(60 * time.Millisecond)
+			Expect(internal.AddObjReturns("GetClock", unavailable)).To(Succeed())
+			_, err := resilient.GetClock()
+			Expect(err).To(Equal(unavailable))
+			_, err = resilient.GetClock()
+			Expect(err).To(Equal(client.ErrCircuitOpen))
+		})
+	})
+
+	Context("with a rate limit", func() {
+		BeforeEach(func() {
+			requestsLimit = 600
+		})
+
+		It("should refuse requests beyond a burst when failing fast", func() {
+			failFast := resilient.FailFast()
+			for i := 0; i < 60; i++ {
+				_, err := failFast.GetClock()
+				Expect(err).ToNot(HaveOccurred())
+			}
+			_, err := failFast.GetClock()
+			Expect(err).To(Equal(client.ErrRateLimited))
+			Expect(resilient.Errors.Counts()).To(Equal(map[apierrors.Kind]int{apierrors.RateLimited: 1}))
+		})
+
+		It("should space out requests beyond a burst", func() {
+			start := time.Now()
+			for i := 0; i < 61; i++ {
+				_, err := resilient.GetClock()
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(time.Since(start)).To(BeNumerically(">=", 90*time.Millisecond))
+		})
+	})
+})
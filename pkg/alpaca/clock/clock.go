@@ -0,0 +1,148 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+)
+
+var _ api.Clock = Real{}
+var _ api.Clock = &Fake{}
+
+// Real is the wall clock, in UTC
+type Real struct{}
+
+// Now implements the function on the api.Clock interface
+func (Real) Now() time.Time {
+	return time.Now().UTC()
+}
+
+// Every implements the function on the api.Clock interface
+func (Real) Every(interval time.Duration, done <-chan struct{}, fn func(time.Time)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				fn(now.UTC())
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// Fake is a clock that only moves when told to. Schedules run on
+// the goroutine moving the clock, so what they do is done by the
+// time it returns.
+type Fake struct {
+	now       time.Time
+	schedules []*schedule
+	// added counts the schedules ever added
+	added int
+	mu    sync.Mutex
+}
+
+// schedule is a function called on an interval by a fake clock
+type schedule struct {
+	interval time.Duration
+	next     time.Time
+	// added orders schedules due at the same time
+	added int
+	done  <-chan struct{}
+	fn    func(time.Time)
+}
+
+// NewFake returns a fake clock stopped at the given time
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now.UTC()}
+}
+
+// Now implements the function on the api.Clock interface
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+// Every implements the function on the api.Clock interface. The
+// first call is one interval after the time the schedule was added.
+func (f *Fake) Every(interval time.Duration, done <-chan struct{}, fn func(time.Time)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.added++
+	f.schedules = append(f.schedules, &schedule{
+		interval: interval,
+		next:     f.now.Add(interval),
+		added:    f.added,
+		done:     done,
+		fn:       fn,
+	})
+}
+
+// Set moves the clock forward to the given time, running every
+// schedule that falls due on the way in time order. Schedules due at
+// the same time run in the order they were added. The clock never
+// moves backwards.
+func (f *Fake) Set(now time.Time) {
+	now = now.UTC()
+	for {
+		f.mu.Lock()
+		f.dropDone()
+		due := f.nextDue(now)
+		if due == nil {
+			if now.After(f.now) {
+				f.now = now
+			}
+			f.mu.Unlock()
+			return
+		}
+
+		tick := due.next
+		due.next = due.next.Add(due.interval)
+		f.now = tick
+		f.mu.Unlock()
+
+		// Call outside the lock, so schedules can read the clock
+		due.fn(tick)
+	}
+}
+
+// Add moves the clock forward by a duration, as Set does
+func (f *Fake) Add(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// nextDue returns the schedule due soonest, by the given time
+func (f *Fake) nextDue(now time.Time) *schedule {
+	sort.Slice(f.schedules, func(i, j int) bool {
+		a, b := f.schedules[i], f.schedules[j]
+		if a.next.Equal(b.next) {
+			return a.added < b.added
+		}
+		return a.next.Before(b.next)
+	})
+	if len(f.schedules) == 0 || f.schedules[0].next.After(now) {
+		return nil
+	}
+	return f.schedules[0]
+}
+
+// dropDone removes the schedules whose done channel has closed
+func (f *Fake) dropDone() {
+	running := f.schedules[:0]
+	for _, s := range f.schedules {
+		select {
+		case <-s.done:
+		default:
+			running = append(running, s)
+		}
+	}
+	f.schedules = running
+}
@@ -0,0 +1,74 @@
+package clock_test
+
+import (
+	"time"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/clock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Clock", func() {
+	var (
+		start time.Time
+		fake  *clock.Fake
+		done  chan struct{}
+		ticks []string
+	)
+
+	BeforeEach(func() {
+		start = time.Date(2020, 11, 2, 14, 30, 0, 0, time.UTC)
+		fake = clock.NewFake(start)
+		done = make(chan struct{})
+		ticks = nil
+	})
+
+	It("should only move when set", func() {
+		Expect(fake.Now()).To(Equal(start))
+		fake.Set(start.Add(time.Minute))
+		Expect(fake.Now()).To(Equal(start.Add(time.Minute)))
+	})
+
+	It("should never move backwards", func() {
+		fake.Set(start.Add(-time.Minute))
+		Expect(fake.Now()).To(Equal(start))
+	})
+
+	It("should run schedules due on the way in time order", func() {
+		fake.Every(2*time.Second, done, func(now time.Time) {
+			ticks = append(ticks, "slow "+now.Format("15:04:05"))
+		})
+		fake.Every(time.Second, done, func(now time.Time) {
+			Expect(fake.Now()).To(Equal(now))
+			ticks = append(ticks, "fast "+now.Format("15:04:05"))
+		})
+
+		fake.Add(2500 * time.Millisecond)
+		Expect(ticks).To(Equal([]string{
+			"fast 14:30:01",
+			"slow 14:30:02",
+			"fast 14:30:02",
+		}))
+		Expect(fake.Now()).To(Equal(start.Add(2500 * time.Millisecond)))
+	})
+
+	It("should stop schedules once done", func() {
+		fake.Every(time.Second, done, func(now time.Time) {
+			ticks = append(ticks, now.Format("15:04:05"))
+		})
+
+		fake.Add(time.Second)
+		close(done)
+		fake.Add(time.Second)
+		Expect(ticks).To(Equal([]string{"14:30:01"}))
+	})
+
+	It("should tick the real clock until done", func() {
+		calls := make(chan time.Time, 10)
+		clock.Real{}.Every(time.Millisecond, done, func(now time.Time) {
+			calls <- now
+		})
+		Eventually(calls).Should(Receive())
+		close(done)
+	})
+})
@@ -3,8 +3,14 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/alpacahq/alpaca-trade-api-go/common"
+	"github.com/markliederbach/stonks/pkg/alpaca/client"
+	"github.com/markliederbach/stonks/pkg/alpaca/controller"
+	"github.com/markliederbach/stonks/pkg/alpaca/sizing"
 	"github.com/sirupsen/logrus"
 )
 
@@ -15,8 +21,104 @@ const (
 	// LogLevelVariable specifies the logging level
 	LogLevelVariable string = "APCA_LOG_LEVEL"
 
+	// MaxPositionValueVariable caps the dollar value held in any single stock
+	MaxPositionValueVariable string = "APCA_MAX_POSITION_VALUE"
+
+	// MaxShortExposureVariable caps the dollar value of all short positions
+	MaxShortExposureVariable string = "APCA_MAX_SHORT_EXPOSURE"
+
+	// SizingVariable selects position sizers per symbol, as a comma-separated
+	// list of SYMBOL=spec pairs, with "default" applying to all other symbols.
+	// DefaultSizer is used when no "default" is given, with a warning
+	// naming the stocks it sizes.
+	SizingVariable string = "APCA_SIZING"
+
+	// ChannelsVariable selects the market data followed per symbol, as a
+	// comma-separated list of SYMBOL=channel|channel pairs, with "default"
+	// applying to all other symbols
+	ChannelsVariable string = "APCA_CHANNELS"
+
+	// DataSourceVariable selects where market data is streamed from,
+	// either alpaca or polygon
+	DataSourceVariable string = "APCA_DATA_SOURCE"
+
+	// RequestsPerMinuteVariable caps the requests made to Alpaca's REST
+	// API, where zero leaves them uncapped
+	RequestsPerMinuteVariable string = "APCA_REQUESTS_PER_MINUTE"
+
+	// AccountRefreshVariable is how long account details may go without
+	// an update before a trade fetches them again, as a duration such as
+	// 30s. Updates are pushed by Alpaca as the account changes.
+	AccountRefreshVariable string = "APCA_ACCOUNT_REFRESH"
+
+	// StaleAfterVariable is how long a stock may go without market data
+	// while the market is open before it is resubscribed to, as a
+	// duration such as 2m. Streams are not watched when it is unset.
+	StaleAfterVariable string = "APCA_STALE_AFTER"
+
+	// GapAfterVariable is how much time may be missing between trades,
+	// or minute aggregates, before what was missed is backfilled, as a
+	// duration such as 1m. Gaps are only backfilled after resubscribing
+	// when it is unset.
+	GapAfterVariable string = "APCA_GAP_AFTER"
+
+	// HistoryDirVariable specifies a directory to cache backfilled
+	// history in. History is fetched every time when it is unset.
+	HistoryDirVariable string = "APCA_HISTORY_DIR"
+
+	// AlgorithmVariable selects the algorithm to trade with
+	AlgorithmVariable string = "APCA_ALGORITHM"
+
+	// AlgorithmParamsVariable configures the algorithm, as a comma-separated
+	// list of KEY=VALUE pairs
+	AlgorithmParamsVariable string = "APCA_ALGORITHM_PARAMS"
+
+	// StocksVariable specifies the stocks to trade, as a comma-separated list
+	StocksVariable string = "APCA_STOCKS"
+
+	// JournalPathVariable specifies a file to record trades in, one JSON
+	// object per line. Trades are not recorded when it is unset.
+	JournalPathVariable string = "APCA_JOURNAL_PATH"
+
+	// RecordDirVariable specifies a directory to record every stream event
+	// in, as daily compressed files. Events are not recorded when it is unset.
+	RecordDirVariable string = "APCA_RECORD_DIR"
+
+	// ReplayDirVariable specifies a recording to replay against a simulated
+	// broker, instead of trading live. Orders sent are compared with those
+	// recorded.
+	ReplayDirVariable string = "APCA_REPLAY_DIR"
+
+	// ReplayFromVariable and ReplayToVariable bound the events replayed,
+	// as RFC 3339 times
+	ReplayFromVariable string = "APCA_REPLAY_FROM"
+	ReplayToVariable   string = "APCA_REPLAY_TO"
+
+	// ReplaySpeedVariable is how many times faster than real time to
+	// replay, where zero replays as fast as possible
+	ReplaySpeedVariable string = "APCA_REPLAY_SPEED"
+
+	// ReplayCashVariable is the cash the simulated broker starts with
+	ReplayCashVariable string = "APCA_REPLAY_CASH"
+
 	// DefaultLogLevel specifies the default logging level
 	DefaultLogLevel logrus.Level = logrus.InfoLevel
+
+	// DefaultAlgorithm specifies the default algorithm
+	DefaultAlgorithm string = "martingale"
+
+	// DefaultDataSource specifies the default market data source
+	DefaultDataSource string = "alpaca"
+
+	// DefaultSizer specifies the sizer for stocks without one of their
+	// own when APCA_SIZING gives no "default"
+	DefaultSizer string = "percent_equity:0.1"
+
+	// DefaultStocks specifies the default stocks to trade
+	DefaultStocks string = "VTI"
+
+	// DefaultReplayCash specifies the default cash to replay with
+	DefaultReplayCash float64 = 100000
 )
 
 // Config holds all configuration data about the currently-running service
@@ -27,7 +129,27 @@ type Config struct {
 	AlpacaAPISecretKey string
 
 	// Optional variables
-	LogLevel logrus.Level
+	LogLevel          logrus.Level
+	Stocks            []string
+	Algorithm         string
+	AlgorithmParams   map[string]string
+	MaxPositionValue  float64
+	MaxShortExposure  float64
+	Sizing            map[string]string
+	Channels          map[string]string
+	DataSource        string
+	RequestsPerMinute int
+	AccountRefresh    time.Duration
+	StaleAfter        time.Duration
+	GapAfter          time.Duration
+	HistoryDir        string
+	JournalPath       string
+	RecordDir         string
+	ReplayDir         string
+	ReplayFrom        time.Time
+	ReplayTo          time.Time
+	ReplaySpeed       float64
+	ReplayCash        float64
 }
 
 // Load creates a new instance of Config, using all available
@@ -40,10 +162,31 @@ func Load() Config {
 		AlpacaAPISecretKey: fromEnvString(common.EnvApiSecretKey, true, ""),
 
 		// Optional
-		LogLevel: fromEnvLogLevel(LogLevelVariable, false, DefaultLogLevel),
+		LogLevel:          fromEnvLogLevel(LogLevelVariable, false, DefaultLogLevel),
+		Stocks:            fromEnvList(StocksVariable, false, DefaultStocks),
+		Algorithm:         fromEnvString(AlgorithmVariable, false, DefaultAlgorithm),
+		AlgorithmParams:   fromEnvMap(AlgorithmParamsVariable, false, map[string]string{}),
+		MaxPositionValue:  fromEnvFloat(MaxPositionValueVariable, false, 0),
+		MaxShortExposure:  fromEnvFloat(MaxShortExposureVariable, false, 0),
+		Sizing:            fromEnvMap(SizingVariable, false, map[string]string{}),
+		Channels:          fromEnvMap(ChannelsVariable, false, map[string]string{}),
+		DataSource:        fromEnvString(DataSourceVariable, false, DefaultDataSource),
+		RequestsPerMinute: fromEnvInt(RequestsPerMinuteVariable, false, client.DefaultRequestsPerMinute),
+		AccountRefresh:    fromEnvDuration(AccountRefreshVariable, false, controller.DefaultAccountRefresh),
+		StaleAfter:        fromEnvDuration(StaleAfterVariable, false, 0),
+		GapAfter:          fromEnvDuration(GapAfterVariable, false, 0),
+		HistoryDir:        fromEnvString(HistoryDirVariable, false, ""),
+		JournalPath:       fromEnvString(JournalPathVariable, false, ""),
+		RecordDir:         fromEnvString(RecordDirVariable, false, ""),
+		ReplayDir:         fromEnvString(ReplayDirVariable, false, ""),
+		ReplayFrom:        fromEnvTime(ReplayFromVariable, false, time.Time{}),
+		ReplayTo:          fromEnvTime(ReplayToVariable, false, time.Time{}),
+		ReplaySpeed:       fromEnvFloat(ReplaySpeedVariable, false, 0),
+		ReplayCash:        fromEnvFloat(ReplayCashVariable, false, DefaultReplayCash),
 	}
 
 	config.configureLogger()
+	config.defaultSizing()
 
 	return config
 }
@@ -60,6 +203,30 @@ func (c *Config) configureLogger() {
 	logrus.SetLevel(c.LogLevel)
 }
 
+// defaultSizing falls back to DefaultSizer when no "default" sizer is
+// given, since signals from the algorithm need a sizer for every stock.
+// The fallback decides how much of the account each trade risks, so it
+// is logged as a warning for every stock that takes it.
+func (c *Config) defaultSizing() {
+	if _, ok := c.Sizing[sizing.DefaultKey]; ok {
+		return
+	}
+	c.Sizing[sizing.DefaultKey] = DefaultSizer
+
+	unsized := []string{}
+	for _, stock := range c.Stocks {
+		if _, ok := c.Sizing[stock]; !ok {
+			unsized = append(unsized, stock)
+		}
+	}
+	if len(unsized) > 0 {
+		logrus.WithFields(logrus.Fields{
+			"stocks": unsized,
+			"sizer":  DefaultSizer,
+		}).Warnf("No default sizer in %s, sizing stocks with the fallback", SizingVariable)
+	}
+}
+
 func fromEnvString(variable string, required bool, defaultValue string) string {
 	rawValue, exists := fromEnv(variable, required)
 	if !exists {
@@ -81,6 +248,84 @@ func fromEnvLogLevel(variable string, required bool, defaultValue logrus.Level)
 	return value
 }
 
+func fromEnvFloat(variable string, required bool, defaultValue float64) float64 {
+	var err error
+	value := defaultValue
+	rawValue, exists := fromEnv(variable, required)
+	if exists {
+		value, err = strconv.ParseFloat(rawValue, 64)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return value
+}
+
+func fromEnvInt(variable string, required bool, defaultValue int) int {
+	var err error
+	value := defaultValue
+	rawValue, exists := fromEnv(variable, required)
+	if exists {
+		value, err = strconv.Atoi(rawValue)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return value
+}
+
+func fromEnvDuration(variable string, required bool, defaultValue time.Duration) time.Duration {
+	var err error
+	value := defaultValue
+	rawValue, exists := fromEnv(variable, required)
+	if exists {
+		value, err = time.ParseDuration(rawValue)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return value
+}
+
+func fromEnvTime(variable string, required bool, defaultValue time.Time) time.Time {
+	var err error
+	value := defaultValue
+	rawValue, exists := fromEnv(variable, required)
+	if exists {
+		value, err = time.Parse(time.RFC3339, rawValue)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return value
+}
+
+func fromEnvList(variable string, required bool, defaultValue string) []string {
+	value := []string{}
+	for _, item := range strings.Split(fromEnvString(variable, required, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			value = append(value, item)
+		}
+	}
+	return value
+}
+
+func fromEnvMap(variable string, required bool, defaultValue map[string]string) map[string]string {
+	value := defaultValue
+	rawValue, exists := fromEnv(variable, required)
+	if exists {
+		value = map[string]string{}
+		for _, pair := range strings.Split(rawValue, ",") {
+			parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				panic(fmt.Errorf("Invalid KEY=VALUE pair %q in %s", pair, variable))
+			}
+			value[parts[0]] = parts[1]
+		}
+	}
+	return value
+}
+
 func fromEnv(variable string, required bool) (string, bool) {
 	value, exists := os.LookupEnv(variable)
 	if !exists && required {
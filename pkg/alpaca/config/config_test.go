@@ -3,13 +3,16 @@ package config_test
 import (
 	"os"
 	"strings"
+	"time"
 
 	"github.com/alpacahq/alpaca-trade-api-go/common"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/sirupsen/logrus"
 
+	"github.com/markliederbach/stonks/pkg/alpaca/client"
 	"github.com/markliederbach/stonks/pkg/alpaca/config"
+	"github.com/markliederbach/stonks/pkg/alpaca/controller"
 )
 
 var _ = Describe("Config", func() {
@@ -48,6 +51,24 @@ var _ = Describe("Config", func() {
 
 			It("should set default optional variables on the config object", func() {
 				Expect(appConfig.LogLevel).To(Equal(config.DefaultLogLevel))
+				Expect(appConfig.Stocks).To(Equal([]string{config.DefaultStocks}))
+				Expect(appConfig.Algorithm).To(Equal(config.DefaultAlgorithm))
+				Expect(appConfig.AlgorithmParams).To(BeEmpty())
+				Expect(appConfig.MaxPositionValue).To(Equal(float64(0)))
+				Expect(appConfig.MaxShortExposure).To(Equal(float64(0)))
+				Expect(appConfig.Sizing).To(Equal(map[string]string{"default": config.DefaultSizer}))
+				Expect(appConfig.Channels).To(BeEmpty())
+				Expect(appConfig.JournalPath).To(BeEmpty())
+				Expect(appConfig.RecordDir).To(BeEmpty())
+				Expect(appConfig.DataSource).To(Equal(config.DefaultDataSource))
+				Expect(appConfig.RequestsPerMinute).To(Equal(client.DefaultRequestsPerMinute))
+				Expect(appConfig.AccountRefresh).To(Equal(controller.DefaultAccountRefresh))
+				Expect(appConfig.StaleAfter).To(BeZero())
+				Expect(appConfig.GapAfter).To(BeZero())
+				Expect(appConfig.HistoryDir).To(BeEmpty())
+				Expect(appConfig.ReplayDir).To(BeEmpty())
+				Expect(appConfig.ReplaySpeed).To(BeZero())
+				Expect(appConfig.ReplayCash).To(Equal(config.DefaultReplayCash))
 			})
 		})
 
@@ -58,12 +79,104 @@ var _ = Describe("Config", func() {
 				os.Setenv(common.EnvApiSecretKey, secretKey)
 
 				os.Setenv(config.LogLevelVariable, "DEBUG")
+				os.Setenv(config.StocksVariable, "VTI, VOO")
+				os.Setenv(config.AlgorithmVariable, "crossover")
+				os.Setenv(config.AlgorithmParamsVariable, "fast=5,slow=20,interval=5m")
+				os.Setenv(config.MaxPositionValueVariable, "5000")
+				os.Setenv(config.MaxShortExposureVariable, "2500.50")
+				os.Setenv(config.SizingVariable, "default=percent_equity:0.05, VTI=fixed_notional:500")
+				os.Setenv(config.ChannelsVariable, "default=trades, VTI=trades|quotes")
+				os.Setenv(config.JournalPathVariable, "/var/log/stonks/journal.jsonl")
+				os.Setenv(config.RecordDirVariable, "/var/lib/stonks/recordings")
+				os.Setenv(config.DataSourceVariable, "polygon")
+				os.Setenv(config.RequestsPerMinuteVariable, "150")
+				os.Setenv(config.AccountRefreshVariable, "30s")
+				os.Setenv(config.StaleAfterVariable, "2m")
+				os.Setenv(config.GapAfterVariable, "90s")
+				os.Setenv(config.HistoryDirVariable, "/var/lib/stonks/history")
+				os.Setenv(config.ReplayDirVariable, "/var/lib/stonks/recordings")
+				os.Setenv(config.ReplayFromVariable, "2020-11-02T14:30:00Z")
+				os.Setenv(config.ReplayToVariable, "2020-11-02T21:00:00Z")
+				os.Setenv(config.ReplaySpeedVariable, "60")
+				os.Setenv(config.ReplayCashVariable, "5000")
 
 				appConfig = config.Load()
 			})
 
 			It("should set optional variables on the config object", func() {
 				Expect(appConfig.LogLevel).To(Equal(logrus.DebugLevel))
+				Expect(appConfig.Stocks).To(Equal([]string{"VTI", "VOO"}))
+				Expect(appConfig.Algorithm).To(Equal("crossover"))
+				Expect(appConfig.AlgorithmParams).To(Equal(map[string]string{
+					"fast":     "5",
+					"slow":     "20",
+					"interval": "5m",
+				}))
+				Expect(appConfig.MaxPositionValue).To(Equal(float64(5000)))
+				Expect(appConfig.MaxShortExposure).To(Equal(2500.50))
+				Expect(appConfig.Sizing).To(Equal(map[string]string{
+					"default": "percent_equity:0.05",
+					"VTI":     "fixed_notional:500",
+				}))
+				Expect(appConfig.Channels).To(Equal(map[string]string{
+					"default": "trades",
+					"VTI":     "trades|quotes",
+				}))
+				Expect(appConfig.JournalPath).To(Equal("/var/log/stonks/journal.jsonl"))
+				Expect(appConfig.RecordDir).To(Equal("/var/lib/stonks/recordings"))
+				Expect(appConfig.DataSource).To(Equal("polygon"))
+				Expect(appConfig.RequestsPerMinute).To(Equal(150))
+				Expect(appConfig.AccountRefresh).To(Equal(30 * time.Second))
+				Expect(appConfig.StaleAfter).To(Equal(2 * time.Minute))
+				Expect(appConfig.GapAfter).To(Equal(90 * time.Second))
+				Expect(appConfig.HistoryDir).To(Equal("/var/lib/stonks/history"))
+				Expect(appConfig.ReplayDir).To(Equal("/var/lib/stonks/recordings"))
+				Expect(appConfig.ReplayFrom).To(Equal(time.Date(2020, 11, 2, 14, 30, 0, 0, time.UTC)))
+				Expect(appConfig.ReplayTo).To(Equal(time.Date(2020, 11, 2, 21, 0, 0, 0, time.UTC)))
+				Expect(appConfig.ReplaySpeed).To(Equal(float64(60)))
+				Expect(appConfig.ReplayCash).To(Equal(float64(5000)))
+			})
+		})
+
+		Context("when a risk limit is not parsable", func() {
+			BeforeEach(func() {
+				os.Setenv(config.AlpacaAPIBaseURLVariable, baseURL)
+				os.Setenv(common.EnvApiKeyID, keyID)
+				os.Setenv(common.EnvApiSecretKey, secretKey)
+
+				os.Setenv(config.MaxShortExposureVariable, "lots")
+			})
+
+			It("should panic", func() {
+				Expect(func() { config.Load() }).To(Panic())
+			})
+		})
+
+		Context("when a replay time is not parsable", func() {
+			BeforeEach(func() {
+				os.Setenv(config.AlpacaAPIBaseURLVariable, baseURL)
+				os.Setenv(common.EnvApiKeyID, keyID)
+				os.Setenv(common.EnvApiSecretKey, secretKey)
+
+				os.Setenv(config.ReplayFromVariable, "yesterday")
+			})
+
+			It("should panic", func() {
+				Expect(func() { config.Load() }).To(Panic())
+			})
+		})
+
+		Context("when a duration is not parsable", func() {
+			BeforeEach(func() {
+				os.Setenv(config.AlpacaAPIBaseURLVariable, baseURL)
+				os.Setenv(common.EnvApiKeyID, keyID)
+				os.Setenv(common.EnvApiSecretKey, secretKey)
+
+				os.Setenv(config.StaleAfterVariable, "soon")
+			})
+
+			It("should panic", func() {
+				Expect(func() { config.Load() }).To(Panic())
 			})
 		})
 
@@ -82,6 +195,68 @@ var _ = Describe("Config", func() {
 			})
 		})
 
+		Context("when sizing has no default", func() {
+			BeforeEach(func() {
+				os.Setenv(config.AlpacaAPIBaseURLVariable, baseURL)
+				os.Setenv(common.EnvApiKeyID, keyID)
+				os.Setenv(common.EnvApiSecretKey, secretKey)
+
+				os.Setenv(config.SizingVariable, "VTI=fixed_notional:500")
+			})
+
+			It("should size other stocks with the default sizer", func() {
+				Expect(config.Load().Sizing).To(Equal(map[string]string{
+					"default": config.DefaultSizer,
+					"VTI":     "fixed_notional:500",
+				}))
+			})
+		})
+
+		Context("when a traded stock falls back to the default sizer", func() {
+			var (
+				hook     *warningHook
+				previous logrus.LevelHooks
+			)
+
+			BeforeEach(func() {
+				os.Setenv(config.AlpacaAPIBaseURLVariable, baseURL)
+				os.Setenv(common.EnvApiKeyID, keyID)
+				os.Setenv(common.EnvApiSecretKey, secretKey)
+
+				os.Setenv(config.StocksVariable, "VTI,BND")
+				os.Setenv(config.SizingVariable, "VTI=fixed_notional:500")
+
+				hook = &warningHook{}
+				previous = logrus.StandardLogger().ReplaceHooks(logrus.LevelHooks{})
+				logrus.AddHook(hook)
+			})
+
+			AfterEach(func() {
+				logrus.StandardLogger().ReplaceHooks(previous)
+			})
+
+			It("should warn about the stocks it sizes", func() {
+				config.Load()
+				Expect(hook.entries).To(HaveLen(1))
+				Expect(hook.entries[0].Data).To(HaveKeyWithValue("stocks", []string{"BND"}))
+				Expect(hook.entries[0].Data).To(HaveKeyWithValue("sizer", config.DefaultSizer))
+			})
+		})
+
+		Context("when sizing is not a list of pairs", func() {
+			BeforeEach(func() {
+				os.Setenv(config.AlpacaAPIBaseURLVariable, baseURL)
+				os.Setenv(common.EnvApiKeyID, keyID)
+				os.Setenv(common.EnvApiSecretKey, secretKey)
+
+				os.Setenv(config.SizingVariable, "fixed_quantity:10")
+			})
+
+			It("should panic", func() {
+				Expect(func() { config.Load() }).To(Panic())
+			})
+		})
+
 		Context("when required Base URL is not set", func() {
 			BeforeEach(func() {
 				os.Setenv(common.EnvApiKeyID, keyID)
@@ -133,6 +308,20 @@ func preserveAndClearEnvironment(prefix string) map[string]string {
 	return preservedEnvironment
 }
 
+// warningHook records the warnings logged
+type warningHook struct {
+	entries []*logrus.Entry
+}
+
+func (h *warningHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.WarnLevel}
+}
+
+func (h *warningHook) Fire(entry *logrus.Entry) error {
+	h.entries = append(h.entries, entry)
+	return nil
+}
+
 // resetEnvironment resets any preserved variables to their original values
 func resetEnvironment(prefix string, preservedEnvironment map[string]string) {
 	for _, env := range os.Environ() {
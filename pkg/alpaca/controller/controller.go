@@ -7,54 +7,243 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
-	"github.com/alpacahq/alpaca-trade-api-go/stream"
 	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/apierrors"
+	"github.com/markliederbach/stonks/pkg/alpaca/bars"
+	"github.com/markliederbach/stonks/pkg/alpaca/clock"
+	"github.com/markliederbach/stonks/pkg/alpaca/history"
+	"github.com/markliederbach/stonks/pkg/alpaca/journal"
+	"github.com/markliederbach/stonks/pkg/alpaca/risk"
+	"github.com/markliederbach/stonks/pkg/alpaca/sizing"
+	"github.com/markliederbach/stonks/pkg/alpaca/streams"
 	"github.com/shopspring/decimal"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	// flushInterval is how often time bars are checked for completion
+	// when no trades arrive to close them
+	flushInterval time.Duration = time.Second
+
+	// marketInterval is how often the market clock is checked
+	// for algorithms that follow the open and close
+	marketInterval time.Duration = time.Minute
+
+	// healthInterval is how often stocks are checked for stale
+	// market data, when StaleAfter is set
+	healthInterval time.Duration = 10 * time.Second
+
+	// DefaultAccountRefresh is how long account details may go without
+	// an update before a trade fetches them again
+	DefaultAccountRefresh time.Duration = time.Minute
+)
+
+var _ api.OrderRouter = &AlpacaController{}
+
 // AlpacaController is the backbone of the system, which supports pluggable
 // underlying algorithms.
 type AlpacaController struct {
 	Client    api.AlpacaClient
 	Algorithm api.AlpacaAlgorithm
-	Stock     api.StockInfo
+	Stocks    map[string]api.StockInfo
 	Account   api.AccountInfo
-	Order     api.OrderInfo
+	Orders    map[string]api.OrderInfo
+	Risk      risk.Limits
+	Sizing    sizing.Sizers
+	Journal   api.Journal
+
+	// DataStream and OrderStream deliver the events we trade on,
+	// from Alpaca unless replaced before Run
+	DataStream  api.DataStream
+	OrderStream api.OrderStream
+
+	// Channels selects the market data followed for each stock. Stocks
+	// without channels follow trades, and whichever other channels the
+	// algorithm handles.
+	Channels streams.Channels
+
+	// Clock tells the time and runs our schedules, the wall
+	// clock unless replaced before Run
+	Clock api.Clock
+
+	// AccountRefresh is how long account details may go without an
+	// update, pushed or fetched, before a trade fetches them again
+	AccountRefresh time.Duration
+
+	// StaleAfter is how long a stock may go without market data while
+	// the market is open before we resubscribe to it and resync, or
+	// zero to never check
+	StaleAfter time.Duration
+
+	// GapAfter is how much time may be missing between consecutive
+	// trades, or minute aggregates, in a stock before we backfill it
+	// and resync, or zero to only backfill after resubscribing
+	GapAfter time.Duration
+
+	// Backfill serves the market data missed in a gap, which is
+	// only logged when it is nil
+	Backfill *history.Loader
+
+	// symbols lists the stocks we trade, in the order they were given
+	symbols []string
+
+	// pendingOrders are the second halves of orders that flip a
+	// position from long to short, or short to long, by symbol
+	pendingOrders map[string]*pendingOrder
+
+	// bars aggregates trades for algorithms that implement
+	// api.BarHandler, by symbol
+	bars map[string]bars.Builder
+
+	// marketOpen is whether the market was open at the last clock
+	// check, or nil before the first
+	marketOpen *bool
+
+	// accountUpdated is when account details were last updated
+	accountUpdated time.Time
+
+	// lastEvent is when market data last arrived for each stock
+	lastEvent map[string]time.Time
+
+	// lastTrade and lastAggregate are the newest trade time and
+	// minute aggregate start seen for each stock, to find gaps
+	lastTrade     map[string]time.Time
+	lastAggregate map[string]time.Time
+
+	// resubscribedTrades and resubscribedAggregates mark stocks whose
+	// next trade or aggregate is backfilled up to, however little was
+	// missed. Each stream catches up on its own.
+	resubscribedTrades     map[string]bool
+	resubscribedAggregates map[string]bool
+
+	// stalePositions marks stocks whose position could not be fetched
+	// after a fill. Orders in them are refused until it is.
+	stalePositions map[string]bool
+
+	// tradeGaps and aggregateGaps are the gaps being backfilled in each
+	// stock's streams, which later events in the stream wait behind
+	tradeGaps     map[string]*gap
+	aggregateGaps map[string]*gap
+
+	// backfilling is set while missed market data is passed to the
+	// algorithm, when orders are refused
+	backfilling bool
+
+	// streaming is set once Run starts subscribing to streams, after
+	// which calls to Alpaca are made holding mu
+	streaming bool
+
+	// running is closed once Run is waiting for events
+	running chan struct{}
+
+	// done is closed to stop the controller
+	done     chan struct{}
+	stopOnce sync.Once
+
+	// mu serializes stream events, which arrive on separate goroutines
+	mu sync.Mutex
+}
+
+// pendingOrder is an order waiting on another order to fill
+type pendingOrder struct {
+	AfterOrderID   string
+	TargetPosition decimal.Decimal
+	TargetPrice    float64
 }
 
-// NewAlpacaController returns an new controller.
-func NewAlpacaController(client api.AlpacaClient, algorithm api.AlpacaAlgorithm, stock string) (AlpacaController, error) {
+// NewAlpacaController returns an new controller trading one or more stocks.
+func NewAlpacaController(client api.AlpacaClient, algorithm api.AlpacaAlgorithm, stocks ...string) (*AlpacaController, error) {
+	if len(stocks) == 0 {
+		return nil, errors.New("controller requires at least one stock")
+	}
+
+	if handler, ok := algorithm.(api.TimerHandler); ok && handler.TimerInterval() <= 0 {
+		return nil, fmt.Errorf("timer interval must be positive, got %v", handler.TimerInterval())
+	}
+
+	if handler, ok := algorithm.(api.StartHandler); ok {
+		if err := bars.CheckHistory(handler.History()); err != nil {
+			return nil, err
+		}
+	}
+
 	// Cancel any open orders so they don't interfere with this script
 	if err := client.CancelAllOrders(); err != nil {
-		return AlpacaController{}, err
+		return nil, err
 	}
 
-	alpacaController := AlpacaController{
-		Client:    client,
-		Algorithm: algorithm,
-		Stock: api.StockInfo{
-			Symbol:   stock,
-			Position: 0,
-		},
-		Account: api.AccountInfo{},
+	sdkStream := streams.NewSDK()
+	alpacaController := &AlpacaController{
+		Client:                 client,
+		Algorithm:              algorithm,
+		Stocks:                 map[string]api.StockInfo{},
+		Account:                api.AccountInfo{},
+		Orders:                 map[string]api.OrderInfo{},
+		Journal:                journal.Discard{},
+		DataStream:             sdkStream,
+		OrderStream:            sdkStream,
+		Clock:                  clock.Real{},
+		AccountRefresh:         DefaultAccountRefresh,
+		running:                make(chan struct{}),
+		done:                   make(chan struct{}),
+		pendingOrders:          map[string]*pendingOrder{},
+		bars:                   map[string]bars.Builder{},
+		lastEvent:              map[string]time.Time{},
+		lastTrade:              map[string]time.Time{},
+		lastAggregate:          map[string]time.Time{},
+		resubscribedTrades:     map[string]bool{},
+		resubscribedAggregates: map[string]bool{},
+		stalePositions:         map[string]bool{},
+		tradeGaps:              map[string]*gap{},
+		aggregateGaps:          map[string]*gap{},
 	}
 
-	if err := alpacaController.UpdatePosition(); err != nil {
-		return AlpacaController{}, err
+	for _, stock := range stocks {
+		if _, exists := alpacaController.Stocks[stock]; exists {
+			return nil, fmt.Errorf("stock %s is listed more than once", stock)
+		}
+
+		alpacaController.symbols = append(alpacaController.symbols, stock)
+		alpacaController.Stocks[stock] = api.StockInfo{
+			Symbol:   stock,
+			Position: decimal.Zero,
+		}
+
+		if handler, ok := algorithm.(api.BarHandler); ok {
+			builder, err := bars.NewBuilder(stock, handler.BarSpec())
+			if err != nil {
+				return nil, err
+			}
+			alpacaController.bars[stock] = builder
+		}
+
+		if err := alpacaController.UpdateAsset(stock); err != nil {
+			return nil, err
+		}
+
+		if err := alpacaController.UpdatePosition(stock); err != nil {
+			return nil, err
+		}
 	}
 
 	if err := alpacaController.UpdateAccount(); err != nil {
-		return AlpacaController{}, err
+		return nil, err
+	}
+
+	for _, stock := range alpacaController.symbols {
+		logrus.WithFields(logrus.Fields{
+			"stock":        stock,
+			"position":     alpacaController.Stocks[stock].Position,
+			"fractionable": alpacaController.Stocks[stock].Asset.Fractionable,
+		}).Debugf("Loaded initial position")
 	}
 
 	logrus.WithFields(logrus.Fields{
-		"stock":        alpacaController.Stock.Symbol,
-		"position":     alpacaController.Stock.Position,
 		"equity":       math.Round(alpacaController.Account.Equity*100) / 100,
 		"buying_power": math.Round(alpacaController.Account.MarginMultiplier*alpacaController.Account.Equity*100) / 100,
 	}).Debugf("Loaded initial state")
@@ -62,48 +251,277 @@ func NewAlpacaController(client api.AlpacaClient, algorithm api.AlpacaAlgorithm,
 	return alpacaController, nil
 }
 
+// UpdateAsset refreshes the tradability details for a stock
+func (c *AlpacaController) UpdateAsset(symbol string) error {
+	asset, err := c.client().GetAsset(symbol)
+	if err != nil {
+		return err
+	}
+
+	stock := c.Stocks[symbol]
+	stock.Asset = *asset
+	c.Stocks[symbol] = stock
+
+	return nil
+}
+
 // UpdatePosition refreshes our current position for a stock
-func (c *AlpacaController) UpdatePosition() error {
-	var position int64 = 0
-	stockPosition, err := c.Client.GetPosition(c.Stock.Symbol)
+func (c *AlpacaController) UpdatePosition(symbol string) error {
+	stockPosition, err := fetchPosition(c.client(), symbol)
 	if err != nil {
-		if err.Error() != "position does not exist" {
-			return err
+		return err
+	}
+
+	c.setPosition(symbol, stockPosition)
+	return nil
+}
+
+// fetchPosition fetches our position in a stock, which is nil if we hold none
+func fetchPosition(client api.AlpacaClient, symbol string) (*alpaca.Position, error) {
+	stockPosition, err := client.GetPosition(symbol)
+	if apierrors.Is(err, apierrors.NotFound) {
+		return nil, nil
+	}
+	return stockPosition, err
+}
+
+// setPosition records our position in a stock, or none when it is nil
+func (c *AlpacaController) setPosition(symbol string, stockPosition *alpaca.Position) {
+	position := decimal.Zero
+	if stockPosition != nil {
+		position = stockPosition.Qty
+		if stockPosition.Side == "short" && position.IsPositive() {
+			position = position.Neg()
 		}
-	} else {
-		position = stockPosition.Qty.IntPart()
 	}
 
-	c.Stock.Position = position
+	stock := c.Stocks[symbol]
+	stock.Position = position
+	c.Stocks[symbol] = stock
+	delete(c.stalePositions, symbol)
+}
 
-	return nil
+// refreshPositions fetches the positions that could not be fetched after a fill
+func (c *AlpacaController) refreshPositions() {
+	for _, symbol := range c.symbols {
+		if !c.stalePositions[symbol] {
+			continue
+		}
+
+		if err := c.UpdatePosition(symbol); err != nil {
+			logrus.WithFields(logrus.Fields{"symbol": symbol}).Errorf("Failed to refresh position: %v", err)
+			continue
+		}
+		c.settlePendingOrder(symbol)
+	}
+}
+
+// settlePendingOrder sends a flip whose closing order is no longer working
+// if its position was closed, or drops it if not
+func (c *AlpacaController) settlePendingOrder(symbol string) {
+	pending, ok := c.pendingOrders[symbol]
+	if !ok {
+		return
+	}
+	if _, working := c.Orders[pending.AfterOrderID]; working {
+		return
+	}
+
+	if c.Stocks[symbol].Position.IsZero() {
+		c.sendPendingOrder(symbol, pending.AfterOrderID)
+	} else {
+		// The closing order ended without closing the position
+		delete(c.pendingOrders, symbol)
+	}
 }
 
 // UpdateAccount refreshes our available equity and margin from Alpaca
 func (c *AlpacaController) UpdateAccount() error {
 	// Figure out how much money we have to work with, accounting for margin
-	accountState, err := c.Client.GetAccount()
+	accountState, err := c.client().GetAccount()
 	if err != nil {
 		return err
 	}
 
+	return c.setAccount(accountState)
+}
+
+// setAccount records the account details Alpaca gave us
+func (c *AlpacaController) setAccount(accountState *alpaca.Account) error {
 	equity, _ := accountState.Equity.Float64()
 	marginMultiplier, err := strconv.ParseFloat(accountState.Multiplier, 64)
 	if err != nil {
 		return err
 	}
 
+	previous := c.Account
 	c.Account.ID = accountState.ID
+	c.Account.Status = accountState.Status
 	c.Account.Equity = equity
+	c.Account.Cash, _ = accountState.Cash.Float64()
+	c.Account.BuyingPower, _ = accountState.BuyingPower.Float64()
 	c.Account.MarginMultiplier = marginMultiplier
+	c.Account.ShortingEnabled = accountState.ShortingEnabled
+	c.Account.ShortMarketValue, _ = accountState.ShortMarketValue.Float64()
+	c.Account.DaytradeCount = accountState.DaytradeCount
+	c.Account.PatternDayTrader = accountState.PatternDayTrader
+	c.Account.TradingBlocked = accountState.TradingBlocked
+	c.Account.AccountBlocked = accountState.AccountBlocked
+	c.accountUpdated = c.Clock.Now()
+	c.checkAccountStatus(previous)
 
 	return nil
 }
 
-// Run kicks off the main logic of this controller
+// refreshAccount fetches our account details if nothing
+// has updated them for AccountRefresh
+func (c *AlpacaController) refreshAccount() {
+	if c.Clock.Now().Sub(c.accountUpdated) < c.AccountRefresh {
+		return
+	}
+
+	if err := c.UpdateAccount(); err != nil {
+		logrus.Error(err)
+	}
+}
+
+// checkAccountStatus logs when the account becomes blocked from
+// trading, or may trade again, and when its status changes
+func (c *AlpacaController) checkAccountStatus(previous api.AccountInfo) {
+	contextLog := logrus.WithFields(logrus.Fields{
+		"status":          c.Account.Status,
+		"trading_blocked": c.Account.TradingBlocked,
+		"account_blocked": c.Account.AccountBlocked,
+	})
+
+	if previous.Status != "" && previous.Status != c.Account.Status {
+		contextLog.WithFields(logrus.Fields{"previous_status": previous.Status}).Warn("Account status changed")
+	}
+
+	switch {
+	case c.Account.Blocked() && !previous.Blocked():
+		contextLog.Error("Account is blocked from trading, orders will be refused")
+	case !c.Account.Blocked() && previous.Blocked():
+		contextLog.Info("Account may trade again")
+	}
+}
+
+// Start prepares the algorithm to trade, passing it the history it asked for
+func (c *AlpacaController) Start() error {
+	handler, ok := c.Algorithm.(api.StartHandler)
+	if !ok {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.Clock.Now()
+	spec := handler.History()
+	history, err := bars.LoadHistory(c.Client, c.symbols, spec, now)
+	if err != nil {
+		return fmt.Errorf("failed to load history: %w", err)
+	}
+
+	contextLog := logrus.WithFields(logrus.Fields{
+		"history_interval": spec.Interval,
+		"history_bars":     spec.Bars,
+	})
+	contextLog.Info("Starting algorithm")
+
+	return handler.OnStart(
+		api.StartContext{
+			Client:     c.Client,
+			Router:     c,
+			Stocks:     c.stocks(),
+			Account:    c.Account,
+			Journal:    c.Journal,
+			History:    history,
+			Now:        now,
+			ContextLog: contextLog,
+		},
+	)
+}
+
+// client returns the client to call Alpaca with. Once streaming, calls are
+// made holding the lock every stream handler waits on, so a client that
+// would wait out rate limits and retries is asked to fail fast instead.
+func (c *AlpacaController) client() api.AlpacaClient {
+	if client, ok := c.Client.(api.FailFastClient); ok && c.streaming {
+		return client.FailFast()
+	}
+	return c.Client
+}
+
+// Running is closed once Run has subscribed to every stream
+// and is waiting for events
+func (c *AlpacaController) Running() <-chan struct{} {
+	return c.running
+}
+
+// Stop asks Run to return, once the algorithm has cleaned up.
+// It is safe to call more than once.
+func (c *AlpacaController) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// CheckMarket checks the market clock, telling the algorithm when the
+// market opens or closes. The first check tells it the market is open
+// if it already was.
+func (c *AlpacaController) CheckMarket() error {
+	handler, ok := c.Algorithm.(api.MarketHandler)
+	if !ok {
+		return nil
+	}
+
+	clock, err := c.Client.GetClock()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wasOpen := c.marketOpen != nil && *c.marketOpen
+	isOpen := clock.IsOpen
+	c.marketOpen = &isOpen
+	if wasOpen == isOpen {
+		return nil
+	}
+
+	contextLog := logrus.WithFields(logrus.Fields{
+		"market_open": isOpen,
+		"next_open":   clock.NextOpen,
+		"next_close":  clock.NextClose,
+	})
+
+	context := api.MarketContext{
+		Client:     c.client(),
+		Router:     c,
+		Stocks:     c.stocks(),
+		Account:    c.Account,
+		Journal:    c.Journal,
+		Clock:      *clock,
+		ContextLog: contextLog,
+	}
+
+	if isOpen {
+		contextLog.Info("Market opened")
+		handler.OnMarketOpen(context)
+	} else {
+		contextLog.Info("Market closed")
+		handler.OnMarketClose(context)
+	}
+	return nil
+}
+
+// Run kicks off the main logic of this controller, returning
+// once Stop is called
 func (c *AlpacaController) Run() error {
 	// Cancel any existing orders so they don't impact our buying power.
-	status, until, limit := "open", time.Now(), 100
+	status, until, limit := "open", c.Clock.Now(), 100
 	orders, _ := c.Client.ListOrders(&status, &until, &limit, nil)
 	for _, order := range orders {
 		logrus.Debugf("Cancelling pre-existing order %s", order.ID)
@@ -112,78 +530,404 @@ func (c *AlpacaController) Run() error {
 		}
 	}
 
-	// Register a handler for the stock stream we want to watch
-	// https://alpaca.markets/docs/api-documentation/api-v2/market-data/streaming/
-	dataStreamKey := fmt.Sprintf("T.%s", c.Stock.Symbol)
-	if err := stream.Register(dataStreamKey, c.handleStreamTrade); err != nil {
+	if err := c.Start(); err != nil {
 		return err
 	}
 
-	// Runs if this function ever returns
-	defer c.deferDeregister(dataStreamKey)
+	c.mu.Lock()
+	c.streaming = true
+	c.mu.Unlock()
+
+	// Subscribe to each stock we want to watch
+	// https://alpaca.markets/docs/api-documentation/api-v2/market-data/streaming/
+	for _, symbol := range c.symbols {
+		// Runs if this function ever returns, whatever was subscribed
+		defer c.deferUnsubscribe(symbol)
+
+		for _, channel := range c.channels(symbol) {
+			if err := c.subscribe(symbol, channel); err != nil {
+				return fmt.Errorf("failed to subscribe to %s for %s: %w", channel, symbol, err)
+			}
+		}
+	}
 
-	// Register a handler for updates to our existing trade orders
-	if err := stream.Register(alpaca.TradeUpdates, c.handleTradeUpdate); err != nil {
+	// Subscribe to updates to our existing trade orders
+	if err := c.OrderStream.SubscribeTradeUpdates(c.handleTradeUpdate); err != nil {
 		return err
 	}
 
 	// Runs if this function ever returns
-	defer c.deferDeregister(alpaca.TradeUpdates)
+	defer c.deferUnsubscribeTradeUpdates()
+
+	// Follow changes to the account as they happen, falling back
+	// to fetching it on trades if the stream cannot
+	if accounts, ok := c.OrderStream.(api.AccountStream); ok {
+		if err := accounts.SubscribeAccountUpdates(c.handleAccountUpdate); err != nil {
+			logrus.Warnf("Failed to follow account updates, fetching the account instead: %v", err)
+		} else {
+			defer c.deferUnsubscribeAccountUpdates(accounts)
+		}
+	}
 
 	// Add SIGTERM handler
-	c.setupInterruptHandler([]string{dataStreamKey, alpaca.TradeUpdates})
+	c.setupInterruptHandler()
+
+	if len(c.bars) > 0 {
+		// Close out time bars even when the stock stops trading
+		c.Clock.Every(flushInterval, c.done, c.flushBars)
+	}
+
+	if handler, ok := c.Algorithm.(api.TimerHandler); ok {
+		c.Clock.Every(handler.TimerInterval(), c.done, c.timer)
+	}
+
+	if _, ok := c.Algorithm.(api.MarketHandler); ok {
+		c.checkMarket(c.Clock.Now())
+		c.Clock.Every(marketInterval, c.done, c.checkMarket)
+	}
+
+	if c.StaleAfter > 0 {
+		c.mu.Lock()
+		for _, symbol := range c.symbols {
+			c.lastEvent[symbol] = c.Clock.Now()
+		}
+		c.mu.Unlock()
+		c.Clock.Every(healthInterval, c.done, c.checkHealth)
+	}
 
 	// TODO: Uncomment to send a test order
 	// time.Sleep(time.Second * 5)
-	// orderID, err := c.SendLimitOrder(1, 192.82)
+	// orderID, err := c.SendLimitOrder(c.symbols[0], decimal.NewFromInt(1), 192.82)
 	// if err != nil {
 	// 	return err
 	// }
 	// logrus.Infof("Created dummy order %s", orderID)
 
-	// Sleep indefinitely while we wait for events
-	select {}
+	// Wait for events until we are stopped
+	close(c.running)
+	<-c.done
+
+	c.handleStop()
+	return nil
+}
+
+// channels returns the market data channels to follow for a stock
+func (c *AlpacaController) channels(symbol string) []api.Channel {
+	if channels := c.Channels.For(symbol); len(channels) > 0 {
+		return channels
+	}
+
+	channels := []api.Channel{api.TradeChannel}
+	if _, ok := c.Algorithm.(api.QuoteHandler); ok {
+		channels = append(channels, api.QuoteChannel)
+	}
+	if _, ok := c.Algorithm.(api.AggregateHandler); ok {
+		channels = append(channels, api.MinuteAggregateChannel)
+	}
+	return channels
 }
 
-// setupInterruptHandler catches CTRL-C interrupts and close streams
-func (c *AlpacaController) setupInterruptHandler(streamKeys []string) {
-	ch := make(chan os.Signal)
+// subscribe follows a market data channel for a stock
+func (c *AlpacaController) subscribe(symbol string, channel api.Channel) error {
+	switch channel {
+	case api.TradeChannel:
+		return c.DataStream.SubscribeTrades(symbol, c.handleStreamTrade)
+	case api.QuoteChannel:
+		return c.DataStream.SubscribeQuotes(symbol, c.handleStreamQuote)
+	default:
+		return c.DataStream.SubscribeAggregates(symbol, channel, func(bar api.Bar) {
+			c.handleStreamAggregate(channel, bar)
+		})
+	}
+}
+
+// setupInterruptHandler catches CTRL-C interrupts and stops the controller
+func (c *AlpacaController) setupInterruptHandler() {
+	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		<-ch
-		logrus.WithFields(logrus.Fields{"stream_keys": streamKeys}).Info("Closing Alpaca data streams")
-		for _, streamKey := range streamKeys {
-			if err := stream.Deregister(streamKey); err != nil {
-				logrus.WithFields(logrus.Fields{"stream_key": streamKey}).Warnf("Failed to deregister stream: %v", err)
-			}
+		select {
+		case <-ch:
+			logrus.WithFields(logrus.Fields{"stocks": c.symbols}).Info("Closing Alpaca data streams")
+			c.Stop()
+		case <-c.done:
 		}
-		os.Exit(1)
+		signal.Stop(ch)
 	}()
 }
 
-func (c *AlpacaController) deferDeregister(streamKey string) {
-	if err := stream.Deregister(streamKey); err != nil {
+func (c *AlpacaController) deferUnsubscribe(symbol string) {
+	if err := c.DataStream.Unsubscribe(symbol); err != nil {
+		logrus.Error(err)
+	}
+}
+
+func (c *AlpacaController) deferUnsubscribeTradeUpdates() {
+	if err := c.OrderStream.UnsubscribeTradeUpdates(); err != nil {
 		logrus.Error(err)
 	}
 }
 
+func (c *AlpacaController) deferUnsubscribeAccountUpdates(accounts api.AccountStream) {
+	if err := accounts.UnsubscribeAccountUpdates(); err != nil {
+		logrus.Error(err)
+	}
+}
+
+// SubmitIntent implements the function on the OrderRouter interface
+func (c *AlpacaController) SubmitIntent(intent api.OrderIntent) (*alpaca.Order, error) {
+	target, err := c.intentTarget(intent)
+	if err != nil {
+		return &alpaca.Order{}, err
+	}
+
+	return c.SendLimitOrder(intent.Symbol, target, intent.LimitPrice)
+}
+
+// SubmitIntents implements the function on the OrderRouter interface. Every leg
+// is sized and risk checked before any order is sent, and legs already at their
+// target are skipped. If a leg fails to send, orders for the earlier legs are
+// canceled.
+func (c *AlpacaController) SubmitIntents(intents ...api.OrderIntent) ([]*alpaca.Order, error) {
+	type leg struct {
+		intent api.OrderIntent
+		target decimal.Decimal
+	}
+
+	legs := []leg{}
+	seen := map[string]bool{}
+	for _, intent := range intents {
+		if seen[intent.Symbol] {
+			return nil, fmt.Errorf("intents contain %s more than once", intent.Symbol)
+		}
+		seen[intent.Symbol] = true
+
+		target, err := c.intentTarget(intent)
+		if err != nil {
+			return nil, err
+		}
+
+		stock := c.Stocks[intent.Symbol]
+		if target.Equal(stock.Position) {
+			continue
+		}
+
+		if err := c.Risk.Check(stock, c.Account, target, intent.LimitPrice); err != nil {
+			return nil, err
+		}
+
+		legs = append(legs, leg{intent: intent, target: target})
+	}
+
+	if len(legs) == 0 {
+		return nil, errors.New("no-op order requested")
+	}
+
+	orders := []*alpaca.Order{}
+	for _, leg := range legs {
+		order, err := c.orderTowards(leg.intent.Symbol, leg.target, leg.intent.LimitPrice)
+		if err != nil {
+			for _, placed := range orders {
+				// A leg that is already gone has nothing to cancel
+				if cancelErr := c.client().CancelOrder(placed.ID); cancelErr != nil && !apierrors.Is(cancelErr, apierrors.NotFound) {
+					logrus.WithFields(logrus.Fields{"order_id": placed.ID}).Errorf("Failed to cancel leg: %v", cancelErr)
+				}
+			}
+			return nil, fmt.Errorf("failed to send %s leg: %w", leg.intent.Symbol, err)
+		}
+		orders = append(orders, order)
+	}
+
+	return orders, nil
+}
+
+// PlaceLimitOrder implements the function on the OrderRouter interface. The order is
+// sent as is, and risk checked as if it and every other working order on the same
+// side fills in full.
+func (c *AlpacaController) PlaceLimitOrder(symbol string, side alpaca.Side, quantity decimal.Decimal, limitPrice float64) (*alpaca.Order, error) {
+	stock, ok := c.Stocks[symbol]
+	if !ok {
+		return &alpaca.Order{}, fmt.Errorf("order for unrelated stock %s", symbol)
+	}
+
+	if !quantity.IsPositive() {
+		return &alpaca.Order{}, fmt.Errorf("order quantity must be positive, got %s", quantity)
+	}
+
+	delta := quantity
+	if side == alpaca.Sell {
+		delta = quantity.Neg()
+	}
+
+	worstCase := stock.Position.Add(c.workingQuantity(symbol, side)).Add(delta)
+	if err := c.Risk.Check(stock, c.Account, worstCase, limitPrice); err != nil {
+		return &alpaca.Order{}, err
+	}
+
+	return c.placeLimitOrder(symbol, delta, limitPrice)
+}
+
+// CancelOrder implements the function on the OrderRouter interface
+func (c *AlpacaController) CancelOrder(orderID string) error {
+	return c.client().CancelOrder(orderID)
+}
+
+// workingQuantity returns the signed quantity left to fill
+// across our working orders in a stock on one side
+func (c *AlpacaController) workingQuantity(symbol string, side alpaca.Side) decimal.Decimal {
+	quantity := decimal.Zero
+	for _, order := range c.Orders {
+		if order.Symbol != symbol || order.Side != side {
+			continue
+		}
+		if side == alpaca.Sell {
+			quantity = quantity.Sub(order.Remaining())
+		} else {
+			quantity = quantity.Add(order.Remaining())
+		}
+	}
+	return quantity
+}
+
+// intentTarget works out the position in shares an intent is asking for
+func (c *AlpacaController) intentTarget(intent api.OrderIntent) (decimal.Decimal, error) {
+	if _, ok := c.Stocks[intent.Symbol]; !ok {
+		return decimal.Zero, fmt.Errorf("intent for unrelated stock %s", intent.Symbol)
+	}
+
+	if intent.Signal != nil {
+		return c.sizeIntent(intent)
+	}
+
+	if intent.Notional != nil {
+		return c.notionalTarget(intent.Symbol, *intent.Notional, intent.LimitPrice)
+	}
+
+	return intent.Target, nil
+}
+
+// sizeIntent asks the sizer configured for a stock to turn a signal into a target position
+func (c *AlpacaController) sizeIntent(intent api.OrderIntent) (decimal.Decimal, error) {
+	sizer := c.Sizing.For(intent.Symbol)
+	if sizer == nil {
+		return decimal.Zero, fmt.Errorf("no sizer configured for %s", intent.Symbol)
+	}
+
+	target, err := sizer.Size(sizing.Context{
+		Signal:     *intent.Signal,
+		Price:      intent.LimitPrice,
+		Volatility: intent.Volatility,
+		Account:    c.Account,
+	})
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	// Round towards flat, so we never size beyond what was asked for
+	return target.Truncate(c.quantityPrecision(intent.Symbol)), nil
+}
+
+// notionalTarget converts a dollar amount into a share count at the target price
+func (c *AlpacaController) notionalTarget(symbol string, notional decimal.Decimal, targetPrice float64) (decimal.Decimal, error) {
+	if targetPrice <= 0 {
+		return decimal.Zero, errors.New("notional order requires a positive price")
+	}
+
+	// Round down, so we never hold more than the requested notional
+	return notional.Div(decimal.NewFromFloat(targetPrice)).Truncate(c.quantityPrecision(symbol)), nil
+}
+
+// SendNotionalLimitOrder converts a dollar amount we want to hold in the stock
+// into a share count at the target price, then orders towards that position.
+func (c *AlpacaController) SendNotionalLimitOrder(symbol string, notional decimal.Decimal, targetPrice float64) (*alpaca.Order, error) {
+	targetPosition, err := c.notionalTarget(symbol, notional, targetPrice)
+	if err != nil {
+		return &alpaca.Order{}, err
+	}
+
+	return c.SendLimitOrder(symbol, targetPosition, targetPrice)
+}
+
 // SendLimitOrder takes a position at which we want to have in the stock and makes it so,
-// either by selling or buying shares.
-func (c *AlpacaController) SendLimitOrder(targetPosition int, targetPrice float64) (*alpaca.Order, error) {
-	delta := math.Max(float64(targetPosition), 0) - math.Max(float64(c.Stock.Position), 0)
+// either by selling or buying shares. Negative positions are short. Alpaca does not
+// allow a single order to cross from long to short, so a flip is sent as an order to
+// close the current position, followed by an order for the rest once that fills.
+func (c *AlpacaController) SendLimitOrder(symbol string, targetPosition decimal.Decimal, targetPrice float64) (*alpaca.Order, error) {
+	stock, ok := c.Stocks[symbol]
+	if !ok {
+		return &alpaca.Order{}, fmt.Errorf("order for unrelated stock %s", symbol)
+	}
+
+	if targetPosition.Equal(stock.Position) {
+		// We are already at our target position
+		return &alpaca.Order{}, errors.New("no-op order requested")
+	}
+
+	if err := c.Risk.Check(stock, c.Account, targetPosition, targetPrice); err != nil {
+		return &alpaca.Order{}, err
+	}
+
+	return c.orderTowards(symbol, targetPosition, targetPrice)
+}
 
+// orderTowards sends the order, or first half of a flip, that
+// moves our position in a stock to the target
+func (c *AlpacaController) orderTowards(symbol string, targetPosition decimal.Decimal, targetPrice float64) (*alpaca.Order, error) {
+	position := c.Stocks[symbol].Position
+
+	// Any earlier flip is superseded by this target
+	delete(c.pendingOrders, symbol)
+
+	if !position.IsZero() && targetPosition.Sign() == -position.Sign() {
+		order, err := c.placeLimitOrder(symbol, position.Neg(), targetPrice)
+		if err != nil {
+			return &alpaca.Order{}, err
+		}
+
+		c.pendingOrders[symbol] = &pendingOrder{
+			AfterOrderID:   order.ID,
+			TargetPosition: targetPosition,
+			TargetPrice:    targetPrice,
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"order_id": order.ID,
+			"symbol":   symbol,
+			"position": position,
+			"target":   targetPosition,
+		}).Info("Closing position before reversing it")
+
+		return order, nil
+	}
+
+	return c.placeLimitOrder(symbol, targetPosition.Sub(position), targetPrice)
+}
+
+// placeLimitOrder buys (positive delta) or sells (negative delta) shares at the given price
+func (c *AlpacaController) placeLimitOrder(symbol string, delta decimal.Decimal, targetPrice float64) (*alpaca.Order, error) {
 	var (
 		side     alpaca.Side
-		quantity float64 = math.Abs(delta)
+		quantity decimal.Decimal = delta.Abs()
 	)
 
-	if delta == 0 {
-		// We are already at our target position
-		return &alpaca.Order{}, errors.New("no-op order requested")
+	if c.Account.Blocked() {
+		return &alpaca.Order{}, apierrors.New(apierrors.Unauthorized, "trading is blocked on the account")
 	}
 
-	if delta > 0 {
-		// We need to buy more shares to reach our target position
+	if c.backfilling {
+		return &alpaca.Order{}, errors.New("orders are not placed on backfilled market data")
+	}
+
+	if c.stalePositions[symbol] {
+		return &alpaca.Order{}, fmt.Errorf("position in %s is out of date", symbol)
+	}
+
+	if !quantity.Equal(quantity.Truncate(c.quantityPrecision(symbol))) {
+		return &alpaca.Order{}, fmt.Errorf("quantity %s is not supported for %s", quantity, symbol)
+	}
+
+	if delta.IsPositive() {
+		// We need to buy shares to reach our target position
 		side = alpaca.Buy
 	} else {
 		// We need to sell shares to reach our target position
@@ -192,10 +936,10 @@ func (c *AlpacaController) SendLimitOrder(targetPosition int, targetPrice float6
 
 	limitPrice := decimal.NewFromFloat(targetPrice)
 
-	order, err := c.Client.PlaceOrder(alpaca.PlaceOrderRequest{
+	order, err := c.client().PlaceOrder(alpaca.PlaceOrderRequest{
 		AccountID:   c.Account.ID,
-		AssetKey:    &c.Stock.Symbol,
-		Qty:         decimal.NewFromFloat(quantity),
+		AssetKey:    &symbol,
+		Qty:         quantity,
 		Side:        side,
 		Type:        alpaca.Limit,
 		LimitPrice:  &limitPrice,
@@ -203,20 +947,214 @@ func (c *AlpacaController) SendLimitOrder(targetPosition int, targetPrice float6
 	})
 
 	if err != nil {
+		c.handleOrderError(symbol, err)
 		return &alpaca.Order{}, err
 	}
 
+	c.trackOrder(*order)
+
 	return order, nil
 }
 
-// Listen for quote data and perform trading logic
-func (c *AlpacaController) handleStreamTrade(msg interface{}) {
-	data, ok := msg.(alpaca.StreamTrade)
+// handleOrderError logs why an order was refused, refreshing the account
+// when the refusal means what we know of it is out of date
+func (c *AlpacaController) handleOrderError(symbol string, err error) {
+	kind := apierrors.Classify(err)
+	contextLog := logrus.WithFields(logrus.Fields{"symbol": symbol, "error_kind": kind})
+
+	switch kind {
+	case apierrors.InsufficientBuyingPower, apierrors.PatternDayTrader:
+		contextLog.Warnf("Order refused, refreshing account: %v", err)
+		if err := c.UpdateAccount(); err != nil {
+			contextLog.Errorf("Failed to refresh account: %v", err)
+		}
+	case apierrors.Unauthorized:
+		contextLog.Errorf("Order refused, check the API keys and account permissions: %v", err)
+	default:
+		contextLog.Warnf("Order failed: %v", err)
+	}
+}
+
+// trackOrder records the latest state of a working order
+func (c *AlpacaController) trackOrder(order alpaca.Order) {
+	info := api.OrderInfo{
+		ID:        order.ID,
+		Symbol:    order.Symbol,
+		Side:      order.Side,
+		Qty:       order.Qty,
+		FilledQty: order.FilledQty,
+	}
+	if order.LimitPrice != nil {
+		info.LimitPrice, _ = order.LimitPrice.Float64()
+	}
+	c.Orders[order.ID] = info
+}
+
+// sendPendingOrder places the rest of a flip once the closing order has filled
+func (c *AlpacaController) sendPendingOrder(symbol, orderID string) {
+	pending, ok := c.pendingOrders[symbol]
+	if !ok || pending.AfterOrderID != orderID {
+		return
+	}
+
+	delete(c.pendingOrders, symbol)
+
+	order, err := c.SendLimitOrder(symbol, pending.TargetPosition, pending.TargetPrice)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"symbol": symbol, "target": pending.TargetPosition}).Errorf("Failed to send pending order: %v", err)
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"order_id": order.ID,
+		"symbol":   symbol,
+		"target":   pending.TargetPosition,
+	}).Info("Sent pending order")
+}
+
+// quantityPrecision returns the number of decimal places allowed
+// when ordering the stock.
+func (c *AlpacaController) quantityPrecision(symbol string) int32 {
+	if c.Stocks[symbol].Asset.Fractionable {
+		return api.FractionalPrecision
+	}
+	return 0
+}
+
+// stocks returns a copy of every stock we trade, for passing to algorithms
+func (c *AlpacaController) stocks() map[string]api.StockInfo {
+	stocks := make(map[string]api.StockInfo, len(c.Stocks))
+	for symbol, stock := range c.Stocks {
+		stocks[symbol] = stock
+	}
+	return stocks
+}
+
+// flushBars completes any time bars that have closed. Stocks that may
+// have missed trades are held until the trades are backfilled, as they
+// would be dropped as late from bars that were already closed.
+func (c *AlpacaController) flushBars(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, symbol := range c.symbols {
+		if c.missingTrades(symbol, now) {
+			continue
+		}
+		c.handleBars(c.bars[symbol].Flush(now))
+	}
+}
+
+// timer calls the algorithm's timer
+func (c *AlpacaController) timer(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.handleTimer(now)
+}
+
+// checkMarket checks the market clock, logging any failure
+func (c *AlpacaController) checkMarket(time.Time) {
+	if err := c.CheckMarket(); err != nil {
+		logrus.Errorf("Failed to check market clock: %v", err)
+	}
+}
+
+// handleStop lets the algorithm clean up before we stop
+func (c *AlpacaController) handleStop() {
+	handler, ok := c.Algorithm.(api.StopHandler)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	contextLog := logrus.WithFields(logrus.Fields{})
+	contextLog.Info("Stopping algorithm")
+
+	handler.OnStop(
+		api.StopContext{
+			Client:     c.client(),
+			Router:     c,
+			Stocks:     c.stocks(),
+			Account:    c.Account,
+			Journal:    c.Journal,
+			Now:        c.Clock.Now(),
+			ContextLog: contextLog,
+		},
+	)
+}
+
+// handleTimer passes a scheduled tick to the algorithm
+func (c *AlpacaController) handleTimer(now time.Time) {
+	handler, ok := c.Algorithm.(api.TimerHandler)
 	if !ok {
-		logrus.Error("Failed to decode stream trade")
 		return
 	}
 
+	contextLog := logrus.WithFields(logrus.Fields{"now": now})
+	contextLog.Debug("Handling timer")
+
+	handler.OnTimer(
+		api.TimerContext{
+			Client:     c.client(),
+			Router:     c,
+			Stocks:     c.stocks(),
+			Account:    c.Account,
+			Journal:    c.Journal,
+			Now:        now,
+			ContextLog: contextLog,
+		},
+	)
+}
+
+// handleBars passes completed bars to the algorithm
+func (c *AlpacaController) handleBars(completed []api.Bar) {
+	handler, ok := c.Algorithm.(api.BarHandler)
+	if !ok {
+		return
+	}
+
+	for _, bar := range completed {
+		contextLog := logrus.WithFields(logrus.Fields{
+			"symbol":    bar.Symbol,
+			"bar_start": bar.Start,
+			"close":     bar.Close,
+			"volume":    bar.Volume,
+		})
+
+		contextLog.Debug("Handling bar")
+
+		handler.OnBar(
+			api.BarContext{
+				Client:     c.client(),
+				Router:     c,
+				Stock:      c.Stocks[bar.Symbol],
+				Stocks:     c.stocks(),
+				Account:    c.Account,
+				Journal:    c.Journal,
+				Bar:        bar,
+				Backfill:   c.backfilling,
+				ContextLog: contextLog,
+			},
+		)
+	}
+}
+
+// Listen for quote data and perform trading logic
+func (c *AlpacaController) handleStreamTrade(data alpaca.StreamTrade) {
+	if missed := c.receiveTrade(data); missed != nil {
+		c.fillGap(missed)
+	}
+}
+
+// receiveTrade passes a trade to the algorithm, or holds it back behind
+// the trades missed before it, returning the gap when it found one
+func (c *AlpacaController) receiveTrade(data alpaca.StreamTrade) *gap {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	contextLog := logrus.WithFields(logrus.Fields{
 		"symbol": data.Symbol,
 		"price":  data.Price,
@@ -224,72 +1162,320 @@ func (c *AlpacaController) handleStreamTrade(msg interface{}) {
 
 	contextLog.Info("Handling stream trade event")
 
-	if data.Symbol != c.Stock.Symbol {
+	if _, ok := c.Stocks[data.Symbol]; !ok {
 		logrus.Infof("Ignoring stream trade event for unrelated stock %s", data.Symbol)
-		return
+		return nil
 	}
 
+	c.lastEvent[data.Symbol] = c.Clock.Now()
+	deliver := func() {
+		c.refreshPositions()
+		c.deliverTrade(data, contextLog)
+		c.refreshAccount()
+	}
+
+	if missed, ok := c.tradeGaps[data.Symbol]; ok {
+		// Trades held behind a gap arrived together after it
+		c.seeTrade(data)
+		missed.held = append(missed.held, deliver)
+		return nil
+	}
+
+	missed := c.checkTradeGap(data)
+	if missed == nil {
+		deliver()
+		return nil
+	}
+
+	missed.held = append(missed.held, deliver)
+	c.tradeGaps[data.Symbol] = missed
+	return missed
+}
+
+// deliverTrade passes a trade to the algorithm, and to any bars it consumes
+func (c *AlpacaController) deliverTrade(data alpaca.StreamTrade, contextLog *logrus.Entry) {
 	c.Algorithm.HandleStreamTrade(
 		api.StreamTradeContext{
-			Client:     c.Client,
-			Stock:      c.Stock,
+			Client:     c.client(),
+			Router:     c,
+			Stock:      c.Stocks[data.Symbol],
+			Stocks:     c.stocks(),
 			Account:    c.Account,
+			Journal:    c.Journal,
 			Trade:      data,
+			Backfill:   c.backfilling,
 			ContextLog: contextLog,
 		},
 	)
 
-	if err := c.UpdateAccount(); err != nil {
-		logrus.Error(err)
+	if builder, ok := c.bars[data.Symbol]; ok {
+		c.handleBars(builder.Add(data))
+	}
+}
+
+// Listen for quotes and pass them to the algorithm
+func (c *AlpacaController) handleStreamQuote(data alpaca.StreamQuote) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.Stocks[data.Symbol]; ok {
+		c.lastEvent[data.Symbol] = c.Clock.Now()
+	}
+
+	handler, ok := c.Algorithm.(api.QuoteHandler)
+	if !ok {
+		return
+	}
+
+	contextLog := logrus.WithFields(logrus.Fields{
+		"symbol": data.Symbol,
+		"bid":    data.BidPrice,
+		"ask":    data.AskPrice,
+	})
+
+	contextLog.Debug("Handling stream quote event")
+
+	stock, ok := c.Stocks[data.Symbol]
+	if !ok {
+		logrus.Infof("Ignoring stream quote event for unrelated stock %s", data.Symbol)
 		return
 	}
+
+	handler.OnQuote(
+		api.QuoteContext{
+			Client:     c.client(),
+			Router:     c,
+			Stock:      stock,
+			Stocks:     c.stocks(),
+			Account:    c.Account,
+			Journal:    c.Journal,
+			Quote:      data,
+			ContextLog: contextLog,
+		},
+	)
 }
 
-// Listen for updates to our orders
-func (c *AlpacaController) handleTradeUpdate(msg interface{}) {
-	data, ok := msg.(alpaca.TradeUpdate)
+// Listen for aggregates and pass them to the algorithm
+func (c *AlpacaController) handleStreamAggregate(channel api.Channel, bar api.Bar) {
+	if missed := c.receiveAggregate(channel, bar); missed != nil {
+		c.fillGap(missed)
+	}
+}
+
+// receiveAggregate passes an aggregate to the algorithm, or holds it back
+// behind the minute aggregates missed before it, returning the gap when
+// it found one
+func (c *AlpacaController) receiveAggregate(channel api.Channel, bar api.Bar) *gap {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.Stocks[bar.Symbol]; ok {
+		c.lastEvent[bar.Symbol] = c.Clock.Now()
+	}
+
+	if _, ok := c.Algorithm.(api.AggregateHandler); !ok {
+		return nil
+	}
+
+	contextLog := logrus.WithFields(logrus.Fields{
+		"symbol":    bar.Symbol,
+		"channel":   channel,
+		"bar_start": bar.Start,
+		"close":     bar.Close,
+		"volume":    bar.Volume,
+	})
+
+	contextLog.Debug("Handling stream aggregate event")
+
+	if _, ok := c.Stocks[bar.Symbol]; !ok {
+		logrus.Infof("Ignoring stream aggregate event for unrelated stock %s", bar.Symbol)
+		return nil
+	}
+
+	deliver := func() {
+		c.deliverAggregate(channel, bar, contextLog)
+	}
+
+	if missed, ok := c.aggregateGaps[bar.Symbol]; ok {
+		// Aggregates held behind a gap arrived together after it
+		if channel == api.MinuteAggregateChannel {
+			c.seeAggregate(bar)
+		}
+		missed.held = append(missed.held, deliver)
+		return nil
+	}
+
+	var missed *gap
+	if channel == api.MinuteAggregateChannel {
+		missed = c.checkAggregateGap(bar)
+	}
+	if missed == nil {
+		deliver()
+		return nil
+	}
+
+	missed.held = append(missed.held, deliver)
+	c.aggregateGaps[bar.Symbol] = missed
+	return missed
+}
+
+// deliverAggregate passes an aggregate to the algorithm
+func (c *AlpacaController) deliverAggregate(channel api.Channel, bar api.Bar, contextLog *logrus.Entry) {
+	handler, ok := c.Algorithm.(api.AggregateHandler)
 	if !ok {
-		logrus.Error("Failed to decode trade update")
 		return
 	}
 
+	handler.OnAggregate(
+		api.AggregateContext{
+			Client:     c.client(),
+			Router:     c,
+			Stock:      c.Stocks[bar.Symbol],
+			Stocks:     c.stocks(),
+			Account:    c.Account,
+			Journal:    c.Journal,
+			Channel:    channel,
+			Bar:        bar,
+			Backfill:   c.backfilling,
+			ContextLog: contextLog,
+		},
+	)
+}
+
+// Listen for updates to our orders
+func (c *AlpacaController) handleTradeUpdate(data alpaca.TradeUpdate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	symbol := data.Order.Symbol
 	contextLog := logrus.WithFields(logrus.Fields{
 		"event":    data.Event,
 		"order_id": data.Order.ID,
+		"symbol":   symbol,
 	})
 
 	contextLog.Info("Handling trade update")
 
-	if data.Order.Symbol != c.Stock.Symbol {
-		logrus.Infof("Ignoring trade update for unrelated stock %s", data.Order.Symbol)
+	if _, ok := c.Stocks[symbol]; !ok {
+		logrus.Infof("Ignoring trade update for unrelated stock %s", symbol)
 		return
 	}
 
 	switch data.Event {
 	case "fill", "partial_fill":
-		// Our position has changed
-		if err := c.UpdatePosition(); err != nil {
-			logrus.Error(err)
-			return
+		// Our position has changed. If it can't be fetched now, the
+		// order is still done with and the position fetched again later.
+		if err := c.UpdatePosition(symbol); err != nil {
+			contextLog.Errorf("Failed to update position, refusing orders until it is refreshed: %v", err)
+			c.stalePositions[symbol] = true
+		} else {
+			contextLog.WithFields(logrus.Fields{
+				"position": c.Stocks[symbol].Position,
+			}).Info("Updated position")
 		}
-		contextLog.WithFields(logrus.Fields{
-			"symbol":   data.Order.Symbol,
-			"position": c.Stock.Position,
-		}).Info("Updated position")
-
-		if data.Event == "fill" && c.Order.ID == data.Order.ID {
-			// Clear out completed order
-			c.Order.ID = ""
+
+		// Our cash has changed too, which account updates tell us,
+		// so only fetch it if they have gone quiet
+		c.refreshAccount()
+
+		if data.Event == "fill" {
+			// Clear out completed order. A flip waiting on it needs the
+			// closed position, so waits for a stale one to be refreshed.
+			delete(c.Orders, data.Order.ID)
+			if !c.stalePositions[symbol] {
+				c.sendPendingOrder(symbol, data.Order.ID)
+			}
+		} else {
+			c.trackOrder(data.Order)
 		}
-	case "rejected", "canceled":
-		if c.Order.ID == data.Order.ID {
-			// Clear out order
-			c.Order.ID = ""
+	case "rejected", "canceled", "expired":
+		// Clear out order
+		delete(c.Orders, data.Order.ID)
+
+		if pending, ok := c.pendingOrders[symbol]; ok && pending.AfterOrderID == data.Order.ID {
+			// The flip can't complete, so don't open the other side
+			delete(c.pendingOrders, symbol)
 		}
 	case "new":
-		c.Order.ID = data.Order.ID
+		c.trackOrder(data.Order)
 	default:
 		contextLog.Error("Unexpected order event type")
 	}
+
+	if handler, ok := c.Algorithm.(api.OrderUpdateHandler); ok {
+		handler.OnOrderUpdate(
+			api.OrderUpdateContext{
+				Client:     c.client(),
+				Router:     c,
+				Stock:      c.Stocks[symbol],
+				Stocks:     c.stocks(),
+				Account:    c.Account,
+				Journal:    c.Journal,
+				Update:     data,
+				ContextLog: contextLog,
+			},
+		)
+	}
+
 	contextLog.Info("Completed trade update")
 }
+
+// Listen for updates to our account
+func (c *AlpacaController) handleAccountUpdate(data api.AccountUpdate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	contextLog := logrus.WithFields(logrus.Fields{
+		"status":     data.Status,
+		"updated_at": data.UpdatedAt,
+	})
+
+	contextLog.Info("Handling account update")
+
+	if data.ID != "" && c.Account.ID != "" && data.ID != c.Account.ID {
+		logrus.Infof("Ignoring account update for unrelated account %s", data.ID)
+		return
+	}
+
+	previous := c.Account
+	if data.Status != "" {
+		c.Account.Status = data.Status
+	}
+	if data.Equity != nil {
+		c.Account.Equity, _ = data.Equity.Float64()
+	}
+	if data.Cash != nil {
+		c.Account.Cash, _ = data.Cash.Float64()
+	}
+	if data.BuyingPower != nil {
+		c.Account.BuyingPower, _ = data.BuyingPower.Float64()
+	}
+	if data.DaytradeCount != nil {
+		c.Account.DaytradeCount = *data.DaytradeCount
+	}
+	if data.PatternDayTrader != nil {
+		c.Account.PatternDayTrader = *data.PatternDayTrader
+	}
+	if data.TradingBlocked != nil {
+		c.Account.TradingBlocked = *data.TradingBlocked
+	}
+	if data.AccountBlocked != nil {
+		c.Account.AccountBlocked = *data.AccountBlocked
+	}
+	c.accountUpdated = c.Clock.Now()
+	c.checkAccountStatus(previous)
+
+	if handler, ok := c.Algorithm.(api.AccountHandler); ok {
+		handler.OnAccountUpdate(
+			api.AccountContext{
+				Client:     c.client(),
+				Router:     c,
+				Stocks:     c.stocks(),
+				Account:    c.Account,
+				Journal:    c.Journal,
+				Update:     data,
+				ContextLog: contextLog,
+			},
+		)
+	}
+}
@@ -2,11 +2,20 @@ package controller_test
 
 import (
 	"errors"
+	"io/ioutil"
+	"os"
+	"time"
 
 	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
 	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/apierrors"
+	"github.com/markliederbach/stonks/pkg/alpaca/client"
+	"github.com/markliederbach/stonks/pkg/alpaca/clock"
 	"github.com/markliederbach/stonks/pkg/alpaca/controller"
+	"github.com/markliederbach/stonks/pkg/alpaca/history"
 	"github.com/markliederbach/stonks/pkg/alpaca/internal"
+	"github.com/markliederbach/stonks/pkg/alpaca/sizing"
+	"github.com/markliederbach/stonks/pkg/alpaca/streams"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/shopspring/decimal"
@@ -14,7 +23,7 @@ import (
 
 var _ = Describe("Controller", func() {
 	var (
-		alpacaController controller.AlpacaController
+		alpacaController *controller.AlpacaController
 		mockClient       api.AlpacaClient
 		mockAlgorithm    api.AlpacaAlgorithm
 		stock            string = "MKL"
@@ -36,27 +45,678 @@ var _ = Describe("Controller", func() {
 				ID:               "account123",
 				Equity:           float64(1000),
 				MarginMultiplier: float64(2.00),
+				ShortingEnabled:  true,
 			}))
-			Expect(alpacaController.Stock).To(Equal(api.StockInfo{
-				Symbol:   stock,
-				Position: 3,
-			}))
+			Expect(alpacaController.Stocks[stock].Symbol).To(Equal(stock))
+			Expect(alpacaController.Stocks[stock].Position).To(Equal(decimal.NewFromFloat(3.5)))
+			Expect(alpacaController.Stocks[stock].Asset.Fractionable).To(BeTrue())
 		})
 
 		Context("when no existing positions are found", func() {
 			BeforeEach(func() {
-				err := internal.AddObjReturns("GetPosition", errors.New("position does not exist"))
+				err := internal.AddObjReturns("GetPosition", &alpaca.APIError{Code: 40410000, Message: "position does not exist"})
 				Expect(err).ToNot(HaveOccurred())
 			})
 			It("should report zero shares", func() {
 				Expect(err).ToNot(HaveOccurred())
-				Expect(alpacaController.Stock).To(Equal(api.StockInfo{
-					Symbol:   stock,
-					Position: 0,
-				}))
+				Expect(alpacaController.Stocks[stock].Position).To(Equal(decimal.Zero))
+			})
+		})
+
+	})
+
+	Context("when creating a controller without stocks", func() {
+		JustBeforeEach(func() {
+			mockClient = internal.NewMockAlpacaClient()
+			mockAlgorithm = internal.NewMockAlgorithm()
+			alpacaController, err = controller.NewAlpacaController(mockClient, mockAlgorithm)
+		})
+		It("should fail to create the controller", func() {
+			Expect(err).To(MatchError("controller requires at least one stock"))
+		})
+	})
+
+	Context("when running an algorithm with lifecycle hooks", func() {
+		var (
+			lifecycle *internal.MockLifecycleAlgorithm
+			spec      api.HistorySpec
+		)
+
+		BeforeEach(func() {
+			spec = api.HistorySpec{Interval: time.Minute, Bars: 2}
+		})
+
+		JustBeforeEach(func() {
+			mockClient = internal.NewMockAlpacaClient()
+			lifecycle = internal.NewMockLifecycleAlgorithm(spec)
+			alpacaController, err = controller.NewAlpacaController(mockClient, lifecycle, stock)
+		})
+
+		It("should start the algorithm with its history", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(internal.AddObjReturns("ListBars", map[string][]alpaca.Bar{
+				stock: {
+					{Time: 1604327400, Open: 10, High: 11, Low: 9, Close: 10.5, Volume: 100},
+					{Time: 1604327460, Open: 10.5, High: 12, Low: 10, Close: 11.5, Volume: 200},
+				},
+			})).To(Succeed())
+
+			Expect(alpacaController.Start()).To(Succeed())
+			Expect(lifecycle.Bars).To(HaveKey(stock))
+			Expect(lifecycle.Bars[stock]).To(HaveLen(2))
+			Expect(lifecycle.Bars[stock][1]).To(Equal(api.Bar{
+				Symbol: stock,
+				Start:  time.Date(2020, 11, 2, 14, 31, 0, 0, time.UTC),
+				End:    time.Date(2020, 11, 2, 14, 32, 0, 0, time.UTC),
+				Open:   10.5,
+				High:   12,
+				Low:    10,
+				Close:  11.5,
+				Volume: 200,
+			}))
+		})
+
+		It("should fail to start when the history cannot be loaded", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(internal.AddObjReturns("ListBars", errors.New("boom"))).To(Succeed())
+			Expect(alpacaController.Start()).To(MatchError("failed to load history: boom"))
+		})
+
+		It("should fail to start when the algorithm does", func() {
+			Expect(err).ToNot(HaveOccurred())
+			lifecycle.StartErr = errors.New("not enough history")
+			Expect(alpacaController.Start()).To(MatchError("not enough history"))
+		})
+
+		It("should tell the algorithm when the market opens and closes", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(internal.AddObjReturns("GetClock",
+				&alpaca.Clock{IsOpen: false},
+				&alpaca.Clock{IsOpen: true},
+				&alpaca.Clock{IsOpen: true},
+				&alpaca.Clock{IsOpen: false},
+			)).To(Succeed())
+
+			for i := 0; i < 4; i++ {
+				Expect(alpacaController.CheckMarket()).To(Succeed())
+			}
+			Expect(lifecycle.Market).To(Equal([]bool{true, false}))
+		})
+
+		It("should be safe to stop more than once", func() {
+			Expect(err).ToNot(HaveOccurred())
+			alpacaController.Stop()
+			alpacaController.Stop()
+		})
+
+		Context("with history Alpaca does not keep", func() {
+			BeforeEach(func() {
+				spec = api.HistorySpec{Interval: 2 * time.Minute, Bars: 10}
+			})
+			It("should fail to create the controller", func() {
+				Expect(err).To(MatchError("no history for 2m0s bars"))
+			})
+		})
+	})
+
+	Context("when running against an in-memory stream", func() {
+		var (
+			lifecycle     *internal.MockLifecycleAlgorithm
+			memory        *streams.Memory
+			channels      streams.Channels
+			subscriptions []string
+			retrying      bool
+			resilient     *client.Resilient
+			done          chan error
+		)
+
+		BeforeEach(func() {
+			channels = streams.Channels{}
+			subscriptions = []string{"AM." + stock, "Q." + stock, "T." + stock, "account_updates", "trade_updates"}
+			retrying = false
+		})
+
+		JustBeforeEach(func() {
+			mockClient = internal.NewMockAlpacaClient()
+			if retrying {
+				resilient = client.NewResilient(mockClient, 0)
+				resilient.Backoff = time.Millisecond
+				mockClient = resilient
+			}
+			lifecycle = internal.NewMockLifecycleAlgorithm(api.HistorySpec{})
+			alpacaController, err = controller.NewAlpacaController(mockClient, lifecycle, stock)
+			Expect(err).ToNot(HaveOccurred())
+
+			memory = streams.NewMemory()
+			alpacaController.DataStream = memory
+			alpacaController.OrderStream = memory
+			alpacaController.Channels = channels
+
+			done = make(chan error, 1)
+			go func() {
+				done <- alpacaController.Run()
+				close(done)
+			}()
+			Eventually(memory.Subscriptions).Should(Equal(subscriptions))
+		})
+
+		AfterEach(func() {
+			alpacaController.Stop()
+			Eventually(done).Should(BeClosed())
+		})
+
+		Context("with a client that retries", func() {
+			BeforeEach(func() {
+				retrying = true
+			})
+
+			It("should not wait to retry while handling events", func() {
+				unavailable := &alpaca.APIError{Code: 50010000, Message: "internal server error"}
+				Expect(internal.AddObjReturns("GetPosition", unavailable)).To(Succeed())
+				memory.PublishTradeUpdate(alpaca.TradeUpdate{
+					Event: "fill",
+					Order: alpaca.Order{ID: "order1", Symbol: stock, Side: alpaca.Buy, Qty: decimal.NewFromInt(1)},
+				})
+				Expect(resilient.Errors.Counts()).To(Equal(map[apierrors.Kind]int{apierrors.Transient: 1}))
+			})
+
+			It("should retry when resyncing, as it waits without holding the lock", func() {
+				unavailable := &alpaca.APIError{Code: 50010000, Message: "internal server error"}
+				Expect(internal.AddObjReturns("GetAccount", unavailable)).To(Succeed())
+				Expect(alpacaController.Resync()).To(Succeed())
+				Expect(resilient.Errors.Counts()).To(Equal(map[apierrors.Kind]int{apierrors.Transient: 1}))
+			})
+		})
+
+		It("should pass stream events to the algorithm", func() {
+			memory.PublishTrade(alpaca.StreamTrade{Symbol: stock, Price: 100})
+			memory.PublishTrade(alpaca.StreamTrade{Symbol: "FOO", Price: 100})
+			memory.PublishQuote(alpaca.StreamQuote{Symbol: stock, BidPrice: 99, AskPrice: 101})
+			Expect(memory.PublishAggregate(api.MinuteAggregateChannel, api.Bar{Symbol: stock, Close: 100})).To(Succeed())
+			Expect(lifecycle.HandleStreamTradeCalled).To(Equal(1))
+			Expect(lifecycle.Quotes).To(HaveLen(1))
+			Expect(lifecycle.Aggregates).To(Equal([]api.Bar{{Symbol: stock, Close: 100}}))
+		})
+
+		Context("with channels configured for the stock", func() {
+			BeforeEach(func() {
+				channels = streams.Channels{
+					Default: []api.Channel{api.TradeChannel},
+					Symbols: map[string][]api.Channel{stock: {api.QuoteChannel}},
+				}
+				subscriptions = []string{"Q." + stock, "account_updates", "trade_updates"}
+			})
+			It("should only follow those channels", func() {
+				memory.PublishTrade(alpaca.StreamTrade{Symbol: stock, Price: 100})
+				memory.PublishQuote(alpaca.StreamQuote{Symbol: stock, BidPrice: 99, AskPrice: 101})
+				Expect(lifecycle.HandleStreamTradeCalled).To(Equal(0))
+				Expect(lifecycle.Quotes).To(HaveLen(1))
+			})
+		})
+
+		It("should follow updates to its orders", func() {
+			memory.PublishTradeUpdate(alpaca.TradeUpdate{
+				Event: "new",
+				Order: alpaca.Order{ID: "order1", Symbol: stock, Side: alpaca.Buy, Qty: decimal.NewFromInt(1)},
+			})
+			Expect(alpacaController.Orders).To(HaveKey("order1"))
+
+			memory.PublishTradeUpdate(alpaca.TradeUpdate{
+				Event: "canceled",
+				Order: alpaca.Order{ID: "order1", Symbol: stock},
+			})
+			Expect(alpacaController.Orders).To(BeEmpty())
+		})
+
+		It("should finish with a fill whose position cannot be fetched", func() {
+			order := alpaca.Order{ID: "order1", Symbol: stock, Side: alpaca.Buy, Qty: decimal.NewFromInt(1)}
+			memory.PublishTradeUpdate(alpaca.TradeUpdate{Event: "new", Order: order})
+			Expect(alpacaController.Orders).To(HaveKey("order1"))
+
+			unavailable := &alpaca.APIError{Code: 50010000, Message: "internal server error"}
+			Expect(internal.AddObjReturns("GetPosition", unavailable)).To(Succeed())
+			memory.PublishTradeUpdate(alpaca.TradeUpdate{Event: "fill", Order: order})
+			Expect(alpacaController.Orders).To(BeEmpty())
+			Expect(lifecycle.Updates).To(HaveLen(2))
+
+			_, err := alpacaController.SendLimitOrder(stock, decimal.NewFromInt(5), 100)
+			Expect(err).To(MatchError("position in MKL is out of date"))
+
+			Expect(internal.AddObjReturns("GetPosition", &alpaca.Position{Qty: decimal.NewFromInt(5)})).To(Succeed())
+			memory.PublishTrade(alpaca.StreamTrade{Symbol: stock, Price: 100})
+			Expect(alpacaController.Stocks[stock].Position).To(Equal(decimal.NewFromInt(5)))
+
+			_, err = alpacaController.SendLimitOrder(stock, decimal.NewFromInt(6), 100)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should hold a flip until the closed position can be fetched", func() {
+			_, err := alpacaController.SendLimitOrder(stock, decimal.NewFromInt(-2), 100)
+			Expect(err).ToNot(HaveOccurred())
+
+			unavailable := &alpaca.APIError{Code: 50010000, Message: "internal server error"}
+			Expect(internal.AddObjReturns("GetPosition", unavailable)).To(Succeed())
+			memory.PublishTradeUpdate(alpaca.TradeUpdate{
+				Event: "fill",
+				Order: alpaca.Order{ID: "order123", Symbol: stock, Side: alpaca.Sell, Qty: decimal.NewFromFloat(3.5)},
+			})
+			Expect(alpacaController.Orders).To(BeEmpty())
+
+			Expect(internal.AddObjReturns("GetPosition", &alpaca.APIError{Code: 40410000, Message: "position does not exist"})).To(Succeed())
+			memory.PublishTrade(alpaca.StreamTrade{Symbol: stock, Price: 100})
+			Expect(alpacaController.Orders).To(HaveKey("order123"))
+			Expect(alpacaController.Orders["order123"].Side).To(Equal(alpaca.Sell))
+			Expect(alpacaController.Orders["order123"].Remaining().String()).To(Equal("2"))
+		})
+
+		It("should follow pushed updates to the account", func() {
+			cash := decimal.NewFromFloat(250.5)
+			daytrades := int64(2)
+			memory.PublishAccountUpdate(api.AccountUpdate{ID: "account123", Status: "ACTIVE", Cash: &cash, DaytradeCount: &daytrades})
+			Expect(alpacaController.Account.Cash).To(Equal(250.5))
+			Expect(alpacaController.Account.DaytradeCount).To(Equal(int64(2)))
+			Expect(alpacaController.Account.Equity).To(Equal(float64(1000)))
+			Expect(lifecycle.Accounts).To(HaveLen(1))
+		})
+
+		It("should ignore updates to other accounts", func() {
+			cash := decimal.NewFromFloat(250.5)
+			memory.PublishAccountUpdate(api.AccountUpdate{ID: "account456", Cash: &cash})
+			Expect(alpacaController.Account.Cash).To(BeZero())
+			Expect(lifecycle.Accounts).To(BeEmpty())
+		})
+
+		It("should refuse orders while trading is blocked", func() {
+			blocked := true
+			memory.PublishAccountUpdate(api.AccountUpdate{TradingBlocked: &blocked})
+			_, err := alpacaController.SendLimitOrder(stock, decimal.NewFromInt(5), 100)
+			Expect(err).To(MatchError("trading is blocked on the account"))
+			Expect(apierrors.Is(err, apierrors.Unauthorized)).To(BeTrue())
+
+			blocked = false
+			memory.PublishAccountUpdate(api.AccountUpdate{TradingBlocked: &blocked})
+			_, err = alpacaController.SendLimitOrder(stock, decimal.NewFromInt(5), 100)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should refresh the account when an order is refused for buying power", func() {
+			equity := decimal.NewFromInt(5000)
+			memory.PublishAccountUpdate(api.AccountUpdate{Equity: &equity})
+			Expect(alpacaController.Account.Equity).To(Equal(float64(5000)))
+			refused := &alpaca.APIError{Code: 40310000, Message: "insufficient buying power"}
+			Expect(internal.AddObjReturns("PlaceOrder", refused)).To(Succeed())
+			_, err := alpacaController.SendLimitOrder(stock, decimal.NewFromInt(5), 100)
+			Expect(apierrors.Is(err, apierrors.InsufficientBuyingPower)).To(BeTrue())
+			Expect(alpacaController.Account.Equity).To(Equal(float64(1000)))
+		})
+
+		It("should only fetch the account on trades once it has gone without updates", func() {
+			Expect(internal.AddObjReturns("GetAccount", &alpaca.Account{
+				ID:         "account123",
+				Equity:     decimal.NewFromFloat(2000),
+				Multiplier: "2.00",
+			})).To(Succeed())
+
+			memory.PublishTrade(alpaca.StreamTrade{Symbol: stock, Price: 100})
+			Expect(alpacaController.Account.Equity).To(Equal(float64(1000)))
+
+			alpacaController.AccountRefresh = 0
+			memory.PublishTrade(alpaca.StreamTrade{Symbol: stock, Price: 100})
+			Expect(alpacaController.Account.Equity).To(Equal(float64(2000)))
+		})
+
+		It("should only fetch the account on fills once it has gone without updates", func() {
+			Expect(internal.AddObjReturns("GetAccount", &alpaca.Account{
+				ID:         "account123",
+				Equity:     decimal.NewFromFloat(2000),
+				Multiplier: "2.00",
+			})).To(Succeed())
+			fill := alpaca.TradeUpdate{
+				Event: "partial_fill",
+				Order: alpaca.Order{ID: "order1", Symbol: stock, Side: alpaca.Buy, Qty: decimal.NewFromInt(2)},
+			}
+
+			memory.PublishTradeUpdate(fill)
+			Expect(alpacaController.Account.Equity).To(Equal(float64(1000)))
+
+			alpacaController.AccountRefresh = 0
+			memory.PublishTradeUpdate(fill)
+			Expect(alpacaController.Account.Equity).To(Equal(float64(2000)))
+		})
+
+		It("should stop the algorithm and unsubscribe when stopped", func() {
+			alpacaController.Stop()
+			Eventually(done).Should(Receive(BeNil()))
+			Expect(lifecycle.Stopped).To(BeTrue())
+			Expect(memory.Subscriptions()).To(BeEmpty())
+		})
+	})
+
+	Context("when watching the health of its streams", func() {
+		var (
+			lifecycle    *internal.MockLifecycleAlgorithm
+			barAlgorithm *internal.MockBarAlgorithm
+			memory       *streams.Memory
+			fake         *clock.Fake
+			dir          string
+			store        *history.Store
+			start        time.Time
+			done         chan error
+		)
+
+		BeforeEach(func() {
+			dir, err = ioutil.TempDir("", "backfill")
+			Expect(err).ToNot(HaveOccurred())
+			store, err = history.NewStore(dir)
+			Expect(err).ToNot(HaveOccurred())
+
+			barAlgorithm = nil
+			start = time.Date(2020, 11, 2, 15, 0, 0, 0, time.UTC)
+			Expect(store.PutTrades(stock, start, []history.Trade{
+				{Symbol: stock, Time: start.Add(-time.Minute), Price: 99},
+				{Symbol: stock, Time: start.Add(5 * time.Second), Price: 101},
+				{Symbol: stock, Time: start.Add(2 * time.Minute), Price: 102},
+				{Symbol: stock, Time: start.Add(3 * time.Minute), Price: 103},
+			})).To(Succeed())
+			Expect(store.PutBars(stock, time.Minute, start, []api.Bar{
+				{Symbol: stock, Start: start.Add(time.Minute), Close: 101},
+				{Symbol: stock, Start: start.Add(2 * time.Minute), Close: 102},
+			})).To(Succeed())
+		})
+
+		JustBeforeEach(func() {
+			mockClient = internal.NewMockAlpacaClient()
+			lifecycle = internal.NewMockLifecycleAlgorithm(api.HistorySpec{})
+			var algorithm api.AlpacaAlgorithm = lifecycle
+			if barAlgorithm != nil {
+				algorithm = barAlgorithm
+			}
+			alpacaController, err = controller.NewAlpacaController(mockClient, algorithm, stock)
+			Expect(err).ToNot(HaveOccurred())
+
+			memory = streams.NewMemory()
+			fake = clock.NewFake(start)
+			alpacaController.DataStream = memory
+			alpacaController.OrderStream = memory
+			alpacaController.Clock = fake
+			alpacaController.StaleAfter = 30 * time.Second
+			alpacaController.GapAfter = time.Minute
+			alpacaController.Backfill = history.NewLoader(store, nil)
+
+			done = make(chan error, 1)
+			go func() {
+				done <- alpacaController.Run()
+				close(done)
+			}()
+			Eventually(alpacaController.Running()).Should(BeClosed())
+		})
+
+		AfterEach(func() {
+			alpacaController.Stop()
+			Eventually(done).Should(BeClosed())
+			os.RemoveAll(dir)
+		})
+
+		trade := func(at time.Time) alpaca.StreamTrade {
+			return alpaca.StreamTrade{Symbol: stock, Price: 100, Timestamp: at.UnixNano()}
+		}
+
+		It("should backfill missed trades before passing on the next", func() {
+			memory.PublishTrade(trade(start))
+			memory.PublishTrade(trade(start.Add(4 * time.Minute)))
+			Expect(lifecycle.HandleStreamTradeCalled).To(Equal(5))
+		})
+
+		It("should not backfill trades close together", func() {
+			memory.PublishTrade(trade(start))
+			memory.PublishTrade(trade(start.Add(30 * time.Second)))
+			Expect(lifecycle.HandleStreamTradeCalled).To(Equal(2))
+		})
+
+		It("should backfill missed minute aggregates in order", func() {
+			Expect(memory.PublishAggregate(api.MinuteAggregateChannel, api.Bar{Symbol: stock, Start: start, Close: 100})).To(Succeed())
+			Expect(memory.PublishAggregate(api.MinuteAggregateChannel, api.Bar{Symbol: stock, Start: start.Add(3 * time.Minute), Close: 103})).To(Succeed())
+
+			closes := []float64{}
+			for _, bar := range lifecycle.Aggregates {
+				closes = append(closes, bar.Close)
+			}
+			Expect(closes).To(Equal([]float64{100, 101, 102, 103}))
+		})
+
+		It("should mark backfilled aggregates and refuse orders on them", func() {
+			lifecycle.PlaceOrders = true
+			Expect(memory.PublishAggregate(api.MinuteAggregateChannel, api.Bar{Symbol: stock, Start: start, Close: 100})).To(Succeed())
+			Expect(memory.PublishAggregate(api.MinuteAggregateChannel, api.Bar{Symbol: stock, Start: start.Add(3 * time.Minute), Close: 103})).To(Succeed())
+
+			closes := []float64{}
+			for _, bar := range lifecycle.Backfilled {
+				closes = append(closes, bar.Close)
+			}
+			Expect(closes).To(Equal([]float64{101, 102}))
+
+			Expect(lifecycle.OrderErrors).To(HaveLen(4))
+			Expect(lifecycle.OrderErrors[0]).ToNot(HaveOccurred())
+			Expect(lifecycle.OrderErrors[1]).To(MatchError("orders are not placed on backfilled market data"))
+			Expect(lifecycle.OrderErrors[2]).To(MatchError("orders are not placed on backfilled market data"))
+			Expect(lifecycle.OrderErrors[3]).ToNot(HaveOccurred())
+		})
+
+		It("should resync its orders once data goes missing", func() {
+			alpacaController.Orders["order1"] = api.OrderInfo{ID: "order1", Symbol: stock}
+			Expect(internal.AddObjReturns("ListOrders", []alpaca.Order{
+				{ID: "order2", Symbol: stock, Side: alpaca.Buy, Qty: decimal.NewFromInt(1)},
+			})).To(Succeed())
+
+			memory.PublishTrade(trade(start))
+			memory.PublishTrade(trade(start.Add(4 * time.Minute)))
+			Expect(alpacaController.Orders).To(HaveLen(1))
+			Expect(alpacaController.Orders).To(HaveKey("order2"))
+		})
+
+		Context("when a stock goes quiet while the market is open", func() {
+			JustBeforeEach(func() {
+				memory.PublishTrade(trade(start))
+				alpacaController.Orders["order1"] = api.OrderInfo{ID: "order1", Symbol: stock}
+				Expect(internal.AddObjReturns("GetClock", &alpaca.Clock{IsOpen: true})).To(Succeed())
+				fake.Add(30 * time.Second)
+			})
+
+			It("should resubscribe and resync", func() {
+				Expect(memory.Subscriptions()).To(ContainElement("T." + stock))
+				Expect(alpacaController.Orders).To(BeEmpty())
+			})
+
+			It("should backfill up to the next trade, however close", func() {
+				memory.PublishTrade(trade(start.Add(10 * time.Second)))
+				Expect(lifecycle.HandleStreamTradeCalled).To(Equal(3))
+			})
+		})
+
+		Context("when a stock with trades and aggregates goes quiet", func() {
+			JustBeforeEach(func() {
+				memory.PublishTrade(trade(start))
+				Expect(memory.PublishAggregate(api.MinuteAggregateChannel, api.Bar{Symbol: stock, Start: start, Close: 100})).To(Succeed())
+				Expect(internal.AddObjReturns("GetClock", &alpaca.Clock{IsOpen: true})).To(Succeed())
+				fake.Add(30 * time.Second)
+			})
+
+			It("should backfill each stream when it comes back", func() {
+				memory.PublishTrade(trade(start.Add(10 * time.Second)))
+				Expect(memory.PublishAggregate(api.MinuteAggregateChannel, api.Bar{Symbol: stock, Start: start.Add(2 * time.Minute), Close: 102})).To(Succeed())
+
+				closes := []float64{}
+				for _, bar := range lifecycle.Aggregates {
+					closes = append(closes, bar.Close)
+				}
+				Expect(closes).To(Equal([]float64{100, 101, 102}))
+			})
+		})
+
+		Context("when a gap in trades spans the time bars close", func() {
+			BeforeEach(func() {
+				barAlgorithm = internal.NewMockBarAlgorithm(api.BarSpec{Type: api.TimeBars, Interval: time.Minute, FillGaps: true})
+			})
+			JustBeforeEach(func() {
+				memory.PublishTrade(trade(start))
+				fake.Add(150 * time.Second)
+			})
+
+			It("should hold the bars open until what was missed is backfilled", func() {
+				Expect(barAlgorithm.OnBarCalls).To(BeEmpty())
+				memory.PublishTrade(trade(start.Add(150 * time.Second)))
+
+				closes := []float64{}
+				for _, bar := range barAlgorithm.Backfilled {
+					closes = append(closes, bar.Close)
+				}
+				Expect(closes).To(Equal([]float64{101, 101}))
+				Expect(barAlgorithm.OnBarCalls).To(Equal(barAlgorithm.Backfilled))
+			})
+		})
+
+		Context("when a stock goes quiet while the market is closed", func() {
+			JustBeforeEach(func() {
+				alpacaController.Orders["order1"] = api.OrderInfo{ID: "order1", Symbol: stock}
+				Expect(internal.AddObjReturns("GetClock", &alpaca.Clock{IsOpen: false})).To(Succeed())
+				fake.Add(30 * time.Second)
+			})
+
+			It("should leave it be", func() {
+				Expect(alpacaController.Orders).To(HaveKey("order1"))
+			})
+		})
+	})
+
+	Context("when creating a controller for a timer algorithm", func() {
+		var interval time.Duration
+
+		JustBeforeEach(func() {
+			mockClient = internal.NewMockAlpacaClient()
+			mockAlgorithm = internal.NewMockTimerAlgorithm(interval)
+			alpacaController, err = controller.NewAlpacaController(mockClient, mockAlgorithm, stock)
+		})
+
+		Context("with a positive interval", func() {
+			BeforeEach(func() {
+				interval = time.Minute
+			})
+			It("should create the controller", func() {
+				Expect(err).ToNot(HaveOccurred())
 			})
 		})
 
+		Context("without an interval", func() {
+			BeforeEach(func() {
+				interval = 0
+			})
+			It("should fail to create the controller", func() {
+				Expect(err).To(MatchError("timer interval must be positive, got 0s"))
+			})
+		})
+	})
+
+	Context("when trading several stocks", func() {
+		var (
+			otherStock string = "FOO"
+			orders     []*alpaca.Order
+		)
+
+		JustBeforeEach(func() {
+			mockClient = internal.NewMockAlpacaClient()
+			mockAlgorithm = internal.NewMockAlgorithm()
+			alpacaController, err = controller.NewAlpacaController(mockClient, mockAlgorithm, stock, otherStock)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should track each stock", func() {
+			Expect(alpacaController.Stocks).To(HaveLen(2))
+			Expect(alpacaController.Stocks[otherStock].Symbol).To(Equal(otherStock))
+			Expect(alpacaController.Stocks[otherStock].Position).To(Equal(decimal.NewFromFloat(3.5)))
+		})
+
+		Context("when submitting the legs of a pair together", func() {
+			JustBeforeEach(func() {
+				orders, err = alpacaController.SubmitIntents(
+					api.TargetIntent(stock, decimal.NewFromFloat(5), 1.25),
+					api.TargetIntent(otherStock, decimal.NewFromFloat(2), 2.50),
+				)
+			})
+			It("should send an order for each leg", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(orders).To(HaveLen(2))
+				Expect(orders[0].Symbol).To(Equal(stock))
+				Expect(orders[0].Side).To(Equal(alpaca.Buy))
+				Expect(orders[1].Symbol).To(Equal(otherStock))
+				Expect(orders[1].Side).To(Equal(alpaca.Sell))
+			})
+		})
+
+		Context("when one leg fails its risk check", func() {
+			BeforeEach(func() {
+				err := internal.AddObjReturns("GetAsset", &api.Asset{
+					Asset:        alpaca.Asset{Symbol: stock, Tradable: true, Shortable: false},
+					Fractionable: true,
+				})
+				Expect(err).ToNot(HaveOccurred())
+			})
+			JustBeforeEach(func() {
+				orders, err = alpacaController.SubmitIntents(
+					api.TargetIntent(otherStock, decimal.NewFromFloat(5), 2.50),
+					api.TargetIntent(stock, decimal.NewFromFloat(-2), 1.25),
+				)
+			})
+			It("should not send any leg", func() {
+				Expect(err).To(MatchError("cannot short MKL: asset is not shortable"))
+				Expect(orders).To(BeNil())
+				Expect(alpacaController.Orders).To(BeEmpty())
+			})
+		})
+
+		Context("when a later leg fails to send", func() {
+			BeforeEach(func() {
+				err := internal.AddObjReturns("PlaceOrder",
+					&alpaca.Order{ID: "leg1", Symbol: stock, Side: alpaca.Buy},
+					errors.New("boom"),
+				)
+				Expect(err).ToNot(HaveOccurred())
+			})
+			JustBeforeEach(func() {
+				orders, err = alpacaController.SubmitIntents(
+					api.TargetIntent(stock, decimal.NewFromFloat(5), 1.25),
+					api.TargetIntent(otherStock, decimal.NewFromFloat(5), 2.50),
+				)
+			})
+			It("should report the failed leg", func() {
+				Expect(err).To(MatchError("failed to send FOO leg: boom"))
+				Expect(orders).To(BeNil())
+			})
+		})
+
+		Context("when an intent repeats a stock", func() {
+			JustBeforeEach(func() {
+				orders, err = alpacaController.SubmitIntents(
+					api.TargetIntent(stock, decimal.NewFromFloat(5), 1.25),
+					api.TargetIntent(stock, decimal.NewFromFloat(4), 1.25),
+				)
+			})
+			It("should reject the intents", func() {
+				Expect(err).To(MatchError("intents contain MKL more than once"))
+			})
+		})
+	})
+
+	Context("when the algorithm consumes bars", func() {
+		Context("when the bar spec is invalid", func() {
+			JustBeforeEach(func() {
+				mockClient = internal.NewMockAlpacaClient()
+				mockAlgorithm = internal.NewMockBarAlgorithm(api.BarSpec{Type: api.VolumeBars})
+				alpacaController, err = controller.NewAlpacaController(mockClient, mockAlgorithm, stock)
+			})
+			It("should fail to create the controller", func() {
+				Expect(err).To(MatchError("volume bars require a positive threshold, got 0"))
+			})
+		})
 	})
 
 	Context("when placing an order", func() {
@@ -72,9 +732,9 @@ var _ = Describe("Controller", func() {
 
 		Context("when target position is greater than current position", func() {
 			JustBeforeEach(func() {
-				order, err = alpacaController.SendLimitOrder(5, 1.25)
+				order, err = alpacaController.SendLimitOrder(stock, decimal.NewFromFloat(5), 1.25)
 			})
-			It("should submit a BUY order for 2 shares and return the order", func() {
+			It("should submit a BUY order for 1.5 shares and return the order", func() {
 				Expect(err).ToNot(HaveOccurred())
 				limitPrice := decimal.NewFromFloat(1.25)
 				Expect(order).To(Equal(&alpaca.Order{
@@ -82,7 +742,7 @@ var _ = Describe("Controller", func() {
 					Symbol:      stock,
 					Side:        alpaca.Buy,
 					Type:        alpaca.Limit,
-					Qty:         decimal.NewFromFloat(2),
+					Qty:         decimal.NewFromFloat(1.5),
 					LimitPrice:  &limitPrice,
 					TimeInForce: alpaca.Day,
 				}))
@@ -91,9 +751,9 @@ var _ = Describe("Controller", func() {
 
 		Context("when target position is less than current position", func() {
 			JustBeforeEach(func() {
-				order, err = alpacaController.SendLimitOrder(2, 1.25)
+				order, err = alpacaController.SendLimitOrder(stock, decimal.NewFromFloat(2), 1.25)
 			})
-			It("should submit a SELL order for 1 share and return the order", func() {
+			It("should submit a SELL order for 1.5 shares and return the order", func() {
 				Expect(err).ToNot(HaveOccurred())
 				limitPrice := decimal.NewFromFloat(1.25)
 				Expect(order).To(Equal(&alpaca.Order{
@@ -101,7 +761,7 @@ var _ = Describe("Controller", func() {
 					Symbol:      stock,
 					Side:        alpaca.Sell,
 					Type:        alpaca.Limit,
-					Qty:         decimal.NewFromFloat(1),
+					Qty:         decimal.NewFromFloat(1.5),
 					LimitPrice:  &limitPrice,
 					TimeInForce: alpaca.Day,
 				}))
@@ -110,13 +770,186 @@ var _ = Describe("Controller", func() {
 
 		Context("when target position is equal to the current position", func() {
 			JustBeforeEach(func() {
-				order, err = alpacaController.SendLimitOrder(3, 1.25)
+				order, err = alpacaController.SendLimitOrder(stock, decimal.NewFromFloat(3.5), 1.25)
 			})
 			It("should return an error for no-op", func() {
 				Expect(err).To(MatchError("no-op order requested"))
 				Expect(order).To(Equal(&alpaca.Order{}))
 			})
 		})
+
+		Context("when target position is short and we hold no shares", func() {
+			BeforeEach(func() {
+				err := internal.AddObjReturns("GetPosition", &alpaca.APIError{Code: 40410000, Message: "position does not exist"})
+				Expect(err).ToNot(HaveOccurred())
+			})
+			JustBeforeEach(func() {
+				order, err = alpacaController.SendLimitOrder(stock, decimal.NewFromFloat(-2), 1.25)
+			})
+			It("should submit a SELL order to open the short position", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(order.Side).To(Equal(alpaca.Sell))
+				Expect(order.Qty.String()).To(Equal("2"))
+			})
+		})
+
+		Context("when we are short and the target is less short", func() {
+			BeforeEach(func() {
+				err := internal.AddObjReturns("GetPosition", &alpaca.Position{Qty: decimal.NewFromFloat(-4), Side: "short"})
+				Expect(err).ToNot(HaveOccurred())
+			})
+			JustBeforeEach(func() {
+				order, err = alpacaController.SendLimitOrder(stock, decimal.NewFromFloat(-1), 1.25)
+			})
+			It("should submit a BUY order to cover part of the position", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(order.Side).To(Equal(alpaca.Buy))
+				Expect(order.Qty.String()).To(Equal("3"))
+			})
+		})
+
+		Context("when the target flips a long position to short", func() {
+			JustBeforeEach(func() {
+				order, err = alpacaController.SendLimitOrder(stock, decimal.NewFromFloat(-2), 1.25)
+			})
+			It("should only submit a SELL order to close the long position", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(order.Side).To(Equal(alpaca.Sell))
+				Expect(order.Qty.String()).To(Equal("3.5"))
+			})
+		})
+
+		Context("when the target is short but the asset is hard to borrow", func() {
+			BeforeEach(func() {
+				err := internal.AddObjReturns("GetAsset", &api.Asset{
+					Asset:        alpaca.Asset{Symbol: stock, Tradable: true, Shortable: true},
+					Fractionable: true,
+				})
+				Expect(err).ToNot(HaveOccurred())
+			})
+			JustBeforeEach(func() {
+				order, err = alpacaController.SendLimitOrder(stock, decimal.NewFromFloat(-2), 1.25)
+			})
+			It("should reject the order", func() {
+				Expect(err).To(MatchError("cannot short MKL: asset is not easy to borrow"))
+				Expect(order).To(Equal(&alpaca.Order{}))
+			})
+		})
+
+		Context("when a notional target is requested", func() {
+			JustBeforeEach(func() {
+				order, err = alpacaController.SubmitIntent(api.NotionalIntent(stock, decimal.NewFromFloat(10), 2.00))
+			})
+			It("should convert the notional into a share target", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(order.Side).To(Equal(alpaca.Buy))
+				Expect(order.Qty.String()).To(Equal("1.5"))
+			})
+		})
+
+		Context("when a signal is sent", func() {
+			var (
+				sizers sizing.Sizers
+			)
+
+			BeforeEach(func() {
+				sizers = sizing.Sizers{}
+			})
+
+			JustBeforeEach(func() {
+				alpacaController.Sizing = sizers
+				order, err = alpacaController.SubmitIntent(api.SignalIntent(stock, 0.5, 2.00))
+			})
+
+			It("should reject the intent without a sizer", func() {
+				Expect(err).To(MatchError("no sizer configured for MKL"))
+			})
+
+			Context("when a sizer is configured", func() {
+				BeforeEach(func() {
+					sizers = sizing.Sizers{Default: sizing.PercentOfEquity{Percent: 0.03}}
+				})
+				It("should size the target from account equity", func() {
+					// Half of 3% of $1000 at $2 is 7.5 shares, up from 3.5
+					Expect(err).ToNot(HaveOccurred())
+					Expect(order.Side).To(Equal(alpaca.Buy))
+					Expect(order.Qty.String()).To(Equal("4"))
+				})
+			})
+		})
+
+		Context("when an explicit limit order is placed", func() {
+			JustBeforeEach(func() {
+				order, err = alpacaController.PlaceLimitOrder(stock, alpaca.Sell, decimal.NewFromFloat(2), 1.25)
+			})
+			It("should send the order as is", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(order.Side).To(Equal(alpaca.Sell))
+				Expect(order.Qty.String()).To(Equal("2"))
+			})
+		})
+
+		Context("when explicit limit orders are already working", func() {
+			JustBeforeEach(func() {
+				alpacaController.Risk.MaxPositionValue = 70
+				_, err = alpacaController.PlaceLimitOrder(stock, alpaca.Buy, decimal.NewFromFloat(2), 10)
+				Expect(err).ToNot(HaveOccurred())
+				order, err = alpacaController.PlaceLimitOrder(stock, alpaca.Buy, decimal.NewFromFloat(2), 10)
+			})
+			It("should track the working order", func() {
+				Expect(alpacaController.Orders).To(HaveKey("order123"))
+				Expect(alpacaController.Orders["order123"].Remaining().String()).To(Equal("2"))
+			})
+			It("should risk check as if every working order fills", func() {
+				Expect(err).To(MatchError("position value 75.00 in MKL exceeds limit of 70.00"))
+				Expect(order).To(Equal(&alpaca.Order{}))
+			})
+		})
+
+		Context("when an explicit limit order is for an unrelated stock", func() {
+			JustBeforeEach(func() {
+				order, err = alpacaController.PlaceLimitOrder("FOO", alpaca.Buy, decimal.NewFromFloat(2), 1.25)
+			})
+			It("should reject the order", func() {
+				Expect(err).To(MatchError("order for unrelated stock FOO"))
+				Expect(order).To(Equal(&alpaca.Order{}))
+			})
+		})
+
+		Context("when the stock is not fractionable", func() {
+			BeforeEach(func() {
+				err := internal.AddObjReturns("GetAsset", &api.Asset{
+					Asset:        alpaca.Asset{Symbol: stock, Tradable: true},
+					Fractionable: false,
+				})
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			Context("when the target requires a fractional quantity", func() {
+				JustBeforeEach(func() {
+					order, err = alpacaController.SendLimitOrder(stock, decimal.NewFromFloat(5), 1.25)
+				})
+				It("should reject the order", func() {
+					Expect(err).To(MatchError("quantity 1.5 is not supported for MKL"))
+					Expect(order).To(Equal(&alpaca.Order{}))
+				})
+			})
+
+			Context("when a notional target is requested", func() {
+				BeforeEach(func() {
+					err := internal.AddObjReturns("GetPosition", &alpaca.Position{Qty: decimal.NewFromFloat(3)})
+					Expect(err).ToNot(HaveOccurred())
+				})
+				JustBeforeEach(func() {
+					order, err = alpacaController.SubmitIntent(api.NotionalIntent(stock, decimal.NewFromFloat(21), 2.00))
+				})
+				It("should round the target down to whole shares", func() {
+					Expect(err).ToNot(HaveOccurred())
+					Expect(order.Side).To(Equal(alpaca.Buy))
+					Expect(order.Qty.String()).To(Equal("7"))
+				})
+			})
+		})
 	})
 
 })
@@ -0,0 +1,355 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/history"
+	"github.com/sirupsen/logrus"
+)
+
+// CheckHealth resubscribes to any stock that has gone without market data
+// for StaleAfter while the market is open, then resyncs our orders and
+// positions, as whatever dropped the stream may have dropped our trade
+// updates too.
+func (c *AlpacaController) CheckHealth() error {
+	if c.StaleAfter <= 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	now := c.Clock.Now()
+	stale := []string{}
+	lastEvents := map[string]time.Time{}
+	for _, symbol := range c.symbols {
+		if now.Sub(c.lastEvent[symbol]) >= c.StaleAfter {
+			stale = append(stale, symbol)
+			lastEvents[symbol] = c.lastEvent[symbol]
+		}
+	}
+	c.mu.Unlock()
+
+	if len(stale) == 0 {
+		return nil
+	}
+
+	// Quiet stocks are expected outside market hours
+	clock, err := c.Client.GetClock()
+	if err != nil {
+		return err
+	}
+	if !clock.IsOpen {
+		return nil
+	}
+
+	for _, symbol := range stale {
+		logrus.WithFields(logrus.Fields{
+			"symbol":     symbol,
+			"last_event": lastEvents[symbol],
+		}).Warn("Market data went stale, resubscribing")
+
+		if err := c.resubscribe(symbol); err != nil {
+			return fmt.Errorf("failed to resubscribe to %s: %w", symbol, err)
+		}
+	}
+
+	return c.Resync()
+}
+
+// Resync refreshes our account, positions and working orders from
+// Alpaca, catching up on any trade updates the stream missed
+func (c *AlpacaController) Resync() error {
+	state, err := c.fetchSnapshot()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.resync(state)
+}
+
+// snapshot is our account, positions and open orders as Alpaca has them
+type snapshot struct {
+	account   *alpaca.Account
+	positions map[string]*alpaca.Position
+	orders    []alpaca.Order
+}
+
+// fetchSnapshot fetches what a resync needs from Alpaca. It is called
+// without holding mu, so events are handled while it waits on Alpaca.
+func (c *AlpacaController) fetchSnapshot() (*snapshot, error) {
+	account, err := c.Client.GetAccount()
+	if err != nil {
+		return nil, err
+	}
+
+	positions := map[string]*alpaca.Position{}
+	for _, symbol := range c.symbols {
+		position, err := fetchPosition(c.Client, symbol)
+		if err != nil {
+			return nil, err
+		}
+		positions[symbol] = position
+	}
+
+	status, until, limit := "open", c.Clock.Now(), 500
+	orders, err := c.Client.ListOrders(&status, &until, &limit, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshot{account: account, positions: positions, orders: orders}, nil
+}
+
+// resync refreshes our account, positions and working orders. Orders we
+// track that are no longer open are dropped, and a flip waiting on one is
+// sent if its position was closed, or dropped if not.
+func (c *AlpacaController) resync(state *snapshot) error {
+	if err := c.setAccount(state.account); err != nil {
+		return err
+	}
+
+	for _, symbol := range c.symbols {
+		c.setPosition(symbol, state.positions[symbol])
+	}
+
+	open := map[string]bool{}
+	for _, order := range state.orders {
+		if _, ok := c.Stocks[order.Symbol]; !ok {
+			continue
+		}
+		open[order.ID] = true
+		c.trackOrder(order)
+	}
+
+	for orderID, order := range c.Orders {
+		if !open[orderID] {
+			logrus.WithFields(logrus.Fields{"order_id": orderID, "symbol": order.Symbol}).Info("Dropped order that is no longer open")
+			delete(c.Orders, orderID)
+		}
+	}
+
+	for _, symbol := range c.symbols {
+		c.settlePendingOrder(symbol)
+	}
+
+	logrus.WithFields(logrus.Fields{"orders": len(c.Orders)}).Info("Resynced orders and positions")
+	return nil
+}
+
+// checkHealth checks for stale market data, logging any failure
+func (c *AlpacaController) checkHealth(time.Time) {
+	if err := c.CheckHealth(); err != nil {
+		logrus.Errorf("Failed to check stream health: %v", err)
+	}
+}
+
+// resubscribe follows a stock's market data channels afresh, marking
+// the stock so what was missed is backfilled when data arrives again
+func (c *AlpacaController) resubscribe(symbol string) error {
+	if err := c.DataStream.Unsubscribe(symbol); err != nil {
+		return err
+	}
+
+	for _, channel := range c.channels(symbol) {
+		if err := c.subscribe(symbol, channel); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastEvent[symbol] = c.Clock.Now()
+	c.resubscribedTrades[symbol] = true
+	c.resubscribedAggregates[symbol] = true
+	return nil
+}
+
+// gap is market data missed in one of a stock's streams, from
+// (inclusive) to (exclusive), with what was fetched to fill it
+type gap struct {
+	symbol string
+	kind   string
+	from   time.Time
+	to     time.Time
+	trades []history.Trade
+	bars   []api.Bar
+
+	// held are the live events that arrived while the gap was
+	// being fetched, passed on once it is filled
+	held []func()
+}
+
+// checkTradeGap returns the trades missed before a trade, when
+// it is far enough from the last one we saw in the stock
+func (c *AlpacaController) checkTradeGap(data alpaca.StreamTrade) *gap {
+	at, last, ok := c.seeTrade(data)
+	if !ok || !c.isGap(c.resubscribedTrades, data.Symbol, at.Sub(last)) {
+		return nil
+	}
+
+	// Trades at the same time as the last one were already seen
+	return &gap{symbol: data.Symbol, kind: "trades", from: last.Add(time.Nanosecond), to: at}
+}
+
+// seeTrade records a trade as the newest in its stock, returning when it
+// and the one before it were made, if it is newer than one we saw
+func (c *AlpacaController) seeTrade(data alpaca.StreamTrade) (time.Time, time.Time, bool) {
+	if data.Timestamp == 0 {
+		return time.Time{}, time.Time{}, false
+	}
+
+	at := time.Unix(0, data.Timestamp).UTC()
+	last, seen := c.lastTrade[data.Symbol]
+	if seen && !at.After(last) {
+		return at, last, false
+	}
+	c.lastTrade[data.Symbol] = at
+	return at, last, seen
+}
+
+// checkAggregateGap returns the minute aggregates missed before an
+// aggregate, when it is far enough from the last one we saw in the stock
+func (c *AlpacaController) checkAggregateGap(bar api.Bar) *gap {
+	last, ok := c.seeAggregate(bar)
+	if !ok {
+		return nil
+	}
+
+	next := last.Add(time.Minute)
+	if !c.isGap(c.resubscribedAggregates, bar.Symbol, bar.Start.Sub(next)) {
+		return nil
+	}
+
+	return &gap{symbol: bar.Symbol, kind: "aggregates", from: next, to: bar.Start}
+}
+
+// seeAggregate records a minute aggregate as the newest in its stock,
+// returning when the one before it started, if it is newer than one we saw
+func (c *AlpacaController) seeAggregate(bar api.Bar) (time.Time, bool) {
+	if bar.Start.IsZero() {
+		return time.Time{}, false
+	}
+
+	last, seen := c.lastAggregate[bar.Symbol]
+	if seen && !bar.Start.After(last) {
+		return last, false
+	}
+	c.lastAggregate[bar.Symbol] = bar.Start
+	return last, seen
+}
+
+// isGap reports whether the time missing from one of a stock's streams
+// needs backfilling, clearing the stream's mark if it was resubscribed
+func (c *AlpacaController) isGap(resubscribed map[string]bool, symbol string, missing time.Duration) bool {
+	if resubscribed[symbol] {
+		delete(resubscribed, symbol)
+		return true
+	}
+	return c.GapAfter > 0 && missing > c.GapAfter
+}
+
+// fillGap fetches what was missed in a gap, then resyncs, as the stream
+// has likely reconnected, and passes what was missed to the algorithm as
+// backfill before the events held back behind the gap. It is called
+// without holding mu, so other events are handled while it fetches.
+func (c *AlpacaController) fillGap(missed *gap) {
+	contextLog := logrus.WithFields(logrus.Fields{
+		"symbol": missed.symbol,
+		"from":   missed.from,
+		"to":     missed.to,
+	})
+	contextLog.Warnf("Missed %s", missed.kind)
+
+	state, syncErr := c.fetchSnapshot()
+	backfillErr := c.fetchBackfill(missed)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if syncErr == nil {
+		syncErr = c.resync(state)
+	}
+	if syncErr != nil {
+		contextLog.Errorf("Failed to resync: %v", syncErr)
+	}
+
+	switch {
+	case c.Backfill == nil:
+		contextLog.Warnf("No backfill configured, %s were lost", missed.kind)
+	case backfillErr != nil:
+		contextLog.Errorf("Failed to backfill %s: %v", missed.kind, backfillErr)
+	default:
+		c.deliverBackfill(missed)
+		contextLog.WithFields(logrus.Fields{"count": len(missed.trades) + len(missed.bars)}).Infof("Backfilled %s", missed.kind)
+	}
+
+	if missed.kind == "trades" {
+		delete(c.tradeGaps, missed.symbol)
+	} else {
+		delete(c.aggregateGaps, missed.symbol)
+	}
+
+	for _, deliver := range missed.held {
+		deliver()
+	}
+}
+
+// deliverBackfill passes what was fetched for a gap to the algorithm
+func (c *AlpacaController) deliverBackfill(missed *gap) {
+	c.backfilling = true
+	defer func() { c.backfilling = false }()
+
+	for _, trade := range missed.trades {
+		data := trade.StreamTrade()
+		c.deliverTrade(data, logrus.WithFields(logrus.Fields{
+			"symbol":   data.Symbol,
+			"price":    data.Price,
+			"backfill": true,
+		}))
+	}
+
+	for _, bar := range missed.bars {
+		c.deliverAggregate(api.MinuteAggregateChannel, bar, logrus.WithFields(logrus.Fields{
+			"symbol":    bar.Symbol,
+			"channel":   api.MinuteAggregateChannel,
+			"bar_start": bar.Start,
+			"close":     bar.Close,
+			"volume":    bar.Volume,
+			"backfill":  true,
+		}))
+	}
+}
+
+// missingTrades reports whether trades in a stock may have been missed
+// and not yet backfilled. That is known once the stock is resubscribed
+// or a gap is found, and suspected once it has gone quiet for longer
+// than GapAfter, as its next trade will backfill what was missed.
+func (c *AlpacaController) missingTrades(symbol string, now time.Time) bool {
+	if _, ok := c.tradeGaps[symbol]; ok || c.resubscribedTrades[symbol] {
+		return true
+	}
+
+	last, seen := c.lastTrade[symbol]
+	return seen && c.GapAfter > 0 && now.Sub(last) > c.GapAfter
+}
+
+// fetchBackfill fetches the market data missed in a gap, without holding mu
+func (c *AlpacaController) fetchBackfill(missed *gap) error {
+	if c.Backfill == nil {
+		return nil
+	}
+
+	var err error
+	if missed.kind == "trades" {
+		missed.trades, err = c.Backfill.Trades(missed.symbol, missed.from, missed.to)
+	} else {
+		missed.bars, err = c.Backfill.Bars(missed.symbol, time.Minute, missed.from, missed.to)
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+)
+
+// alpacaLimit is the most bars Alpaca returns for a request
+const alpacaLimit int = 1000
+
+var _ Source = &AlpacaSource{}
+
+// alpacaTimeframes maps the bar intervals Alpaca keeps history
+// for to the timeframe names its bars endpoint expects
+var alpacaTimeframes = map[time.Duration]string{
+	time.Minute:      "1Min",
+	5 * time.Minute:  "5Min",
+	15 * time.Minute: "15Min",
+	day:              "1D",
+}
+
+// AlpacaSource fetches bars from Alpaca. Alpaca does not serve trades.
+type AlpacaSource struct {
+	Client api.AlpacaClient
+}
+
+// NewAlpacaSource returns a source fetching from Alpaca through the client
+func NewAlpacaSource(client api.AlpacaClient) *AlpacaSource {
+	return &AlpacaSource{Client: client}
+}
+
+// Bars implements the function on the Source interface
+func (s *AlpacaSource) Bars(symbol string, interval time.Duration, date time.Time) ([]api.Bar, error) {
+	timeframe, ok := alpacaTimeframes[interval]
+	if !ok {
+		return nil, fmt.Errorf("alpaca has no history for %v bars", interval)
+	}
+
+	start, end, limit := date.UTC(), date.UTC().Add(day-time.Nanosecond), alpacaLimit
+	results, err := s.Client.ListBars([]string{symbol}, alpaca.ListBarParams{
+		Timeframe: timeframe,
+		StartDt:   &start,
+		EndDt:     &end,
+		Limit:     &limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bars := []api.Bar{}
+	for _, result := range results[symbol] {
+		barStart := time.Unix(result.Time, 0).UTC()
+		if barStart.Before(start) || barStart.After(end) {
+			continue
+		}
+		bars = append(bars, api.Bar{
+			Symbol: symbol,
+			Start:  barStart,
+			End:    barStart.Add(interval),
+			Open:   float64(result.Open),
+			High:   float64(result.High),
+			Low:    float64(result.Low),
+			Close:  float64(result.Close),
+			Volume: float64(result.Volume),
+		})
+	}
+	return bars, nil
+}
+
+// Trades implements the function on the Source interface
+func (s *AlpacaSource) Trades(symbol string, date time.Time) ([]Trade, error) {
+	return nil, errors.New("alpaca does not serve historic trades")
+}
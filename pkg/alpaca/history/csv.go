@@ -0,0 +1,241 @@
+package history
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+)
+
+// csvTimeFormats are the layouts times may take in an imported CSV,
+// besides Unix seconds. Times without a zone are UTC.
+var csvTimeFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// csvColumns are the names each field may have in an imported CSV header
+var csvColumns = map[string][]string{
+	"time":     {"time", "timestamp", "date", "t"},
+	"open":     {"open", "o"},
+	"high":     {"high", "h"},
+	"low":      {"low", "l"},
+	"close":    {"close", "c"},
+	"volume":   {"volume", "v"},
+	"vwap":     {"vwap", "vw"},
+	"price":    {"price", "p"},
+	"size":     {"size", "s"},
+	"exchange": {"exchange", "x"},
+}
+
+// ImportBars caches bars of an interval for a stock from a CSV with a
+// header naming its time, open, high, low, close and volume columns,
+// and optionally vwap. Each day from the first bar to the last is
+// replaced, including days without bars. It returns the bars imported.
+func (s *Store) ImportBars(r io.Reader, symbol string, interval time.Duration) (int, error) {
+	if _, err := Timeframe(interval); err != nil {
+		return 0, err
+	}
+
+	byDay := map[time.Time][]api.Bar{}
+	count, err := readCSV(r, []string{"time", "open", "high", "low", "close", "volume"}, func(row csvRow) error {
+		start, err := row.time("time")
+		if err != nil {
+			return err
+		}
+
+		bar := api.Bar{Symbol: symbol, Start: start, End: start.Add(interval)}
+		for field, value := range map[string]*float64{
+			"open":   &bar.Open,
+			"high":   &bar.High,
+			"low":    &bar.Low,
+			"close":  &bar.Close,
+			"volume": &bar.Volume,
+			"vwap":   &bar.VWAP,
+		} {
+			if *value, err = row.float(field); err != nil {
+				return err
+			}
+		}
+
+		date := start.Truncate(day)
+		byDay[date] = append(byDay[date], bar)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	dates := []time.Time{}
+	for date := range byDay {
+		dates = append(dates, date)
+	}
+
+	for _, date := range importedDays(dates) {
+		bars := byDay[date]
+		sort.SliceStable(bars, func(i, j int) bool { return bars[i].Start.Before(bars[j].Start) })
+		if err := s.PutBars(symbol, interval, date, bars); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+// ImportTrades caches trades in a stock from a CSV with a header
+// naming its time, price and size columns, and optionally exchange.
+// Each day from the first trade to the last is replaced, including
+// days without trades. It returns the trades imported.
+func (s *Store) ImportTrades(r io.Reader, symbol string) (int, error) {
+	byDay := map[time.Time][]Trade{}
+	count, err := readCSV(r, []string{"time", "price", "size"}, func(row csvRow) error {
+		at, err := row.time("time")
+		if err != nil {
+			return err
+		}
+
+		trade := Trade{Symbol: symbol, Time: at, Exchange: row.string("exchange")}
+		if trade.Price, err = row.float("price"); err != nil {
+			return err
+		}
+		if trade.Size, err = row.float("size"); err != nil {
+			return err
+		}
+
+		date := at.Truncate(day)
+		byDay[date] = append(byDay[date], trade)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	dates := []time.Time{}
+	for date := range byDay {
+		dates = append(dates, date)
+	}
+
+	for _, date := range importedDays(dates) {
+		trades := byDay[date]
+		sort.SliceStable(trades, func(i, j int) bool { return trades[i].Time.Before(trades[j].Time) })
+		if err := s.PutTrades(symbol, date, trades); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+// importedDays lists every day from the earliest to the latest given
+func importedDays(dates []time.Time) []time.Time {
+	if len(dates) == 0 {
+		return nil
+	}
+
+	first, last := dates[0], dates[0]
+	for _, date := range dates {
+		if date.Before(first) {
+			first = date
+		}
+		if date.After(last) {
+			last = date
+		}
+	}
+	return days(first, last.Add(day))
+}
+
+// csvRow is a row of an imported CSV, with its columns by field
+type csvRow struct {
+	line    int
+	record  []string
+	columns map[string]int
+}
+
+// string returns a field, or nothing if the CSV does not have it
+func (r csvRow) string(field string) string {
+	column, ok := r.columns[field]
+	if !ok || column >= len(r.record) {
+		return ""
+	}
+	return strings.TrimSpace(r.record[column])
+}
+
+// float parses a field as a number, where a missing field is zero
+func (r csvRow) float(field string) (float64, error) {
+	value := r.string(field)
+	if value == "" {
+		return 0, nil
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("line %d: invalid %s %q", r.line, field, value)
+	}
+	return number, nil
+}
+
+// time parses a field as a time, in UTC
+func (r csvRow) time(field string) (time.Time, error) {
+	value := r.string(field)
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	for _, format := range csvTimeFormats {
+		if parsed, err := time.Parse(format, value); err == nil {
+			return parsed.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("line %d: invalid %s %q", r.line, field, value)
+}
+
+// readCSV calls the function with each row of a CSV, after checking
+// its header has the required fields, returning the rows read
+func readCSV(r io.Reader, required []string, fn func(csvRow) error) (int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	columns := map[string]int{}
+	for column, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		for field, aliases := range csvColumns {
+			for _, alias := range aliases {
+				if name == alias {
+					columns[field] = column
+				}
+			}
+		}
+	}
+	for _, field := range required {
+		if _, ok := columns[field]; !ok {
+			return 0, fmt.Errorf("csv header is missing a %s column", field)
+		}
+	}
+
+	count, line := 0, 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		line++
+
+		if err := fn(csvRow{line: line, record: record, columns: columns}); err != nil {
+			return 0, err
+		}
+		count++
+	}
+}
@@ -0,0 +1,90 @@
+package history_test
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/history"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CSV", func() {
+	var (
+		dir    string
+		store  *history.Store
+		friday time.Time
+		err    error
+	)
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "csv")
+		Expect(err).ToNot(HaveOccurred())
+		store, err = history.NewStore(dir)
+		Expect(err).ToNot(HaveOccurred())
+		friday = time.Date(2020, 11, 6, 0, 0, 0, 0, time.UTC)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should import bars, caching the days between them", func() {
+		count, err := store.ImportBars(strings.NewReader(
+			"Date,Open,High,Low,Close,Volume\n"+
+				"2020-11-09T14:31:00Z,11,12,10,11.5,200\n"+
+				"2020-11-06 14:30:00,10,11,9,10.5,100\n",
+		), "MKL", time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(Equal(2))
+
+		bars, ok, err := store.Bars("MKL", time.Minute, friday)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ok).To(BeTrue())
+		Expect(bars).To(HaveLen(1))
+		Expect(bars[0].Start).To(Equal(friday.Add(14*time.Hour + 30*time.Minute)))
+		Expect(bars[0].End).To(Equal(friday.Add(14*time.Hour + 31*time.Minute)))
+		Expect(bars[0].Close).To(Equal(10.5))
+
+		// The weekend is cached, with nothing in it
+		loader := history.NewLoader(store, nil)
+		all, err := loader.Bars("MKL", time.Minute, friday, friday.Add(4*24*time.Hour))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(all).To(HaveLen(2))
+	})
+
+	It("should import trades timed in Unix seconds", func() {
+		count, err := store.ImportTrades(strings.NewReader(
+			"t,p,s,x\n"+
+				"1604673000,100.25,10,4\n",
+		), "MKL")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(Equal(1))
+
+		trades, ok, err := store.Trades("MKL", friday)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ok).To(BeTrue())
+		Expect(trades).To(Equal([]history.Trade{{
+			Symbol:   "MKL",
+			Time:     friday.Add(14*time.Hour + 30*time.Minute),
+			Price:    100.25,
+			Size:     10,
+			Exchange: "4",
+		}}))
+	})
+
+	It("should require the columns it needs", func() {
+		_, err := store.ImportTrades(strings.NewReader("time,price\n"), "MKL")
+		Expect(err).To(MatchError("csv header is missing a size column"))
+	})
+
+	It("should report the line of an invalid value", func() {
+		_, err := store.ImportTrades(strings.NewReader(
+			"time,price,size\n"+
+				"2020-11-06T14:30:00Z,lots,10\n",
+		), "MKL")
+		Expect(err).To(MatchError(`line 2: invalid price "lots"`))
+	})
+})
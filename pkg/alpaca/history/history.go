@@ -0,0 +1,191 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/clock"
+)
+
+const (
+	// dayFormat names each day of history
+	dayFormat string = "2006-01-02"
+
+	// day is the length of a UTC day, by which history is fetched and cached
+	day time.Duration = 24 * time.Hour
+)
+
+// ErrNotCached is returned offline for history that was never cached
+var ErrNotCached = errors.New("history is not cached")
+
+// Trade is a single trade in a stock, as any source reports it
+type Trade struct {
+	Symbol   string    `json:"symbol"`
+	Time     time.Time `json:"time"`
+	Price    float64   `json:"price"`
+	Size     float64   `json:"size"`
+	Exchange string    `json:"exchange,omitempty"`
+}
+
+// StreamTrade converts the trade to one as Alpaca streams it,
+// such as to replay it through a controller
+func (t Trade) StreamTrade() alpaca.StreamTrade {
+	return alpaca.StreamTrade{
+		Event:     "T",
+		Symbol:    t.Symbol,
+		Price:     float32(t.Price),
+		Size:      int32(t.Size),
+		Timestamp: t.Time.UnixNano(),
+	}
+}
+
+// Source fetches history from a data provider, one UTC day at a time
+type Source interface {
+	// Bars returns the bars of the interval that start on the day, oldest first
+	Bars(symbol string, interval time.Duration, day time.Time) ([]api.Bar, error)
+	// Trades returns the trades made on the day, oldest first
+	Trades(symbol string, day time.Time) ([]Trade, error)
+}
+
+// Timeframe names a bar interval, such as 1Min or 1D
+func Timeframe(interval time.Duration) (string, error) {
+	switch {
+	case interval <= 0 || interval%time.Minute != 0:
+		return "", fmt.Errorf("bars must last a positive number of minutes, got %v", interval)
+	case interval%day == 0:
+		return fmt.Sprintf("%dD", interval/day), nil
+	default:
+		return fmt.Sprintf("%dMin", interval/time.Minute), nil
+	}
+}
+
+// Loader serves history from a store, fetching what the store is
+// missing from a source and caching it for next time. Without a
+// source, it only serves what was cached. Days that have not ended
+// are fetched every time, as their history is not complete.
+type Loader struct {
+	Store  *Store
+	Source Source
+	Clock  api.Clock
+}
+
+// NewLoader returns a loader caching history from the source in the
+// store, or serving the store offline if the source is nil. Without a
+// store, every request is fetched.
+func NewLoader(store *Store, source Source) *Loader {
+	return &Loader{
+		Store:  store,
+		Source: source,
+		Clock:  clock.Real{},
+	}
+}
+
+// Bars returns the bars of an interval for a stock starting in the
+// range from (inclusive) to (exclusive), oldest first
+func (l *Loader) Bars(symbol string, interval time.Duration, from, to time.Time) ([]api.Bar, error) {
+	timeframe, err := Timeframe(interval)
+	if err != nil {
+		return nil, err
+	}
+
+	bars := []api.Bar{}
+	for _, date := range days(from, to) {
+		cached, ok, err := l.Store.Bars(symbol, interval, date)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			if cached, err = l.fetchBars(symbol, interval, timeframe, date); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, bar := range cached {
+			if !bar.Start.Before(from) && bar.Start.Before(to) {
+				bars = append(bars, bar)
+			}
+		}
+	}
+	return bars, nil
+}
+
+// Trades returns the trades in a stock made in the range from
+// (inclusive) to (exclusive), oldest first
+func (l *Loader) Trades(symbol string, from, to time.Time) ([]Trade, error) {
+	trades := []Trade{}
+	for _, date := range days(from, to) {
+		cached, ok, err := l.Store.Trades(symbol, date)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			if cached, err = l.fetchTrades(symbol, date); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, trade := range cached {
+			if !trade.Time.Before(from) && trade.Time.Before(to) {
+				trades = append(trades, trade)
+			}
+		}
+	}
+	return trades, nil
+}
+
+// fetchBars fetches a day of bars, caching them if the day has ended
+func (l *Loader) fetchBars(symbol string, interval time.Duration, timeframe string, date time.Time) ([]api.Bar, error) {
+	if l.Source == nil {
+		return nil, fmt.Errorf("%s %s bars on %s: %w", symbol, timeframe, date.Format(dayFormat), ErrNotCached)
+	}
+
+	bars, err := l.Source.Bars(symbol, interval, date)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s %s bars on %s: %w", symbol, timeframe, date.Format(dayFormat), err)
+	}
+
+	if l.ended(date) {
+		if err := l.Store.PutBars(symbol, interval, date, bars); err != nil {
+			return nil, err
+		}
+	}
+	return bars, nil
+}
+
+// fetchTrades fetches a day of trades, caching them if the day has ended
+func (l *Loader) fetchTrades(symbol string, date time.Time) ([]Trade, error) {
+	if l.Source == nil {
+		return nil, fmt.Errorf("%s trades on %s: %w", symbol, date.Format(dayFormat), ErrNotCached)
+	}
+
+	trades, err := l.Source.Trades(symbol, date)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s trades on %s: %w", symbol, date.Format(dayFormat), err)
+	}
+
+	if l.ended(date) {
+		if err := l.Store.PutTrades(symbol, date, trades); err != nil {
+			return nil, err
+		}
+	}
+	return trades, nil
+}
+
+// ended reports whether a day is over, so its history is complete
+func (l *Loader) ended(date time.Time) bool {
+	return !l.Clock.Now().Before(date.Add(day))
+}
+
+// days lists the UTC days touching the range from (inclusive) to (exclusive)
+func days(from, to time.Time) []time.Time {
+	dates := []time.Time{}
+	for date := from.UTC().Truncate(day); date.Before(to); date = date.Add(day) {
+		dates = append(dates, date)
+	}
+	return dates
+}
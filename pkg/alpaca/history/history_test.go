@@ -0,0 +1,149 @@
+package history_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/clock"
+	"github.com/markliederbach/stonks/pkg/alpaca/history"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// countingSource serves a bar and a trade at the open of each
+// day, counting the days fetched
+type countingSource struct {
+	fetched int
+	err     error
+}
+
+func (s *countingSource) Bars(symbol string, interval time.Duration, day time.Time) ([]api.Bar, error) {
+	s.fetched++
+	open := day.Add(14*time.Hour + 30*time.Minute)
+	return []api.Bar{{Symbol: symbol, Start: open, End: open.Add(interval), Close: 100}}, s.err
+}
+
+func (s *countingSource) Trades(symbol string, day time.Time) ([]history.Trade, error) {
+	s.fetched++
+	open := day.Add(14*time.Hour + 30*time.Minute)
+	return []history.Trade{{Symbol: symbol, Time: open, Price: 100, Size: 10}}, s.err
+}
+
+var _ = Describe("History", func() {
+	var (
+		dir    string
+		store  *history.Store
+		source *countingSource
+		loader *history.Loader
+		monday time.Time
+		err    error
+	)
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "history")
+		Expect(err).ToNot(HaveOccurred())
+		store, err = history.NewStore(dir)
+		Expect(err).ToNot(HaveOccurred())
+
+		monday = time.Date(2020, 11, 2, 0, 0, 0, 0, time.UTC)
+		source = &countingSource{}
+		loader = history.NewLoader(store, source)
+		loader.Clock = clock.NewFake(monday.Add(72 * time.Hour))
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should name timeframes by their interval", func() {
+		Expect(history.Timeframe(time.Minute)).To(Equal("1Min"))
+		Expect(history.Timeframe(15 * time.Minute)).To(Equal("15Min"))
+		Expect(history.Timeframe(24 * time.Hour)).To(Equal("1D"))
+		_, err := history.Timeframe(30 * time.Second)
+		Expect(err).To(MatchError("bars must last a positive number of minutes, got 30s"))
+	})
+
+	It("should fetch each day once, then serve it from the store", func() {
+		bars, err := loader.Bars("MKL", time.Minute, monday, monday.Add(48*time.Hour))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bars).To(HaveLen(2))
+		Expect(source.fetched).To(Equal(2))
+		Expect(store.Path("MKL", "1Min", monday)).To(BeAnExistingFile())
+
+		again, err := loader.Bars("MKL", time.Minute, monday, monday.Add(48*time.Hour))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(again).To(Equal(bars))
+		Expect(source.fetched).To(Equal(2))
+	})
+
+	It("should only return history in the range", func() {
+		trades, err := loader.Trades("MKL", monday.Add(15*time.Hour), monday.Add(48*time.Hour))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(trades).To(HaveLen(1))
+		Expect(trades[0].Time).To(Equal(monday.Add(24*time.Hour + 14*time.Hour + 30*time.Minute)))
+	})
+
+	It("should not cache days that have not ended", func() {
+		loader.Clock = clock.NewFake(monday.Add(12 * time.Hour))
+		_, err := loader.Bars("MKL", time.Minute, monday, monday.Add(24*time.Hour))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(store.Path("MKL", "1Min", monday)).ToNot(BeAnExistingFile())
+	})
+
+	It("should report failures to fetch", func() {
+		source.err = errors.New("boom")
+		_, err := loader.Bars("MKL", time.Minute, monday, monday.Add(24*time.Hour))
+		Expect(err).To(MatchError("failed to fetch MKL 1Min bars on 2020-11-02: boom"))
+	})
+
+	Context("when offline", func() {
+		BeforeEach(func() {
+			loader = history.NewLoader(store, nil)
+		})
+
+		It("should serve what was cached", func() {
+			Expect(store.PutTrades("MKL", monday, []history.Trade{{Symbol: "MKL", Time: monday, Price: 100}})).To(Succeed())
+			trades, err := loader.Trades("MKL", monday, monday.Add(24*time.Hour))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(trades).To(HaveLen(1))
+		})
+
+		It("should fail for history that was never cached", func() {
+			_, err := loader.Bars("MKL", time.Minute, monday, monday.Add(24*time.Hour))
+			Expect(errors.Is(err, history.ErrNotCached)).To(BeTrue())
+		})
+	})
+
+	Context("without a store", func() {
+		BeforeEach(func() {
+			loader.Store = nil
+		})
+
+		It("should fetch every time", func() {
+			for i := 0; i < 2; i++ {
+				_, err := loader.Bars("MKL", time.Minute, monday, monday.Add(24*time.Hour))
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(source.fetched).To(Equal(2))
+		})
+	})
+
+	It("should cache a day with nothing in it", func() {
+		Expect(store.PutBars("MKL", time.Minute, monday, nil)).To(Succeed())
+		bars, ok, err := store.Bars("MKL", time.Minute, monday)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ok).To(BeTrue())
+		Expect(bars).To(BeEmpty())
+	})
+
+	It("should convert trades to stream trades", func() {
+		trade := history.Trade{Symbol: "MKL", Time: monday, Price: 100.5, Size: 10}.StreamTrade()
+		Expect(trade.Symbol).To(Equal("MKL"))
+		Expect(trade.Price).To(Equal(float32(100.5)))
+		Expect(trade.Size).To(Equal(int32(10)))
+		Expect(trade.Timestamp).To(Equal(monday.UnixNano()))
+	})
+})
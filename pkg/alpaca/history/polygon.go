@@ -0,0 +1,108 @@
+package history
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/polygon"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+)
+
+// polygonLimit is the most trades requested from Polygon at a time
+const polygonLimit int64 = 50000
+
+var _ Source = &PolygonSource{}
+var _ PolygonClient = &polygon.Client{}
+
+// PolygonClient wraps polygon.Client to allow easy swap-out (such as for testing)
+type PolygonClient interface {
+	GetHistoricAggregatesV2(symbol string, multiplier int, resolution polygon.AggType, from, to *time.Time, unadjusted *bool) (*polygon.HistoricAggregatesV2, error)
+	GetHistoricTradesV2(ticker string, date string, opts *polygon.HistoricTicksV2Params) (*polygon.HistoricTradesV2, error)
+}
+
+// PolygonSource fetches bars and trades from Polygon
+type PolygonSource struct {
+	Client PolygonClient
+}
+
+// NewPolygonSource returns a source fetching from Polygon through the client
+func NewPolygonSource(client PolygonClient) *PolygonSource {
+	return &PolygonSource{Client: client}
+}
+
+// Bars implements the function on the Source interface
+func (s *PolygonSource) Bars(symbol string, interval time.Duration, date time.Time) ([]api.Bar, error) {
+	multiplier, resolution := int(interval/time.Minute), polygon.Minute
+	if interval%day == 0 {
+		multiplier, resolution = int(interval/day), polygon.Day
+	}
+	if multiplier <= 0 || interval%time.Minute != 0 {
+		return nil, fmt.Errorf("polygon has no history for %v bars", interval)
+	}
+
+	start, end := date.UTC(), date.UTC().Add(day-time.Millisecond)
+	results, err := s.Client.GetHistoricAggregatesV2(symbol, multiplier, resolution, &start, &end, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	bars := []api.Bar{}
+	for _, tick := range results.Ticks {
+		barStart := time.Unix(0, tick.EpochMilliseconds*int64(time.Millisecond)).UTC()
+		if barStart.Before(start) || barStart.After(end) {
+			continue
+		}
+		bars = append(bars, api.Bar{
+			Symbol: symbol,
+			Start:  barStart,
+			End:    barStart.Add(interval),
+			Open:   tick.Open,
+			High:   tick.High,
+			Low:    tick.Low,
+			Close:  tick.Close,
+			Volume: tick.Volume,
+		})
+	}
+	return bars, nil
+}
+
+// Trades implements the function on the Source interface, paging
+// through the day by timestamp
+func (s *PolygonSource) Trades(symbol string, date time.Time) ([]Trade, error) {
+	trades := []Trade{}
+	params := &polygon.HistoricTicksV2Params{Limit: polygonLimit}
+	for {
+		offset := params.Timestamp
+		results, err := s.Client.GetHistoricTradesV2(symbol, date.UTC().Format(dayFormat), params)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tick := range results.Results {
+			if tick.SIPTimestamp == nil || tick.Price == nil {
+				continue
+			}
+			trade := Trade{
+				Symbol: symbol,
+				Time:   time.Unix(0, *tick.SIPTimestamp).UTC(),
+				Price:  *tick.Price,
+			}
+			if tick.Size != nil {
+				trade.Size = float64(*tick.Size)
+			}
+			if tick.Exchange != nil {
+				trade.Exchange = strconv.Itoa(*tick.Exchange)
+			}
+			trades = append(trades, trade)
+			params.Timestamp = *tick.SIPTimestamp
+		}
+
+		if int64(len(results.Results)) < polygonLimit {
+			return trades, nil
+		}
+		if params.Timestamp == offset {
+			return nil, fmt.Errorf("polygon trades for %s did not page past %d", symbol, offset)
+		}
+	}
+}
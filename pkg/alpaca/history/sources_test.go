@@ -0,0 +1,114 @@
+package history_test
+
+import (
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/alpacahq/alpaca-trade-api-go/polygon"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/history"
+	"github.com/markliederbach/stonks/pkg/alpaca/internal"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// pagingPolygon serves trades a page at a time, by timestamp
+type pagingPolygon struct {
+	trades []polygon.TradeTickV2
+	pages  int
+}
+
+func (p *pagingPolygon) GetHistoricAggregatesV2(symbol string, multiplier int, resolution polygon.AggType, from, to *time.Time, unadjusted *bool) (*polygon.HistoricAggregatesV2, error) {
+	return &polygon.HistoricAggregatesV2{Ticks: []polygon.AggTick{
+		{Open: 10, High: 11, Low: 9, Close: 10.5, Volume: 100, EpochMilliseconds: from.Add(14*time.Hour+30*time.Minute).UnixNano() / int64(time.Millisecond)},
+	}}, nil
+}
+
+func (p *pagingPolygon) GetHistoricTradesV2(ticker string, date string, opts *polygon.HistoricTicksV2Params) (*polygon.HistoricTradesV2, error) {
+	p.pages++
+	page := []polygon.TradeTickV2{}
+	for _, trade := range p.trades {
+		if *trade.SIPTimestamp > opts.Timestamp && int64(len(page)) < opts.Limit {
+			page = append(page, trade)
+		}
+	}
+	return &polygon.HistoricTradesV2{Results: page}, nil
+}
+
+var _ = Describe("Sources", func() {
+	var monday time.Time
+
+	BeforeEach(func() {
+		monday = time.Date(2020, 11, 2, 0, 0, 0, 0, time.UTC)
+	})
+
+	Context("from Alpaca", func() {
+		var source *history.AlpacaSource
+
+		BeforeEach(func() {
+			source = history.NewAlpacaSource(internal.NewMockAlpacaClient())
+		})
+
+		It("should normalize bars", func() {
+			Expect(internal.AddObjReturns("ListBars", map[string][]alpaca.Bar{
+				"MKL": {{Time: 1604327400, Open: 10, High: 11, Low: 9, Close: 10.5, Volume: 100}},
+			})).To(Succeed())
+
+			bars, err := source.Bars("MKL", 5*time.Minute, monday)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bars).To(Equal([]api.Bar{{
+				Symbol: "MKL",
+				Start:  time.Date(2020, 11, 2, 14, 30, 0, 0, time.UTC),
+				End:    time.Date(2020, 11, 2, 14, 35, 0, 0, time.UTC),
+				Open:   10,
+				High:   11,
+				Low:    9,
+				Close:  10.5,
+				Volume: 100,
+			}}))
+		})
+
+		It("should refuse intervals Alpaca does not keep", func() {
+			_, err := source.Bars("MKL", 2*time.Minute, monday)
+			Expect(err).To(MatchError("alpaca has no history for 2m0s bars"))
+		})
+
+		It("should refuse trades", func() {
+			_, err := source.Trades("MKL", monday)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("from Polygon", func() {
+		var (
+			client *pagingPolygon
+			source *history.PolygonSource
+		)
+
+		BeforeEach(func() {
+			client = &pagingPolygon{}
+			for i := 1; i <= 3; i++ {
+				timestamp, price, size := monday.Add(time.Duration(i)*time.Second).UnixNano(), float64(100+i), 10
+				client.trades = append(client.trades, polygon.TradeTickV2{SIPTimestamp: &timestamp, Price: &price, Size: &size})
+			}
+			source = history.NewPolygonSource(client)
+		})
+
+		It("should normalize bars", func() {
+			bars, err := source.Bars("MKL", 2*time.Minute, monday)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bars).To(HaveLen(1))
+			Expect(bars[0].Start).To(Equal(time.Date(2020, 11, 2, 14, 30, 0, 0, time.UTC)))
+			Expect(bars[0].End).To(Equal(time.Date(2020, 11, 2, 14, 32, 0, 0, time.UTC)))
+		})
+
+		It("should page through the day's trades", func() {
+			trades, err := source.Trades("MKL", monday)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(trades).To(HaveLen(3))
+			Expect(trades[2].Price).To(Equal(float64(103)))
+			Expect(trades[2].Time).To(Equal(monday.Add(3 * time.Second)))
+			Expect(client.pages).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,160 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+)
+
+// tradesTimeframe is where trades are kept alongside bars
+const tradesTimeframe string = "trades"
+
+// Store keeps history on disk, as a JSON lines file per symbol,
+// timeframe and UTC day. A day cached with nothing in it, such as a
+// weekend, is kept as an empty file, so it is not fetched again. A nil
+// store caches nothing.
+type Store struct {
+	dir string
+}
+
+// NewStore returns a store keeping history in the given
+// directory, creating it if it does not exist
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Path is the file a day of history is kept in
+func (s *Store) Path(symbol, timeframe string, date time.Time) string {
+	return filepath.Join(s.dir, symbol, timeframe, date.UTC().Format(dayFormat)+".jsonl")
+}
+
+// Bars returns a cached day of bars, reporting whether the day was cached
+func (s *Store) Bars(symbol string, interval time.Duration, date time.Time) ([]api.Bar, bool, error) {
+	timeframe, err := Timeframe(interval)
+	if err != nil {
+		return nil, false, err
+	}
+
+	bars := []api.Bar{}
+	ok, err := s.read(symbol, timeframe, date, func(line []byte) error {
+		bar := api.Bar{}
+		if err := json.Unmarshal(line, &bar); err != nil {
+			return err
+		}
+		bars = append(bars, bar)
+		return nil
+	})
+	return bars, ok, err
+}
+
+// Trades returns a cached day of trades, reporting whether the day was cached
+func (s *Store) Trades(symbol string, date time.Time) ([]Trade, bool, error) {
+	trades := []Trade{}
+	ok, err := s.read(symbol, tradesTimeframe, date, func(line []byte) error {
+		trade := Trade{}
+		if err := json.Unmarshal(line, &trade); err != nil {
+			return err
+		}
+		trades = append(trades, trade)
+		return nil
+	})
+	return trades, ok, err
+}
+
+// PutBars caches a day of bars, replacing any cached before
+func (s *Store) PutBars(symbol string, interval time.Duration, date time.Time, bars []api.Bar) error {
+	timeframe, err := Timeframe(interval)
+	if err != nil {
+		return err
+	}
+	return s.write(symbol, timeframe, date, func(encoder *json.Encoder) error {
+		for _, bar := range bars {
+			if err := encoder.Encode(bar); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PutTrades caches a day of trades, replacing any cached before
+func (s *Store) PutTrades(symbol string, date time.Time, trades []Trade) error {
+	return s.write(symbol, tradesTimeframe, date, func(encoder *json.Encoder) error {
+		for _, trade := range trades {
+			if err := encoder.Encode(trade); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// write replaces a cached day with whatever the function encodes,
+// all at once, so a failed write never leaves a partial day behind
+func (s *Store) write(symbol, timeframe string, date time.Time, fn func(*json.Encoder) error) error {
+	if s == nil {
+		return nil
+	}
+
+	path := s.Path(symbol, timeframe, date)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), ".write-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	writer := bufio.NewWriter(file)
+	if err := fn(json.NewEncoder(writer)); err != nil {
+		file.Close()
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// read calls the function with each line of a cached day,
+// reporting whether the day was cached
+func (s *Store) read(symbol, timeframe string, date time.Time, fn func([]byte) error) (bool, error) {
+	if s == nil {
+		return false, nil
+	}
+
+	path := s.Path(symbol, timeframe, date)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if err := fn(scanner.Bytes()); err != nil {
+			return false, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+	}
+	return true, scanner.Err()
+}
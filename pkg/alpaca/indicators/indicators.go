@@ -0,0 +1,87 @@
+package indicators
+
+import (
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+)
+
+// Indicator is a streaming indicator that updates in constant
+// time as each bar completes.
+type Indicator interface {
+	// UpdateBar adds a completed bar to the indicator
+	UpdateBar(bar api.Bar)
+	// Value returns the latest value of the indicator
+	Value() float64
+	// Ready reports whether the indicator has seen enough bars
+	// for its value to be meaningful
+	Ready() bool
+}
+
+// SeriesIndicator is an indicator over a single series of values,
+// which defaults to bar closes when updated from a bar.
+type SeriesIndicator interface {
+	Indicator
+	// Update adds the next value in the series
+	Update(value float64)
+}
+
+// window is a fixed-size ring buffer of the latest values in a series
+type window struct {
+	values []float64
+	next   int
+	count  int
+}
+
+func newWindow(size int) *window {
+	return &window{values: make([]float64, size)}
+}
+
+// push adds a value, returning the value it evicted, if any
+func (w *window) push(value float64) (float64, bool) {
+	evicted, full := w.values[w.next], w.full()
+	w.values[w.next] = value
+	w.next = (w.next + 1) % len(w.values)
+	if !full {
+		w.count++
+	}
+	return evicted, full
+}
+
+func (w *window) full() bool {
+	return w.count == len(w.values)
+}
+
+// monotonicQueue tracks the maximum (or minimum) of a sliding window
+// in amortized constant time.
+type monotonicQueue struct {
+	size    int
+	better  func(a, b float64) bool
+	indices []int
+	values  []float64
+	seen    int
+}
+
+func newMaxQueue(size int) *monotonicQueue {
+	return &monotonicQueue{size: size, better: func(a, b float64) bool { return a >= b }}
+}
+
+func newMinQueue(size int) *monotonicQueue {
+	return &monotonicQueue{size: size, better: func(a, b float64) bool { return a <= b }}
+}
+
+func (q *monotonicQueue) push(value float64) {
+	for len(q.values) > 0 && q.better(value, q.values[len(q.values)-1]) {
+		q.values = q.values[:len(q.values)-1]
+		q.indices = q.indices[:len(q.indices)-1]
+	}
+	q.values = append(q.values, value)
+	q.indices = append(q.indices, q.seen)
+	q.seen++
+	if q.indices[0] <= q.seen-1-q.size {
+		q.values = q.values[1:]
+		q.indices = q.indices[1:]
+	}
+}
+
+func (q *monotonicQueue) best() float64 {
+	return q.values[0]
+}
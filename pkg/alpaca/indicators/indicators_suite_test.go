@@ -0,0 +1,13 @@
+package indicators_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestIndicators(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Alpaca Indicators Suite")
+}
@@ -0,0 +1,265 @@
+package indicators_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/indicators"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Reference values were computed independently with naive,
+// non-incremental implementations of each indicator.
+
+var closes = []float64{
+	44.34, 44.09, 44.15, 43.61, 44.33, 44.83, 45.10, 45.42, 45.84, 46.08, 45.89,
+	46.03, 45.61, 46.28, 46.28, 46.00, 46.03, 46.41, 46.22, 45.64, 46.21, 46.25,
+	45.71, 46.45, 45.78, 45.35, 44.03, 44.18, 44.22, 44.57, 43.42, 42.66, 43.13,
+}
+
+var ohlcv = [][5]float64{
+	{100.0, 101.1, 99.5, 100.46, 100},
+	{100.46, 100.76, 98.66, 98.87, 600},
+	{98.87, 100.77, 98.67, 100.35, 400},
+	{100.35, 100.55, 100.05, 100.35, 700},
+	{100.35, 100.65, 99.55, 99.66, 900},
+	{99.66, 101.06, 99.46, 100.9, 200},
+	{100.9, 101.7, 99.0, 99.0, 700},
+	{99.0, 99.2, 98.2, 98.2, 900},
+	{98.2, 98.7, 97.2, 98.1, 300},
+	{98.1, 99.9, 97.7, 99.68, 500},
+}
+
+// none marks a value that should not be ready yet
+const none float64 = -1e9
+
+type indicatorCase struct {
+	name      string
+	indicator indicators.Indicator
+	value     func(indicators.Indicator) float64
+	expected  []float64
+}
+
+func bars() []api.Bar {
+	start := time.Date(2021, 1, 4, 14, 30, 0, 0, time.UTC)
+	result := make([]api.Bar, len(ohlcv))
+	for i, values := range ohlcv {
+		result[i] = api.Bar{
+			Start:  start.Add(time.Duration(i) * time.Minute),
+			Open:   values[0],
+			High:   values[1],
+			Low:    values[2],
+			Close:  values[3],
+			Volume: values[4],
+		}
+	}
+	return result
+}
+
+func closeBars(values []float64) []api.Bar {
+	result := make([]api.Bar, len(values))
+	for i, value := range values {
+		result[i] = api.Bar{Close: value}
+	}
+	return result
+}
+
+func primary(indicator indicators.Indicator) float64 {
+	return indicator.Value()
+}
+
+var _ = Describe("Indicators", func() {
+	seriesCases := []indicatorCase{
+		{
+			name:      "SMA",
+			indicator: indicators.NewSMA(5),
+			value:     primary,
+			expected:  []float64{none, none, none, none, 44.104, 44.202, 44.404, 44.658, 45.104, 45.454, 45.666, 45.852},
+		},
+		{
+			name:      "EMA",
+			indicator: indicators.NewEMA(5),
+			value:     primary,
+			expected:  []float64{none, none, none, none, 44.104, 44.346, 44.5973, 44.8716, 45.1944, 45.4896, 45.6231, 45.7587},
+		},
+		{
+			name:      "WMA",
+			indicator: indicators.NewWMA(5),
+			value:     primary,
+			expected:  []float64{none, none, none, none, 44.0707, 44.3127, 44.612, 44.9507, 45.3447, 45.67, 45.8153, 45.9367},
+		},
+		{
+			name:      "standard deviation",
+			indicator: indicators.NewStdDev(5),
+			value:     primary,
+			expected:  []float64{none, none, none, none, 0.2658, 0.3941, 0.5227, 0.6343, 0.513, 0.4597, 0.3557, 0.2332},
+		},
+		{
+			name:      "z-score",
+			indicator: indicators.NewZScore(5),
+			value:     primary,
+			expected:  []float64{none, none, none, none, 0.8504, 1.5936, 1.3314, 1.2014, 1.4348, 1.3617, 0.6297, 0.7633},
+		},
+		{
+			name:      "Bollinger upper band",
+			indicator: indicators.NewBollinger(5, 2),
+			value:     func(i indicators.Indicator) float64 { return i.(*indicators.Bollinger).Upper() },
+			expected:  []float64{none, none, none, none, 44.6356, 44.9902, 45.4494, 45.9266, 46.1300, 46.3734, 46.3774, 46.3184},
+		},
+		{
+			name:      "Bollinger lower band",
+			indicator: indicators.NewBollinger(5, 2),
+			value:     func(i indicators.Indicator) float64 { return i.(*indicators.Bollinger).Lower() },
+			expected:  []float64{none, none, none, none, 43.5724, 43.4138, 43.3586, 43.3894, 44.0780, 44.5346, 44.9546, 45.3856},
+		},
+	}
+
+	longSeriesCases := []indicatorCase{
+		{
+			name:      "RSI",
+			indicator: indicators.NewRSI(14),
+			value:     primary,
+			expected: []float64{
+				none, none, none, none, none, none, none, none, none, none, none, none, none, none,
+				70.4641, 66.2496, 66.4809, 69.3469, 66.2947, 57.915, 62.8807, 63.2088, 56.0116,
+				62.3399, 54.671, 50.3868, 40.0194, 41.4926, 41.9024, 45.4995, 37.3228, 33.0905, 37.7888,
+			},
+		},
+		{
+			name:      "MACD line",
+			indicator: indicators.NewMACD(3, 6, 3),
+			value:     primary,
+			expected: []float64{
+				none, none, none, none, none, none, none, 0.3582, 0.4138, 0.4259, 0.3287, 0.277,
+				0.129, 0.2013, 0.1983, 0.1089, 0.0679, 0.125, 0.0868, -0.0635,
+			},
+		},
+		{
+			name:      "MACD signal",
+			indicator: indicators.NewMACD(3, 6, 3),
+			value:     func(i indicators.Indicator) float64 { return i.(*indicators.MACD).Signal() },
+			expected: []float64{
+				none, none, none, none, none, none, none, 0.3059, 0.3598, 0.3929, 0.3608, 0.3189,
+				0.2239, 0.2126, 0.2055, 0.1572, 0.1125, 0.1187, 0.1028, 0.0196,
+			},
+		},
+	}
+
+	barCases := []indicatorCase{
+		{
+			name:      "ATR",
+			indicator: indicators.NewATR(3),
+			value:     primary,
+			expected:  []float64{none, none, 1.9333, 1.4556, 1.337, 1.4247, 1.8498, 1.5665, 1.5444, 1.7629},
+		},
+		{
+			name:      "stochastic %K",
+			indicator: indicators.NewStochastic(3, 2),
+			value:     primary,
+			expected:  []float64{none, none, none, 80.0948, 47.1429, 90.0, 0.0, 0.0, 20.0, 91.8519},
+		},
+		{
+			name:      "stochastic %D",
+			indicator: indicators.NewStochastic(3, 2),
+			value:     func(i indicators.Indicator) float64 { return i.(*indicators.Stochastic).D() },
+			expected:  []float64{none, none, none, 74.6785, 63.6188, 68.5714, 45.0, 0.0, 10.0, 55.9259},
+		},
+		{
+			name:      "OBV",
+			indicator: indicators.NewOBV(),
+			value:     primary,
+			expected:  []float64{0, -600, -200, -200, -1100, -900, -1600, -2500, -2800, -2300},
+		},
+		{
+			name:      "VWAP",
+			indicator: indicators.NewVWAP(),
+			value:     primary,
+			expected:  []float64{100.3533, 99.5619, 99.6958, 99.9372, 99.9426, 99.9792, 99.9638, 99.6777, 99.5728, 99.5276},
+		},
+	}
+
+	check := func(c indicatorCase, inputs []api.Bar) {
+		It(fmt.Sprintf("should match reference values for %s", c.name), func() {
+			Expect(inputs).To(HaveLen(len(c.expected)))
+			for i, bar := range inputs {
+				c.indicator.UpdateBar(bar)
+				if c.expected[i] == none {
+					Expect(c.indicator.Ready()).To(BeFalse(), "ready after %d bars", i+1)
+					continue
+				}
+				Expect(c.indicator.Ready()).To(BeTrue(), "not ready after %d bars", i+1)
+				Expect(c.value(c.indicator)).To(BeNumerically("~", c.expected[i], 1e-4), "after %d bars", i+1)
+			}
+		})
+	}
+
+	for _, c := range seriesCases {
+		check(c, closeBars(closes[:12]))
+	}
+	for _, c := range longSeriesCases {
+		check(c, closeBars(closes[:len(c.expected)]))
+	}
+	for _, c := range barCases {
+		check(c, bars())
+	}
+
+	Context("when a new session starts", func() {
+		It("should reset the VWAP", func() {
+			vwap := indicators.NewVWAP()
+			for _, bar := range bars() {
+				vwap.UpdateBar(bar)
+			}
+			vwap.UpdateBar(api.Bar{
+				Start:  time.Date(2021, 1, 5, 14, 30, 0, 0, time.UTC),
+				Close:  101,
+				VWAP:   100.5,
+				Volume: 10,
+			})
+			Expect(vwap.Value()).To(Equal(100.5))
+		})
+	})
+
+	Context("when the series is flat", func() {
+		It("should report no deviation", func() {
+			zScore := indicators.NewZScore(3)
+			for i := 0; i < 5; i++ {
+				zScore.Update(1.1)
+			}
+			Expect(zScore.StdDev()).To(Equal(float64(0)))
+			Expect(zScore.Value()).To(Equal(float64(0)))
+		})
+	})
+
+	Context("when regressing one series on another", func() {
+		It("should match reference values", func() {
+			xs := closes[:10]
+			ys := make([]float64, len(ohlcv))
+			for i, values := range ohlcv {
+				ys[i] = values[3]
+			}
+			expected := [][3]float64{
+				{-0.3094, 113.5844, -0.1359},
+				{0.5446, 75.9554, 0.3069},
+				{-0.4788, 121.3141, -0.3811},
+				{-0.9516, 142.1176, -0.6311},
+				{-1.4795, 165.9045, -0.7339},
+				{-1.0221, 145.6365, -0.4535},
+			}
+
+			regression := indicators.NewRegression(5)
+			for i := range xs {
+				regression.Update(xs[i], ys[i])
+				if i < 4 {
+					Expect(regression.Ready()).To(BeFalse(), "ready after %d pairs", i+1)
+					continue
+				}
+				Expect(regression.Ready()).To(BeTrue(), "not ready after %d pairs", i+1)
+				Expect(regression.Slope()).To(BeNumerically("~", expected[i-4][0], 1e-4), "after %d pairs", i+1)
+				Expect(regression.Intercept()).To(BeNumerically("~", expected[i-4][1], 1e-4), "after %d pairs", i+1)
+				Expect(regression.Correlation()).To(BeNumerically("~", expected[i-4][2], 1e-4), "after %d pairs", i+1)
+			}
+		})
+	})
+})
@@ -0,0 +1,179 @@
+package indicators
+
+import (
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+)
+
+var _ SeriesIndicator = &RSI{}
+var _ SeriesIndicator = &MACD{}
+var _ Indicator = &Stochastic{}
+
+// RSI is Wilder's relative strength index
+type RSI struct {
+	period  int
+	count   int
+	prev    float64
+	avgGain float64
+	avgLoss float64
+}
+
+// NewRSI returns a relative strength index over the given number of changes
+func NewRSI(period int) *RSI {
+	return &RSI{period: period}
+}
+
+// Update implements the function on the SeriesIndicator interface
+func (i *RSI) Update(value float64) {
+	if i.count == 0 {
+		i.prev = value
+		i.count++
+		return
+	}
+
+	change := value - i.prev
+	i.prev = value
+	gain, loss := 0.0, 0.0
+	if change > 0 {
+		gain = change
+	} else {
+		loss = -change
+	}
+
+	changes := i.count
+	i.count++
+	if changes <= i.period {
+		// The first averages are simple averages of the changes
+		i.avgGain += (gain - i.avgGain) / float64(changes)
+		i.avgLoss += (loss - i.avgLoss) / float64(changes)
+		return
+	}
+	i.avgGain = (i.avgGain*float64(i.period-1) + gain) / float64(i.period)
+	i.avgLoss = (i.avgLoss*float64(i.period-1) + loss) / float64(i.period)
+}
+
+// UpdateBar implements the function on the Indicator interface
+func (i *RSI) UpdateBar(bar api.Bar) {
+	i.Update(bar.Close)
+}
+
+// Value implements the function on the Indicator interface
+func (i *RSI) Value() float64 {
+	if i.avgLoss == 0 {
+		if i.avgGain == 0 {
+			return 50
+		}
+		return 100
+	}
+	return 100 - 100/(1+i.avgGain/i.avgLoss)
+}
+
+// Ready implements the function on the Indicator interface
+func (i *RSI) Ready() bool {
+	return i.count > i.period
+}
+
+// MACD is the moving average convergence divergence: the difference
+// between a fast and slow EMA, with a signal EMA of that difference.
+type MACD struct {
+	fast   *EMA
+	slow   *EMA
+	signal *EMA
+}
+
+// NewMACD returns a MACD with the given EMA periods, commonly 12, 26 and 9
+func NewMACD(fastPeriod, slowPeriod, signalPeriod int) *MACD {
+	return &MACD{
+		fast:   NewEMA(fastPeriod),
+		slow:   NewEMA(slowPeriod),
+		signal: NewEMA(signalPeriod),
+	}
+}
+
+// Update implements the function on the SeriesIndicator interface
+func (i *MACD) Update(value float64) {
+	i.fast.Update(value)
+	i.slow.Update(value)
+	if i.slow.Ready() {
+		i.signal.Update(i.Value())
+	}
+}
+
+// UpdateBar implements the function on the Indicator interface
+func (i *MACD) UpdateBar(bar api.Bar) {
+	i.Update(bar.Close)
+}
+
+// Value implements the function on the Indicator interface,
+// returning the MACD line
+func (i *MACD) Value() float64 {
+	return i.fast.Value() - i.slow.Value()
+}
+
+// Signal returns the signal line
+func (i *MACD) Signal() float64 {
+	return i.signal.Value()
+}
+
+// Histogram returns the MACD line less the signal line
+func (i *MACD) Histogram() float64 {
+	return i.Value() - i.Signal()
+}
+
+// Ready implements the function on the Indicator interface
+func (i *MACD) Ready() bool {
+	return i.signal.Ready()
+}
+
+// Stochastic is the stochastic oscillator, where %K places the close
+// within the range of recent bars and %D is a simple average of %K.
+type Stochastic struct {
+	highs *monotonicQueue
+	lows  *monotonicQueue
+	k     float64
+	d     *SMA
+	count int
+	// kPeriod is the number of bars in the %K range
+	kPeriod int
+}
+
+// NewStochastic returns a stochastic oscillator, commonly over 14 and 3 bars
+func NewStochastic(kPeriod, dPeriod int) *Stochastic {
+	return &Stochastic{
+		highs:   newMaxQueue(kPeriod),
+		lows:    newMinQueue(kPeriod),
+		d:       NewSMA(dPeriod),
+		kPeriod: kPeriod,
+	}
+}
+
+// UpdateBar implements the function on the Indicator interface
+func (i *Stochastic) UpdateBar(bar api.Bar) {
+	i.highs.push(bar.High)
+	i.lows.push(bar.Low)
+	i.count++
+
+	highest, lowest := i.highs.best(), i.lows.best()
+	i.k = 50
+	if highest > lowest {
+		i.k = 100 * (bar.Close - lowest) / (highest - lowest)
+	}
+
+	if i.count >= i.kPeriod {
+		i.d.Update(i.k)
+	}
+}
+
+// Value implements the function on the Indicator interface, returning %K
+func (i *Stochastic) Value() float64 {
+	return i.k
+}
+
+// D returns %D
+func (i *Stochastic) D() float64 {
+	return i.d.Value()
+}
+
+// Ready implements the function on the Indicator interface
+func (i *Stochastic) Ready() bool {
+	return i.d.Ready()
+}
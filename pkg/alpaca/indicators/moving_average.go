@@ -0,0 +1,131 @@
+package indicators
+
+import (
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+)
+
+var _ SeriesIndicator = &SMA{}
+var _ SeriesIndicator = &EMA{}
+var _ SeriesIndicator = &WMA{}
+
+// SMA is a simple moving average
+type SMA struct {
+	window *window
+	sum    float64
+}
+
+// NewSMA returns a simple moving average over the given number of values
+func NewSMA(period int) *SMA {
+	return &SMA{window: newWindow(period)}
+}
+
+// Update implements the function on the SeriesIndicator interface
+func (i *SMA) Update(value float64) {
+	evicted, _ := i.window.push(value)
+	i.sum += value - evicted
+}
+
+// UpdateBar implements the function on the Indicator interface
+func (i *SMA) UpdateBar(bar api.Bar) {
+	i.Update(bar.Close)
+}
+
+// Value implements the function on the Indicator interface
+func (i *SMA) Value() float64 {
+	if i.window.count == 0 {
+		return 0
+	}
+	return i.sum / float64(i.window.count)
+}
+
+// Ready implements the function on the Indicator interface
+func (i *SMA) Ready() bool {
+	return i.window.full()
+}
+
+// EMA is an exponential moving average, seeded with the simple
+// average of its first period of values.
+type EMA struct {
+	period int
+	alpha  float64
+	count  int
+	value  float64
+}
+
+// NewEMA returns an exponential moving average with a smoothing
+// factor of 2 / (period + 1)
+func NewEMA(period int) *EMA {
+	return &EMA{period: period, alpha: 2 / (float64(period) + 1)}
+}
+
+// Update implements the function on the SeriesIndicator interface
+func (i *EMA) Update(value float64) {
+	i.count++
+	if i.count <= i.period {
+		// Average the warm-up values, so the first ready value is an SMA
+		i.value += (value - i.value) / float64(i.count)
+		return
+	}
+	i.value += i.alpha * (value - i.value)
+}
+
+// UpdateBar implements the function on the Indicator interface
+func (i *EMA) UpdateBar(bar api.Bar) {
+	i.Update(bar.Close)
+}
+
+// Value implements the function on the Indicator interface
+func (i *EMA) Value() float64 {
+	return i.value
+}
+
+// Ready implements the function on the Indicator interface
+func (i *EMA) Ready() bool {
+	return i.count >= i.period
+}
+
+// WMA is a linearly weighted moving average, where the latest
+// value has a weight of period and the oldest a weight of one.
+type WMA struct {
+	window *window
+	// sum and weightedSum cover the values in the window
+	sum         float64
+	weightedSum float64
+}
+
+// NewWMA returns a weighted moving average over the given number of values
+func NewWMA(period int) *WMA {
+	return &WMA{window: newWindow(period)}
+}
+
+// Update implements the function on the SeriesIndicator interface
+func (i *WMA) Update(value float64) {
+	evicted, full := i.window.push(value)
+	if full {
+		// Every remaining value loses one weight and the oldest drops out
+		i.weightedSum += float64(i.window.count)*value - i.sum
+		i.sum += value - evicted
+		return
+	}
+	i.weightedSum += float64(i.window.count) * value
+	i.sum += value
+}
+
+// UpdateBar implements the function on the Indicator interface
+func (i *WMA) UpdateBar(bar api.Bar) {
+	i.Update(bar.Close)
+}
+
+// Value implements the function on the Indicator interface
+func (i *WMA) Value() float64 {
+	n := float64(i.window.count)
+	if n == 0 {
+		return 0
+	}
+	return i.weightedSum / (n * (n + 1) / 2)
+}
+
+// Ready implements the function on the Indicator interface
+func (i *WMA) Ready() bool {
+	return i.window.full()
+}
@@ -0,0 +1,78 @@
+package indicators
+
+import (
+	"math"
+)
+
+// Regression is a rolling ordinary least squares fit of one series
+// against another, such as the prices of two related stocks. It takes
+// pairs of values rather than bars, so it is not an Indicator.
+type Regression struct {
+	x     *window
+	y     *window
+	sumX  float64
+	sumY  float64
+	sumXX float64
+	sumYY float64
+	sumXY float64
+}
+
+// NewRegression returns a regression over the given number of pairs
+func NewRegression(period int) *Regression {
+	return &Regression{x: newWindow(period), y: newWindow(period)}
+}
+
+// Update adds the next pair of values
+func (i *Regression) Update(x, y float64) {
+	evictedX, _ := i.x.push(x)
+	evictedY, _ := i.y.push(y)
+	i.sumX += x - evictedX
+	i.sumY += y - evictedY
+	i.sumXX += x*x - evictedX*evictedX
+	i.sumYY += y*y - evictedY*evictedY
+	i.sumXY += x*y - evictedX*evictedY
+}
+
+// Slope returns how much y moves for each unit move in x, the hedge
+// ratio when regressing one price on another
+func (i *Regression) Slope() float64 {
+	varianceX := i.covariance(i.sumXX, i.sumX, i.sumX)
+	if varianceX == 0 {
+		return 0
+	}
+	return i.covariance(i.sumXY, i.sumX, i.sumY) / varianceX
+}
+
+// Intercept returns the value of y the fit predicts when x is zero
+func (i *Regression) Intercept() float64 {
+	if i.x.count == 0 {
+		return 0
+	}
+	n := float64(i.x.count)
+	return i.sumY/n - i.Slope()*i.sumX/n
+}
+
+// Correlation returns the correlation between the two series
+func (i *Regression) Correlation() float64 {
+	varianceX := i.covariance(i.sumXX, i.sumX, i.sumX)
+	varianceY := i.covariance(i.sumYY, i.sumY, i.sumY)
+	if varianceX <= 0 || varianceY <= 0 {
+		return 0
+	}
+	return i.covariance(i.sumXY, i.sumX, i.sumY) / math.Sqrt(varianceX*varianceY)
+}
+
+// Ready reports whether the regression has seen enough pairs
+// for its fit to be meaningful
+func (i *Regression) Ready() bool {
+	return i.x.full()
+}
+
+// covariance returns the population covariance from running sums
+func (i *Regression) covariance(sumProducts, sumA, sumB float64) float64 {
+	if i.x.count == 0 {
+		return 0
+	}
+	n := float64(i.x.count)
+	return sumProducts/n - (sumA/n)*(sumB/n)
+}
@@ -0,0 +1,194 @@
+package indicators
+
+import (
+	"math"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+)
+
+var _ SeriesIndicator = &StdDev{}
+var _ SeriesIndicator = &ZScore{}
+var _ SeriesIndicator = &Bollinger{}
+var _ Indicator = &ATR{}
+
+// StdDev is the rolling population standard deviation of a series
+type StdDev struct {
+	window     *window
+	sum        float64
+	sumSquares float64
+}
+
+// NewStdDev returns a standard deviation over the given number of values
+func NewStdDev(period int) *StdDev {
+	return &StdDev{window: newWindow(period)}
+}
+
+// Update implements the function on the SeriesIndicator interface
+func (i *StdDev) Update(value float64) {
+	evicted, _ := i.window.push(value)
+	i.sum += value - evicted
+	i.sumSquares += value*value - evicted*evicted
+}
+
+// UpdateBar implements the function on the Indicator interface
+func (i *StdDev) UpdateBar(bar api.Bar) {
+	i.Update(bar.Close)
+}
+
+// Mean returns the rolling mean of the series
+func (i *StdDev) Mean() float64 {
+	if i.window.count == 0 {
+		return 0
+	}
+	return i.sum / float64(i.window.count)
+}
+
+// Value implements the function on the Indicator interface
+func (i *StdDev) Value() float64 {
+	if i.window.count == 0 {
+		return 0
+	}
+	mean := i.Mean()
+	// Rounding can leave a tiny negative variance for a flat series
+	variance := math.Max(i.sumSquares/float64(i.window.count)-mean*mean, 0)
+	return math.Sqrt(variance)
+}
+
+// Ready implements the function on the Indicator interface
+func (i *StdDev) Ready() bool {
+	return i.window.full()
+}
+
+// ZScore is the number of rolling standard deviations the latest
+// value sits from the rolling mean, including the latest value.
+type ZScore struct {
+	stdDev *StdDev
+	last   float64
+}
+
+// NewZScore returns a z-score over the given number of values
+func NewZScore(period int) *ZScore {
+	return &ZScore{stdDev: NewStdDev(period)}
+}
+
+// Update implements the function on the SeriesIndicator interface
+func (i *ZScore) Update(value float64) {
+	i.stdDev.Update(value)
+	i.last = value
+}
+
+// UpdateBar implements the function on the Indicator interface
+func (i *ZScore) UpdateBar(bar api.Bar) {
+	i.Update(bar.Close)
+}
+
+// Mean returns the rolling mean of the series
+func (i *ZScore) Mean() float64 {
+	return i.stdDev.Mean()
+}
+
+// StdDev returns the rolling standard deviation of the series
+func (i *ZScore) StdDev() float64 {
+	return i.stdDev.Value()
+}
+
+// Value implements the function on the Indicator interface
+func (i *ZScore) Value() float64 {
+	stdDev := i.stdDev.Value()
+	if stdDev == 0 {
+		return 0
+	}
+	return (i.last - i.stdDev.Mean()) / stdDev
+}
+
+// Ready implements the function on the Indicator interface
+func (i *ZScore) Ready() bool {
+	return i.stdDev.Ready()
+}
+
+// Bollinger tracks Bollinger Bands, a number of standard deviations
+// either side of a simple moving average.
+type Bollinger struct {
+	width  float64
+	stdDev *StdDev
+}
+
+// NewBollinger returns Bollinger Bands over the given number of values,
+// with bands the given number of standard deviations from the middle
+func NewBollinger(period int, width float64) *Bollinger {
+	return &Bollinger{width: width, stdDev: NewStdDev(period)}
+}
+
+// Update implements the function on the SeriesIndicator interface
+func (i *Bollinger) Update(value float64) {
+	i.stdDev.Update(value)
+}
+
+// UpdateBar implements the function on the Indicator interface
+func (i *Bollinger) UpdateBar(bar api.Bar) {
+	i.Update(bar.Close)
+}
+
+// Value implements the function on the Indicator interface,
+// returning the middle band
+func (i *Bollinger) Value() float64 {
+	return i.stdDev.Mean()
+}
+
+// Upper returns the upper band
+func (i *Bollinger) Upper() float64 {
+	return i.stdDev.Mean() + i.width*i.stdDev.Value()
+}
+
+// Lower returns the lower band
+func (i *Bollinger) Lower() float64 {
+	return i.stdDev.Mean() - i.width*i.stdDev.Value()
+}
+
+// Ready implements the function on the Indicator interface
+func (i *Bollinger) Ready() bool {
+	return i.stdDev.Ready()
+}
+
+// ATR is Wilder's average true range
+type ATR struct {
+	period    int
+	count     int
+	value     float64
+	prevClose float64
+}
+
+// NewATR returns an average true range over the given number of bars
+func NewATR(period int) *ATR {
+	return &ATR{period: period}
+}
+
+// UpdateBar implements the function on the Indicator interface
+func (i *ATR) UpdateBar(bar api.Bar) {
+	trueRange := bar.High - bar.Low
+	if i.count > 0 {
+		trueRange = math.Max(trueRange, math.Max(
+			math.Abs(bar.High-i.prevClose),
+			math.Abs(bar.Low-i.prevClose),
+		))
+	}
+	i.prevClose = bar.Close
+	i.count++
+
+	if i.count <= i.period {
+		// The first value is a simple average of true ranges
+		i.value += (trueRange - i.value) / float64(i.count)
+		return
+	}
+	i.value = (i.value*float64(i.period-1) + trueRange) / float64(i.period)
+}
+
+// Value implements the function on the Indicator interface
+func (i *ATR) Value() float64 {
+	return i.value
+}
+
+// Ready implements the function on the Indicator interface
+func (i *ATR) Ready() bool {
+	return i.count >= i.period
+}
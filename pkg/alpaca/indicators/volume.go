@@ -0,0 +1,97 @@
+package indicators
+
+import (
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+)
+
+var _ Indicator = &OBV{}
+var _ Indicator = &VWAP{}
+
+// OBV is on-balance volume, a running total of volume that adds
+// on up closes and subtracts on down closes.
+type OBV struct {
+	count     int
+	value     float64
+	prevClose float64
+}
+
+// NewOBV returns an on-balance volume
+func NewOBV() *OBV {
+	return &OBV{}
+}
+
+// UpdateBar implements the function on the Indicator interface
+func (i *OBV) UpdateBar(bar api.Bar) {
+	if i.count > 0 {
+		switch {
+		case bar.Close > i.prevClose:
+			i.value += bar.Volume
+		case bar.Close < i.prevClose:
+			i.value -= bar.Volume
+		}
+	}
+	i.prevClose = bar.Close
+	i.count++
+}
+
+// Value implements the function on the Indicator interface
+func (i *OBV) Value() float64 {
+	return i.value
+}
+
+// Ready implements the function on the Indicator interface
+func (i *OBV) Ready() bool {
+	return i.count > 0
+}
+
+// VWAP is the volume-weighted average price of the trading session,
+// which resets when a bar starts on a new (UTC) day.
+type VWAP struct {
+	session  string
+	notional float64
+	volume   float64
+	last     float64
+}
+
+// NewVWAP returns a session volume-weighted average price
+func NewVWAP() *VWAP {
+	return &VWAP{}
+}
+
+// UpdateBar implements the function on the Indicator interface
+func (i *VWAP) UpdateBar(bar api.Bar) {
+	session := bar.Start.UTC().Format("2006-01-02")
+	if session != i.session {
+		i.Reset()
+		i.session = session
+	}
+
+	// Prefer the bar's own VWAP, falling back to the typical price
+	price := bar.VWAP
+	if price == 0 {
+		price = (bar.High + bar.Low + bar.Close) / 3
+	}
+	i.notional += price * bar.Volume
+	i.volume += bar.Volume
+	i.last = bar.Close
+}
+
+// Reset starts a new session
+func (i *VWAP) Reset() {
+	i.session = ""
+	i.notional = 0
+	i.volume = 0
+}
+
+// Value implements the function on the Indicator interface
+func (i *VWAP) Value() float64 {
+	if i.volume == 0 {
+		return i.last
+	}
+	return i.notional / i.volume
+}
+
+// Ready implements the function on the Indicator interface
+func (i *VWAP) Ready() bool {
+	return i.volume > 0
+}
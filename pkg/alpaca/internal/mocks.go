@@ -2,6 +2,7 @@ package internal
 
 import (
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
@@ -19,7 +20,12 @@ func init() {
 	functions := []string{
 		"CancelAllOrders",
 		"GetAccount",
+		"GetAsset",
 		"GetPosition",
+		"GetCalendar",
+		"GetClock",
+		"ListBars",
+		"ListPositions",
 		"CancelOrder",
 		"ListOrders",
 		"PlaceOrder",
@@ -98,9 +104,33 @@ func (mc *MockAlpacaClient) GetAccount() (*alpaca.Account, error) {
 		return &alpaca.Account{}, obj
 	default:
 		return &alpaca.Account{
-			ID:         "account123",
-			Equity:     decimal.NewFromFloat(1000),
-			Multiplier: "2.00",
+			ID:              "account123",
+			Equity:          decimal.NewFromFloat(1000),
+			Multiplier:      "2.00",
+			ShortingEnabled: true,
+		}, nil
+	}
+}
+
+// GetAsset implements the corresponding function on api.AlpacaClient
+func (mc *MockAlpacaClient) GetAsset(symbol string) (*api.Asset, error) {
+	funcitonName := "GetAsset"
+	obj := getObj(funcitonName)
+	switch obj := obj.(type) {
+	case *api.Asset:
+		return obj, nil
+	case error:
+		return &api.Asset{}, obj
+	default:
+		return &api.Asset{
+			Asset: alpaca.Asset{
+				Symbol:       symbol,
+				Status:       "active",
+				Tradable:     true,
+				Shortable:    true,
+				EasyToBorrow: true,
+			},
+			Fractionable: true,
 		}, nil
 	}
 }
@@ -121,6 +151,62 @@ func (mc *MockAlpacaClient) GetPosition(string) (*alpaca.Position, error) {
 	}
 }
 
+// GetCalendar implements the corresponding function on api.AlpacaClient
+func (mc *MockAlpacaClient) GetCalendar(start, end *string) ([]alpaca.CalendarDay, error) {
+	funcitonName := "GetCalendar"
+	obj := getObj(funcitonName)
+	switch obj := obj.(type) {
+	case []alpaca.CalendarDay:
+		return obj, nil
+	case error:
+		return []alpaca.CalendarDay{}, obj
+	default:
+		return []alpaca.CalendarDay{}, nil
+	}
+}
+
+// GetClock implements the corresponding function on api.AlpacaClient
+func (mc *MockAlpacaClient) GetClock() (*alpaca.Clock, error) {
+	funcitonName := "GetClock"
+	obj := getObj(funcitonName)
+	switch obj := obj.(type) {
+	case *alpaca.Clock:
+		return obj, nil
+	case error:
+		return &alpaca.Clock{}, obj
+	default:
+		return &alpaca.Clock{IsOpen: true}, nil
+	}
+}
+
+// ListBars implements the corresponding function on api.AlpacaClient
+func (mc *MockAlpacaClient) ListBars(symbols []string, opts alpaca.ListBarParams) (map[string][]alpaca.Bar, error) {
+	funcitonName := "ListBars"
+	obj := getObj(funcitonName)
+	switch obj := obj.(type) {
+	case map[string][]alpaca.Bar:
+		return obj, nil
+	case error:
+		return map[string][]alpaca.Bar{}, obj
+	default:
+		return map[string][]alpaca.Bar{}, nil
+	}
+}
+
+// ListPositions implements the corresponding function on api.AlpacaClient
+func (mc *MockAlpacaClient) ListPositions() ([]alpaca.Position, error) {
+	funcitonName := "ListPositions"
+	obj := getObj(funcitonName)
+	switch obj := obj.(type) {
+	case []alpaca.Position:
+		return obj, nil
+	case error:
+		return []alpaca.Position{}, obj
+	default:
+		return []alpaca.Position{}, nil
+	}
+}
+
 // ListOrders implements the corresponding function on api.AlpacaClient
 func (mc *MockAlpacaClient) ListOrders(status *string, until *time.Time, limit *int, nested *bool) ([]alpaca.Order, error) {
 	funcitonName := "ListOrders"
@@ -174,3 +260,238 @@ func NewMockAlgorithm() api.AlpacaAlgorithm {
 func (ma *MockAlgorithm) HandleStreamTrade(context api.StreamTradeContext) {
 	ma.HandleStreamTradeCalled++
 }
+
+// TradesWithIntents implements the function on api.IntentTrader
+func (ma *MockAlgorithm) TradesWithIntents() {}
+
+// MockOrderUpdateAlgorithm mocks an algorithm that follows
+// its orders and records the updates it received
+type MockOrderUpdateAlgorithm struct {
+	MockAlgorithm
+	Updates []alpaca.TradeUpdate
+}
+
+// NewMockOrderUpdateAlgorithm returns a new mock order update algorithm
+func NewMockOrderUpdateAlgorithm() *MockOrderUpdateAlgorithm {
+	return &MockOrderUpdateAlgorithm{}
+}
+
+// OnOrderUpdate implements the function on api.OrderUpdateHandler
+func (ma *MockOrderUpdateAlgorithm) OnOrderUpdate(context api.OrderUpdateContext) {
+	ma.Updates = append(ma.Updates, context.Update)
+}
+
+// MockJournal records journal entries in memory
+type MockJournal struct {
+	Entries []api.JournalEntry
+}
+
+// NewMockJournal returns a new mock journal
+func NewMockJournal() *MockJournal {
+	return &MockJournal{}
+}
+
+// Record implements the function on api.Journal
+func (mj *MockJournal) Record(entry api.JournalEntry) error {
+	mj.Entries = append(mj.Entries, entry)
+	return nil
+}
+
+// MockLifecycleAlgorithm mocks an algorithm that follows
+// the controller's lifecycle and records what it was told
+type MockLifecycleAlgorithm struct {
+	MockAlgorithm
+	Spec       api.HistorySpec
+	StartErr   error
+	Bars       map[string][]api.Bar
+	Quotes     []alpaca.StreamQuote
+	Aggregates []api.Bar
+	Backfilled []api.Bar
+	Accounts   []api.AccountInfo
+	Updates    []alpaca.TradeUpdate
+	Market     []bool
+	Stopped    bool
+
+	// PlaceOrders buys a share at the close of each aggregate,
+	// recording whether each order was placed
+	PlaceOrders bool
+	OrderErrors []error
+}
+
+// NewMockLifecycleAlgorithm returns a new mock lifecycle algorithm
+func NewMockLifecycleAlgorithm(spec api.HistorySpec) *MockLifecycleAlgorithm {
+	return &MockLifecycleAlgorithm{Spec: spec}
+}
+
+// History implements the function on api.StartHandler
+func (ma *MockLifecycleAlgorithm) History() api.HistorySpec {
+	return ma.Spec
+}
+
+// OnStart implements the function on api.StartHandler
+func (ma *MockLifecycleAlgorithm) OnStart(context api.StartContext) error {
+	ma.Bars = context.History
+	return ma.StartErr
+}
+
+// OnQuote implements the function on api.QuoteHandler
+func (ma *MockLifecycleAlgorithm) OnQuote(context api.QuoteContext) {
+	ma.Quotes = append(ma.Quotes, context.Quote)
+}
+
+// OnAggregate implements the function on api.AggregateHandler
+func (ma *MockLifecycleAlgorithm) OnAggregate(context api.AggregateContext) {
+	ma.Aggregates = append(ma.Aggregates, context.Bar)
+	if context.Backfill {
+		ma.Backfilled = append(ma.Backfilled, context.Bar)
+	}
+
+	if ma.PlaceOrders {
+		_, err := context.Router.PlaceLimitOrder(context.Bar.Symbol, alpaca.Buy, decimal.NewFromInt(1), context.Bar.Close)
+		ma.OrderErrors = append(ma.OrderErrors, err)
+	}
+}
+
+// OnAccountUpdate implements the function on api.AccountHandler
+func (ma *MockLifecycleAlgorithm) OnAccountUpdate(context api.AccountContext) {
+	ma.Accounts = append(ma.Accounts, context.Account)
+}
+
+// OnOrderUpdate implements the function on api.OrderUpdateHandler
+func (ma *MockLifecycleAlgorithm) OnOrderUpdate(context api.OrderUpdateContext) {
+	ma.Updates = append(ma.Updates, context.Update)
+}
+
+// OnMarketOpen implements the function on api.MarketHandler
+func (ma *MockLifecycleAlgorithm) OnMarketOpen(context api.MarketContext) {
+	ma.Market = append(ma.Market, true)
+}
+
+// OnMarketClose implements the function on api.MarketHandler
+func (ma *MockLifecycleAlgorithm) OnMarketClose(context api.MarketContext) {
+	ma.Market = append(ma.Market, false)
+}
+
+// OnStop implements the function on api.StopHandler
+func (ma *MockLifecycleAlgorithm) OnStop(context api.StopContext) {
+	ma.Stopped = true
+}
+
+// MockTimerAlgorithm mocks an algorithm that acts on a
+// schedule and records when it was called
+type MockTimerAlgorithm struct {
+	MockAlgorithm
+	Interval time.Duration
+	Ticks    []time.Time
+}
+
+// NewMockTimerAlgorithm returns a new mock timer algorithm
+func NewMockTimerAlgorithm(interval time.Duration) *MockTimerAlgorithm {
+	return &MockTimerAlgorithm{Interval: interval}
+}
+
+// TimerInterval implements the function on api.TimerHandler
+func (ma *MockTimerAlgorithm) TimerInterval() time.Duration {
+	return ma.Interval
+}
+
+// OnTimer implements the function on api.TimerHandler
+func (ma *MockTimerAlgorithm) OnTimer(context api.TimerContext) {
+	ma.Ticks = append(ma.Ticks, context.Now)
+}
+
+// MockBarAlgorithm mocks an algorithm that consumes bars
+// and tracks how many bars it received
+type MockBarAlgorithm struct {
+	MockAlgorithm
+	Spec       api.BarSpec
+	OnBarCalls []api.Bar
+	Backfilled []api.Bar
+}
+
+// NewMockBarAlgorithm returns a new mock bar algorithm
+func NewMockBarAlgorithm(spec api.BarSpec) *MockBarAlgorithm {
+	return &MockBarAlgorithm{Spec: spec}
+}
+
+// BarSpec implements the function on api.BarHandler
+func (ma *MockBarAlgorithm) BarSpec() api.BarSpec {
+	return ma.Spec
+}
+
+// OnBar implements the function on api.BarHandler
+func (ma *MockBarAlgorithm) OnBar(context api.BarContext) {
+	ma.OnBarCalls = append(ma.OnBarCalls, context.Bar)
+	if context.Backfill {
+		ma.Backfilled = append(ma.Backfilled, context.Bar)
+	}
+}
+
+// MockOrderRouter records the intents and orders submitted by an algorithm
+type MockOrderRouter struct {
+	Intents []api.OrderIntent
+	Orders  []alpaca.PlaceOrderRequest
+	Cancels []string
+
+	// Err, when set, refuses every intent and order without recording it
+	Err error
+}
+
+// NewMockOrderRouter returns a new mock order router
+func NewMockOrderRouter() *MockOrderRouter {
+	return &MockOrderRouter{}
+}
+
+// SubmitIntent implements the function on api.OrderRouter. Orders are
+// given sequential IDs, starting from intent1.
+func (mr *MockOrderRouter) SubmitIntent(intent api.OrderIntent) (*alpaca.Order, error) {
+	if mr.Err != nil {
+		return &alpaca.Order{}, mr.Err
+	}
+	mr.Intents = append(mr.Intents, intent)
+	return &alpaca.Order{ID: fmt.Sprintf("intent%d", len(mr.Intents)), Symbol: intent.Symbol}, nil
+}
+
+// SubmitIntents implements the function on api.OrderRouter
+func (mr *MockOrderRouter) SubmitIntents(intents ...api.OrderIntent) ([]*alpaca.Order, error) {
+	if mr.Err != nil {
+		return nil, mr.Err
+	}
+	orders := []*alpaca.Order{}
+	for _, intent := range intents {
+		order, _ := mr.SubmitIntent(intent)
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+// PlaceLimitOrder implements the function on api.OrderRouter. Orders are
+// given sequential IDs, starting from order1.
+func (mr *MockOrderRouter) PlaceLimitOrder(symbol string, side alpaca.Side, quantity decimal.Decimal, limitPrice float64) (*alpaca.Order, error) {
+	if mr.Err != nil {
+		return &alpaca.Order{}, mr.Err
+	}
+	price := decimal.NewFromFloat(limitPrice)
+	mr.Orders = append(mr.Orders, alpaca.PlaceOrderRequest{
+		AssetKey:    &symbol,
+		Qty:         quantity,
+		Side:        side,
+		Type:        alpaca.Limit,
+		LimitPrice:  &price,
+		TimeInForce: alpaca.Day,
+	})
+	return &alpaca.Order{
+		ID:         fmt.Sprintf("order%d", len(mr.Orders)),
+		Symbol:     symbol,
+		Qty:        quantity,
+		Side:       side,
+		Type:       alpaca.Limit,
+		LimitPrice: &price,
+	}, nil
+}
+
+// CancelOrder implements the function on api.OrderRouter
+func (mr *MockOrderRouter) CancelOrder(orderID string) error {
+	mr.Cancels = append(mr.Cancels, orderID)
+	return nil
+}
@@ -0,0 +1,58 @@
+package journal
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+)
+
+var _ api.Journal = &File{}
+var _ api.Journal = Discard{}
+
+// File appends journal entries to a file, one JSON object per line
+type File struct {
+	file    *os.File
+	encoder *json.Encoder
+	mu      sync.Mutex
+}
+
+// NewFile opens a journal at the given path, adding to any entries
+// already recorded there
+func NewFile(path string) (*File, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{
+		file:    file,
+		encoder: json.NewEncoder(file),
+	}, nil
+}
+
+// Record implements the function on the api.Journal interface
+func (j *File) Record(entry api.JournalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entry.Time = entry.Time.UTC()
+	return j.encoder.Encode(entry)
+}
+
+// Close closes the journal file
+func (j *File) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.file.Close()
+}
+
+// Discard is a journal that keeps nothing
+type Discard struct{}
+
+// Record implements the function on the api.Journal interface
+func (Discard) Record(entry api.JournalEntry) error {
+	return nil
+}
@@ -0,0 +1,87 @@
+package journal_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/journal"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/shopspring/decimal"
+)
+
+var _ = Describe("File", func() {
+	var (
+		dir  string
+		path string
+		err  error
+	)
+
+	entry := func(symbol string) api.JournalEntry {
+		return api.JournalEntry{
+			Time:      time.Date(2020, 11, 2, 14, 30, 0, 0, time.UTC),
+			Algorithm: "dca",
+			Symbol:    symbol,
+			Side:      alpaca.Buy,
+			Quantity:  decimal.NewFromFloat(1.5),
+			Price:     100,
+			Notional:  150,
+			OrderID:   "order123",
+		}
+	}
+
+	lines := func() []string {
+		contents, err := ioutil.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		return strings.Split(strings.TrimSpace(string(contents)), "\n")
+	}
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "journal")
+		Expect(err).ToNot(HaveOccurred())
+		path = filepath.Join(dir, "journal.jsonl")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should write one entry per line", func() {
+		file, err := journal.NewFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(file.Record(entry("VTI"))).To(Succeed())
+		Expect(file.Record(entry("BND"))).To(Succeed())
+		Expect(file.Close()).To(Succeed())
+
+		recorded := lines()
+		Expect(recorded).To(HaveLen(2))
+
+		decoded := api.JournalEntry{}
+		Expect(json.Unmarshal([]byte(recorded[1]), &decoded)).To(Succeed())
+		Expect(decoded.Symbol).To(Equal("BND"))
+		Expect(decoded.Side).To(Equal(alpaca.Buy))
+		Expect(decoded.Quantity.Equal(decimal.NewFromFloat(1.5))).To(BeTrue())
+		Expect(decoded.Time).To(Equal(entry("BND").Time))
+	})
+
+	It("should add to an existing journal", func() {
+		for _, symbol := range []string{"VTI", "BND"} {
+			file, err := journal.NewFile(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(file.Record(entry(symbol))).To(Succeed())
+			Expect(file.Close()).To(Succeed())
+		}
+		Expect(lines()).To(HaveLen(2))
+	})
+
+	It("should fail to open a journal in a missing directory", func() {
+		_, err := journal.NewFile(filepath.Join(dir, "missing", "journal.jsonl"))
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -1,11 +1,24 @@
 package main
 
 import (
+	"errors"
+	"time"
+
 	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
 	"github.com/alpacahq/alpaca-trade-api-go/common"
+	"github.com/alpacahq/alpaca-trade-api-go/polygon"
 	"github.com/markliederbach/stonks/pkg/alpaca/algorithm"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/client"
 	"github.com/markliederbach/stonks/pkg/alpaca/config"
 	"github.com/markliederbach/stonks/pkg/alpaca/controller"
+	"github.com/markliederbach/stonks/pkg/alpaca/history"
+	"github.com/markliederbach/stonks/pkg/alpaca/journal"
+	"github.com/markliederbach/stonks/pkg/alpaca/recorder"
+	"github.com/markliederbach/stonks/pkg/alpaca/replay"
+	"github.com/markliederbach/stonks/pkg/alpaca/risk"
+	"github.com/markliederbach/stonks/pkg/alpaca/sizing"
+	"github.com/markliederbach/stonks/pkg/alpaca/streams"
 	"github.com/sirupsen/logrus"
 )
 
@@ -21,22 +34,54 @@ func init() {
 func main() {
 	logrus.Info("Alpaca trader is starting")
 
-	stock := "VTI"
-
-	client := alpaca.NewClient(&common.APIKey{
+	alpacaClient := client.NewResilient(client.NewClient(appConfig.AlpacaAPIBaseURL, &common.APIKey{
 		ID:     appConfig.AlpacaAPIKeyID,
 		Secret: appConfig.AlpacaAPISecretKey,
-	})
+	}), appConfig.RequestsPerMinute)
 
-	martingale, err := algorithm.NewMartingale()
+	tradingAlgorithm, err := algorithm.New(appConfig.Algorithm, appConfig.AlgorithmParams)
 	if err != nil {
 		logrus.Panic(err)
 	}
 
-	alpacaController, err := controller.NewAlpacaController(client, martingale, stock)
+	if appConfig.ReplayDir != "" {
+		replaySession(alpacaClient, tradingAlgorithm)
+		return
+	}
+
+	dataSource, err := streams.ParseDataSource(appConfig.DataSource)
 	if err != nil {
 		logrus.Panic(err)
 	}
+	if err := streams.UseDataSource(dataSource); err != nil {
+		logrus.Panic(err)
+	}
+
+	alpacaController := newController(alpacaClient, tradingAlgorithm)
+	alpacaController.AccountRefresh = appConfig.AccountRefresh
+	alpacaController.StaleAfter = appConfig.StaleAfter
+	alpacaController.GapAfter = appConfig.GapAfter
+	alpacaController.Backfill = newBackfill(alpacaClient, dataSource)
+
+	if appConfig.JournalPath != "" {
+		tradeJournal, err := journal.NewFile(appConfig.JournalPath)
+		if err != nil {
+			logrus.Panic(err)
+		}
+		defer tradeJournal.Close()
+		alpacaController.Journal = tradeJournal
+	}
+
+	if appConfig.RecordDir != "" {
+		eventRecorder, err := recorder.New(appConfig.RecordDir)
+		if err != nil {
+			logrus.Panic(err)
+		}
+		defer eventRecorder.Close()
+		tee := recorder.NewTee(alpacaController.DataStream, alpacaController.OrderStream, eventRecorder)
+		alpacaController.DataStream = tee
+		alpacaController.OrderStream = tee
+	}
 
 	// Does not return unless an error occurred
 	if err := alpacaController.Run(); err != nil {
@@ -44,3 +89,89 @@ func main() {
 	}
 
 }
+
+// newController creates a controller trading through the client, as configured
+func newController(alpacaClient api.AlpacaClient, tradingAlgorithm api.AlpacaAlgorithm) *controller.AlpacaController {
+	alpacaController, err := controller.NewAlpacaController(alpacaClient, tradingAlgorithm, appConfig.Stocks...)
+	if err != nil {
+		logrus.Panic(err)
+	}
+
+	alpacaController.Risk = risk.Limits{
+		MaxPositionValue: appConfig.MaxPositionValue,
+		MaxShortExposure: appConfig.MaxShortExposure,
+	}
+
+	alpacaController.Sizing, err = sizing.ParseSizers(appConfig.Sizing)
+	if err != nil {
+		logrus.Panic(err)
+	}
+
+	alpacaController.Channels, err = streams.ParseChannels(appConfig.Channels)
+	if err != nil {
+		logrus.Panic(err)
+	}
+
+	return alpacaController
+}
+
+// newBackfill returns a loader serving missed market data from the same
+// source as the stream, cached if a history directory is configured
+func newBackfill(alpacaClient api.AlpacaClient, dataSource streams.DataSource) *history.Loader {
+	var store *history.Store
+	if appConfig.HistoryDir != "" {
+		var err error
+		if store, err = history.NewStore(appConfig.HistoryDir); err != nil {
+			logrus.Panic(err)
+		}
+	}
+
+	if dataSource == streams.PolygonSource {
+		return history.NewLoader(store, history.NewPolygonSource(polygon.NewClient(common.Credentials())))
+	}
+	return history.NewLoader(store, history.NewAlpacaSource(alpacaClient))
+}
+
+// replaySession replays a recording against a simulated broker, logging
+// where the orders sent differ from those recorded
+func replaySession(alpacaClient api.AlpacaClient, tradingAlgorithm api.AlpacaAlgorithm) {
+	if appConfig.ReplayFrom.IsZero() {
+		logrus.Panic(errors.New(config.ReplayFromVariable + " is required to replay"))
+	}
+	to := appConfig.ReplayTo
+	if to.IsZero() {
+		to = appConfig.ReplayFrom.Add(24 * time.Hour)
+	}
+
+	session, err := replay.Load(appConfig.ReplayDir, recorder.Filter{
+		From:    appConfig.ReplayFrom,
+		To:      to,
+		Symbols: appConfig.Stocks,
+	}, appConfig.ReplayCash)
+	if err != nil {
+		logrus.Panic(err)
+	}
+	session.Speed = appConfig.ReplaySpeed
+	// History comes from Alpaca, as it was when the session started
+	session.Broker.Data = alpacaClient
+
+	alpacaController := newController(session.Broker, tradingAlgorithm)
+	session.Attach(alpacaController)
+
+	diff, err := session.Play(alpacaController)
+	if err != nil {
+		logrus.Panic(err)
+	}
+
+	for _, order := range diff.Missing {
+		logrus.WithFields(logrus.Fields{"order": order.String()}).Warn("Order sent in production was not replayed")
+	}
+	for _, order := range diff.Extra {
+		logrus.WithFields(logrus.Fields{"order": order.String()}).Warn("Order replayed was not sent in production")
+	}
+	logrus.WithFields(logrus.Fields{
+		"matched": len(diff.Matched),
+		"missing": len(diff.Missing),
+		"extra":   len(diff.Extra),
+	}).Info("Replay complete")
+}
@@ -0,0 +1,135 @@
+package recorder
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// maxRecordSize is the longest line a recording may hold
+const maxRecordSize int = 1024 * 1024
+
+// Filter selects records to read from a recording
+type Filter struct {
+	// From and To bound when records were received, inclusively
+	From time.Time
+	To   time.Time
+	// Symbols limits records to these stocks, or every stock when empty
+	Symbols []string
+}
+
+// Read calls the function with each record in the directory matching
+// the filter, in the order they were received. Only indexed blocks
+// are read, using the index to skip blocks outside the filter.
+func Read(dir string, filter Filter, fn func(Record) error) error {
+	symbols := map[string]bool{}
+	for _, symbol := range filter.Symbols {
+		symbols[symbol] = true
+	}
+
+	from, to := filter.From.UTC(), filter.To.UTC()
+	for day := from.Truncate(24 * time.Hour); !day.After(to); day = day.Add(24 * time.Hour) {
+		blocks, err := ReadIndex(dir, day)
+		if err != nil {
+			return err
+		}
+
+		for _, block := range blocks {
+			if block.End.Before(from) || block.Start.After(to) || !block.matches(symbols) {
+				continue
+			}
+			if err := readBlock(DataPath(dir, day), block, func(record Record) error {
+				if record.Received.Before(from) || record.Received.After(to) {
+					return nil
+				}
+				if len(symbols) > 0 && !symbols[record.Symbol()] {
+					return nil
+				}
+				return fn(record)
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ReadIndex returns the indexed blocks for a day, in the order they
+// were written, or none if nothing was recorded that day
+func ReadIndex(dir string, day time.Time) ([]Block, error) {
+	path := IndexPath(dir, day)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	blocks := []Block{}
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		block := Block{}
+		if err := json.Unmarshal(scanner.Bytes(), &block); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, scanner.Err()
+}
+
+// matches reports whether the block holds any of the symbols,
+// where no symbols matches every block
+func (b Block) matches(symbols map[string]bool) bool {
+	if len(symbols) == 0 {
+		return true
+	}
+	for _, symbol := range b.Symbols {
+		if symbols[symbol] {
+			return true
+		}
+	}
+	return false
+}
+
+// readBlock calls the function with each record in one block
+func readBlock(path string, block Block, fn func(Record) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(block.Offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("%s block at %d: %w", path, block.Offset, err)
+	}
+	defer reader.Close()
+	// Stop at the end of this block's gzip member
+	reader.Multistream(false)
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRecordSize)
+	for scanner.Scan() {
+		record := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("%s block at %d: %w", path, block.Offset, err)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,227 @@
+package recorder
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/streams"
+)
+
+const (
+	// dayFormat names each day's files
+	dayFormat string = "2006-01-02"
+
+	// blockInterval is how long records are compressed together
+	// before a new block is started and indexed
+	blockInterval time.Duration = time.Minute
+
+	// flushInterval is how often buffered records are written out
+	// while events keep arriving
+	flushInterval time.Duration = time.Second
+)
+
+// Record is a stream event as recorded, with the time it was received
+type Record struct {
+	Received time.Time `json:"received"`
+	streams.Event
+}
+
+// Block is an index entry for a run of records compressed together.
+// Each block is a gzip member of its own, so it can be read without
+// reading the blocks before it.
+type Block struct {
+	// Offset is where the block starts in the day's data file
+	Offset  int64     `json:"offset"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Events  int       `json:"events"`
+	Symbols []string  `json:"symbols"`
+}
+
+// Recorder appends stream events to a directory of compressed JSON
+// lines files, one per UTC day, each with an index of its blocks.
+// Files are only ever appended to, so a recorder can resume a day
+// another recorder started. Records in a block that was never
+// closed, such as after a crash, are not indexed.
+type Recorder struct {
+	dir string
+
+	// day is the date of the open files
+	day     string
+	data    *offsetWriter
+	gzip    *gzip.Writer
+	encoder *json.Encoder
+	index   *os.File
+
+	// block is the block being written, or nil between blocks
+	block     *Block
+	symbols   map[string]bool
+	lastFlush time.Time
+
+	mu sync.Mutex
+}
+
+// New returns a recorder writing to the given directory, creating it
+// if it does not exist
+func New(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Recorder{dir: dir}, nil
+}
+
+// DataPath is the data file for a day in a recording directory
+func DataPath(dir string, day time.Time) string {
+	return filepath.Join(dir, day.UTC().Format(dayFormat)+".jsonl.gz")
+}
+
+// IndexPath is the index file for a day in a recording directory
+func IndexPath(dir string, day time.Time) string {
+	return filepath.Join(dir, day.UTC().Format(dayFormat)+".index.jsonl")
+}
+
+// Record appends an event received at the given time
+func (r *Recorder) Record(received time.Time, event streams.Event) error {
+	if _, err := event.Stream(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	received = received.UTC()
+	if day := received.Format(dayFormat); day != r.day {
+		if err := r.rotate(received); err != nil {
+			return err
+		}
+	} else if r.block != nil && received.Sub(r.block.Start) >= blockInterval {
+		if err := r.closeBlock(); err != nil {
+			return err
+		}
+	}
+
+	if r.block == nil {
+		r.block = &Block{Offset: r.data.offset, Start: received}
+		r.symbols = map[string]bool{}
+		r.gzip = gzip.NewWriter(r.data)
+		r.encoder = json.NewEncoder(r.gzip)
+		r.lastFlush = received
+	}
+
+	if err := r.encoder.Encode(Record{Received: received, Event: event}); err != nil {
+		return err
+	}
+
+	if received.After(r.block.End) {
+		r.block.End = received
+	}
+	r.block.Events++
+	if symbol := event.Symbol(); symbol != "" {
+		r.symbols[symbol] = true
+	}
+
+	if received.Sub(r.lastFlush) >= flushInterval {
+		r.lastFlush = received
+		return r.gzip.Flush()
+	}
+	return nil
+}
+
+// Close closes the open block and files
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.closeFiles()
+}
+
+// rotate closes the open files, opening the files for another day
+func (r *Recorder) rotate(day time.Time) error {
+	if err := r.closeFiles(); err != nil {
+		return err
+	}
+
+	data, err := os.OpenFile(DataPath(r.dir, day), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := data.Stat()
+	if err != nil {
+		data.Close()
+		return err
+	}
+
+	index, err := os.OpenFile(IndexPath(r.dir, day), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		data.Close()
+		return err
+	}
+
+	r.day = day.Format(dayFormat)
+	r.data = &offsetWriter{file: data, offset: info.Size()}
+	r.index = index
+	return nil
+}
+
+// closeBlock finishes the open block and adds it to the index
+func (r *Recorder) closeBlock() error {
+	if r.block == nil {
+		return nil
+	}
+
+	if err := r.gzip.Close(); err != nil {
+		return err
+	}
+
+	for symbol := range r.symbols {
+		r.block.Symbols = append(r.block.Symbols, symbol)
+	}
+	sort.Strings(r.block.Symbols)
+
+	line, err := json.Marshal(r.block)
+	if err != nil {
+		return err
+	}
+	r.block = nil
+	if _, err := r.index.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to index block: %w", err)
+	}
+	return nil
+}
+
+// closeFiles closes the open block and the day's files
+func (r *Recorder) closeFiles() error {
+	if r.data == nil {
+		return nil
+	}
+
+	err := r.closeBlock()
+	if closeErr := r.data.file.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := r.index.Close(); err == nil {
+		err = closeErr
+	}
+
+	r.day, r.data, r.index = "", nil, nil
+	return err
+}
+
+// offsetWriter appends to a file, keeping track of where blocks start
+type offsetWriter struct {
+	file   *os.File
+	offset int64
+}
+
+// Write implements the function on the io.Writer interface
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.offset += int64(n)
+	return n, err
+}
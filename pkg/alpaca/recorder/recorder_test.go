@@ -0,0 +1,125 @@
+package recorder_test
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/recorder"
+	"github.com/markliederbach/stonks/pkg/alpaca/streams"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func tradeEvent(symbol string, price float32) streams.Event {
+	return streams.Event{Type: streams.TradeEvent, Trade: &alpaca.StreamTrade{Symbol: symbol, Price: price}}
+}
+
+func readAll(dir string, filter recorder.Filter) []recorder.Record {
+	records := []recorder.Record{}
+	Expect(recorder.Read(dir, filter, func(record recorder.Record) error {
+		records = append(records, record)
+		return nil
+	})).To(Succeed())
+	return records
+}
+
+var _ = Describe("Recorder", func() {
+	var (
+		dir     string
+		rec     *recorder.Recorder
+		opening time.Time
+		err     error
+	)
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "recorder")
+		Expect(err).ToNot(HaveOccurred())
+		rec, err = recorder.New(dir)
+		Expect(err).ToNot(HaveOccurred())
+		opening = time.Date(2020, 11, 2, 14, 30, 0, 0, time.UTC)
+	})
+
+	AfterEach(func() {
+		rec.Close()
+		os.RemoveAll(dir)
+	})
+
+	Context("with events across blocks and days", func() {
+		BeforeEach(func() {
+			Expect(rec.Record(opening, tradeEvent("MKL", 100))).To(Succeed())
+			Expect(rec.Record(opening.Add(time.Second), tradeEvent("VTI", 200))).To(Succeed())
+			Expect(rec.Record(opening.Add(2*time.Minute), tradeEvent("MKL", 101))).To(Succeed())
+			Expect(rec.Record(opening.Add(24*time.Hour), tradeEvent("MKL", 102))).To(Succeed())
+			Expect(rec.Close()).To(Succeed())
+		})
+
+		It("should write a data file and index per day", func() {
+			Expect(recorder.DataPath(dir, opening)).To(BeAnExistingFile())
+			Expect(recorder.DataPath(dir, opening.Add(24*time.Hour))).To(BeAnExistingFile())
+
+			blocks, err := recorder.ReadIndex(dir, opening)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(blocks).To(HaveLen(2))
+			Expect(blocks[0].Offset).To(BeZero())
+			Expect(blocks[0].Start).To(Equal(opening))
+			Expect(blocks[0].End).To(Equal(opening.Add(time.Second)))
+			Expect(blocks[0].Events).To(Equal(2))
+			Expect(blocks[0].Symbols).To(Equal([]string{"MKL", "VTI"}))
+			Expect(blocks[1].Offset).To(BeNumerically(">", 0))
+			Expect(blocks[1].Symbols).To(Equal([]string{"MKL"}))
+		})
+
+		It("should read every record in the range in order", func() {
+			records := readAll(dir, recorder.Filter{From: opening, To: opening.Add(48 * time.Hour)})
+			Expect(records).To(HaveLen(4))
+			Expect(records[0].Received).To(Equal(opening))
+			Expect(records[0].Trade.Price).To(Equal(float32(100)))
+			Expect(records[3].Trade.Price).To(Equal(float32(102)))
+		})
+
+		It("should filter records by symbol", func() {
+			records := readAll(dir, recorder.Filter{From: opening, To: opening.Add(48 * time.Hour), Symbols: []string{"VTI"}})
+			Expect(records).To(HaveLen(1))
+			Expect(records[0].Symbol()).To(Equal("VTI"))
+		})
+
+		It("should filter records by time", func() {
+			records := readAll(dir, recorder.Filter{From: opening.Add(time.Minute), To: opening.Add(time.Hour)})
+			Expect(records).To(HaveLen(1))
+			Expect(records[0].Trade.Price).To(Equal(float32(101)))
+		})
+
+		It("should stop reading when the function fails", func() {
+			calls := 0
+			Expect(recorder.Read(dir, recorder.Filter{From: opening, To: opening.Add(48 * time.Hour)}, func(recorder.Record) error {
+				calls++
+				return os.ErrClosed
+			})).To(MatchError(os.ErrClosed))
+			Expect(calls).To(Equal(1))
+		})
+	})
+
+	It("should resume a day recorded before", func() {
+		Expect(rec.Record(opening, tradeEvent("MKL", 100))).To(Succeed())
+		Expect(rec.Close()).To(Succeed())
+
+		resumed, err := recorder.New(dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resumed.Record(opening.Add(time.Hour), tradeEvent("MKL", 101))).To(Succeed())
+		Expect(resumed.Close()).To(Succeed())
+
+		records := readAll(dir, recorder.Filter{From: opening, To: opening.Add(time.Hour)})
+		Expect(records).To(HaveLen(2))
+		Expect(records[1].Trade.Price).To(Equal(float32(101)))
+	})
+
+	It("should reject events the streams could not carry", func() {
+		Expect(rec.Record(opening, streams.Event{Type: streams.TradeEvent})).To(MatchError("trade event is missing its data"))
+	})
+
+	It("should read nothing from days never recorded", func() {
+		Expect(readAll(dir, recorder.Filter{From: opening, To: opening.Add(time.Hour)})).To(BeEmpty())
+	})
+})
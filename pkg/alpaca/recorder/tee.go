@@ -0,0 +1,107 @@
+package recorder
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/streams"
+	"github.com/sirupsen/logrus"
+)
+
+var _ api.DataStream = &Tee{}
+var _ api.OrderStream = &Tee{}
+var _ api.AccountStream = &Tee{}
+
+// Tee passes on events from other streams, recording each one as it
+// arrives. A failure to record is logged, and the event still passed on.
+type Tee struct {
+	data     api.DataStream
+	orders   api.OrderStream
+	recorder *Recorder
+}
+
+// NewTee returns a new stream recording events from the given streams
+func NewTee(data api.DataStream, orders api.OrderStream, recorder *Recorder) *Tee {
+	return &Tee{
+		data:     data,
+		orders:   orders,
+		recorder: recorder,
+	}
+}
+
+// SubscribeTrades implements the function on the api.DataStream interface
+func (t *Tee) SubscribeTrades(symbol string, handler func(alpaca.StreamTrade)) error {
+	return t.data.SubscribeTrades(symbol, func(trade alpaca.StreamTrade) {
+		t.record(streams.Event{Type: streams.TradeEvent, Trade: &trade})
+		handler(trade)
+	})
+}
+
+// SubscribeQuotes implements the function on the api.DataStream interface
+func (t *Tee) SubscribeQuotes(symbol string, handler func(alpaca.StreamQuote)) error {
+	return t.data.SubscribeQuotes(symbol, func(quote alpaca.StreamQuote) {
+		t.record(streams.Event{Type: streams.QuoteEvent, Quote: &quote})
+		handler(quote)
+	})
+}
+
+// SubscribeAggregates implements the function on the api.DataStream interface
+func (t *Tee) SubscribeAggregates(symbol string, channel api.Channel, handler func(api.Bar)) error {
+	return t.data.SubscribeAggregates(symbol, channel, func(bar api.Bar) {
+		t.record(streams.Event{Type: streams.AggregateEvent, Channel: channel, Aggregate: &bar})
+		handler(bar)
+	})
+}
+
+// Unsubscribe implements the function on the api.DataStream interface
+func (t *Tee) Unsubscribe(symbol string) error {
+	return t.data.Unsubscribe(symbol)
+}
+
+// SubscribeTradeUpdates implements the function on the api.OrderStream interface
+func (t *Tee) SubscribeTradeUpdates(handler func(alpaca.TradeUpdate)) error {
+	return t.orders.SubscribeTradeUpdates(func(update alpaca.TradeUpdate) {
+		t.record(streams.Event{Type: streams.TradeUpdateEvent, TradeUpdate: &update})
+		handler(update)
+	})
+}
+
+// UnsubscribeTradeUpdates implements the function on the api.OrderStream interface
+func (t *Tee) UnsubscribeTradeUpdates() error {
+	return t.orders.UnsubscribeTradeUpdates()
+}
+
+// SubscribeAccountUpdates implements the function on the api.AccountStream
+// interface, failing if the order stream does not deliver account updates
+func (t *Tee) SubscribeAccountUpdates(handler func(api.AccountUpdate)) error {
+	accounts, ok := t.orders.(api.AccountStream)
+	if !ok {
+		return fmt.Errorf("%T does not deliver account updates", t.orders)
+	}
+
+	return accounts.SubscribeAccountUpdates(func(update api.AccountUpdate) {
+		t.record(streams.Event{Type: streams.AccountUpdateEvent, AccountUpdate: &update})
+		handler(update)
+	})
+}
+
+// UnsubscribeAccountUpdates implements the function on the api.AccountStream interface
+func (t *Tee) UnsubscribeAccountUpdates() error {
+	accounts, ok := t.orders.(api.AccountStream)
+	if !ok {
+		return nil
+	}
+	return accounts.UnsubscribeAccountUpdates()
+}
+
+// record records an event received now
+func (t *Tee) record(event streams.Event) {
+	if err := t.recorder.Record(time.Now(), event); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"type":   event.Type,
+			"symbol": event.Symbol(),
+		}).Errorf("Failed to record stream event: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package recorder_test
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/recorder"
+	"github.com/markliederbach/stonks/pkg/alpaca/streams"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tee", func() {
+	var (
+		dir    string
+		rec    *recorder.Recorder
+		memory *streams.Memory
+		tee    *recorder.Tee
+		events []streams.EventType
+		err    error
+	)
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "tee")
+		Expect(err).ToNot(HaveOccurred())
+		rec, err = recorder.New(dir)
+		Expect(err).ToNot(HaveOccurred())
+		memory = streams.NewMemory()
+		tee = recorder.NewTee(memory, memory, rec)
+		events = nil
+
+		Expect(tee.SubscribeTrades("MKL", func(alpaca.StreamTrade) {
+			events = append(events, streams.TradeEvent)
+		})).To(Succeed())
+		Expect(tee.SubscribeQuotes("MKL", func(alpaca.StreamQuote) {
+			events = append(events, streams.QuoteEvent)
+		})).To(Succeed())
+		Expect(tee.SubscribeAggregates("MKL", api.MinuteAggregateChannel, func(api.Bar) {
+			events = append(events, streams.AggregateEvent)
+		})).To(Succeed())
+		Expect(tee.SubscribeTradeUpdates(func(alpaca.TradeUpdate) {
+			events = append(events, streams.TradeUpdateEvent)
+		})).To(Succeed())
+		Expect(tee.SubscribeAccountUpdates(func(api.AccountUpdate) {
+			events = append(events, streams.AccountUpdateEvent)
+		})).To(Succeed())
+	})
+
+	AfterEach(func() {
+		rec.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("should record each event as it passes it on", func() {
+		start := time.Now().UTC()
+		memory.PublishTrade(alpaca.StreamTrade{Symbol: "MKL", Price: 100})
+		memory.PublishQuote(alpaca.StreamQuote{Symbol: "MKL", BidPrice: 99, AskPrice: 101})
+		Expect(memory.PublishAggregate(api.MinuteAggregateChannel, api.Bar{Symbol: "MKL", Close: 100})).To(Succeed())
+		memory.PublishTradeUpdate(alpaca.TradeUpdate{Event: "fill", Order: alpaca.Order{Symbol: "MKL"}})
+		memory.PublishAccountUpdate(api.AccountUpdate{ID: "account123"})
+		Expect(rec.Close()).To(Succeed())
+
+		expected := []streams.EventType{streams.TradeEvent, streams.QuoteEvent, streams.AggregateEvent, streams.TradeUpdateEvent, streams.AccountUpdateEvent}
+		Expect(events).To(Equal(expected))
+
+		recorded := []streams.EventType{}
+		Expect(recorder.Read(dir, recorder.Filter{From: start, To: time.Now()}, func(record recorder.Record) error {
+			recorded = append(recorded, record.Type)
+			return nil
+		})).To(Succeed())
+		Expect(recorded).To(Equal(expected))
+	})
+
+	It("should unsubscribe from the underlying streams", func() {
+		Expect(tee.Unsubscribe("MKL")).To(Succeed())
+		Expect(tee.UnsubscribeTradeUpdates()).To(Succeed())
+		Expect(tee.UnsubscribeAccountUpdates()).To(Succeed())
+		Expect(memory.Subscriptions()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,421 @@
+package replay
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	// Market hours are kept in New York time, wherever we replay
+	_ "time/tzdata"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/shopspring/decimal"
+)
+
+var _ api.AlpacaClient = &Broker{}
+var _ api.OrderStream = &Broker{}
+
+var (
+	// marketOpen and marketClose bound the regular session, in New York
+	marketOpen  = 9*time.Hour + 30*time.Minute
+	marketClose = 16 * time.Hour
+)
+
+// Broker is a simulated Alpaca account for replaying a session. Limit
+// orders rest until a trade prints at or through their price, then
+// fill in full at the limit. Updates to orders are queued, to be sent
+// by Deliver, as Alpaca would send them after the request returns.
+// Requests are refused with errors as Alpaca would refuse them.
+type Broker struct {
+	// Fractionable is whether every asset trades in fractional quantities
+	Fractionable bool
+	// Multiplier is the account's margin multiplier
+	Multiplier float64
+	// Data answers requests for market data, such as the history
+	// algorithms load at start, or returns none when nil
+	Data api.AlpacaClient
+
+	clock     api.Clock
+	newYork   *time.Location
+	cash      float64
+	positions map[string]decimal.Decimal
+	prices    map[string]float64
+
+	// open are the orders resting with the broker, by ID
+	open   map[string]*alpaca.Order
+	placed []Order
+	nextID int
+
+	updates []alpaca.TradeUpdate
+	handler func(alpaca.TradeUpdate)
+
+	mu sync.Mutex
+}
+
+// NewBroker returns a new simulated account holding only cash,
+// telling the time by the given clock
+func NewBroker(clock api.Clock, cash float64) *Broker {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		panic(err)
+	}
+
+	return &Broker{
+		Multiplier: 1,
+		clock:      clock,
+		newYork:    newYork,
+		cash:       cash,
+		positions:  map[string]decimal.Decimal{},
+		prices:     map[string]float64{},
+		open:       map[string]*alpaca.Order{},
+	}
+}
+
+// Placed returns every order placed with the broker, in order
+func (b *Broker) Placed() []Order {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]Order{}, b.placed...)
+}
+
+// Trade fills the resting orders a trade prints at or through,
+// and marks the stock to the trade's price
+func (b *Broker) Trade(trade alpaca.StreamTrade) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.prices[trade.Symbol] = float64(trade.Price)
+	price := decimal.NewFromFloat32(trade.Price)
+
+	for _, id := range b.openIDs() {
+		order := b.open[id]
+		if order.Symbol != trade.Symbol {
+			continue
+		}
+		if order.Side == alpaca.Buy && price.GreaterThan(*order.LimitPrice) {
+			continue
+		}
+		if order.Side == alpaca.Sell && price.LessThan(*order.LimitPrice) {
+			continue
+		}
+		b.fill(order)
+	}
+}
+
+// Deliver sends every queued order update to the subscriber, including
+// any queued while handling them, returning once none are left
+func (b *Broker) Deliver() {
+	for {
+		b.mu.Lock()
+		if len(b.updates) == 0 || b.handler == nil {
+			b.mu.Unlock()
+			return
+		}
+		update, handler := b.updates[0], b.handler
+		b.updates = b.updates[1:]
+		b.mu.Unlock()
+
+		// Call outside the lock, so the handler can place orders
+		handler(update)
+	}
+}
+
+// SubscribeTradeUpdates implements the function on the api.OrderStream interface
+func (b *Broker) SubscribeTradeUpdates(handler func(alpaca.TradeUpdate)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handler = handler
+	return nil
+}
+
+// UnsubscribeTradeUpdates implements the function on the api.OrderStream interface
+func (b *Broker) UnsubscribeTradeUpdates() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handler = nil
+	return nil
+}
+
+// CancelAllOrders implements the function on the api.AlpacaClient interface
+func (b *Broker) CancelAllOrders() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, id := range b.openIDs() {
+		b.cancel(b.open[id])
+	}
+	return nil
+}
+
+// GetAccount implements the function on the api.AlpacaClient interface
+func (b *Broker) GetAccount() (*alpaca.Account, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	longValue, shortValue := 0.0, 0.0
+	for symbol, position := range b.positions {
+		value, _ := position.Mul(decimal.NewFromFloat(b.prices[symbol])).Float64()
+		if value < 0 {
+			shortValue += value
+		} else {
+			longValue += value
+		}
+	}
+
+	equity := decimal.NewFromFloat(b.cash + longValue + shortValue)
+	return &alpaca.Account{
+		ID:               "replay",
+		Status:           "ACTIVE",
+		Currency:         "USD",
+		Cash:             decimal.NewFromFloat(b.cash),
+		ShortingEnabled:  true,
+		BuyingPower:      equity.Mul(decimal.NewFromFloat(b.Multiplier)),
+		Equity:           equity,
+		Multiplier:       strconv.FormatFloat(b.Multiplier, 'f', -1, 64),
+		LongMarketValue:  decimal.NewFromFloat(longValue),
+		ShortMarketValue: decimal.NewFromFloat(shortValue),
+		PortfolioValue:   equity,
+	}, nil
+}
+
+// GetAsset implements the function on the api.AlpacaClient interface.
+// Every asset is tradable and easy to borrow.
+func (b *Broker) GetAsset(symbol string) (*api.Asset, error) {
+	return &api.Asset{
+		Asset: alpaca.Asset{
+			ID:           symbol,
+			Symbol:       symbol,
+			Class:        "us_equity",
+			Status:       "active",
+			Tradable:     true,
+			Marginable:   true,
+			Shortable:    true,
+			EasyToBorrow: true,
+		},
+		Fractionable: b.Fractionable,
+	}, nil
+}
+
+// GetPosition implements the function on the api.AlpacaClient interface
+func (b *Broker) GetPosition(symbol string) (*alpaca.Position, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	position, ok := b.position(symbol)
+	if !ok {
+		return nil, &alpaca.APIError{Code: 40410000, Message: "position does not exist"}
+	}
+	return &position, nil
+}
+
+// GetCalendar implements the function on the api.AlpacaClient interface
+func (b *Broker) GetCalendar(start, end *string) ([]alpaca.CalendarDay, error) {
+	if b.Data == nil {
+		return []alpaca.CalendarDay{}, nil
+	}
+	return b.Data.GetCalendar(start, end)
+}
+
+// GetClock implements the function on the api.AlpacaClient interface.
+// The market is open for the regular session on weekdays, ignoring holidays.
+func (b *Broker) GetClock() (*alpaca.Clock, error) {
+	now := b.clock.Now()
+	local := now.In(b.newYork)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, b.newYork)
+
+	clock := &alpaca.Clock{Timestamp: now}
+	open, close := day.Add(marketOpen), day.Add(marketClose)
+	if isWeekday(day) && !local.Before(open) && local.Before(close) {
+		clock.IsOpen = true
+		clock.NextClose = close.UTC()
+	}
+
+	for !isWeekday(day) || !local.Before(day.Add(marketOpen)) {
+		day = day.AddDate(0, 0, 1)
+	}
+	clock.NextOpen = day.Add(marketOpen).UTC()
+	if !clock.IsOpen {
+		clock.NextClose = day.Add(marketClose).UTC()
+	}
+	return clock, nil
+}
+
+// ListBars implements the function on the api.AlpacaClient interface
+func (b *Broker) ListBars(symbols []string, opts alpaca.ListBarParams) (map[string][]alpaca.Bar, error) {
+	if b.Data == nil {
+		return map[string][]alpaca.Bar{}, nil
+	}
+	return b.Data.ListBars(symbols, opts)
+}
+
+// ListPositions implements the function on the api.AlpacaClient interface
+func (b *Broker) ListPositions() ([]alpaca.Position, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	positions := []alpaca.Position{}
+	for symbol := range b.positions {
+		if position, ok := b.position(symbol); ok {
+			positions = append(positions, position)
+		}
+	}
+	return positions, nil
+}
+
+// CancelOrder implements the function on the api.AlpacaClient interface
+func (b *Broker) CancelOrder(orderID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	order, ok := b.open[orderID]
+	if !ok {
+		return &alpaca.APIError{Code: 42210000, Message: fmt.Sprintf("order %s is not open", orderID)}
+	}
+	b.cancel(order)
+	return nil
+}
+
+// ListOrders implements the function on the api.AlpacaClient interface,
+// listing the orders still open
+func (b *Broker) ListOrders(status *string, until *time.Time, limit *int, nested *bool) ([]alpaca.Order, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	orders := []alpaca.Order{}
+	for _, id := range b.openIDs() {
+		if limit != nil && len(orders) == *limit {
+			break
+		}
+		orders = append(orders, *b.open[id])
+	}
+	return orders, nil
+}
+
+// PlaceOrder implements the function on the api.AlpacaClient interface.
+// Only limit orders are supported.
+func (b *Broker) PlaceOrder(req alpaca.PlaceOrderRequest) (*alpaca.Order, error) {
+	if req.AssetKey == nil {
+		return nil, &alpaca.APIError{Code: 42210000, Message: "order is missing its symbol"}
+	}
+	if req.Type != alpaca.Limit || req.LimitPrice == nil {
+		return nil, &alpaca.APIError{Code: 42210000, Message: fmt.Sprintf("%s orders are not supported in replay", req.Type)}
+	}
+	if !req.Qty.IsPositive() {
+		return nil, &alpaca.APIError{Code: 42210000, Message: fmt.Sprintf("order quantity must be positive, got %s", req.Qty)}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	b.nextID++
+	limitPrice := *req.LimitPrice
+	order := &alpaca.Order{
+		ID:          fmt.Sprintf("replay-%d", b.nextID),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		SubmittedAt: now,
+		AssetID:     *req.AssetKey,
+		Symbol:      *req.AssetKey,
+		Class:       "us_equity",
+		Qty:         req.Qty,
+		FilledQty:   decimal.Zero,
+		Type:        req.Type,
+		Side:        req.Side,
+		TimeInForce: req.TimeInForce,
+		LimitPrice:  &limitPrice,
+		Status:      "new",
+	}
+
+	b.open[order.ID] = order
+	b.placed = append(b.placed, orderOf(now, *order))
+	b.queue("new", order)
+
+	placed := *order
+	return &placed, nil
+}
+
+// fill fills an open order in full at its limit price
+func (b *Broker) fill(order *alpaca.Order) {
+	now := b.clock.Now()
+	quantity := order.Qty
+	if order.Side == alpaca.Sell {
+		quantity = quantity.Neg()
+	}
+
+	b.positions[order.Symbol] = b.positions[order.Symbol].Add(quantity)
+	cost, _ := quantity.Mul(*order.LimitPrice).Float64()
+	b.cash -= cost
+
+	price := *order.LimitPrice
+	order.FilledQty = order.Qty
+	order.FilledAvgPrice = &price
+	order.FilledAt = &now
+	order.UpdatedAt = now
+	order.Status = "filled"
+
+	delete(b.open, order.ID)
+	b.queue("fill", order)
+}
+
+// cancel cancels an open order
+func (b *Broker) cancel(order *alpaca.Order) {
+	now := b.clock.Now()
+	order.CanceledAt = &now
+	order.UpdatedAt = now
+	order.Status = "canceled"
+
+	delete(b.open, order.ID)
+	b.queue("canceled", order)
+}
+
+// queue queues an update carrying the order as it is now
+func (b *Broker) queue(event string, order *alpaca.Order) {
+	b.updates = append(b.updates, alpaca.TradeUpdate{Event: event, Order: *order})
+}
+
+// position returns our position in a stock, if we hold one
+func (b *Broker) position(symbol string) (alpaca.Position, bool) {
+	quantity := b.positions[symbol]
+	if quantity.IsZero() {
+		return alpaca.Position{}, false
+	}
+
+	side := "long"
+	if quantity.IsNegative() {
+		side = "short"
+	}
+
+	price := decimal.NewFromFloat(b.prices[symbol])
+	return alpaca.Position{
+		AssetID:      symbol,
+		Symbol:       symbol,
+		Class:        "us_equity",
+		AccountID:    "replay",
+		Qty:          quantity.Abs(),
+		Side:         side,
+		MarketValue:  quantity.Mul(price),
+		CurrentPrice: price,
+	}, true
+}
+
+// openIDs lists the open orders in the order they were placed
+func (b *Broker) openIDs() []string {
+	ids := []string{}
+	for _, order := range b.placed {
+		if _, ok := b.open[order.ID]; ok {
+			ids = append(ids, order.ID)
+		}
+	}
+	return ids
+}
+
+// isWeekday reports whether a day is Monday to Friday
+func isWeekday(day time.Time) bool {
+	return day.Weekday() != time.Saturday && day.Weekday() != time.Sunday
+}
@@ -0,0 +1,141 @@
+package replay_test
+
+import (
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/apierrors"
+	"github.com/markliederbach/stonks/pkg/alpaca/clock"
+	"github.com/markliederbach/stonks/pkg/alpaca/replay"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/shopspring/decimal"
+)
+
+func limitOrder(symbol string, side alpaca.Side, qty int64, price float64) alpaca.PlaceOrderRequest {
+	limitPrice := decimal.NewFromFloat(price)
+	return alpaca.PlaceOrderRequest{
+		AssetKey:    &symbol,
+		Qty:         decimal.NewFromInt(qty),
+		Side:        side,
+		Type:        alpaca.Limit,
+		LimitPrice:  &limitPrice,
+		TimeInForce: alpaca.Day,
+	}
+}
+
+var _ = Describe("Broker", func() {
+	var (
+		fake    *clock.Fake
+		broker  *replay.Broker
+		updates []string
+	)
+
+	BeforeEach(func() {
+		// Monday, half an hour into the regular session
+		fake = clock.NewFake(time.Date(2020, 11, 2, 15, 0, 0, 0, time.UTC))
+		broker = replay.NewBroker(fake, 10000)
+		updates = nil
+		Expect(broker.SubscribeTradeUpdates(func(update alpaca.TradeUpdate) {
+			updates = append(updates, update.Event+" "+update.Order.ID)
+		})).To(Succeed())
+	})
+
+	It("should start with only cash", func() {
+		account, err := broker.GetAccount()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(account.Equity.Equal(decimal.NewFromInt(10000))).To(BeTrue())
+		Expect(account.Multiplier).To(Equal("1"))
+
+		_, err = broker.GetPosition("MKL")
+		Expect(err).To(MatchError("position does not exist"))
+		Expect(apierrors.Is(err, apierrors.NotFound)).To(BeTrue())
+	})
+
+	It("should queue updates until delivered", func() {
+		order, err := broker.PlaceOrder(limitOrder("MKL", alpaca.Buy, 10, 100))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(order.ID).To(Equal("replay-1"))
+		Expect(updates).To(BeEmpty())
+
+		broker.Deliver()
+		Expect(updates).To(Equal([]string{"new replay-1"}))
+	})
+
+	It("should fill a buy when a trade prints at or below its limit", func() {
+		_, err := broker.PlaceOrder(limitOrder("MKL", alpaca.Buy, 10, 100))
+		Expect(err).ToNot(HaveOccurred())
+
+		broker.Trade(alpaca.StreamTrade{Symbol: "MKL", Price: 100.5})
+		broker.Trade(alpaca.StreamTrade{Symbol: "VTI", Price: 99})
+		broker.Deliver()
+		Expect(updates).To(Equal([]string{"new replay-1"}))
+
+		broker.Trade(alpaca.StreamTrade{Symbol: "MKL", Price: 100})
+		broker.Deliver()
+		Expect(updates).To(Equal([]string{"new replay-1", "fill replay-1"}))
+
+		position, err := broker.GetPosition("MKL")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(position.Qty.Equal(decimal.NewFromInt(10))).To(BeTrue())
+		Expect(position.Side).To(Equal("long"))
+
+		account, err := broker.GetAccount()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(account.Cash.Equal(decimal.NewFromInt(9000))).To(BeTrue())
+		Expect(account.Equity.Equal(decimal.NewFromInt(10000))).To(BeTrue())
+	})
+
+	It("should open a short when a sell fills", func() {
+		_, err := broker.PlaceOrder(limitOrder("MKL", alpaca.Sell, 5, 100))
+		Expect(err).ToNot(HaveOccurred())
+		broker.Trade(alpaca.StreamTrade{Symbol: "MKL", Price: 101})
+
+		position, err := broker.GetPosition("MKL")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(position.Qty.Equal(decimal.NewFromInt(5))).To(BeTrue())
+		Expect(position.Side).To(Equal("short"))
+
+		account, err := broker.GetAccount()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(account.ShortMarketValue.Equal(decimal.NewFromInt(-505))).To(BeTrue())
+	})
+
+	It("should cancel open orders", func() {
+		_, err := broker.PlaceOrder(limitOrder("MKL", alpaca.Buy, 10, 100))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(broker.CancelOrder("replay-1")).To(Succeed())
+		Expect(broker.CancelOrder("replay-1")).To(MatchError("order replay-1 is not open"))
+
+		broker.Trade(alpaca.StreamTrade{Symbol: "MKL", Price: 90})
+		broker.Deliver()
+		Expect(updates).To(Equal([]string{"new replay-1", "canceled replay-1"}))
+
+		orders, err := broker.ListOrders(nil, nil, nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(orders).To(BeEmpty())
+	})
+
+	It("should reject orders other than limit orders", func() {
+		req := limitOrder("MKL", alpaca.Buy, 10, 100)
+		req.Type = alpaca.Market
+		_, err := broker.PlaceOrder(req)
+		Expect(err).To(MatchError("market orders are not supported in replay"))
+	})
+
+	It("should open the market for the regular session", func() {
+		marketClock, err := broker.GetClock()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(marketClock.IsOpen).To(BeTrue())
+		Expect(marketClock.NextClose).To(Equal(time.Date(2020, 11, 2, 21, 0, 0, 0, time.UTC)))
+		Expect(marketClock.NextOpen).To(Equal(time.Date(2020, 11, 3, 14, 30, 0, 0, time.UTC)))
+
+		// Friday evening, after the close
+		fake.Set(time.Date(2020, 11, 6, 22, 0, 0, 0, time.UTC))
+		marketClock, err = broker.GetClock()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(marketClock.IsOpen).To(BeFalse())
+		Expect(marketClock.NextOpen).To(Equal(time.Date(2020, 11, 9, 14, 30, 0, 0, time.UTC)))
+		Expect(marketClock.NextClose).To(Equal(time.Date(2020, 11, 9, 21, 0, 0, 0, time.UTC)))
+	})
+})
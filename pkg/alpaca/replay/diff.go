@@ -0,0 +1,146 @@
+package replay
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/recorder"
+	"github.com/markliederbach/stonks/pkg/alpaca/streams"
+	"github.com/shopspring/decimal"
+)
+
+// priceTolerance is how close limit prices must be to match,
+// allowing for prices that passed through a float32
+const priceTolerance float64 = 1e-4
+
+// Order is an order as it was sent, for comparing a replay with
+// the session it replays
+type Order struct {
+	Time       time.Time       `json:"time"`
+	ID         string          `json:"id"`
+	Symbol     string          `json:"symbol"`
+	Side       alpaca.Side     `json:"side"`
+	Qty        decimal.Decimal `json:"qty"`
+	LimitPrice float64         `json:"limit_price"`
+}
+
+// String describes the order on one line
+func (o Order) String() string {
+	return fmt.Sprintf("%s %s %s %s @ %v (%s)", o.Time.Format(time.RFC3339), o.Side, o.Qty, o.Symbol, o.LimitPrice, o.ID)
+}
+
+// orderOf converts an order sent at the given time
+func orderOf(at time.Time, order alpaca.Order) Order {
+	converted := Order{
+		Time:   at.UTC(),
+		ID:     order.ID,
+		Symbol: order.Symbol,
+		Side:   order.Side,
+		Qty:    order.Qty,
+	}
+	if order.LimitPrice != nil {
+		converted.LimitPrice, _ = order.LimitPrice.Float64()
+	}
+	return converted
+}
+
+// RecordedOrders returns the orders sent during a recorded session,
+// from the updates to them, in the order they were sent. Each is
+// timed by when Alpaca says it was submitted, or when its first
+// update arrived if Alpaca did not say.
+func RecordedOrders(records []recorder.Record) []Order {
+	orders := []Order{}
+	seen := map[string]bool{}
+	for _, record := range records {
+		if record.Type != streams.TradeUpdateEvent || record.TradeUpdate == nil {
+			continue
+		}
+
+		order := record.TradeUpdate.Order
+		if seen[order.ID] {
+			continue
+		}
+		seen[order.ID] = true
+
+		at := order.SubmittedAt
+		if at.IsZero() {
+			at = record.Received
+		}
+		orders = append(orders, orderOf(at, order))
+	}
+
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].Time.Before(orders[j].Time)
+	})
+	return orders
+}
+
+// Match pairs an order sent in production with the same order
+// sent in the replay
+type Match struct {
+	Production Order `json:"production"`
+	Replay     Order `json:"replay"`
+}
+
+// Diff compares the orders sent in production with those sent
+// when replaying the same session
+type Diff struct {
+	Matched []Match `json:"matched"`
+	// Missing were sent in production, but not in the replay
+	Missing []Order `json:"missing"`
+	// Extra were sent in the replay, but not in production
+	Extra []Order `json:"extra"`
+}
+
+// Empty reports whether the replay sent the same orders as production
+func (d Diff) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Extra) == 0
+}
+
+// Compare pairs each order sent in the replay with the earliest
+// unpaired production order for the same symbol, side, quantity and
+// limit price sent within the tolerance of it. Both lists must be in
+// the order the orders were sent.
+func Compare(production, replayed []Order, tolerance time.Duration) Diff {
+	diff := Diff{Matched: []Match{}, Missing: []Order{}, Extra: []Order{}}
+	paired := make([]bool, len(production))
+
+	for _, replay := range replayed {
+		match := -1
+		for i, sent := range production {
+			if paired[i] || !sameOrder(sent, replay) {
+				continue
+			}
+			if gap := replay.Time.Sub(sent.Time); gap > tolerance || gap < -tolerance {
+				continue
+			}
+			match = i
+			break
+		}
+
+		if match < 0 {
+			diff.Extra = append(diff.Extra, replay)
+			continue
+		}
+		paired[match] = true
+		diff.Matched = append(diff.Matched, Match{Production: production[match], Replay: replay})
+	}
+
+	for i, sent := range production {
+		if !paired[i] {
+			diff.Missing = append(diff.Missing, sent)
+		}
+	}
+	return diff
+}
+
+// sameOrder reports whether two orders ask for the same trade
+func sameOrder(a, b Order) bool {
+	return a.Symbol == b.Symbol &&
+		a.Side == b.Side &&
+		a.Qty.Equal(b.Qty) &&
+		math.Abs(a.LimitPrice-b.LimitPrice) < priceTolerance
+}
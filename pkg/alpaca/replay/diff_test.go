@@ -0,0 +1,61 @@
+package replay_test
+
+import (
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/replay"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/shopspring/decimal"
+)
+
+var _ = Describe("Diff", func() {
+	var start time.Time
+
+	order := func(id string, offset time.Duration, price float64) replay.Order {
+		return replay.Order{
+			Time:       start.Add(offset),
+			ID:         id,
+			Symbol:     "MKL",
+			Side:       alpaca.Buy,
+			Qty:        decimal.NewFromInt(1),
+			LimitPrice: price,
+		}
+	}
+
+	BeforeEach(func() {
+		start = time.Date(2020, 11, 2, 15, 0, 0, 0, time.UTC)
+	})
+
+	It("should match the same orders sent within the tolerance", func() {
+		diff := replay.Compare(
+			[]replay.Order{order("prod-1", 0, 100), order("prod-2", time.Minute, 100)},
+			[]replay.Order{order("replay-1", 500*time.Millisecond, 100), order("replay-2", time.Minute, 100)},
+			time.Second,
+		)
+		Expect(diff.Empty()).To(BeTrue())
+		Expect(diff.Matched[0].Production.ID).To(Equal("prod-1"))
+		Expect(diff.Matched[1].Production.ID).To(Equal("prod-2"))
+	})
+
+	It("should not match orders sent too far apart", func() {
+		diff := replay.Compare(
+			[]replay.Order{order("prod-1", 0, 100)},
+			[]replay.Order{order("replay-1", 2*time.Second, 100)},
+			time.Second,
+		)
+		Expect(diff.Matched).To(BeEmpty())
+		Expect(diff.Missing[0].ID).To(Equal("prod-1"))
+		Expect(diff.Extra[0].ID).To(Equal("replay-1"))
+	})
+
+	It("should not match orders at different prices", func() {
+		diff := replay.Compare(
+			[]replay.Order{order("prod-1", 0, 100)},
+			[]replay.Order{order("replay-1", 0, 100.5)},
+			time.Second,
+		)
+		Expect(diff.Empty()).To(BeFalse())
+	})
+})
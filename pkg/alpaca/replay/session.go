@@ -0,0 +1,139 @@
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/clock"
+	"github.com/markliederbach/stonks/pkg/alpaca/controller"
+	"github.com/markliederbach/stonks/pkg/alpaca/recorder"
+	"github.com/markliederbach/stonks/pkg/alpaca/streams"
+)
+
+const (
+	// DefaultTolerance is how far apart a replayed order and the
+	// production order it matches may be sent, by default
+	DefaultTolerance time.Duration = time.Second
+)
+
+// Session replays a recorded session through a controller, on a fake
+// clock set to when each event was received, trading with a simulated
+// broker. Each event is handled in full before the next is played,
+// so replaying the same recording sends the same orders every time.
+type Session struct {
+	Clock  *clock.Fake
+	Broker *Broker
+	Stream *streams.Memory
+
+	// Speed is how many times faster than real time to play the
+	// session, or zero to play it as fast as possible
+	Speed float64
+	// Tolerance is how far apart in time a replayed order may be
+	// from the production order it matches
+	Tolerance time.Duration
+
+	records []recorder.Record
+}
+
+// NewSession returns a session replaying the records, in the order
+// they were received, with a broker holding the given cash
+func NewSession(records []recorder.Record, cash float64) (*Session, error) {
+	if len(records) == 0 {
+		return nil, errors.New("nothing was recorded to replay")
+	}
+
+	fake := clock.NewFake(records[0].Received)
+	return &Session{
+		Clock:     fake,
+		Broker:    NewBroker(fake, cash),
+		Stream:    streams.NewMemory(),
+		Tolerance: DefaultTolerance,
+		records:   records,
+	}, nil
+}
+
+// Load returns a session replaying the records in a recording
+// directory that match the filter
+func Load(dir string, filter recorder.Filter, cash float64) (*Session, error) {
+	records := []recorder.Record{}
+	if err := recorder.Read(dir, filter, func(record recorder.Record) error {
+		records = append(records, record)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return NewSession(records, cash)
+}
+
+// Attach points a controller at the session's clock and streams. The
+// controller should trade with the session's broker as its client.
+func (s *Session) Attach(c *controller.AlpacaController) {
+	c.Clock = s.Clock
+	c.DataStream = s.Stream
+	c.OrderStream = s.Broker
+}
+
+// Play runs the attached controller through every recorded event, then
+// stops it, comparing the orders it sent with those recorded. Recorded
+// updates to production orders are compared, not played, as the
+// broker sends the controller updates to its own.
+func (s *Session) Play(c *controller.AlpacaController) (Diff, error) {
+	if s.Speed < 0 {
+		return Diff{}, fmt.Errorf("replay speed must not be negative, got %v", s.Speed)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run()
+	}()
+
+	select {
+	case <-c.Running():
+	case err := <-done:
+		return Diff{}, err
+	}
+
+	err := s.play()
+	c.Stop()
+	if runErr := <-done; err == nil {
+		err = runErr
+	}
+	if err != nil {
+		return Diff{}, err
+	}
+
+	return Compare(RecordedOrders(s.records), s.Broker.Placed(), s.Tolerance), nil
+}
+
+// play plays each event in turn, at the session's speed
+func (s *Session) play() error {
+	start, first := time.Now(), s.records[0].Received
+	for _, record := range s.records {
+		if s.Speed > 0 {
+			due := start.Add(time.Duration(float64(record.Received.Sub(first)) / s.Speed))
+			time.Sleep(time.Until(due))
+		}
+
+		// Anything scheduled before the event happens first
+		s.Clock.Set(record.Received)
+		s.Broker.Deliver()
+
+		switch record.Type {
+		case streams.TradeUpdateEvent, streams.AccountUpdateEvent:
+			// The broker reports on orders and the account itself
+			continue
+		case streams.TradeEvent:
+			if record.Trade != nil {
+				s.Broker.Trade(*record.Trade)
+				s.Broker.Deliver()
+			}
+		}
+
+		if err := s.Stream.Publish(record.Event); err != nil {
+			return fmt.Errorf("failed to replay event received at %s: %w", record.Received.Format(time.RFC3339Nano), err)
+		}
+		s.Broker.Deliver()
+	}
+	return nil
+}
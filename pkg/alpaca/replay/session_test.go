@@ -0,0 +1,112 @@
+package replay_test
+
+import (
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/controller"
+	"github.com/markliederbach/stonks/pkg/alpaca/recorder"
+	"github.com/markliederbach/stonks/pkg/alpaca/replay"
+	"github.com/markliederbach/stonks/pkg/alpaca/streams"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/shopspring/decimal"
+)
+
+// thresholdAlgorithm buys ten shares at or below 100, and sells them at or above 105
+type thresholdAlgorithm struct{}
+
+func (thresholdAlgorithm) HandleStreamTrade(context api.StreamTradeContext) {
+	price := float64(context.Trade.Price)
+	switch {
+	case context.Stock.Position.IsZero() && price <= 100:
+		_, _ = context.Router.SubmitIntent(api.TargetIntent(context.Stock.Symbol, decimal.NewFromInt(10), price))
+	case context.Stock.Position.IsPositive() && price >= 105:
+		_, _ = context.Router.SubmitIntent(api.TargetIntent(context.Stock.Symbol, decimal.Zero, price))
+	}
+}
+
+func tradeRecord(at time.Time, price float32) recorder.Record {
+	return recorder.Record{
+		Received: at,
+		Event:    streams.Event{Type: streams.TradeEvent, Trade: &alpaca.StreamTrade{Symbol: "MKL", Price: price}},
+	}
+}
+
+func orderRecord(at time.Time, id string, side alpaca.Side, price float64) recorder.Record {
+	limitPrice := decimal.NewFromFloat(price)
+	return recorder.Record{
+		Received: at.Add(100 * time.Millisecond),
+		Event: streams.Event{Type: streams.TradeUpdateEvent, TradeUpdate: &alpaca.TradeUpdate{
+			Event: "new",
+			Order: alpaca.Order{
+				ID:          id,
+				Symbol:      "MKL",
+				Side:        side,
+				Qty:         decimal.NewFromInt(10),
+				LimitPrice:  &limitPrice,
+				SubmittedAt: at,
+			},
+		}},
+	}
+}
+
+var _ = Describe("Session", func() {
+	var (
+		start   time.Time
+		records []recorder.Record
+	)
+
+	BeforeEach(func() {
+		start = time.Date(2020, 11, 2, 15, 0, 0, 0, time.UTC)
+		records = []recorder.Record{
+			tradeRecord(start, 101),
+			tradeRecord(start.Add(time.Second), 100),
+			orderRecord(start.Add(time.Second), "prod-1", alpaca.Buy, 100),
+			tradeRecord(start.Add(3*time.Second), 99.5),
+			tradeRecord(start.Add(4*time.Second), 105),
+			orderRecord(start.Add(4*time.Second), "prod-2", alpaca.Sell, 106),
+		}
+	})
+
+	play := func() (replay.Diff, []replay.Order) {
+		session, err := replay.NewSession(records, 10000)
+		Expect(err).ToNot(HaveOccurred())
+
+		alpacaController, err := controller.NewAlpacaController(session.Broker, thresholdAlgorithm{}, "MKL")
+		Expect(err).ToNot(HaveOccurred())
+		session.Attach(alpacaController)
+
+		diff, err := session.Play(alpacaController)
+		Expect(err).ToNot(HaveOccurred())
+		return diff, session.Broker.Placed()
+	}
+
+	It("should compare the orders replayed with those recorded", func() {
+		diff, placed := play()
+		Expect(placed).To(HaveLen(2))
+		Expect(placed[0].Time).To(Equal(start.Add(time.Second)))
+		Expect(placed[1].Time).To(Equal(start.Add(4 * time.Second)))
+
+		Expect(diff.Empty()).To(BeFalse())
+		Expect(diff.Matched).To(HaveLen(1))
+		Expect(diff.Matched[0].Production.ID).To(Equal("prod-1"))
+		Expect(diff.Matched[0].Replay.ID).To(Equal("replay-1"))
+		Expect(diff.Missing).To(HaveLen(1))
+		Expect(diff.Missing[0].ID).To(Equal("prod-2"))
+		Expect(diff.Extra).To(HaveLen(1))
+		Expect(diff.Extra[0].LimitPrice).To(Equal(float64(105)))
+	})
+
+	It("should send the same orders every time", func() {
+		_, first := play()
+		_, second := play()
+		Expect(second).To(Equal(first))
+	})
+
+	It("should refuse to replay nothing", func() {
+		_, err := replay.NewSession(nil, 10000)
+		Expect(err).To(MatchError("nothing was recorded to replay"))
+	})
+})
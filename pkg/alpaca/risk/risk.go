@@ -0,0 +1,87 @@
+package risk
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/shopspring/decimal"
+)
+
+// Limits caps the exposure the controller is allowed to take on.
+// A zero value disables the corresponding limit.
+type Limits struct {
+	// MaxPositionValue caps the absolute dollar value held in any
+	// single stock, whether long or short
+	MaxPositionValue float64
+	// MaxShortExposure caps the total dollar value of short
+	// positions held across the account
+	MaxShortExposure float64
+}
+
+// Check returns an error if moving a stock from its current position
+// to the target position at the given price is not allowed. Orders that
+// only reduce exposure are always allowed.
+func (l Limits) Check(stock api.StockInfo, account api.AccountInfo, target decimal.Decimal, price float64) error {
+	current := stock.Position
+
+	if !increasesExposure(current, target) {
+		return nil
+	}
+
+	if target.IsNegative() {
+		if err := CheckShortable(stock, account); err != nil {
+			return err
+		}
+	}
+
+	targetValue, _ := target.Abs().Mul(decimal.NewFromFloat(price)).Float64()
+	if l.MaxPositionValue > 0 && targetValue > l.MaxPositionValue {
+		return fmt.Errorf(
+			"position value %.2f in %s exceeds limit of %.2f",
+			targetValue, stock.Symbol, l.MaxPositionValue,
+		)
+	}
+
+	if l.MaxShortExposure > 0 && target.IsNegative() {
+		// Swap this stock's current short value for the target value,
+		// keeping any shorts held in other stocks.
+		currentShortValue, _ := decimal.Min(current, decimal.Zero).Abs().Mul(decimal.NewFromFloat(price)).Float64()
+		shortExposure := math.Abs(account.ShortMarketValue) - currentShortValue + targetValue
+		if shortExposure > l.MaxShortExposure {
+			return fmt.Errorf(
+				"short exposure %.2f exceeds limit of %.2f",
+				shortExposure, l.MaxShortExposure,
+			)
+		}
+	}
+
+	return nil
+}
+
+// CheckShortable returns an error if the account cannot open or
+// add to a short position in the stock.
+func CheckShortable(stock api.StockInfo, account api.AccountInfo) error {
+	if !account.ShortingEnabled {
+		return fmt.Errorf("cannot short %s: shorting is not enabled on the account", stock.Symbol)
+	}
+	if !stock.Asset.Shortable {
+		return fmt.Errorf("cannot short %s: asset is not shortable", stock.Symbol)
+	}
+	if !stock.Asset.EasyToBorrow {
+		return fmt.Errorf("cannot short %s: asset is not easy to borrow", stock.Symbol)
+	}
+	return nil
+}
+
+// increasesExposure reports whether the target is further from flat
+// than the current position, or on the other side of it.
+func increasesExposure(current, target decimal.Decimal) bool {
+	if target.IsZero() {
+		return false
+	}
+	if current.Sign() != target.Sign() {
+		return true
+	}
+	return target.Abs().GreaterThan(current.Abs())
+}
@@ -0,0 +1,117 @@
+package risk_test
+
+import (
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/risk"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/shopspring/decimal"
+)
+
+var _ = Describe("Risk", func() {
+	var (
+		limits  risk.Limits
+		stock   api.StockInfo
+		account api.AccountInfo
+		target  decimal.Decimal
+		err     error
+	)
+
+	BeforeEach(func() {
+		limits = risk.Limits{}
+		stock = api.StockInfo{
+			Symbol:   "MKL",
+			Position: decimal.NewFromFloat(5),
+			Asset: api.Asset{
+				Asset: alpaca.Asset{Symbol: "MKL", Shortable: true, EasyToBorrow: true},
+			},
+		}
+		account = api.AccountInfo{ShortingEnabled: true}
+	})
+
+	JustBeforeEach(func() {
+		err = limits.Check(stock, account, target, 10)
+	})
+
+	Context("when flipping from long to short", func() {
+		BeforeEach(func() {
+			target = decimal.NewFromFloat(-5)
+		})
+
+		It("should allow the order", func() {
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		Context("when the asset is not easy to borrow", func() {
+			BeforeEach(func() {
+				stock.Asset.EasyToBorrow = false
+			})
+			It("should reject the order", func() {
+				Expect(err).To(MatchError("cannot short MKL: asset is not easy to borrow"))
+			})
+		})
+
+		Context("when the asset is not shortable", func() {
+			BeforeEach(func() {
+				stock.Asset.Shortable = false
+			})
+			It("should reject the order", func() {
+				Expect(err).To(MatchError("cannot short MKL: asset is not shortable"))
+			})
+		})
+
+		Context("when the account cannot short", func() {
+			BeforeEach(func() {
+				account.ShortingEnabled = false
+			})
+			It("should reject the order", func() {
+				Expect(err).To(MatchError("cannot short MKL: shorting is not enabled on the account"))
+			})
+		})
+
+		Context("when short exposure would exceed the limit", func() {
+			BeforeEach(func() {
+				limits.MaxShortExposure = 100
+				account.ShortMarketValue = -60
+			})
+			It("should reject the order", func() {
+				Expect(err).To(MatchError("short exposure 110.00 exceeds limit of 100.00"))
+			})
+		})
+	})
+
+	Context("when covering part of a short position", func() {
+		BeforeEach(func() {
+			stock.Position = decimal.NewFromFloat(-5)
+			stock.Asset.EasyToBorrow = false
+			limits.MaxShortExposure = 10
+			target = decimal.NewFromFloat(-2)
+		})
+		It("should allow the order", func() {
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Context("when adding to a short position", func() {
+		BeforeEach(func() {
+			stock.Position = decimal.NewFromFloat(-5)
+			limits.MaxShortExposure = 100
+			account.ShortMarketValue = -50
+			target = decimal.NewFromFloat(-8)
+		})
+		It("should only count the change in this stock's short value", func() {
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Context("when a long position would exceed the position limit", func() {
+		BeforeEach(func() {
+			limits.MaxPositionValue = 75
+			target = decimal.NewFromFloat(8)
+		})
+		It("should reject the order", func() {
+			Expect(err).To(MatchError("position value 80.00 in MKL exceeds limit of 75.00"))
+		})
+	})
+})
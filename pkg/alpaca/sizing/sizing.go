@@ -0,0 +1,202 @@
+package sizing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// DefaultKey is the key used in config for the sizer applied
+	// to any symbol without its own sizer
+	DefaultKey string = "default"
+)
+
+// Sizer turns a signal from an algorithm into a target position
+type Sizer interface {
+	// Size returns the target position in shares, which may be
+	// fractional and is negative for a short position.
+	Size(context Context) (decimal.Decimal, error)
+}
+
+// Context carries what a sizer needs to choose a target position
+type Context struct {
+	// Signal is 1 for a full long position, -1 for a full short and 0 for flat
+	Signal float64
+	// Price is the expected price per share
+	Price float64
+	// Volatility is the latest ATR for the stock, if the algorithm tracks one
+	Volatility float64
+	Account    api.AccountInfo
+}
+
+// Sizers selects a sizer for each symbol, falling back to a default
+type Sizers struct {
+	Default Sizer
+	Symbols map[string]Sizer
+}
+
+// For returns the sizer for a symbol, or nil if none is configured
+func (s Sizers) For(symbol string) Sizer {
+	if sizer, ok := s.Symbols[symbol]; ok {
+		return sizer
+	}
+	return s.Default
+}
+
+// ParseSizers builds sizers from a map of symbol to spec, where
+// the DefaultKey entry applies to every other symbol.
+func ParseSizers(specs map[string]string) (Sizers, error) {
+	sizers := Sizers{Symbols: map[string]Sizer{}}
+	for symbol, spec := range specs {
+		sizer, err := Parse(spec)
+		if err != nil {
+			return Sizers{}, fmt.Errorf("invalid sizer for %s: %v", symbol, err)
+		}
+		if symbol == DefaultKey {
+			sizers.Default = sizer
+			continue
+		}
+		sizers.Symbols[symbol] = sizer
+	}
+	return sizers, nil
+}
+
+// Parse builds a sizer from a spec of the form name:arg[:arg...], such as
+//
+//	fixed_quantity:10           hold 10 shares
+//	fixed_notional:500          hold $500 of stock
+//	percent_equity:0.1          hold 10% of account equity
+//	volatility:0.01:2           risk 1% of equity over 2 ATRs
+//	kelly:0.55:1.5:0.5          half Kelly for a 55% win rate and 1.5 payoff ratio
+func Parse(spec string) (Sizer, error) {
+	parts := strings.Split(spec, ":")
+	name, rawArgs := parts[0], parts[1:]
+
+	args := make([]float64, len(rawArgs))
+	for i, rawArg := range rawArgs {
+		arg, err := strconv.ParseFloat(rawArg, 64)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = arg
+	}
+
+	switch name {
+	case "fixed_quantity":
+		if len(args) != 1 {
+			return nil, errors.New("fixed_quantity takes a quantity")
+		}
+		return FixedQuantity{Quantity: args[0]}, nil
+	case "fixed_notional":
+		if len(args) != 1 {
+			return nil, errors.New("fixed_notional takes a dollar amount")
+		}
+		return FixedNotional{Notional: args[0]}, nil
+	case "percent_equity":
+		if len(args) != 1 {
+			return nil, errors.New("percent_equity takes a fraction of equity")
+		}
+		return PercentOfEquity{Percent: args[0]}, nil
+	case "volatility":
+		if len(args) != 2 {
+			return nil, errors.New("volatility takes a fraction of equity to risk and an ATR multiple")
+		}
+		return VolatilityTarget{RiskPercent: args[0], ATRMultiple: args[1]}, nil
+	case "kelly":
+		if len(args) != 3 {
+			return nil, errors.New("kelly takes a win rate, payoff ratio and Kelly fraction")
+		}
+		return Kelly{WinRate: args[0], PayoffRatio: args[1], Fraction: args[2]}, nil
+	default:
+		return nil, fmt.Errorf("unknown sizer %q", name)
+	}
+}
+
+// FixedQuantity always targets the same number of shares
+type FixedQuantity struct {
+	Quantity float64
+}
+
+// Size implements the function on the Sizer interface
+func (s FixedQuantity) Size(context Context) (decimal.Decimal, error) {
+	return decimal.NewFromFloat(context.Signal * s.Quantity), nil
+}
+
+// FixedNotional always targets the same dollar value of stock
+type FixedNotional struct {
+	Notional float64
+}
+
+// Size implements the function on the Sizer interface
+func (s FixedNotional) Size(context Context) (decimal.Decimal, error) {
+	if context.Price <= 0 {
+		return decimal.Zero, errors.New("price is required to size a notional")
+	}
+	return decimal.NewFromFloat(context.Signal * s.Notional / context.Price), nil
+}
+
+// PercentOfEquity targets a fraction of account equity, up to our buying power
+type PercentOfEquity struct {
+	Percent float64
+}
+
+// Size implements the function on the Sizer interface
+func (s PercentOfEquity) Size(context Context) (decimal.Decimal, error) {
+	return notionalShares(context, s.Percent*context.Account.Equity)
+}
+
+// VolatilityTarget sizes a position so that an adverse move of some
+// multiple of the ATR loses a fixed fraction of equity.
+type VolatilityTarget struct {
+	RiskPercent float64
+	ATRMultiple float64
+}
+
+// Size implements the function on the Sizer interface
+func (s VolatilityTarget) Size(context Context) (decimal.Decimal, error) {
+	riskPerShare := context.Volatility * s.ATRMultiple
+	if riskPerShare <= 0 {
+		return decimal.Zero, errors.New("volatility is required to size by ATR")
+	}
+	shares := s.RiskPercent * context.Account.Equity / riskPerShare
+	return notionalShares(context, shares*context.Price)
+}
+
+// Kelly sizes a position with a fraction of the Kelly criterion,
+// given the strategy's historical win rate and payoff ratio.
+type Kelly struct {
+	WinRate     float64
+	PayoffRatio float64
+	Fraction    float64
+}
+
+// Size implements the function on the Sizer interface
+func (s Kelly) Size(context Context) (decimal.Decimal, error) {
+	if s.PayoffRatio <= 0 {
+		return decimal.Zero, errors.New("payoff ratio must be positive")
+	}
+	// f* = W - (1 - W) / R
+	kelly := s.WinRate - (1-s.WinRate)/s.PayoffRatio
+	if kelly <= 0 {
+		// The strategy has no edge, so don't take a position
+		return decimal.Zero, nil
+	}
+	return notionalShares(context, s.Fraction*kelly*context.Account.Equity)
+}
+
+// notionalShares converts a dollar amount into a signed share count,
+// capped at the account's buying power.
+func notionalShares(context Context, notional float64) (decimal.Decimal, error) {
+	if context.Price <= 0 {
+		return decimal.Zero, errors.New("price is required to size a notional")
+	}
+	buyingPower := context.Account.Equity * math.Max(context.Account.MarginMultiplier, 1)
+	notional = math.Min(notional, buyingPower)
+	return decimal.NewFromFloat(context.Signal * notional / context.Price), nil
+}
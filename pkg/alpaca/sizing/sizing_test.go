@@ -0,0 +1,104 @@
+package sizing_test
+
+import (
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/sizing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sizing", func() {
+	var (
+		context sizing.Context
+	)
+
+	BeforeEach(func() {
+		context = sizing.Context{
+			Signal:     1,
+			Price:      50,
+			Volatility: 2,
+			Account: api.AccountInfo{
+				Equity:           10000,
+				MarginMultiplier: 2,
+			},
+		}
+	})
+
+	sizeOf := func(sizer sizing.Sizer) float64 {
+		target, err := sizer.Size(context)
+		Expect(err).ToNot(HaveOccurred())
+		value, _ := target.Float64()
+		return value
+	}
+
+	Context("when sizing a long signal", func() {
+		It("should size a fixed quantity", func() {
+			Expect(sizeOf(sizing.FixedQuantity{Quantity: 10})).To(Equal(float64(10)))
+		})
+		It("should size a fixed notional", func() {
+			Expect(sizeOf(sizing.FixedNotional{Notional: 125})).To(Equal(2.5))
+		})
+		It("should size a percent of equity", func() {
+			Expect(sizeOf(sizing.PercentOfEquity{Percent: 0.1})).To(Equal(float64(20)))
+		})
+		It("should cap a percent of equity at buying power", func() {
+			Expect(sizeOf(sizing.PercentOfEquity{Percent: 3})).To(Equal(float64(400)))
+		})
+		It("should size by volatility", func() {
+			// $100 at risk over 2 ATRs of $2 is 25 shares
+			Expect(sizeOf(sizing.VolatilityTarget{RiskPercent: 0.01, ATRMultiple: 2})).To(Equal(float64(25)))
+		})
+		It("should size a Kelly fraction", func() {
+			// f* = 0.6 - 0.4 / 2 = 0.4, halved is 20% of equity
+			Expect(sizeOf(sizing.Kelly{WinRate: 0.6, PayoffRatio: 2, Fraction: 0.5})).To(BeNumerically("~", 40, 1e-9))
+		})
+		It("should not size a Kelly fraction without an edge", func() {
+			Expect(sizeOf(sizing.Kelly{WinRate: 0.3, PayoffRatio: 1, Fraction: 1})).To(Equal(float64(0)))
+		})
+	})
+
+	Context("when sizing a partial short signal", func() {
+		BeforeEach(func() {
+			context.Signal = -0.5
+		})
+		It("should return a negative target", func() {
+			Expect(sizeOf(sizing.FixedQuantity{Quantity: 10})).To(Equal(float64(-5)))
+			Expect(sizeOf(sizing.PercentOfEquity{Percent: 0.1})).To(Equal(float64(-10)))
+		})
+	})
+
+	Context("when volatility is unknown", func() {
+		BeforeEach(func() {
+			context.Volatility = 0
+		})
+		It("should fail to size by volatility", func() {
+			_, err := sizing.VolatilityTarget{RiskPercent: 0.01, ATRMultiple: 2}.Size(context)
+			Expect(err).To(MatchError("volatility is required to size by ATR"))
+		})
+	})
+
+	Context("when parsing sizers from config", func() {
+		It("should select sizers per symbol with a default", func() {
+			sizers, err := sizing.ParseSizers(map[string]string{
+				sizing.DefaultKey: "percent_equity:0.05",
+				"VTI":             "fixed_notional:500",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sizers.For("VTI")).To(Equal(sizing.FixedNotional{Notional: 500}))
+			Expect(sizers.For("BND")).To(Equal(sizing.PercentOfEquity{Percent: 0.05}))
+		})
+		It("should parse multi-argument sizers", func() {
+			sizer, err := sizing.Parse("kelly:0.55:1.5:0.5")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sizer).To(Equal(sizing.Kelly{WinRate: 0.55, PayoffRatio: 1.5, Fraction: 0.5}))
+		})
+		It("should reject unknown sizers", func() {
+			_, err := sizing.Parse("martingale:2")
+			Expect(err).To(MatchError(`unknown sizer "martingale"`))
+		})
+		It("should reject the wrong number of arguments", func() {
+			_, err := sizing.Parse("volatility:0.01")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,207 @@
+package streams
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+)
+
+var _ api.DataStream = &Memory{}
+var _ api.OrderStream = &Memory{}
+var _ api.AccountStream = &Memory{}
+
+// Memory is a stream fed by calls to Publish, such as from a test.
+// Events nobody subscribed to are dropped, as they are by Alpaca.
+type Memory struct {
+	trades map[string]func(alpaca.StreamTrade)
+	quotes map[string]func(alpaca.StreamQuote)
+	// aggregates are keyed by stream name, as each
+	// aggregate channel has its own
+	aggregates     map[string]func(api.Bar)
+	tradeUpdates   func(alpaca.TradeUpdate)
+	accountUpdates func(api.AccountUpdate)
+	mu             sync.Mutex
+}
+
+// NewMemory returns a new in-memory stream
+func NewMemory() *Memory {
+	return &Memory{
+		trades:     map[string]func(alpaca.StreamTrade){},
+		quotes:     map[string]func(alpaca.StreamQuote){},
+		aggregates: map[string]func(api.Bar){},
+	}
+}
+
+// SubscribeTrades implements the function on the api.DataStream interface
+func (m *Memory) SubscribeTrades(symbol string, handler func(alpaca.StreamTrade)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.trades[symbol] = handler
+	return nil
+}
+
+// SubscribeQuotes implements the function on the api.DataStream interface
+func (m *Memory) SubscribeQuotes(symbol string, handler func(alpaca.StreamQuote)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.quotes[symbol] = handler
+	return nil
+}
+
+// SubscribeAggregates implements the function on the api.DataStream interface
+func (m *Memory) SubscribeAggregates(symbol string, channel api.Channel, handler func(api.Bar)) error {
+	key, err := aggregateKey(symbol, channel)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.aggregates[key] = handler
+	return nil
+}
+
+// Unsubscribe implements the function on the api.DataStream interface
+func (m *Memory) Unsubscribe(symbol string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.trades, symbol)
+	delete(m.quotes, symbol)
+	for _, channel := range []api.Channel{api.MinuteAggregateChannel, api.SecondAggregateChannel} {
+		key, _ := aggregateKey(symbol, channel)
+		delete(m.aggregates, key)
+	}
+	return nil
+}
+
+// SubscribeTradeUpdates implements the function on the api.OrderStream interface
+func (m *Memory) SubscribeTradeUpdates(handler func(alpaca.TradeUpdate)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tradeUpdates = handler
+	return nil
+}
+
+// UnsubscribeTradeUpdates implements the function on the api.OrderStream interface
+func (m *Memory) UnsubscribeTradeUpdates() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tradeUpdates = nil
+	return nil
+}
+
+// SubscribeAccountUpdates implements the function on the api.AccountStream interface
+func (m *Memory) SubscribeAccountUpdates(handler func(api.AccountUpdate)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.accountUpdates = handler
+	return nil
+}
+
+// UnsubscribeAccountUpdates implements the function on the api.AccountStream interface
+func (m *Memory) UnsubscribeAccountUpdates() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.accountUpdates = nil
+	return nil
+}
+
+// Subscriptions lists the Alpaca streams currently followed, sorted
+func (m *Memory) Subscriptions() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := []string{}
+	for symbol := range m.trades {
+		keys = append(keys, tradeKey(symbol))
+	}
+	for symbol := range m.quotes {
+		keys = append(keys, quoteKey(symbol))
+	}
+	for key := range m.aggregates {
+		keys = append(keys, key)
+	}
+	if m.tradeUpdates != nil {
+		keys = append(keys, alpaca.TradeUpdates)
+	}
+	if m.accountUpdates != nil {
+		keys = append(keys, alpaca.AccountUpdates)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Publish passes an event to its subscriber, on the caller's goroutine
+func (m *Memory) Publish(event Event) error {
+	key, err := event.Stream()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	var handler func()
+	switch event.Type {
+	case TradeEvent:
+		if trade, ok := m.trades[event.Trade.Symbol]; ok {
+			handler = func() { trade(*event.Trade) }
+		}
+	case QuoteEvent:
+		if quote, ok := m.quotes[event.Quote.Symbol]; ok {
+			handler = func() { quote(*event.Quote) }
+		}
+	case AggregateEvent:
+		if aggregate, ok := m.aggregates[key]; ok {
+			handler = func() { aggregate(*event.Aggregate) }
+		}
+	case TradeUpdateEvent:
+		if update := m.tradeUpdates; update != nil {
+			handler = func() { update(*event.TradeUpdate) }
+		}
+	case AccountUpdateEvent:
+		if update := m.accountUpdates; update != nil {
+			handler = func() { update(*event.AccountUpdate) }
+		}
+	}
+	m.mu.Unlock()
+
+	// Call outside the lock, so handlers can change subscriptions
+	if handler != nil {
+		handler()
+	}
+	return nil
+}
+
+// PublishTrade passes a trade to its subscriber
+func (m *Memory) PublishTrade(trade alpaca.StreamTrade) {
+	_ = m.Publish(Event{Type: TradeEvent, Trade: &trade})
+}
+
+// PublishQuote passes a quote to its subscriber
+func (m *Memory) PublishQuote(quote alpaca.StreamQuote) {
+	_ = m.Publish(Event{Type: QuoteEvent, Quote: &quote})
+}
+
+// PublishAggregate passes a bar from an aggregate channel to its subscriber
+func (m *Memory) PublishAggregate(channel api.Channel, bar api.Bar) error {
+	return m.Publish(Event{Type: AggregateEvent, Channel: channel, Aggregate: &bar})
+}
+
+// PublishTradeUpdate passes an order update to its subscriber
+func (m *Memory) PublishTradeUpdate(update alpaca.TradeUpdate) {
+	_ = m.Publish(Event{Type: TradeUpdateEvent, TradeUpdate: &update})
+}
+
+// PublishAccountUpdate passes an account update to its subscriber
+func (m *Memory) PublishAccountUpdate(update api.AccountUpdate) {
+	_ = m.Publish(Event{Type: AccountUpdateEvent, AccountUpdate: &update})
+}
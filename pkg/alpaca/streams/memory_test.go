@@ -0,0 +1,80 @@
+package streams_test
+
+import (
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/streams"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Memory", func() {
+	var (
+		memory     *streams.Memory
+		trades     []alpaca.StreamTrade
+		quotes     []alpaca.StreamQuote
+		aggregates []api.Bar
+		updates    []alpaca.TradeUpdate
+		accounts   []api.AccountUpdate
+	)
+
+	BeforeEach(func() {
+		memory = streams.NewMemory()
+		trades, quotes, aggregates, updates, accounts = nil, nil, nil, nil, nil
+
+		Expect(memory.SubscribeTrades("MKL", func(trade alpaca.StreamTrade) {
+			trades = append(trades, trade)
+		})).To(Succeed())
+		Expect(memory.SubscribeQuotes("MKL", func(quote alpaca.StreamQuote) {
+			quotes = append(quotes, quote)
+		})).To(Succeed())
+		Expect(memory.SubscribeAggregates("MKL", api.MinuteAggregateChannel, func(bar api.Bar) {
+			aggregates = append(aggregates, bar)
+		})).To(Succeed())
+		Expect(memory.SubscribeTradeUpdates(func(update alpaca.TradeUpdate) {
+			updates = append(updates, update)
+		})).To(Succeed())
+		Expect(memory.SubscribeAccountUpdates(func(update api.AccountUpdate) {
+			accounts = append(accounts, update)
+		})).To(Succeed())
+	})
+
+	It("should list what is subscribed", func() {
+		Expect(memory.Subscriptions()).To(Equal([]string{"AM.MKL", "Q.MKL", "T.MKL", "account_updates", "trade_updates"}))
+	})
+
+	It("should pass events to their subscribers", func() {
+		memory.PublishTrade(alpaca.StreamTrade{Symbol: "MKL", Price: 100})
+		memory.PublishTrade(alpaca.StreamTrade{Symbol: "VTI", Price: 200})
+		memory.PublishQuote(alpaca.StreamQuote{Symbol: "MKL", BidPrice: 99, AskPrice: 101})
+		Expect(memory.PublishAggregate(api.MinuteAggregateChannel, api.Bar{Symbol: "MKL", Close: 100})).To(Succeed())
+		Expect(memory.PublishAggregate(api.SecondAggregateChannel, api.Bar{Symbol: "MKL", Close: 100})).To(Succeed())
+		memory.PublishTradeUpdate(alpaca.TradeUpdate{Event: "new", Order: alpaca.Order{ID: "order1"}})
+		memory.PublishAccountUpdate(api.AccountUpdate{ID: "account123", Status: "ACTIVE"})
+
+		Expect(trades).To(Equal([]alpaca.StreamTrade{{Symbol: "MKL", Price: 100}}))
+		Expect(quotes).To(HaveLen(1))
+		Expect(aggregates).To(Equal([]api.Bar{{Symbol: "MKL", Close: 100}}))
+		Expect(updates).To(HaveLen(1))
+		Expect(accounts).To(Equal([]api.AccountUpdate{{ID: "account123", Status: "ACTIVE"}}))
+	})
+
+	It("should drop events once unsubscribed", func() {
+		Expect(memory.Unsubscribe("MKL")).To(Succeed())
+		Expect(memory.UnsubscribeTradeUpdates()).To(Succeed())
+		Expect(memory.UnsubscribeAccountUpdates()).To(Succeed())
+		Expect(memory.Subscriptions()).To(BeEmpty())
+
+		memory.PublishTrade(alpaca.StreamTrade{Symbol: "MKL", Price: 100})
+		memory.PublishTradeUpdate(alpaca.TradeUpdate{Event: "new"})
+		Expect(trades).To(BeEmpty())
+		Expect(updates).To(BeEmpty())
+	})
+
+	It("should reject events without their data", func() {
+		Expect(memory.Publish(streams.Event{Type: streams.TradeEvent})).To(MatchError("trade event is missing its data"))
+		Expect(memory.Publish(streams.Event{Type: streams.AccountUpdateEvent})).To(MatchError("account_update event is missing its data"))
+		Expect(memory.Publish(streams.Event{Type: "bar"})).To(MatchError(`unknown event type "bar"`))
+		Expect(memory.PublishAggregate(api.QuoteChannel, api.Bar{Symbol: "MKL"})).To(MatchError(`"quotes" is not an aggregate channel`))
+	})
+})
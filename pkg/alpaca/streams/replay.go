@@ -0,0 +1,71 @@
+package streams
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// maxEventSize is the longest line a replay file may hold
+const maxEventSize int = 1024 * 1024
+
+// Replay is an in-memory stream that plays back events from a file,
+// one JSON Event per line, to whoever subscribed. Files ending in .gz
+// are decompressed as they are read.
+type Replay struct {
+	*Memory
+	path string
+}
+
+// NewReplay returns a new stream replaying the file at the given path
+func NewReplay(path string) *Replay {
+	return &Replay{
+		Memory: NewMemory(),
+		path:   path,
+	}
+}
+
+// Play publishes every event in the file in order, as fast as the
+// subscribers take them, returning once the file has been played
+func (r *Replay) Play() error {
+	file, err := os.Open(r.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(r.path, ".gz") {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", r.path, err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxEventSize)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		event := Event{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("%s line %d: %w", r.path, line, err)
+		}
+		if err := r.Publish(event); err != nil {
+			return fmt.Errorf("%s line %d: %w", r.path, line, err)
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,100 @@
+package streams_test
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/streams"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Replay", func() {
+	var (
+		dir    string
+		path   string
+		replay *streams.Replay
+		events []string
+		err    error
+	)
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "replay")
+		Expect(err).ToNot(HaveOccurred())
+		path = filepath.Join(dir, "events.jsonl")
+		events = nil
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	JustBeforeEach(func() {
+		replay = streams.NewReplay(path)
+		Expect(replay.SubscribeTrades("MKL", func(trade alpaca.StreamTrade) {
+			events = append(events, "trade")
+		})).To(Succeed())
+		Expect(replay.SubscribeTradeUpdates(func(update alpaca.TradeUpdate) {
+			events = append(events, update.Event)
+		})).To(Succeed())
+	})
+
+	Context("with a recorded file", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(path, []byte(
+				`{"type":"trade","trade":{"T":"MKL","p":100,"s":10,"t":1604327400000000000}}`+"\n"+
+					"\n"+
+					`{"type":"quote","quote":{"T":"MKL","p":99,"P":101}}`+"\n"+
+					`{"type":"trade_update","trade_update":{"event":"fill","order":{"id":"order1","symbol":"MKL"}}}`+"\n",
+			), 0644)).To(Succeed())
+		})
+
+		It("should play every subscribed event in order", func() {
+			Expect(replay.Play()).To(Succeed())
+			Expect(events).To(Equal([]string{"trade", "fill"}))
+		})
+	})
+
+	Context("with a compressed file", func() {
+		BeforeEach(func() {
+			path = filepath.Join(dir, "events.jsonl.gz")
+			file, err := os.Create(path)
+			Expect(err).ToNot(HaveOccurred())
+			defer file.Close()
+
+			writer := gzip.NewWriter(file)
+			_, err = writer.Write([]byte(
+				`{"type":"trade","trade":{"T":"MKL","p":100,"s":10,"t":1604327400000000000}}` + "\n" +
+					`{"type":"trade_update","trade_update":{"event":"fill","order":{"id":"order1","symbol":"MKL"}}}` + "\n",
+			))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(writer.Close()).To(Succeed())
+		})
+
+		It("should decompress and play every subscribed event", func() {
+			Expect(replay.Play()).To(Succeed())
+			Expect(events).To(Equal([]string{"trade", "fill"}))
+		})
+	})
+
+	Context("with a malformed line", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(path, []byte(
+				`{"type":"trade","trade":{"T":"MKL","p":100}}`+"\n"+
+					`{"type":"trade"}`+"\n",
+			), 0644)).To(Succeed())
+		})
+
+		It("should stop at the line", func() {
+			Expect(replay.Play()).To(MatchError(path + " line 2: trade event is missing its data"))
+			Expect(events).To(Equal([]string{"trade"}))
+		})
+	})
+
+	It("should fail when the file does not exist", func() {
+		Expect(replay.Play()).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,206 @@
+package streams
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/alpacahq/alpaca-trade-api-go/stream"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/sirupsen/logrus"
+)
+
+var _ api.DataStream = &SDK{}
+var _ api.OrderStream = &SDK{}
+var _ api.AccountStream = &SDK{}
+
+var (
+	// sdkSource is where the SDK's data stream comes from, and
+	// sdkStarted whether it has connected, after which it is fixed
+	sdkSource  = AlpacaSource
+	sdkStarted bool
+	sdkMu      sync.Mutex
+)
+
+// UseDataSource selects where the SDK streams market data from, for
+// every SDK adapter in the process. It must be called before anything
+// subscribes, as the SDK connects once.
+func UseDataSource(source DataSource) error {
+	sdkMu.Lock()
+	defer sdkMu.Unlock()
+
+	if sdkStarted {
+		return errors.New("data source cannot change once the stream has started")
+	}
+	if _, err := ParseDataSource(string(source)); err != nil {
+		return err
+	}
+
+	stream.SetDataStream(string(source))
+	sdkSource = source
+	return nil
+}
+
+// SDK streams from Alpaca through the SDK's stream package. The SDK
+// keeps one connection for the whole process, so every SDK adapter
+// shares it, and only one handler can follow each stream at a time.
+// Market data from Polygon is converted to the form Alpaca streams it
+// in, so handlers see the same data whichever source is used.
+type SDK struct {
+	// keys are the streams registered for each stock, by symbol
+	keys map[string][]string
+	mu   sync.Mutex
+}
+
+// NewSDK returns a new SDK stream adapter
+func NewSDK() *SDK {
+	return &SDK{keys: map[string][]string{}}
+}
+
+// SubscribeTrades implements the function on the api.DataStream interface
+func (s *SDK) SubscribeTrades(symbol string, handler func(alpaca.StreamTrade)) error {
+	key := tradeKey(symbol)
+	return s.register(symbol, key, func(msg interface{}) {
+		trade, source, err := NormalizeTrade(msg)
+		if !check(key, source, msg, err) {
+			return
+		}
+		handler(trade)
+	})
+}
+
+// SubscribeQuotes implements the function on the api.DataStream interface
+func (s *SDK) SubscribeQuotes(symbol string, handler func(alpaca.StreamQuote)) error {
+	key := quoteKey(symbol)
+	return s.register(symbol, key, func(msg interface{}) {
+		quote, source, err := NormalizeQuote(msg)
+		if !check(key, source, msg, err) {
+			return
+		}
+		handler(quote)
+	})
+}
+
+// SubscribeAggregates implements the function on the api.DataStream interface.
+// Only Polygon streams second aggregates.
+func (s *SDK) SubscribeAggregates(symbol string, channel api.Channel, handler func(api.Bar)) error {
+	key, err := aggregateKey(symbol, channel)
+	if err != nil {
+		return err
+	}
+	if channel == api.SecondAggregateChannel && currentSource() != PolygonSource {
+		return fmt.Errorf("%s are not streamed by Alpaca", channel)
+	}
+
+	return s.register(symbol, key, func(msg interface{}) {
+		bar, source, err := NormalizeAggregate(msg)
+		if !check(key, source, msg, err) {
+			return
+		}
+		handler(bar)
+	})
+}
+
+// Unsubscribe implements the function on the api.DataStream interface
+func (s *SDK) Unsubscribe(symbol string) error {
+	s.mu.Lock()
+	keys := s.keys[symbol]
+	delete(s.keys, symbol)
+	s.mu.Unlock()
+
+	var firstErr error
+	for _, key := range keys {
+		if err := stream.Deregister(key); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// SubscribeTradeUpdates implements the function on the api.OrderStream interface
+func (s *SDK) SubscribeTradeUpdates(handler func(alpaca.TradeUpdate)) error {
+	startSDK()
+	return stream.Register(alpaca.TradeUpdates, func(msg interface{}) {
+		update, ok := msg.(alpaca.TradeUpdate)
+		if !ok {
+			check(alpaca.TradeUpdates, "", msg, fmt.Errorf("%T is not a trade update", msg))
+			return
+		}
+		handler(update)
+	})
+}
+
+// UnsubscribeTradeUpdates implements the function on the api.OrderStream interface
+func (s *SDK) UnsubscribeTradeUpdates() error {
+	return stream.Deregister(alpaca.TradeUpdates)
+}
+
+// SubscribeAccountUpdates implements the function on the api.AccountStream interface
+func (s *SDK) SubscribeAccountUpdates(handler func(api.AccountUpdate)) error {
+	startSDK()
+	return stream.Register(alpaca.AccountUpdates, func(msg interface{}) {
+		update, err := NormalizeAccountUpdate(msg)
+		if err != nil {
+			check(alpaca.AccountUpdates, "", msg, err)
+			return
+		}
+		handler(update)
+	})
+}
+
+// UnsubscribeAccountUpdates implements the function on the api.AccountStream interface
+func (s *SDK) UnsubscribeAccountUpdates() error {
+	return stream.Deregister(alpaca.AccountUpdates)
+}
+
+// register follows an SDK stream, remembering it for the stock
+func (s *SDK) register(symbol, key string, handler func(msg interface{})) error {
+	startSDK()
+	if err := stream.Register(key, handler); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[symbol] = append(s.keys[symbol], key)
+	return nil
+}
+
+// startSDK fixes the data source, as the SDK is about to connect
+func startSDK() {
+	sdkMu.Lock()
+	defer sdkMu.Unlock()
+
+	sdkStarted = true
+}
+
+// currentSource returns where the SDK streams market data from
+func currentSource() DataSource {
+	sdkMu.Lock()
+	defer sdkMu.Unlock()
+
+	return sdkSource
+}
+
+// check logs a message that could not be normalized, rather than
+// dropping it silently, or one that came from a source other than
+// the one selected, which means the SDK was set up elsewhere. It
+// reports whether the message can be handled.
+func check(key string, source DataSource, msg interface{}, err error) bool {
+	expected := currentSource()
+	contextLog := logrus.WithFields(logrus.Fields{
+		"stream":   key,
+		"expected": expected,
+		"type":     fmt.Sprintf("%T", msg),
+	})
+
+	if err != nil {
+		contextLog.Errorf("Dropped stream message: %v", err)
+		return false
+	}
+	if source != expected {
+		contextLog.WithFields(logrus.Fields{"source": source}).Warn("Stream message came from an unexpected data source")
+	}
+	return true
+}
@@ -0,0 +1,176 @@
+package streams
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+)
+
+// FakeServer is a local websocket server speaking Alpaca's stream
+// protocol, for streaming to a Socket without connecting to Alpaca.
+// It authorizes any keys, and sends each event to every connection
+// listening to its stream.
+type FakeServer struct {
+	server   *httptest.Server
+	upgrader websocket.Upgrader
+	// conns are the streams each open connection listens to
+	conns map[*websocket.Conn]map[string]bool
+	// mu guards conns and serializes writes to them
+	mu sync.Mutex
+}
+
+// NewFakeServer starts a new fake stream server
+func NewFakeServer() *FakeServer {
+	s := &FakeServer{
+		conns: map[*websocket.Conn]map[string]bool{},
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// URL is the websocket URL to dial the server at
+func (s *FakeServer) URL() string {
+	return "ws" + strings.TrimPrefix(s.server.URL, "http") + "/stream"
+}
+
+// Subscriptions lists the streams any connection listens to, sorted
+func (s *FakeServer) Subscriptions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	streams := map[string]bool{}
+	for _, listening := range s.conns {
+		for stream := range listening {
+			streams[stream] = true
+		}
+	}
+
+	keys := []string{}
+	for stream := range streams {
+		keys = append(keys, stream)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Publish sends an event to every connection listening to its stream
+func (s *FakeServer) Publish(event Event) error {
+	stream, err := event.Stream()
+	if err != nil {
+		return err
+	}
+
+	var data interface{}
+	switch event.Type {
+	case TradeEvent:
+		data = event.Trade
+	case QuoteEvent:
+		data = event.Quote
+	case AggregateEvent:
+		if event.Channel != api.MinuteAggregateChannel {
+			return fmt.Errorf("%s are not streamed by Alpaca", event.Channel)
+		}
+		data = alpacaAgg(*event.Aggregate)
+	case TradeUpdateEvent:
+		data = event.TradeUpdate
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn, listening := range s.conns {
+		if !listening[stream] {
+			continue
+		}
+		if err := conn.WriteJSON(map[string]interface{}{"stream": stream, "data": data}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close shuts the server down, closing any open connections
+func (s *FakeServer) Close() {
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.server.Close()
+}
+
+// serve authorizes a connection and follows its requests to listen
+func (s *FakeServer) serve(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	request := struct {
+		Action string `json:"action"`
+		Data   struct {
+			Streams []string `json:"streams"`
+		} `json:"data"`
+	}{}
+
+	if err := conn.ReadJSON(&request); err != nil || request.Action != "authenticate" {
+		return
+	}
+
+	s.mu.Lock()
+	s.conns[conn] = map[string]bool{}
+	err = conn.WriteJSON(map[string]interface{}{
+		"stream": "authorization",
+		"data":   map[string]string{"action": "authenticate", "status": "authorized"},
+	})
+	s.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	for {
+		request.Data.Streams = nil
+		if err := conn.ReadJSON(&request); err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		listening := s.conns[conn]
+		for _, stream := range request.Data.Streams {
+			switch request.Action {
+			case "listen":
+				listening[stream] = true
+			case "unlisten":
+				delete(listening, stream)
+			}
+		}
+
+		streams := []string{}
+		for stream := range listening {
+			streams = append(streams, stream)
+		}
+		sort.Strings(streams)
+		err := conn.WriteJSON(map[string]interface{}{
+			"stream": "listening",
+			"data":   map[string][]string{"streams": streams},
+		})
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package streams
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/gorilla/websocket"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/sirupsen/logrus"
+)
+
+var _ api.DataStream = &Socket{}
+var _ api.OrderStream = &Socket{}
+var _ api.AccountStream = &Socket{}
+
+// authTimeout is how long to wait for the server to authorize us
+const authTimeout time.Duration = 5 * time.Second
+
+// serverMessage is a message from a server speaking Alpaca's stream protocol
+type serverMessage struct {
+	Stream string          `json:"stream"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// clientMessage is a message to a server speaking Alpaca's stream protocol
+type clientMessage struct {
+	Action string      `json:"action"`
+	Data   interface{} `json:"data"`
+}
+
+// Socket streams over its own websocket connection, speaking Alpaca's
+// stream protocol, such as to a FakeServer. Unlike the SDK it does not
+// reconnect, and each Socket connects to one server, so data and order
+// updates from Alpaca need a Socket each.
+type Socket struct {
+	conn *websocket.Conn
+	// handlers decode and pass on events, by stream name
+	handlers map[string]func(json.RawMessage)
+	closed   bool
+	mu       sync.Mutex
+	// writeMu serializes writes to the connection
+	writeMu sync.Mutex
+}
+
+// DialSocket connects and authenticates to the stream at the given
+// websocket URL, such as wss://data.alpaca.markets/stream
+func DialSocket(url, keyID, secretKey string) (*Socket, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	socket := &Socket{
+		conn:     conn,
+		handlers: map[string]func(json.RawMessage){},
+	}
+
+	if err := socket.auth(keyID, secretKey); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go socket.read()
+	return socket, nil
+}
+
+// SubscribeTrades implements the function on the api.DataStream interface
+func (s *Socket) SubscribeTrades(symbol string, handler func(alpaca.StreamTrade)) error {
+	return s.listen(tradeKey(symbol), func(data json.RawMessage) {
+		trade := alpaca.StreamTrade{}
+		if err := json.Unmarshal(data, &trade); err != nil {
+			logrus.Errorf("Failed to decode stream trade: %v", err)
+			return
+		}
+		handler(trade)
+	})
+}
+
+// SubscribeQuotes implements the function on the api.DataStream interface
+func (s *Socket) SubscribeQuotes(symbol string, handler func(alpaca.StreamQuote)) error {
+	return s.listen(quoteKey(symbol), func(data json.RawMessage) {
+		quote := alpaca.StreamQuote{}
+		if err := json.Unmarshal(data, &quote); err != nil {
+			logrus.Errorf("Failed to decode stream quote: %v", err)
+			return
+		}
+		handler(quote)
+	})
+}
+
+// SubscribeAggregates implements the function on the api.DataStream interface.
+// Alpaca only streams minute aggregates.
+func (s *Socket) SubscribeAggregates(symbol string, channel api.Channel, handler func(api.Bar)) error {
+	if channel != api.MinuteAggregateChannel {
+		return fmt.Errorf("%s are not streamed by Alpaca", channel)
+	}
+	key, _ := aggregateKey(symbol, channel)
+
+	return s.listen(key, func(data json.RawMessage) {
+		agg := alpaca.StreamAgg{}
+		if err := json.Unmarshal(data, &agg); err != nil {
+			logrus.Errorf("Failed to decode stream aggregate: %v", err)
+			return
+		}
+		handler(alpacaBar(agg))
+	})
+}
+
+// Unsubscribe implements the function on the api.DataStream interface
+func (s *Socket) Unsubscribe(symbol string) error {
+	minuteKey, _ := aggregateKey(symbol, api.MinuteAggregateChannel)
+	return s.unlisten(tradeKey(symbol), quoteKey(symbol), minuteKey)
+}
+
+// SubscribeTradeUpdates implements the function on the api.OrderStream interface
+func (s *Socket) SubscribeTradeUpdates(handler func(alpaca.TradeUpdate)) error {
+	return s.listen(alpaca.TradeUpdates, func(data json.RawMessage) {
+		update := alpaca.TradeUpdate{}
+		if err := json.Unmarshal(data, &update); err != nil {
+			logrus.Errorf("Failed to decode trade update: %v", err)
+			return
+		}
+		handler(update)
+	})
+}
+
+// UnsubscribeTradeUpdates implements the function on the api.OrderStream interface
+func (s *Socket) UnsubscribeTradeUpdates() error {
+	return s.unlisten(alpaca.TradeUpdates)
+}
+
+// SubscribeAccountUpdates implements the function on the api.AccountStream interface
+func (s *Socket) SubscribeAccountUpdates(handler func(api.AccountUpdate)) error {
+	return s.listen(alpaca.AccountUpdates, func(data json.RawMessage) {
+		update := api.AccountUpdate{}
+		if err := json.Unmarshal(data, &update); err != nil {
+			logrus.Errorf("Failed to decode account update: %v", err)
+			return
+		}
+		handler(update)
+	})
+}
+
+// UnsubscribeAccountUpdates implements the function on the api.AccountStream interface
+func (s *Socket) UnsubscribeAccountUpdates() error {
+	return s.unlisten(alpaca.AccountUpdates)
+}
+
+// Close closes the connection
+func (s *Socket) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	err := s.conn.WriteMessage(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+	)
+	if closeErr := s.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// auth sends our keys, waiting for the server to authorize them
+func (s *Socket) auth(keyID, secretKey string) error {
+	if err := s.write(clientMessage{
+		Action: "authenticate",
+		Data: map[string]string{
+			"key_id":     keyID,
+			"secret_key": secretKey,
+		},
+	}); err != nil {
+		return err
+	}
+
+	s.conn.SetReadDeadline(time.Now().Add(authTimeout))
+	defer s.conn.SetReadDeadline(time.Time{})
+
+	msg := serverMessage{}
+	if err := s.conn.ReadJSON(&msg); err != nil {
+		return err
+	}
+
+	status := struct {
+		Status string `json:"status"`
+	}{}
+	if err := json.Unmarshal(msg.Data, &status); err != nil {
+		return err
+	}
+	if !strings.EqualFold(status.Status, "authorized") {
+		return errors.New("failed to authorize stream")
+	}
+	return nil
+}
+
+// listen follows a stream, passing its events to the handler
+func (s *Socket) listen(key string, handler func(json.RawMessage)) error {
+	s.mu.Lock()
+	s.handlers[key] = handler
+	s.mu.Unlock()
+
+	err := s.write(clientMessage{
+		Action: "listen",
+		Data:   map[string][]string{"streams": {key}},
+	})
+	if err != nil {
+		s.mu.Lock()
+		delete(s.handlers, key)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// unlisten stops following any of the streams we follow
+func (s *Socket) unlisten(keys ...string) error {
+	s.mu.Lock()
+	followed := []string{}
+	for _, key := range keys {
+		if _, ok := s.handlers[key]; ok {
+			followed = append(followed, key)
+			delete(s.handlers, key)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(followed) == 0 {
+		return nil
+	}
+	return s.write(clientMessage{
+		Action: "unlisten",
+		Data:   map[string][]string{"streams": followed},
+	})
+}
+
+// write sends a message to the server
+func (s *Socket) write(msg clientMessage) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	return s.conn.WriteJSON(msg)
+}
+
+// read passes events to their handlers until the connection closes
+func (s *Socket) read() {
+	for {
+		msg := serverMessage{}
+		if err := s.conn.ReadJSON(&msg); err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if !closed {
+				logrus.Errorf("Stream connection lost: %v", err)
+			}
+			return
+		}
+
+		s.mu.Lock()
+		handler := s.handlers[msg.Stream]
+		s.mu.Unlock()
+
+		if handler != nil {
+			handler(msg.Data)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package streams_test
+
+import (
+	"sync"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/streams"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/shopspring/decimal"
+)
+
+var _ = Describe("Socket", func() {
+	var (
+		server  *streams.FakeServer
+		socket  *streams.Socket
+		mu      sync.Mutex
+		trades  []alpaca.StreamTrade
+		updates []alpaca.TradeUpdate
+		err     error
+	)
+
+	received := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(trades) + len(updates)
+	}
+
+	BeforeEach(func() {
+		server = streams.NewFakeServer()
+		trades, updates = nil, nil
+
+		socket, err = streams.DialSocket(server.URL(), "key", "secret")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(socket.SubscribeTrades("MKL", func(trade alpaca.StreamTrade) {
+			mu.Lock()
+			defer mu.Unlock()
+			trades = append(trades, trade)
+		})).To(Succeed())
+		Expect(socket.SubscribeTradeUpdates(func(update alpaca.TradeUpdate) {
+			mu.Lock()
+			defer mu.Unlock()
+			updates = append(updates, update)
+		})).To(Succeed())
+		Eventually(server.Subscriptions).Should(Equal([]string{"T.MKL", "trade_updates"}))
+	})
+
+	AfterEach(func() {
+		socket.Close()
+		server.Close()
+	})
+
+	It("should decode the events it listens to", func() {
+		at := time.Date(2020, 11, 2, 14, 30, 0, 0, time.UTC)
+		trade := alpaca.StreamTrade{Symbol: "MKL", Price: 100, Size: 10, Timestamp: at.UnixNano()}
+		Expect(server.Publish(streams.Event{Type: streams.TradeEvent, Trade: &trade})).To(Succeed())
+		other := alpaca.StreamTrade{Symbol: "VTI", Price: 200}
+		Expect(server.Publish(streams.Event{Type: streams.TradeEvent, Trade: &other})).To(Succeed())
+		update := alpaca.TradeUpdate{Event: "fill", Order: alpaca.Order{ID: "order1", Symbol: "MKL", FilledQty: decimal.NewFromInt(10)}}
+		Expect(server.Publish(streams.Event{Type: streams.TradeUpdateEvent, TradeUpdate: &update})).To(Succeed())
+
+		Eventually(received).Should(Equal(2))
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(trades).To(Equal([]alpaca.StreamTrade{trade}))
+		Expect(updates[0].Order.ID).To(Equal("order1"))
+		Expect(updates[0].Order.FilledQty.Equal(decimal.NewFromInt(10))).To(BeTrue())
+	})
+
+	It("should decode minute aggregates", func() {
+		bars := make(chan api.Bar, 1)
+		Expect(socket.SubscribeAggregates("MKL", api.MinuteAggregateChannel, func(bar api.Bar) {
+			bars <- bar
+		})).To(Succeed())
+		Eventually(server.Subscriptions).Should(ContainElement("AM.MKL"))
+
+		start := time.Date(2020, 11, 2, 14, 30, 0, 0, time.UTC)
+		bar := api.Bar{Symbol: "MKL", Start: start, End: start.Add(time.Minute), Open: 10, High: 12, Low: 9, Close: 11, Volume: 500, VWAP: 10.5}
+		Expect(server.Publish(streams.Event{Type: streams.AggregateEvent, Channel: api.MinuteAggregateChannel, Aggregate: &bar})).To(Succeed())
+		Eventually(bars).Should(Receive(Equal(bar)))
+	})
+
+	It("should not subscribe to second aggregates", func() {
+		err := socket.SubscribeAggregates("MKL", api.SecondAggregateChannel, func(bar api.Bar) {})
+		Expect(err).To(MatchError("second_aggregates are not streamed by Alpaca"))
+	})
+
+	It("should stop listening once unsubscribed", func() {
+		Expect(socket.Unsubscribe("MKL")).To(Succeed())
+		Expect(socket.UnsubscribeTradeUpdates()).To(Succeed())
+		Eventually(server.Subscriptions).Should(BeEmpty())
+	})
+
+	It("should fail to dial a server that is not there", func() {
+		url := server.URL()
+		server.Close()
+		_, err := streams.DialSocket(url, "key", "secret")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,304 @@
+package streams
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/alpacahq/alpaca-trade-api-go/polygon"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+)
+
+const (
+	// DefaultKey is the key used in config for the channels followed
+	// for any symbol without channels of its own
+	DefaultKey string = "default"
+)
+
+// DataSource names a provider of market data
+type DataSource string
+
+const (
+	// AlpacaSource streams market data from Alpaca
+	AlpacaSource DataSource = "alpaca"
+	// PolygonSource streams market data from Polygon
+	PolygonSource DataSource = "polygon"
+)
+
+// ParseDataSource returns the data source with the given name
+func ParseDataSource(name string) (DataSource, error) {
+	switch source := DataSource(strings.ToLower(strings.TrimSpace(name))); source {
+	case AlpacaSource, PolygonSource:
+		return source, nil
+	default:
+		return "", fmt.Errorf("unknown data source %q", name)
+	}
+}
+
+// EventType names the kind of event carried by an Event
+type EventType string
+
+const (
+	// TradeEvent carries a trade in a stock
+	TradeEvent EventType = "trade"
+	// QuoteEvent carries a quote for a stock
+	QuoteEvent EventType = "quote"
+	// AggregateEvent carries a bar aggregated by the stream
+	AggregateEvent EventType = "aggregate"
+	// TradeUpdateEvent carries an update to one of our orders
+	TradeUpdateEvent EventType = "trade_update"
+	// AccountUpdateEvent carries an update to our account
+	AccountUpdateEvent EventType = "account_update"
+)
+
+// Event is a single stream event, as published to an in-memory
+// stream or written to a file for replay. Aggregates carry the
+// channel they arrived on.
+type Event struct {
+	Type          EventType           `json:"type"`
+	Trade         *alpaca.StreamTrade `json:"trade,omitempty"`
+	Quote         *alpaca.StreamQuote `json:"quote,omitempty"`
+	Channel       api.Channel         `json:"channel,omitempty"`
+	Aggregate     *api.Bar            `json:"aggregate,omitempty"`
+	TradeUpdate   *alpaca.TradeUpdate `json:"trade_update,omitempty"`
+	AccountUpdate *api.AccountUpdate  `json:"account_update,omitempty"`
+}
+
+// Stream returns the name of the Alpaca stream the event arrives on
+func (e Event) Stream() (string, error) {
+	switch {
+	case e.Type == TradeEvent && e.Trade != nil:
+		return tradeKey(e.Trade.Symbol), nil
+	case e.Type == QuoteEvent && e.Quote != nil:
+		return quoteKey(e.Quote.Symbol), nil
+	case e.Type == AggregateEvent && e.Aggregate != nil:
+		return aggregateKey(e.Aggregate.Symbol, e.Channel)
+	case e.Type == TradeUpdateEvent && e.TradeUpdate != nil:
+		return alpaca.TradeUpdates, nil
+	case e.Type == AccountUpdateEvent && e.AccountUpdate != nil:
+		return alpaca.AccountUpdates, nil
+	case e.Type == TradeEvent, e.Type == QuoteEvent, e.Type == AggregateEvent, e.Type == TradeUpdateEvent, e.Type == AccountUpdateEvent:
+		return "", fmt.Errorf("%s event is missing its data", e.Type)
+	case e.Type == "":
+		return "", errors.New("event is missing its type")
+	default:
+		return "", fmt.Errorf("unknown event type %q", e.Type)
+	}
+}
+
+// Symbol returns the stock the event is about
+func (e Event) Symbol() string {
+	switch {
+	case e.Trade != nil:
+		return e.Trade.Symbol
+	case e.Quote != nil:
+		return e.Quote.Symbol
+	case e.Aggregate != nil:
+		return e.Aggregate.Symbol
+	case e.TradeUpdate != nil:
+		return e.TradeUpdate.Order.Symbol
+	default:
+		return ""
+	}
+}
+
+// tradeKey is the name of the Alpaca stream of trades in a stock
+func tradeKey(symbol string) string {
+	return fmt.Sprintf("T.%s", symbol)
+}
+
+// quoteKey is the name of the Alpaca stream of quotes for a stock
+func quoteKey(symbol string) string {
+	return fmt.Sprintf("Q.%s", symbol)
+}
+
+// aggregateKey is the name of the stream of aggregates for a stock
+func aggregateKey(symbol string, channel api.Channel) (string, error) {
+	switch channel {
+	case api.MinuteAggregateChannel:
+		return fmt.Sprintf("AM.%s", symbol), nil
+	case api.SecondAggregateChannel:
+		return fmt.Sprintf("A.%s", symbol), nil
+	default:
+		return "", fmt.Errorf("%q is not an aggregate channel", channel)
+	}
+}
+
+// alpacaBar converts an aggregate from Alpaca, timed in milliseconds
+func alpacaBar(agg alpaca.StreamAgg) api.Bar {
+	return api.Bar{
+		Symbol: agg.Symbol,
+		Start:  time.Unix(0, agg.Start*int64(time.Millisecond)).UTC(),
+		End:    time.Unix(0, agg.End*int64(time.Millisecond)).UTC(),
+		Open:   float64(agg.Open),
+		High:   float64(agg.High),
+		Low:    float64(agg.Low),
+		Close:  float64(agg.Close),
+		Volume: float64(agg.Volume),
+		VWAP:   float64(agg.VWAP),
+	}
+}
+
+// alpacaAgg converts a bar to an aggregate as Alpaca streams it
+func alpacaAgg(bar api.Bar) alpaca.StreamAgg {
+	return alpaca.StreamAgg{
+		Event:  "AM",
+		Symbol: bar.Symbol,
+		Start:  bar.Start.UnixNano() / int64(time.Millisecond),
+		End:    bar.End.UnixNano() / int64(time.Millisecond),
+		Open:   float32(bar.Open),
+		High:   float32(bar.High),
+		Low:    float32(bar.Low),
+		Close:  float32(bar.Close),
+		Volume: int32(bar.Volume),
+		VWAP:   float32(bar.VWAP),
+	}
+}
+
+// polygonBar converts an aggregate from Polygon, timed in milliseconds
+func polygonBar(agg polygon.StreamAggregate) api.Bar {
+	return api.Bar{
+		Symbol: agg.Symbol,
+		Start:  time.Unix(0, agg.StartTimestamp*int64(time.Millisecond)).UTC(),
+		End:    time.Unix(0, agg.EndTimestamp*int64(time.Millisecond)).UTC(),
+		Open:   agg.OpenPrice,
+		High:   agg.HighPrice,
+		Low:    agg.LowPrice,
+		Close:  agg.ClosePrice,
+		Volume: float64(agg.Volume),
+		VWAP:   agg.VWAP,
+	}
+}
+
+// NormalizeTrade converts a trade from either data source to the form
+// Alpaca streams it in, reporting which source it came from
+func NormalizeTrade(msg interface{}) (alpaca.StreamTrade, DataSource, error) {
+	switch trade := msg.(type) {
+	case alpaca.StreamTrade:
+		return trade, AlpacaSource, nil
+	case polygon.StreamTrade:
+		return polygonTrade(trade), PolygonSource, nil
+	default:
+		return alpaca.StreamTrade{}, "", fmt.Errorf("%T is not a stream trade", msg)
+	}
+}
+
+// NormalizeQuote converts a quote from either data source to the form
+// Alpaca streams it in, reporting which source it came from
+func NormalizeQuote(msg interface{}) (alpaca.StreamQuote, DataSource, error) {
+	switch quote := msg.(type) {
+	case alpaca.StreamQuote:
+		return quote, AlpacaSource, nil
+	case polygon.StreamQuote:
+		return polygonQuote(quote), PolygonSource, nil
+	default:
+		return alpaca.StreamQuote{}, "", fmt.Errorf("%T is not a stream quote", msg)
+	}
+}
+
+// NormalizeAggregate converts an aggregate from either data source to
+// a bar, reporting which source it came from
+func NormalizeAggregate(msg interface{}) (api.Bar, DataSource, error) {
+	switch agg := msg.(type) {
+	case alpaca.StreamAgg:
+		return alpacaBar(agg), AlpacaSource, nil
+	case polygon.StreamAggregate:
+		return polygonBar(agg), PolygonSource, nil
+	default:
+		return api.Bar{}, "", fmt.Errorf("%T is not a stream aggregate", msg)
+	}
+}
+
+// NormalizeAccountUpdate converts an account update, as the SDK passes
+// it on undecoded, to an api.AccountUpdate
+func NormalizeAccountUpdate(msg interface{}) (api.AccountUpdate, error) {
+	switch data := msg.(type) {
+	case api.AccountUpdate:
+		return data, nil
+	case map[string]interface{}:
+		update := api.AccountUpdate{}
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return update, err
+		}
+		if err := json.Unmarshal(raw, &update); err != nil {
+			return update, fmt.Errorf("invalid account update: %w", err)
+		}
+		return update, nil
+	default:
+		return api.AccountUpdate{}, fmt.Errorf("%T is not an account update", msg)
+	}
+}
+
+// polygonTrade converts a trade from Polygon, timed in milliseconds,
+// to a trade as Alpaca streams it, timed in nanoseconds
+func polygonTrade(trade polygon.StreamTrade) alpaca.StreamTrade {
+	return alpaca.StreamTrade{
+		Event:      "T",
+		Symbol:     trade.Symbol,
+		TradeID:    trade.TradeID,
+		Exchange:   trade.Exchange,
+		Price:      float32(trade.Price),
+		Size:       int32(trade.Size),
+		Timestamp:  trade.Timestamp * int64(time.Millisecond),
+		Conditions: trade.Conditions,
+	}
+}
+
+// polygonQuote converts a quote from Polygon, timed in milliseconds,
+// to a quote as Alpaca streams it, timed in nanoseconds
+func polygonQuote(quote polygon.StreamQuote) alpaca.StreamQuote {
+	return alpaca.StreamQuote{
+		Event:       "Q",
+		Symbol:      quote.Symbol,
+		BidPrice:    float32(quote.BidPrice),
+		BidSize:     int32(quote.BidSize),
+		BidExchange: quote.BidExchange,
+		AskPrice:    float32(quote.AskPrice),
+		AskSize:     int32(quote.AskSize),
+		AskExchange: quote.AskExchange,
+		Timestamp:   quote.Timestamp * int64(time.Millisecond),
+	}
+}
+
+// Channels selects the channels followed for each symbol, falling back to a default
+type Channels struct {
+	Default []api.Channel
+	Symbols map[string][]api.Channel
+}
+
+// For returns the channels for a symbol, or nil if none are configured
+func (c Channels) For(symbol string) []api.Channel {
+	if channels, ok := c.Symbols[symbol]; ok {
+		return channels
+	}
+	return c.Default
+}
+
+// ParseChannels builds channels from a map of symbol to a list of
+// channels separated by "|", such as "trades|quotes", where the
+// DefaultKey entry applies to every other symbol.
+func ParseChannels(specs map[string]string) (Channels, error) {
+	channels := Channels{Symbols: map[string][]api.Channel{}}
+	for symbol, spec := range specs {
+		parsed := []api.Channel{}
+		for _, name := range strings.Split(spec, "|") {
+			channel := api.Channel(strings.TrimSpace(name))
+			switch channel {
+			case api.TradeChannel, api.QuoteChannel, api.MinuteAggregateChannel, api.SecondAggregateChannel:
+			default:
+				return Channels{}, fmt.Errorf("invalid channels for %s: unknown channel %q", symbol, channel)
+			}
+			parsed = append(parsed, channel)
+		}
+		if symbol == DefaultKey {
+			channels.Default = parsed
+			continue
+		}
+		channels.Symbols[symbol] = parsed
+	}
+	return channels, nil
+}
@@ -0,0 +1,128 @@
+package streams_test
+
+import (
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/alpacahq/alpaca-trade-api-go/polygon"
+	"github.com/markliederbach/stonks/pkg/alpaca/api"
+	"github.com/markliederbach/stonks/pkg/alpaca/streams"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/shopspring/decimal"
+)
+
+var _ = Describe("Channels", func() {
+	It("should parse channels per symbol with a default", func() {
+		channels, err := streams.ParseChannels(map[string]string{
+			"default": "trades",
+			"VTI":     "trades| quotes|minute_aggregates",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(channels.For("VTI")).To(Equal([]api.Channel{api.TradeChannel, api.QuoteChannel, api.MinuteAggregateChannel}))
+		Expect(channels.For("MKL")).To(Equal([]api.Channel{api.TradeChannel}))
+	})
+
+	It("should have no channels for a symbol when none are configured", func() {
+		channels, err := streams.ParseChannels(map[string]string{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(channels.For("MKL")).To(BeNil())
+	})
+
+	It("should reject unknown channels", func() {
+		_, err := streams.ParseChannels(map[string]string{"VTI": "trades|bars"})
+		Expect(err).To(MatchError(`invalid channels for VTI: unknown channel "bars"`))
+	})
+})
+
+var _ = Describe("Data sources", func() {
+	It("should parse data source names", func() {
+		Expect(streams.ParseDataSource("Polygon")).To(Equal(streams.PolygonSource))
+		Expect(streams.ParseDataSource("alpaca")).To(Equal(streams.AlpacaSource))
+		_, err := streams.ParseDataSource("iex")
+		Expect(err).To(MatchError(`unknown data source "iex"`))
+	})
+
+	It("should normalize trades from Polygon", func() {
+		trade, source, err := streams.NormalizeTrade(polygon.StreamTrade{
+			Symbol:    "MKL",
+			Exchange:  4,
+			TradeID:   "t1",
+			Price:     100.25,
+			Size:      10,
+			Timestamp: 1604327400000,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(source).To(Equal(streams.PolygonSource))
+		Expect(trade).To(Equal(alpaca.StreamTrade{
+			Event:     "T",
+			Symbol:    "MKL",
+			TradeID:   "t1",
+			Exchange:  4,
+			Price:     100.25,
+			Size:      10,
+			Timestamp: 1604327400000000000,
+		}))
+	})
+
+	It("should pass trades from Alpaca through", func() {
+		trade, source, err := streams.NormalizeTrade(alpaca.StreamTrade{Symbol: "MKL", Price: 100})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(source).To(Equal(streams.AlpacaSource))
+		Expect(trade).To(Equal(alpaca.StreamTrade{Symbol: "MKL", Price: 100}))
+	})
+
+	It("should normalize quotes from Polygon", func() {
+		quote, source, err := streams.NormalizeQuote(polygon.StreamQuote{
+			Symbol:    "MKL",
+			BidPrice:  99.5,
+			BidSize:   3,
+			AskPrice:  100.5,
+			AskSize:   4,
+			Timestamp: 1604327400000,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(source).To(Equal(streams.PolygonSource))
+		Expect(quote.BidPrice).To(Equal(float32(99.5)))
+		Expect(quote.AskSize).To(Equal(int32(4)))
+		Expect(quote.Time().Equal(time.Date(2020, 11, 2, 14, 30, 0, 0, time.UTC))).To(BeTrue())
+	})
+
+	It("should normalize aggregates from either source to bars", func() {
+		bar, source, err := streams.NormalizeAggregate(polygon.StreamAggregate{Symbol: "MKL", ClosePrice: 100, StartTimestamp: 1604327400000})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(source).To(Equal(streams.PolygonSource))
+		Expect(bar.Close).To(Equal(float64(100)))
+		Expect(bar.Start).To(Equal(time.Date(2020, 11, 2, 14, 30, 0, 0, time.UTC)))
+
+		_, source, err = streams.NormalizeAggregate(alpaca.StreamAgg{Symbol: "MKL", Close: 100})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(source).To(Equal(streams.AlpacaSource))
+	})
+
+	It("should decode account updates as the SDK passes them on", func() {
+		update, err := streams.NormalizeAccountUpdate(map[string]interface{}{
+			"id":              "account123",
+			"status":          "ACTIVE",
+			"cash":            "1000.50",
+			"trading_blocked": true,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(update.ID).To(Equal("account123"))
+		Expect(update.Cash.Equal(decimal.NewFromFloat(1000.5))).To(BeTrue())
+		Expect(*update.TradingBlocked).To(BeTrue())
+		Expect(update.Equity).To(BeNil())
+
+		_, err = streams.NormalizeAccountUpdate(map[string]interface{}{"cash": []string{}})
+		Expect(err).To(HaveOccurred())
+		_, err = streams.NormalizeAccountUpdate("account")
+		Expect(err).To(MatchError("string is not an account update"))
+	})
+
+	It("should reject messages of other types", func() {
+		_, _, err := streams.NormalizeTrade(alpaca.StreamQuote{Symbol: "MKL"})
+		Expect(err).To(MatchError("alpaca.StreamQuote is not a stream trade"))
+		_, _, err = streams.NormalizeQuote(nil)
+		Expect(err).To(MatchError("<nil> is not a stream quote"))
+	})
+})